@@ -1,28 +1,89 @@
 /*
 Package assets provides a bundle for serving static asset files.
+
+Directories are served with their index.html, and listing them is disabled
+unless WithDirectoryListing is given. HTML files are always revalidated by
+clients while other files are cached for an hour by default.
 */
 package assets
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/goburrow/melon/core"
 )
 
+const (
+	indexFile     = "index.html"
+	defaultMaxAge = time.Hour
+)
+
 // bundle serves static asset files.
 // it implements core.Bundle interface
 type bundle struct {
-	dir     string
+	fs      http.FileSystem
 	urlPath string
+
+	spa     bool
+	listing bool
+	maxAge  time.Duration
+}
+
+// Option is an option of the assets Bundle.
+type Option func(b *bundle)
+
+// WithSPA serves index.html of the root directory for paths which do not
+// exist, so that a single page application can route them in browsers.
+func WithSPA() Option {
+	return func(b *bundle) {
+		b.spa = true
+	}
 }
 
-// NewBundle returns a new Bundle serving static asset files.
+// WithDirectoryListing lists files of directories without index.html.
+func WithDirectoryListing() Option {
+	return func(b *bundle) {
+		b.listing = true
+	}
+}
+
+// WithMaxAge sets max-age of Cache-Control header of files other than HTML.
+// Zero requires clients to revalidate all files.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(b *bundle) {
+		b.maxAge = maxAge
+	}
+}
+
+// NewBundle returns a new Bundle serving static asset files in dir.
 // urlPath must always start with "/".
-func NewBundle(dir, urlPath string) core.Bundle {
-	return &bundle{
-		dir:     dir,
+func NewBundle(dir, urlPath string, options ...Option) core.Bundle {
+	return newBundle(http.Dir(dir), urlPath, options)
+}
+
+// NewFSBundle returns a new Bundle serving static asset files in fsys, e.g.
+// files embedded with go:embed.
+func NewFSBundle(fsys fs.FS, urlPath string, options ...Option) core.Bundle {
+	return newBundle(http.FS(fsys), urlPath, options)
+}
+
+func newBundle(fs http.FileSystem, urlPath string, options []Option) *bundle {
+	b := &bundle{
+		fs:      fs,
 		urlPath: urlPath,
+		maxAge:  defaultMaxAge,
 	}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b
 }
 
 // Initialize does not do anything.
@@ -31,12 +92,16 @@ func (b *bundle) Initialize(bootstrap *core.Bootstrap) {
 }
 
 // Run registers current Bundle to the server in the given environment.
+// The path is relative to the path prefix of the application router.
 func (b *bundle) Run(_ interface{}, env *core.Environment) error {
 	core.GetLogger("melon/assets").Infof("registering AssetsBundle for path %s", b.urlPath)
 
 	// Add slashes if necessary
 	p := addSlashes(b.urlPath)
-	handler := http.FileServer(http.Dir(b.dir))
+	var handler http.Handler = &assetHandler{
+		bundle:     b,
+		fileServer: http.FileServer(b.fs),
+	}
 	// Strip path prefix if needed
 	if p != "/" {
 		handler = http.StripPrefix(p, handler)
@@ -45,6 +110,63 @@ func (b *bundle) Run(_ interface{}, env *core.Environment) error {
 	return nil
 }
 
+// assetHandler serves files with cache headers.
+type assetHandler struct {
+	*bundle
+	fileServer http.Handler
+}
+
+func (h *assetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	html, err := h.isHTML(name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || !h.spa || name == "/" {
+			http.NotFound(w, r)
+			return
+		}
+		// Index of the root directory
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = "/"
+		r2.URL = &u
+		r = r2
+		html = true
+	}
+	if html || h.maxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(h.maxAge/time.Second)))
+	}
+	h.fileServer.ServeHTTP(w, r)
+}
+
+// isHTML returns true if name is a HTML file or a directory which has index
+// file or can be listed.
+func (h *assetHandler) isHTML(name string) (bool, error) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !stat.IsDir() {
+		return strings.HasSuffix(name, ".html"), nil
+	}
+	index, err := h.fs.Open(path.Join(name, indexFile))
+	if err != nil {
+		if h.listing {
+			return true, nil
+		}
+		return false, err
+	}
+	index.Close()
+	return true, nil
+}
+
 // addSlashes adds leading and trailing slashes if necessary.
 func addSlashes(p string) string {
 	if p == "" {
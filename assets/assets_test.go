@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/server/router"
@@ -30,12 +31,12 @@ func TestAssetsBundle(t *testing.T) {
 	// Start server
 	server := httptest.NewServer(handler)
 	defer server.Close()
-	// Get dir
+	// Directory listing is disabled
 	res, err := http.Get(server.URL + "/static/")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if res.StatusCode != 200 {
+	if res.StatusCode != 404 {
 		t.Fatalf("unexpected response code: %+v", res)
 	}
 	// Get file
@@ -59,3 +60,55 @@ func TestAssetsBundle(t *testing.T) {
 		t.Fatalf("unexpected response body: %s", body)
 	}
 }
+
+func newFSHandler(t *testing.T, urlPath string, options ...Option) *router.Router {
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("index")},
+		"app.js":             {Data: []byte("app")},
+		"docs/index.html":    {Data: []byte("docs")},
+		"images/logo.svg":    {Data: []byte("logo")},
+		"images/icons/a.png": {Data: []byte("a")},
+	}
+	env := core.NewEnvironment()
+	handler := router.New(router.WithPathPrefix("/application"))
+	env.Server.Router = handler
+	if err := NewFSBundle(fsys, urlPath, options...).Run(nil, env); err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestFSBundle(t *testing.T) {
+	tests := []struct {
+		options      []Option
+		path         string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{nil, "/application/ui/", 200, "index", "no-cache"},
+		{nil, "/application/ui/app.js", 200, "app", "public, max-age=3600"},
+		{nil, "/application/ui/docs/", 200, "docs", "no-cache"},
+		{nil, "/application/ui/docs", 301, "", "no-cache"},
+		{nil, "/application/ui/images/", 404, "", ""},
+		{nil, "/application/ui/users/1", 404, "", ""},
+		{nil, "/application/app.js", 404, "", ""},
+		{[]Option{WithDirectoryListing()}, "/application/ui/images/", 200, "", "no-cache"},
+		{[]Option{WithMaxAge(0)}, "/application/ui/app.js", 200, "app", "no-cache"},
+		// SPA
+		{[]Option{WithSPA()}, "/application/ui/users/1", 200, "index", "no-cache"},
+		{[]Option{WithSPA()}, "/application/ui/images/", 200, "index", "no-cache"},
+		{[]Option{WithSPA()}, "/application/ui/app.js", 200, "app", "public, max-age=3600"},
+	}
+	for _, test := range tests {
+		handler := newFSHandler(t, "ui", test.options...)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status || w.Header().Get("Cache-Control") != test.cacheControl {
+			t.Fatalf("%s: unexpected response: %d %v", test.path, w.Code, w.Header())
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Fatalf("%s: unexpected body: %s", test.path, w.Body)
+		}
+	}
+}
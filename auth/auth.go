@@ -1,5 +1,6 @@
 /*
-Package auth provides authentication using HTTP Basic Authentication.
+Package auth provides authentication of application resources, e.g. using
+HTTP Basic Authentication.
 */
 package auth
 
@@ -7,8 +8,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/conncache"
 	"github.com/goburrow/melon/server/filter"
 )
 
@@ -28,6 +31,57 @@ func (p principalName) Name() string {
 	return string(p)
 }
 
+// RoleHolder is implemented by principals having roles, which are checked
+// by resources allowing only some roles.
+type RoleHolder interface {
+	HasRole(role string) bool
+}
+
+// NewPrincipalWithRoles returns a Principal of name having roles.
+func NewPrincipalWithRoles(name string, roles ...string) Principal {
+	return &rolePrincipal{name: name, roles: roles}
+}
+
+type rolePrincipal struct {
+	name  string
+	roles []string
+}
+
+func (p *rolePrincipal) Name() string {
+	return p.name
+}
+
+func (p *rolePrincipal) HasRole(role string) bool {
+	for _, r := range p.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyRole returns true if p has one of roles. Principals which do not
+// implement RoleHolder have no roles.
+func HasAnyRole(p Principal, roles ...string) bool {
+	holder, ok := p.(RoleHolder)
+	if !ok {
+		return false
+	}
+	for _, role := range roles {
+		if holder.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expiring is implemented by principals whose credentials expire, e.g. a
+// token. Principals cached by WithConnectionCache are authenticated again
+// once they expire.
+type Expiring interface {
+	ExpiresAt() time.Time
+}
+
 // Authenticator is an interface which authenticates request and returns
 // principal object.
 type Authenticator interface {
@@ -62,13 +116,22 @@ func (h *unauthorizedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 type authFilter struct {
 	authenticator       Authenticator
 	unauthorizedHandler http.Handler
+
+	// cacheTTL enables caching principals per connection.
+	cacheTTL time.Duration
+	cacheKey string
+	clock    core.Clock
 }
 
 // NewFilter creates a new Filter authenticating all HTTP requests with given authenticator.
+// The filter only protects the router it is added to, e.g. env.Server.Router
+// of application resources.
 func NewFilter(authenticator Authenticator, options ...Option) filter.Filter {
 	f := &authFilter{
 		authenticator: authenticator,
+		clock:         core.SystemClock,
 	}
+	f.cacheKey = fmt.Sprintf("melon/auth %p", f)
 	for _, opt := range options {
 		opt(f)
 	}
@@ -79,7 +142,7 @@ func NewFilter(authenticator Authenticator, options ...Option) filter.Filter {
 }
 
 func (f *authFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p, err := f.authenticator.Authenticate(r)
+	p, err := f.authenticate(r)
 	if err != nil {
 		core.GetLogger("melon/auth").Errorf("authenticate error: %v", err)
 		// TODO: error handler
@@ -90,10 +153,39 @@ func (f *authFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		f.unauthorizedHandler.ServeHTTP(w, r)
 		return
 	}
-	ctx := newContext(r.Context(), p)
+	ctx := NewContext(r.Context(), p)
 	filter.Continue(w, r.WithContext(ctx))
 }
 
+// authenticate returns the principal cached for the Authorization header of
+// the connection if it has not expired.
+func (f *authFilter) authenticate(r *http.Request) (Principal, error) {
+	var cache *conncache.Cache
+	credentials := r.Header.Get("Authorization")
+	if f.cacheTTL > 0 && credentials != "" {
+		cache = conncache.FromContext(r.Context())
+	}
+	if cache == nil {
+		return f.authenticator.Authenticate(r)
+	}
+	now := f.clock.Now()
+	if p, ok := cache.Get(f.cacheKey, credentials, now); ok {
+		return p.(Principal), nil
+	}
+	p, err := f.authenticator.Authenticate(r)
+	if err != nil || p == nil {
+		return p, err
+	}
+	expires := now.Add(f.cacheTTL)
+	if e, ok := p.(Expiring); ok && e.ExpiresAt().Before(expires) {
+		expires = e.ExpiresAt()
+	}
+	if expires.After(now) {
+		cache.Put(f.cacheKey, credentials, p, expires)
+	}
+	return p, nil
+}
+
 // Option is a Filter option.
 type Option func(f *authFilter)
 
@@ -104,6 +196,34 @@ func WithUnauthorizedHandler(h http.Handler) Option {
 	}
 }
 
+// WithRealm responds Basic authentication challenge of realm to
+// unauthorized requests, "Server" by default.
+func WithRealm(realm string) Option {
+	return func(f *authFilter) {
+		f.unauthorizedHandler = NewUnauthorizedHandler("Basic", realm)
+	}
+}
+
+// WithConnectionCache caches authenticated principals for the Authorization
+// header of each connection, so that requests on a keep-alive connection are
+// not verified again. A principal is authenticated again after ttl, when it
+// expires (see Expiring) or when the header changes.
+// It requires conncache.ConnContext on the server (Connector.ConnectionCache)
+// and must only be used with authenticators depending solely on the
+// Authorization header.
+func WithConnectionCache(ttl time.Duration) Option {
+	return func(f *authFilter) {
+		f.cacheTTL = ttl
+	}
+}
+
+// WithClock sets the clock of cached principal expiry, SystemClock by default.
+func WithClock(clock core.Clock) Option {
+	return func(f *authFilter) {
+		f.clock = clock
+	}
+}
+
 // contextKey is a value for use with context.WithValue
 type contextKey struct {
 	name string
@@ -115,11 +235,15 @@ func (c *contextKey) String() string {
 
 var principalContextKey = &contextKey{"principal"}
 
-func newContext(ctx context.Context, p Principal) context.Context {
+// NewContext returns a new context carrying principal p, e.g. for
+// authenticating requests without Filter.
+func NewContext(ctx context.Context, p Principal) context.Context {
 	return context.WithValue(ctx, principalContextKey, p)
 }
 
-func fromContext(ctx context.Context) Principal {
+// PrincipalFromContext returns the principal authenticated by Filter, or nil
+// if ctx is not of an authenticated request.
+func PrincipalFromContext(ctx context.Context) Principal {
 	if p, ok := ctx.Value(principalContextKey).(Principal); ok {
 		return p
 	}
@@ -130,7 +254,7 @@ func fromContext(ctx context.Context) Principal {
 // If no principal found in the request context, it will panic.
 // This panic should not happen if Filter is added to the server correctly.
 func Must(r *http.Request) Principal {
-	p := fromContext(r.Context())
+	p := PrincipalFromContext(r.Context())
 	if p == nil {
 		panic("melon/auth: no principal")
 	}
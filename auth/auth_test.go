@@ -1,12 +1,21 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/conncache"
+	"github.com/goburrow/melon/server/filter/filtertest"
 	"github.com/goburrow/melon/server/router"
 )
 
@@ -69,3 +78,120 @@ func TestFilter(t *testing.T) {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
+
+// tokenAuthenticator verifies HMAC-SHA256 signed bearer tokens "name.exp.sig"
+// similar to JWT.
+type tokenAuthenticator struct {
+	key      []byte
+	verified int
+}
+
+type tokenPrincipal struct {
+	name    string
+	expires time.Time
+}
+
+func (p *tokenPrincipal) Name() string {
+	return p.name
+}
+
+func (p *tokenPrincipal) ExpiresAt() time.Time {
+	return p.expires
+}
+
+func (a *tokenAuthenticator) sign(name string, expires time.Time) string {
+	payload := fmt.Sprintf("%s.%d", name, expires.Unix())
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return "Bearer " + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	a.verified++
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	idx := strings.LastIndex(token, ".")
+	if idx < 0 {
+		return nil, nil
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(token[:idx]))
+	sig, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, nil
+	}
+	parts := strings.Split(token[:idx], ".")
+	var exp int64
+	fmt.Sscan(parts[1], &exp)
+	return &tokenPrincipal{name: parts[0], expires: time.Unix(exp, 0)}, nil
+}
+
+func TestFilterConnectionCache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	clock := melontest.NewFakeClock(now)
+	auth := &tokenAuthenticator{key: []byte("secret")}
+	h := filtertest.NewHarness(NewFilter(auth, WithConnectionCache(time.Minute), WithClock(clock)))
+	ctx := conncache.NewContext(context.Background(), conncache.New())
+	withConn := filtertest.WithRequest(func(r *http.Request) *http.Request {
+		return r.WithContext(ctx)
+	})
+	token := auth.sign("user", now.Add(90*time.Second))
+
+	for i := 0; i < 3; i++ {
+		h.Request("GET", "/", withConn, filtertest.WithHeader("Authorization", token)).AssertReached(t)
+	}
+	if auth.verified != 1 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+	// A different token is verified.
+	h.Request("GET", "/", withConn, filtertest.WithHeader("Authorization", "Bearer bad.0.sig")).
+		AssertShortCircuited(t, http.StatusUnauthorized)
+	if auth.verified != 2 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+	// Max TTL.
+	h.Request("GET", "/", withConn, filtertest.WithHeader("Authorization", token)).AssertReached(t)
+	if auth.verified != 2 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+	clock.Add(time.Minute)
+	h.Request("GET", "/", withConn, filtertest.WithHeader("Authorization", token)).AssertReached(t)
+	if auth.verified != 3 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+	// Token expiry is earlier than TTL.
+	clock.Add(30 * time.Second)
+	h.Request("GET", "/", withConn, filtertest.WithHeader("Authorization", token)).AssertReached(t)
+	if auth.verified != 4 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+	// Without connection cache.
+	h.Request("GET", "/", filtertest.WithHeader("Authorization", token)).AssertReached(t)
+	if auth.verified != 5 {
+		t.Fatalf("unexpected verifications: %d", auth.verified)
+	}
+}
+
+func benchmarkFilter(b *testing.B, options ...Option) {
+	auth := &tokenAuthenticator{key: []byte("secret")}
+	f := NewFilter(auth, options...)
+	ctx := conncache.NewContext(context.Background(), conncache.New())
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	r.Header.Set("Authorization", auth.sign("user", time.Now().Add(time.Hour)))
+	handler := router.New()
+	handler.AddFilter(f)
+	handler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	benchmarkFilter(b)
+}
+
+func BenchmarkFilterConnectionCache(b *testing.B) {
+	benchmarkFilter(b, WithConnectionCache(time.Minute))
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "net/http"
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
 
 // basicAuthenticator is an Authenticator which authenticates requests
 // using Basic Authenticator mechanism.
@@ -23,3 +27,36 @@ func (b *basicAuthenticator) Authenticate(r *http.Request) (Principal, error) {
 	}
 	return b.authFunc(user, pass)
 }
+
+// staticUser is a user of static authenticator.
+type staticUser struct {
+	name     string
+	username [sha256.Size]byte
+	password [sha256.Size]byte
+}
+
+// NewStaticAuthenticator returns a new Basic Authenticator of users which
+// maps usernames to passwords. Principal names are the usernames.
+// Credentials are compared in constant time.
+func NewStaticAuthenticator(users map[string]string) Authenticator {
+	list := make([]staticUser, 0, len(users))
+	for username, password := range users {
+		list = append(list, staticUser{
+			name:     username,
+			username: sha256.Sum256([]byte(username)),
+			password: sha256.Sum256([]byte(password)),
+		})
+	}
+	return NewBasicAuthenticator(func(username, password string) (Principal, error) {
+		u := sha256.Sum256([]byte(username))
+		p := sha256.Sum256([]byte(password))
+		var principal Principal
+		// All users are always compared.
+		for i := range list {
+			if subtle.ConstantTimeCompare(u[:], list[i].username[:])&subtle.ConstantTimeCompare(p[:], list[i].password[:]) == 1 {
+				principal = NewPrincipal(list[i].name)
+			}
+		}
+		return principal, nil
+	})
+}
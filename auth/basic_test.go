@@ -66,3 +66,44 @@ func TestBasicAuthenticator(t *testing.T) {
 		t.Fatalf("unexpected status code: %v", rsp.StatusCode)
 	}
 }
+
+func TestStaticAuthenticator(t *testing.T) {
+	f := NewFilter(NewStaticAuthenticator(map[string]string{"adm": "sec", "usr": "pwd"}), WithRealm("Users"))
+	rt := router.New()
+	rt.AddFilter(f)
+	rt.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + PrincipalFromContext(r.Context()).Name()))
+	}))
+	tests := []struct {
+		username, password string
+		status             int
+		body               string
+	}{
+		{"", "", http.StatusUnauthorized, ""},
+		{"adm", "pwd", http.StatusUnauthorized, ""},
+		{"unknown", "sec", http.StatusUnauthorized, ""},
+		{"usr", "pwd", http.StatusOK, "hello usr"},
+		{"adm", "sec", http.StatusOK, "hello adm"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("unexpected status code for %+v: %v", test, w.Code)
+		}
+		if test.status == http.StatusOK {
+			if w.Body.String() != test.body {
+				t.Fatalf("unexpected body: %s", w.Body)
+			}
+		} else if header := w.Header().Get("WWW-Authenticate"); header != `Basic realm="Users"` {
+			t.Fatalf("unexpected header: %v", header)
+		}
+	}
+	if p := PrincipalFromContext(httptest.NewRequest("GET", "/", nil).Context()); p != nil {
+		t.Fatalf("unexpected principal: %v", p)
+	}
+}
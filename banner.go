@@ -0,0 +1,112 @@
+package melon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	maxBannerSize = 50 * 1024 // 50KB
+)
+
+var errFileTooLarge = errors.New("file is too large")
+
+// BannerConfiguration configures reading the banner, a .txt file in the
+// current directory which has the same name with the running application.
+type BannerConfiguration struct {
+	// FollowSymlinks allows the banner to be a symbolic link. Symbolic links
+	// are rejected by default on platforms supporting it.
+	FollowSymlinks bool
+}
+
+// bannerConfigurable is implemented by configurations providing
+// BannerConfiguration, e.g. Configuration.
+type bannerConfigurable interface {
+	BannerConfiguration() *BannerConfiguration
+}
+
+// printBanner prints application banner to the given logger
+func printBanner(config interface{}) {
+	followSymlinks := false
+	if c, ok := config.(bannerConfigurable); ok {
+		followSymlinks = c.BannerConfiguration().FollowSymlinks
+	}
+	banner := readBanner(followSymlinks)
+	if banner == "" {
+		logger().Infof("starting")
+	} else {
+		logger().Infof("starting\n%s", banner)
+	}
+}
+
+// readBanner read contents of a banner found in the current directory.
+func readBanner(followSymlinks bool) string {
+	banner, err := readFileContents(os.Args[0]+".txt", maxBannerSize, followSymlinks)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger().Debugf("could not read banner: %v", err)
+		}
+		return ""
+	}
+	return banner
+}
+
+// readFileContents reads contents of a regular file with a limit of maximum
+// bytes. Larger files are rejected rather than truncated.
+func readFileContents(file string, maxBytes int, followSymlinks bool) (string, error) {
+	f, err := openRegularFile(file, followSymlinks)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// Read one more byte to detect files growing after they are opened.
+	buf, err := ioutil.ReadAll(io.LimitReader(f, int64(maxBytes)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) > maxBytes {
+		return "", fmt.Errorf("%s: %w, maximum is %d bytes", file, errFileTooLarge, maxBytes)
+	}
+	if path, err := filepath.Abs(file); err == nil {
+		logger().Debugf("loaded %s", path)
+	}
+	return string(buf), nil
+}
+
+// openRegularFile opens file for reading and rejects directories, devices and
+// named pipes.
+func openRegularFile(file string, followSymlinks bool) (*os.File, error) {
+	if !followSymlinks {
+		// Open flags reject symbolic links atomically when supported,
+		// Lstat is for the other platforms.
+		fi, err := os.Lstat(file)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("%s: symbolic link is not allowed", file)
+		}
+	}
+	flags := os.O_RDONLY | openNonBlock
+	if !followSymlinks {
+		flags |= openNoFollow
+	}
+	f, err := os.OpenFile(file, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a regular file: %v", file, fi.Mode())
+	}
+	return f, nil
+}
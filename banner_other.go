@@ -0,0 +1,9 @@
+//go:build windows || plan9 || js || wasip1
+
+package melon
+
+// Symbolic links and named pipes are checked after opening on these platforms.
+const (
+	openNoFollow = 0
+	openNonBlock = 0
+)
@@ -0,0 +1,53 @@
+package melon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadFileContents(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "banner.txt")
+	if err := os.WriteFile(file, []byte("melon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := readFileContents(file, 5, false)
+	if err != nil || s != "melon" {
+		t.Fatalf("unexpected contents: %q %v", s, err)
+	}
+	_, err = readFileContents(file, 4, false)
+	if !errors.Is(err, errFileTooLarge) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = readFileContents(filepath.Join(dir, "none.txt"), 5, false)
+	if !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = readFileContents(dir, 5, false)
+	if err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReadFileContentsSymlink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret")
+	if err := os.WriteFile(file, []byte("melon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "banner.txt")
+	if err := os.Symlink(file, link); err != nil {
+		t.Skip(err)
+	}
+	_, err := readFileContents(link, 10, false)
+	if err == nil {
+		t.Fatalf("error expected when reading symbolic link")
+	}
+	s, err := readFileContents(link, 10, true)
+	if err != nil || s != "melon" {
+		t.Fatalf("unexpected contents: %q %v", s, err)
+	}
+}
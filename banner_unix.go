@@ -0,0 +1,12 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package melon
+
+import "syscall"
+
+// openNoFollow fails opening symbolic links and openNonBlock prevents
+// blocking on named pipes without writers.
+const (
+	openNoFollow = syscall.O_NOFOLLOW
+	openNonBlock = syscall.O_NONBLOCK
+)
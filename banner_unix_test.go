@@ -0,0 +1,21 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package melon
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestReadFileContentsFIFO(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "banner.txt")
+	if err := syscall.Mkfifo(file, 0644); err != nil {
+		t.Skip(err)
+	}
+	_, err := readFileContents(file, 10, true)
+	if err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package melon
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/goburrow/melon/configuration"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server"
+	"github.com/goburrow/melon/views"
+)
+
+const defaultBuilderPort = 8080
+
+// Builder runs an application without defining an application type or a
+// configuration file:
+//
+//	melon.New("hello").
+//		Resource(views.NewResource("GET", "/", views.HandlerFunc(hello))).
+//		HealthCheck("database", db.Ping).
+//		Run()
+//
+// It is a shortcut of Run with an application which supports RESTful
+// resources in JSON, an optional configuration file and a DefaultServer
+// listening on localhost at Port (application) and Port+1 (admin).
+type Builder struct {
+	name         string
+	port         int
+	config       core.Configuration
+	resources    []interface{}
+	healthChecks map[string]health.Checker
+
+	mu sync.Mutex
+	// env is the environment of the running application.
+	env *core.Environment
+}
+
+// New returns a Builder of the named application.
+func New(name string) *Builder {
+	return &Builder{
+		name:         name,
+		port:         defaultBuilderPort,
+		config:       &Configuration{},
+		healthChecks: make(map[string]health.Checker),
+	}
+}
+
+// Resource registers resources to the server environment, e.g. views.Resource.
+func (b *Builder) Resource(r ...interface{}) *Builder {
+	b.resources = append(b.resources, r...)
+	return b
+}
+
+// HealthCheck registers a health check which is unhealthy when fn returns an error.
+func (b *Builder) HealthCheck(name string, fn func() error) *Builder {
+	b.healthChecks[name] = health.CheckerFunc(func() health.Result {
+		if err := fn(); err != nil {
+			return health.ResultUnhealthy("", err)
+		}
+		return health.Healthy
+	})
+	return b
+}
+
+// Configure binds configuration of the application to ptr, which must embed
+// Configuration. It is parsed from the file given in command arguments if any.
+func (b *Builder) Configure(ptr core.Configuration) *Builder {
+	b.config = ptr
+	return b
+}
+
+// Port sets the port of the application connector, 8080 by default. The admin
+// connector listens on the next port. It is ignored when the server is set in
+// the configuration file.
+func (b *Builder) Port(n int) *Builder {
+	b.port = n
+	return b
+}
+
+// Run runs the application with command line arguments. The server command is
+// run when no arguments are given.
+func (b *Builder) Run() error {
+	return b.run(os.Args[1:])
+}
+
+// environment returns the environment of the running application or nil if
+// it has not been started.
+func (b *Builder) environment() *core.Environment {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.env
+}
+
+func (b *Builder) run(args []string) error {
+	if len(args) == 0 {
+		args = []string{"server"}
+	}
+	return Run(&builderApplication{b}, args)
+}
+
+// builderApplication is the application created by Builder.
+type builderApplication struct {
+	builder *Builder
+}
+
+// Initialize sets the default configuration and supports JSON resources.
+func (app *builderApplication) Initialize(bootstrap *core.Bootstrap) {
+	b := app.builder
+	if f, ok := b.config.ServerFactory().(*server.Factory); ok && f.Value() == nil {
+		factory := server.NewDefaultFactory()
+		factory.ApplicationConnectors[0].Addr = fmt.Sprintf("localhost:%d", b.port)
+		factory.AdminConnectors[0].Addr = fmt.Sprintf("localhost:%d", b.port+1)
+		f.SetValue(factory)
+	}
+	factory := configuration.NewFactory(b.config)
+	factory.SetOptional(true)
+	bootstrap.ConfigurationFactory = factory
+	bootstrap.Name = b.name
+	bootstrap.AddBundle(views.NewBundle(views.NewJSONProvider()))
+}
+
+// Run registers resources and health checks.
+func (app *builderApplication) Run(conf interface{}, env *core.Environment) error {
+	b := app.builder
+	logger().Infof("running %s", b.name)
+	env.Server.Register(b.resources...)
+	for name, checker := range b.healthChecks {
+		env.Admin.HealthChecks.Register(name, checker)
+	}
+	b.mu.Lock()
+	b.env = env
+	b.mu.Unlock()
+	return nil
+}
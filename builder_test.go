@@ -0,0 +1,87 @@
+package melon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/views"
+)
+
+// freePort returns a port which is free together with the next one.
+func freePort(t *testing.T) int {
+	for i := 0; i < 10; i++ {
+		l1, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l1.Addr().(*net.TCPAddr).Port
+		l2, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port+1))
+		l1.Close()
+		if err == nil {
+			l2.Close()
+			return port
+		}
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+func waitGet(t *testing.T, url string) (int, string) {
+	var err error
+	for i := 0; i < 50; i++ {
+		var rsp *http.Response
+		rsp, err = http.Get(url)
+		if err == nil {
+			body, _ := ioutil.ReadAll(rsp.Body)
+			rsp.Body.Close()
+			return rsp.StatusCode, string(body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal(err)
+	return 0, ""
+}
+
+func TestBuilder(t *testing.T) {
+	port := freePort(t)
+	b := New("hello").
+		Port(port).
+		Resource(views.NewResource("GET", "/hello", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+			return map[string]string{"hello": "world"}, nil
+		}))).
+		HealthCheck("failing", func() error {
+			return errors.New("failed")
+		})
+	done := make(chan error, 1)
+	go func() {
+		done <- b.run(nil)
+	}()
+
+	status, body := waitGet(t, fmt.Sprintf("http://localhost:%d/hello", port))
+	if status != http.StatusOK || body != `{"hello":"world"}`+"\n" {
+		t.Fatalf("unexpected response: %d %s", status, body)
+	}
+	status, body = waitGet(t, fmt.Sprintf("http://localhost:%d/ping", port+1))
+	if status != http.StatusOK || body != "pong\n" {
+		t.Fatalf("unexpected response: %d %s", status, body)
+	}
+	status, _ = waitGet(t, fmt.Sprintf("http://localhost:%d/healthcheck", port+1))
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected health check status: %d", status)
+	}
+
+	b.environment().Lifecycle.Shutdown("test", "")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("application is not stopped")
+	}
+}
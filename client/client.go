@@ -0,0 +1,165 @@
+/*
+Package client provides HTTP clients for calling other services, with
+timeouts, per-host metrics and logging of slow requests.
+
+Factory can be embedded in the application configuration:
+
+	type AppConfig struct {
+		melon.Configuration
+		Payments client.Factory
+	}
+
+Clients which are not built by Factory can be instrumented by wrapping their
+transports with NewTransport.
+*/
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server"
+)
+
+const (
+	defaultConnectTimeout = 5 * time.Second
+	defaultTimeout        = 30 * time.Second
+)
+
+// Factory is the configuration of an HTTP client.
+type Factory struct {
+	// Name identifies the client in metrics, logs and health checks.
+	Name string `valid:"notempty"`
+	// ConnectTimeout limits dialing connections, 5s by default.
+	ConnectTimeout string
+	// Timeout limits requests including reading response bodies, 30s by
+	// default.
+	Timeout string
+	// MaxIdleConnsPerHost is the number of idle connections kept for each
+	// host, 2 by default.
+	MaxIdleConnsPerHost int `valid:"min=0"`
+	// TLS configures connections to https servers.
+	TLS *server.TLSClientConfiguration
+	// Proxy is the URL of the proxy server. Proxy is read from environment
+	// variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY if it is empty.
+	Proxy string
+	// SlowRequestThreshold logs requests taking longer than it, e.g. 1s.
+	// Slow requests are not logged if it is empty.
+	SlowRequestThreshold string
+	// HealthCheckURL is requested to check health of the service. Health
+	// check is not registered when it is empty.
+	HealthCheckURL string
+}
+
+// Validate checks durations and URLs of the factory.
+func (f *Factory) Validate() error {
+	for _, d := range []struct {
+		name  string
+		value string
+	}{
+		{"ConnectTimeout", f.ConnectTimeout},
+		{"Timeout", f.Timeout},
+		{"SlowRequestThreshold", f.SlowRequestThreshold},
+	} {
+		if err := core.ValidateDuration(d.name, d.value); err != nil {
+			return err
+		}
+	}
+	if _, err := parseURL(f.Proxy); err != nil {
+		return fmt.Errorf("Proxy must be a URL: %s", f.Proxy)
+	}
+	if _, err := parseURL(f.HealthCheckURL); err != nil {
+		return fmt.Errorf("HealthCheckURL must be a URL: %s", f.HealthCheckURL)
+	}
+	return nil
+}
+
+// Build returns a new http.Client whose requests are recorded in env.Metrics.
+// Health check of the client is registered if HealthCheckURL is set.
+func (f *Factory) Build(env *core.Environment) (*http.Client, error) {
+	connectTimeout, err := core.ParseDuration(f.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid connect timeout of %s: %v", f.Name, err)
+	}
+	timeout, err := core.ParseDuration(f.Timeout, defaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid timeout of %s: %v", f.Name, err)
+	}
+	slowThreshold, err := core.ParseDuration(f.SlowRequestThreshold, 0)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid slow request threshold of %s: %v", f.Name, err)
+	}
+	proxy, err := parseURL(f.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid proxy of %s: %v", f.Name, err)
+	}
+	if _, err = parseURL(f.HealthCheckURL); err != nil {
+		return nil, fmt.Errorf("client: invalid health check URL of %s: %v", f.Name, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	if f.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = f.MaxIdleConnsPerHost
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if f.TLS != nil {
+		transport.TLSClientConfig, err = f.TLS.Build()
+		if err != nil {
+			return nil, fmt.Errorf("client: invalid TLS of %s: %v", f.Name, err)
+		}
+	}
+	client := &http.Client{
+		Transport: NewTransport(transport, env.Metrics, WithName(f.Name), WithSlowThreshold(slowThreshold)),
+		Timeout:   timeout,
+	}
+	if f.HealthCheckURL != "" {
+		env.Admin.HealthChecks.Register("client "+f.Name, &healthCheck{
+			url:    f.HealthCheckURL,
+			client: client,
+		})
+	}
+	return client, nil
+}
+
+// parseURL returns nil if value is empty.
+func parseURL(value string) (*url.URL, error) {
+	if value == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("missing scheme or host in %s", value)
+	}
+	return u, nil
+}
+
+// healthCheck is healthy when the service responds with status 2xx.
+type healthCheck struct {
+	url    string
+	client *http.Client
+}
+
+func (c *healthCheck) Check() health.Result {
+	rsp, err := c.client.Get(c.url)
+	if err != nil {
+		return health.ResultUnhealthy("service is unavailable", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return health.ResultUnhealthy(fmt.Sprintf("service responded %s", rsp.Status), nil)
+	}
+	return health.Healthy
+}
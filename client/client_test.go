@@ -0,0 +1,114 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+)
+
+func TestFactory(t *testing.T) {
+	metrics.Reset()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	env := core.NewEnvironment()
+	f := Factory{
+		Name:                 "test",
+		Timeout:              "5s",
+		MaxIdleConnsPerHost:  10,
+		SlowRequestThreshold: "1ns",
+		HealthCheckURL:       srv.URL + "/ping",
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := f.Build(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout != 5*time.Second {
+		t.Fatalf("unexpected timeout: %v", client.Timeout)
+	}
+	transport := client.Transport.(*transport).next.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 10 {
+		t.Fatalf("unexpected transport: %+v", transport)
+	}
+	rsp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	result, ok := env.Admin.HealthChecks.RunChecker("client test")
+	if !ok || !result.Healthy() {
+		t.Fatalf("unexpected health check result: %v %v", result, ok)
+	}
+	counters, _ := metrics.Snapshot()
+	if counters["HTTPClient.test.Requests."+host] != 2 {
+		t.Fatalf("unexpected counters: %v", counters)
+	}
+
+	f.Name = "down"
+	f.HealthCheckURL = srv.URL + "/down"
+	if _, err = f.Build(env); err != nil {
+		t.Fatal(err)
+	}
+	result, ok = env.Admin.HealthChecks.RunChecker("client down")
+	if !ok || result.Healthy() {
+		t.Fatalf("unexpected health check result: %v %v", result, ok)
+	}
+}
+
+func TestInvalidFactory(t *testing.T) {
+	factories := []Factory{
+		{Name: "a", Timeout: "1"},
+		{Name: "a", ConnectTimeout: "-1s"},
+		{Name: "a", Proxy: "localhost"},
+		{Name: "a", HealthCheckURL: "/ping"},
+	}
+	for _, f := range factories {
+		if err := f.Validate(); err == nil {
+			t.Fatalf("error expected for %+v", f)
+		}
+		if _, err := f.Build(core.NewEnvironment()); err == nil {
+			t.Fatalf("error expected for %+v", f)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransport(t *testing.T) {
+	metrics.Reset()
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "error" {
+			return nil, errors.New("refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: http.NoBody}, nil
+	})
+	rt := NewTransport(next, nil)
+	for _, host := range []string{"ok", "error", "error"} {
+		rt.RoundTrip(&http.Request{Method: "GET", URL: &url.URL{Scheme: "http", Host: host}})
+	}
+	counters, _ := metrics.Snapshot()
+	if counters["HTTPClient.Requests.ok"] != 1 || counters["HTTPClient.Errors.ok"] != 0 ||
+		counters["HTTPClient.Requests.error"] != 2 || counters["HTTPClient.Errors.error"] != 2 {
+		t.Fatalf("unexpected counters: %v", counters)
+	}
+}
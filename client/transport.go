@@ -0,0 +1,84 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+// transport records metrics and logs slow requests of the wrapped transport.
+type transport struct {
+	next    http.RoundTripper
+	metrics *core.MetricsRegistry
+	prefix  string
+	// slowThreshold is disabled if it is zero.
+	slowThreshold time.Duration
+}
+
+// Option is an option of the instrumented Transport.
+type Option func(t *transport)
+
+// WithName includes name in metrics of the transport, e.g.
+// HTTPClient.<name>.Requests.<host>.
+func WithName(name string) Option {
+	return func(t *transport) {
+		if name != "" {
+			t.prefix = "HTTPClient." + name + "."
+		}
+	}
+}
+
+// WithSlowThreshold logs requests taking longer than d.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(t *transport) {
+		t.slowThreshold = d
+	}
+}
+
+// NewTransport returns a RoundTripper which sends requests with next, or
+// http.DefaultTransport if it is nil, and records numbers of requests and
+// errors in counters HTTPClient.Requests.<host> and HTTPClient.Errors.<host>
+// and their latency in timer HTTPClient.Latency.<host> of registry m.
+func NewTransport(next http.RoundTripper, m *core.MetricsRegistry, options ...Option) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if m == nil {
+		m = core.NewMetricsRegistry()
+	}
+	t := &transport{
+		next:    next,
+		metrics: m,
+		prefix:  "HTTPClient.",
+	}
+	for _, opt := range options {
+		opt(t)
+	}
+	return t
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Host
+	start := time.Now()
+	rsp, err := t.next.RoundTrip(r)
+	elapsed := time.Since(start)
+	t.metrics.Counter(t.prefix + "Requests." + host).Inc(1)
+	t.metrics.Timer(t.prefix + "Latency." + host).Update(elapsed)
+	if err != nil {
+		t.metrics.Counter(t.prefix + "Errors." + host).Inc(1)
+	}
+	if t.slowThreshold > 0 && elapsed > t.slowThreshold {
+		status := "error"
+		if err == nil {
+			status = rsp.Status
+		}
+		core.GetLogger("melon/client").Warnf("slow request %s %s: %v, %s", r.Method, r.URL.Redacted(), elapsed, status)
+	}
+	return rsp, err
+}
+
+// Unwrap returns the wrapped transport.
+func (t *transport) Unwrap() http.RoundTripper {
+	return t.next
+}
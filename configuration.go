@@ -2,6 +2,8 @@ package melon
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/logging"
@@ -12,9 +14,20 @@ import (
 // Configuration is the default configuration that implements core.Configuration
 // interface.
 type Configuration struct {
+	// Mode is either production (default) or development, which adjusts
+	// defaults of other components.
+	Mode string
+	// Strict rejects unknown fields in the configuration file.
+	// The default depends on Mode.
+	Strict *bool
+
 	Server  server.Factory
 	Logging logging.Factory
 	Metrics metrics.Factory
+
+	Shutdown  ShutdownConfiguration
+	Lifecycle LifecycleConfiguration
+	Banner    BannerConfiguration
 }
 
 // Configuration implements core.Configuration interface.
@@ -35,6 +48,42 @@ func (c *Configuration) MetricsFactory() core.MetricsFactory {
 	return &c.Metrics
 }
 
+// ShutdownConfiguration returns configuration for shutdown reporting.
+func (c *Configuration) ShutdownConfiguration() *ShutdownConfiguration {
+	return &c.Shutdown
+}
+
+// EnvironmentMode returns the parsed Mode.
+func (c *Configuration) EnvironmentMode() (core.Mode, error) {
+	return core.ParseMode(c.Mode)
+}
+
+// StrictParsing returns whether unknown fields in the configuration file are
+// rejected.
+func (c *Configuration) StrictParsing() bool {
+	if c.Strict != nil {
+		return *c.Strict
+	}
+	mode, _ := c.EnvironmentMode()
+	return mode.Defaults().StrictParsing
+}
+
+// LifecycleConfiguration returns configuration for startup and shutdown timings.
+func (c *Configuration) LifecycleConfiguration() *LifecycleConfiguration {
+	return &c.Lifecycle
+}
+
+// BannerConfiguration returns configuration of the banner.
+func (c *Configuration) BannerConfiguration() *BannerConfiguration {
+	return &c.Banner
+}
+
+// modeConfigurable is implemented by configurations providing Mode of the
+// environment, e.g. Configuration.
+type modeConfigurable interface {
+	EnvironmentMode() (core.Mode, error)
+}
+
 // configurationCommand parses configuration.
 type configurationCommand struct {
 	// validator is created by bootstrap.ValidatorFactory.
@@ -54,10 +103,20 @@ func (command *configurationCommand) Run(bootstrap *core.Bootstrap) error {
 	if err != nil {
 		return err
 	}
-	err = command.validator.Validate(command.configuration)
+	return command.validate()
+}
+
+// validate validates the parsed configuration.
+func (command *configurationCommand) validate() error {
+	err := command.validator.Validate(command.configuration)
 	if err != nil {
 		return fmt.Errorf("configuration is invalid: %v", err)
 	}
+	if c, ok := command.configuration.(modeConfigurable); ok {
+		if _, err = c.EnvironmentMode(); err != nil {
+			return fmt.Errorf("configuration is invalid: %v", err)
+		}
+	}
 	// Configuration provided must implement core.Configuration interface.
 	if _, ok := command.configuration.(core.Configuration); !ok {
 		return fmt.Errorf("configuration does not implement core.Configuration interface %[1]v %[1]T", command.configuration)
@@ -65,9 +124,20 @@ func (command *configurationCommand) Run(bootstrap *core.Bootstrap) error {
 	return nil
 }
 
-// checkCommand is a command for validating configuration files.
+// checkCommand is a command for validating configuration files. It parses
+// and validates the configuration like serverCommand without building the
+// server or running bundles, e.g. in CI before deploying:
+//
+//	myapp check config.yaml
+//
+// It returns the error if the configuration is invalid so that the
+// application exits with a non-zero code.
 type checkCommand struct {
 	configurationCommand
+
+	// stdout and stderr are os.Stdout and os.Stderr if nil.
+	stdout io.Writer
+	stderr io.Writer
 }
 
 // Name returns name of this check command.
@@ -82,10 +152,17 @@ func (c *checkCommand) Description() string {
 
 // Run utilizes underlying configurationCommand to verify configuration file.
 func (c *checkCommand) Run(bootstrap *core.Bootstrap) error {
+	stdout, stderr := c.stdout, c.stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
 	if err := c.configurationCommand.Run(bootstrap); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(stderr, err)
 		return err
 	}
-	fmt.Println("configuration is OK")
+	fmt.Fprintln(stdout, "Configuration is valid")
 	return nil
 }
@@ -4,31 +4,63 @@ Package configuration provides JSON file support for application configuration.
 package configuration
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/goburrow/melon/core"
 )
 
+// EnvPath is the environment variable of the configuration file path, which
+// is used when the path is not given in command arguments.
+const EnvPath = "MELON_CONFIG"
+
 // Factory implements melon.ConfigurationFactory interface.
+//
+// The configuration is read from the first source found in order: the file
+// given in command arguments, the file in environment variable MELON_CONFIG,
+// the default path and the default content set by the application.
 type Factory struct {
 	// ref is the type/pointer of application configuration.
 	ref      interface{}
 	decoders map[string]func(io.Reader, interface{}) error
+	// strictDecoders reject unknown fields.
+	strictDecoders map[string]func(io.Reader, interface{}) error
+	// optional allows running without configuration file.
+	optional bool
+	// defaultPath is used when the file is not given and it exists.
+	defaultPath string
+	// defaultData is decoded by the decoder of defaultExt when no file is found.
+	defaultData []byte
+	defaultExt  string
+	// source describes where the configuration was read from.
+	source string
+}
+
+// strictConfigurable is implemented by configurations which can be parsed
+// strictly, e.g. melon.Configuration.
+type strictConfigurable interface {
+	StrictParsing() bool
 }
 
 // NewFactory creates a new core.ConfigurationFactory with given pointer to
 // configuration object.
 func NewFactory(ref interface{}) *Factory {
 	f := &Factory{
-		ref:      ref,
-		decoders: make(map[string]func(io.Reader, interface{}) error),
+		ref:            ref,
+		decoders:       make(map[string]func(io.Reader, interface{}) error),
+		strictDecoders: make(map[string]func(io.Reader, interface{}) error),
 	}
 	f.decoders[".js"] = unmarshalJSON
 	f.decoders[".json"] = unmarshalJSON
+	f.strictDecoders[".js"] = unmarshalStrictJSON
+	f.strictDecoders[".json"] = unmarshalStrictJSON
 	return f
 }
 
@@ -36,32 +68,116 @@ func (f *Factory) SetDecoder(ext string, decode func(io.Reader, interface{}) err
 	f.decoders[ext] = decode
 }
 
+// SetStrictDecoder sets the decoder which rejects unknown fields for files
+// with extension ext. Strict parsing is skipped for files without one.
+func (f *Factory) SetStrictDecoder(ext string, decode func(io.Reader, interface{}) error) {
+	f.strictDecoders[ext] = decode
+}
+
+// SetOptional sets whether the configuration file is optional. When it is
+// not specified, the configuration given to NewFactory is used as is.
+func (f *Factory) SetOptional(optional bool) {
+	f.optional = optional
+}
+
+// SetDefaultPath sets the configuration file used when it is given neither
+// in command arguments nor in environment variable MELON_CONFIG, e.g. the
+// path where containers mount the file. It is skipped if the file does not
+// exist.
+func (f *Factory) SetDefaultPath(path string) {
+	f.defaultPath = path
+}
+
+// SetDefault sets the configuration used when no file is found, e.g. an
+// embedded file. data is decoded in the format of file extension ext.
+func (f *Factory) SetDefault(data []byte, ext string) {
+	f.defaultData = data
+	f.defaultExt = ext
+}
+
+// Source returns where the configuration was read from by BuildConfiguration.
+func (f *Factory) Source() string {
+	return f.source
+}
+
 // BuildConfiguration parses configuration file and returns the factory configuration.
 func (f *Factory) BuildConfiguration(bootstrap *core.Bootstrap) (interface{}, error) {
-	if len(bootstrap.Arguments) < 2 {
-		return nil, fmt.Errorf("configuration: no file specified in command arguments")
+	if len(bootstrap.Arguments) >= 2 {
+		return f.parseFile(bootstrap.Arguments[1], "file "+bootstrap.Arguments[1])
+	}
+	tried := []string{"command arguments", "$" + EnvPath}
+	if path := os.Getenv(EnvPath); path != "" {
+		return f.parseFile(path, fmt.Sprintf("file %s ($%s)", path, EnvPath))
+	}
+	if f.defaultPath != "" {
+		if _, err := os.Stat(f.defaultPath); err == nil {
+			return f.parseFile(f.defaultPath, fmt.Sprintf("file %s (default)", f.defaultPath))
+		}
+		tried = append(tried, "default path "+f.defaultPath)
+	}
+	if f.defaultData != nil {
+		f.setSource("embedded default")
+		return f.Parse(f.defaultExt, f.defaultData)
+	}
+	if f.optional {
+		f.setSource("none")
+		return f.ref, nil
 	}
-	if err := f.unmarshal(bootstrap.Arguments[1], f.ref); err != nil {
+	return nil, fmt.Errorf("configuration: no file specified, tried: %s", strings.Join(tried, ", "))
+}
+
+// parseFile reads and parses configuration file path.
+func (f *Factory) parseFile(path, source string) (interface{}, error) {
+	ext := filepath.Ext(path)
+	if f.decoders[ext] == nil {
+		return nil, fmt.Errorf("configuration: unsupported file extention %s", ext)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
 		return nil, fmt.Errorf("configuration: %v", err)
 	}
+	f.setSource(source)
+	return f.Parse(ext, data)
+}
+
+func (f *Factory) setSource(source string) {
+	f.source = source
+	core.GetLogger("melon/configuration").Infof("configuration source: %s", source)
+}
+
+// Parse decodes data in the format of file extension ext, e.g. ".json", and
+// returns the factory configuration.
+func (f *Factory) Parse(ext string, data []byte) (interface{}, error) {
+	if err := f.unmarshal(ext, data, f.ref, f.decoders); err != nil {
+		return nil, fmt.Errorf("configuration: %v", err)
+	}
+	if c, ok := f.ref.(strictConfigurable); ok && c.StrictParsing() {
+		if f.strictDecoders[ext] != nil {
+			// Decode again to a new value so that the result is not affected.
+			v := reflect.New(reflect.TypeOf(f.ref).Elem()).Interface()
+			if err := f.unmarshal(ext, data, v, f.strictDecoders); err != nil {
+				return nil, fmt.Errorf("configuration: %v", err)
+			}
+		}
+	}
 	return f.ref, nil
 }
 
-// unmarshal decodes the given file to output type.
-func (f *Factory) unmarshal(path string, output interface{}) error {
-	ext := filepath.Ext(path)
-	decoder := f.decoders[ext]
+// unmarshal decodes data to output using the decoder of extension ext.
+func (f *Factory) unmarshal(ext string, data []byte, output interface{}, decoders map[string]func(io.Reader, interface{}) error) error {
+	decoder := decoders[ext]
 	if decoder == nil {
 		return fmt.Errorf("unsupported file extention %s", ext)
 	}
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	return decoder(file, output)
+	return decoder(bytes.NewReader(data), output)
 }
 
 func unmarshalJSON(r io.Reader, output interface{}) error {
 	return json.NewDecoder(r).Decode(output)
 }
+
+func unmarshalStrictJSON(r io.Reader, output interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(output)
+}
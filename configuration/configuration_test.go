@@ -1,6 +1,9 @@
 package configuration
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/goburrow/melon/core"
@@ -37,6 +40,7 @@ type metricsConfiguration struct {
 }
 
 func TestMissingArgument(t *testing.T) {
+	t.Setenv(EnvPath, "")
 	bootstrap := core.Bootstrap{
 		Arguments: []string{"server"},
 	}
@@ -45,9 +49,17 @@ func TestMissingArgument(t *testing.T) {
 	if err == nil {
 		t.Fatal("error expected")
 	}
-	if err.Error() != "configuration: no file specified in command arguments" {
+	if err.Error() != "configuration: no file specified, tried: command arguments, $MELON_CONFIG" {
 		t.Fatalf("unexpected error message: actual=%v", err.Error())
 	}
+	// Configuration is used as is when file is optional.
+	ref := &configuration{}
+	factory = NewFactory(ref)
+	factory.SetOptional(true)
+	c, err := factory.BuildConfiguration(&bootstrap)
+	if err != nil || c != ref {
+		t.Fatalf("unexpected configuration: %v %v", c, err)
+	}
 }
 
 func TestLoadJSON(t *testing.T) {
@@ -57,6 +69,84 @@ func TestLoadJSON(t *testing.T) {
 	testFactory(t, &bootstrap)
 }
 
+func TestResolvePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, level string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(`{"Logging":{"Level":"`+level+`"}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	argPath := writeFile("arg.json", "arg")
+	envPath := writeFile("env.json", "env")
+	defaultPath := writeFile("default.json", "default")
+	missingPath := filepath.Join(dir, "missing.json")
+	embedded := []byte(`{"Logging":{"Level":"embedded"}}`)
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         string
+		defaultPath string
+		level       string
+		source      string
+	}{
+		{"arguments", []string{"server", argPath}, envPath, defaultPath, "arg", "file " + argPath},
+		{"environment", []string{"server"}, envPath, defaultPath, "env", "file " + envPath + " ($MELON_CONFIG)"},
+		{"default path", []string{"server"}, "", defaultPath, "default", "file " + defaultPath + " (default)"},
+		{"embedded", []string{"server"}, "", missingPath, "embedded", "embedded default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(EnvPath, test.env)
+			factory := NewFactory(&configuration{})
+			factory.SetDefaultPath(test.defaultPath)
+			factory.SetDefault(embedded, ".json")
+			c, err := factory.BuildConfiguration(&core.Bootstrap{Arguments: test.args})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if level := c.(*configuration).Logging.Level; level != test.level {
+				t.Fatalf("unexpected level: %s", level)
+			}
+			if factory.Source() != test.source {
+				t.Fatalf("unexpected source: %s", factory.Source())
+			}
+		})
+	}
+}
+
+func TestResolvePathError(t *testing.T) {
+	t.Setenv(EnvPath, "")
+	dir, err := ioutil.TempDir("", "configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missingPath := filepath.Join(dir, "missing.json")
+	factory := NewFactory(&configuration{})
+	factory.SetDefaultPath(missingPath)
+	_, err = factory.BuildConfiguration(&core.Bootstrap{Arguments: []string{"server"}})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	expected := "configuration: no file specified, tried: command arguments, $MELON_CONFIG, default path " + missingPath
+	if err.Error() != expected {
+		t.Fatalf("unexpected error message: actual=%v", err)
+	}
+	// File in environment variable must exist.
+	t.Setenv(EnvPath, missingPath)
+	factory.SetDefault([]byte(`{}`), ".json")
+	if _, err = factory.BuildConfiguration(&core.Bootstrap{Arguments: []string{"server"}}); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
 func testFactory(t *testing.T, bootstrap *core.Bootstrap) {
 	factory := NewFactory(&configuration{})
 	c, err := factory.BuildConfiguration(bootstrap)
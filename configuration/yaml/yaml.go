@@ -1,6 +1,8 @@
 package yaml
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 
@@ -16,6 +18,8 @@ func (b *bundle) Initialize(bootstrap *core.Bootstrap) {
 	if ok {
 		f.SetDecoder(".yml", unmarshalYAML)
 		f.SetDecoder(".yaml", unmarshalYAML)
+		f.SetStrictDecoder(".yml", unmarshalStrictYAML)
+		f.SetStrictDecoder(".yaml", unmarshalStrictYAML)
 	}
 }
 
@@ -31,6 +35,20 @@ func unmarshalYAML(r io.Reader, output interface{}) error {
 	return yaml.Unmarshal(content, output)
 }
 
+func unmarshalStrictYAML(r io.Reader, output interface{}) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	content, err = yaml.YAMLToJSON(content)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(output)
+}
+
 // NewBundle creates a Bundle that adds support for YAML configuration file.
 func NewBundle() core.Bundle {
 	return &bundle{}
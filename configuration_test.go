@@ -0,0 +1,88 @@
+package melon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goburrow/melon/configuration"
+	"github.com/goburrow/melon/core"
+)
+
+func TestConfigurationStrictParsing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "melon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		content string
+		valid   bool
+	}{
+		{`{"unknown": 1}`, false},
+		{`{"mode": "production", "unknown": 1}`, false},
+		{`{"mode": "development", "unknown": 1}`, true},
+		{`{"strict": false, "unknown": 1}`, true},
+		{`{"mode": "development", "strict": true, "unknown": 1}`, false},
+		{`{"mode": "production"}`, true},
+	}
+	for i, test := range tests {
+		file := filepath.Join(dir, "config.json")
+		if err = ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		factory := configuration.NewFactory(&Configuration{})
+		_, err = factory.BuildConfiguration(&core.Bootstrap{Arguments: []string{"server", file}})
+		if (err == nil) != test.valid {
+			t.Fatalf("%d: unexpected error for %s: %v", i, test.content, err)
+		}
+	}
+}
+
+func TestConfigurationMode(t *testing.T) {
+	c := &Configuration{}
+	if mode, err := c.EnvironmentMode(); err != nil || mode != core.ModeProduction {
+		t.Fatalf("unexpected mode: %v %v", mode, err)
+	}
+	c.Mode = "development"
+	if mode, err := c.EnvironmentMode(); err != nil || mode != core.ModeDevelopment {
+		t.Fatalf("unexpected mode: %v %v", mode, err)
+	}
+	c.Mode = "test"
+	if _, err := c.EnvironmentMode(); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "melon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		content string
+		output  string
+	}{
+		{`{"server": {"type": "SimpleServer"}}`, "Configuration is valid\n"},
+		{`{"server": `, "configuration: unexpected EOF\n"},
+		{`{"metrics": {"slo": {"routes": [{"pattern": "/", "availability": 0.9}, {"pattern": "/a", "availability": 2}]}}}`,
+			"configuration is invalid: Metrics.SLO.Routes[1]: Availability is out of range\n"},
+	}
+	for i, test := range tests {
+		file := filepath.Join(dir, "config.json")
+		if err = ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		command := &checkCommand{stdout: &out, stderr: &out}
+		err = command.Run(newBootstrap(nil, []string{"check", file}))
+		if (err == nil) != (i == 0) || out.String() != test.output {
+			t.Fatalf("%d: unexpected output: %q %v", i, out.String(), err)
+		}
+	}
+}
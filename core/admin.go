@@ -2,27 +2,45 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/goburrow/melon/health"
 )
 
 const (
-	pingPath        = "/ping"
-	runtimePath     = "/runtime"
-	healthCheckPath = "/healthcheck"
-	tasksPath       = "/tasks"
+	pingPath          = "/ping"
+	runtimePath       = "/runtime"
+	threadsPath       = "/threads"
+	healthCheckPath   = "/healthcheck"
+	healthHistoryPath = "/healthcheck/history"
+	tasksPath         = "/tasks"
+	endpointsPath     = "/endpoints"
 
 	adminHTML = `<!DOCTYPE html>
 <html>
 <head>
-	<title>Operational Menu</title>
+	<title>{{if .Name}}{{.Name}} {{end}}Operational Menu</title>
 </head>
 <body>
-	<h1>Operational Menu</h1>
-	<ul>%[1]s</ul>
+	<h1>{{if .Name}}{{.Name}} {{end}}Operational Menu</h1>
+	<ul>{{range .Handlers}}<li><a href="{{.URL}}">{{.Name}}</a></li>{{end}}</ul>
+	{{- if .Tasks}}
+	<h2>Tasks</h2>
+	<ul>{{range .Tasks}}<li><form method="post" action="{{.URL}}"><button type="submit">{{.Name}}</button> POST {{.URL}}</form></li>{{end}}</ul>
+	{{- end}}
 </body>
 </html>
 `
@@ -32,7 +50,14 @@ const (
 !!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!
 `
 
-	gcTaskName = "gc"
+	gcTaskName                 = "gc"
+	freeOSMemoryTaskName       = "free-os-memory"
+	heapProfileTaskName        = "heap-profile"
+	cpuProfileTaskName         = "cpu-profile"
+	clearHealthHistoryTaskName = "clear-health-history"
+
+	defaultCPUProfileDuration    = 30 * time.Second
+	defaultMaxCPUProfileDuration = 5 * time.Minute
 )
 
 // AdminHandler is an item listed in the admin homepage.
@@ -49,36 +74,124 @@ type AdminEnvironment struct {
 
 	handlers []AdminHandler
 	tasks    []Task
+	// taskHistory records executions of tasks.
+	taskHistory *taskHistory
+	// auth is set by SetAuth.
+	auth *adminAuth
+	// cpuProfile is the cpu-profile task.
+	cpuProfile *cpuProfileTask
+	// name is the application name shown in the index page.
+	name string
+	// indexTemplate renders the index page.
+	indexTemplate *template.Template
+
+	// parent is set when this environment is mounted to another one.
+	parent *AdminEnvironment
+	prefix string
 }
 
 // NewAdminEnvironment allocates and returns a new AdminEnvironment.
 func NewAdminEnvironment() *AdminEnvironment {
 	env := &AdminEnvironment{
 		HealthChecks: health.NewRegistry(),
+		taskHistory:  &taskHistory{},
+		cpuProfile:   &cpuProfileTask{maxDuration: defaultMaxCPUProfileDuration},
 	}
 	// Default handlers
-	env.AddHandler(&pingHandler{}, &runtimeHandler{}, &healthCheckHandler{env.HealthChecks})
+	env.AddHandler(&pingHandler{}, &runtimeHandler{}, &threadsHandler{}, &versionHandler{},
+		&healthCheckHandler{env.HealthChecks}, &healthHistoryHandler{env.HealthChecks},
+		&tasksHandler{env: env}, &taskHistoryHandler{env.taskHistory})
 	// Default tasks
-	env.AddTask(&gcTask{})
+	env.AddParamTask(&gcTask{}, &freeOSMemoryTask{})
+	env.AddTask(&heapProfileTask{}, env.cpuProfile, &clearHealthHistoryTask{env.HealthChecks}, &logLevelTask{})
 	return env
 }
 
+// SetMaxCPUProfileDuration limits the duration of CPU profiles requested
+// with the cpu-profile task, which is 5 minutes by default.
+func (env *AdminEnvironment) SetMaxCPUProfileDuration(d time.Duration) {
+	if env.parent != nil {
+		env.parent.SetMaxCPUProfileDuration(d)
+		return
+	}
+	env.cpuProfile.maxDuration = d
+}
+
+// SetApplicationName sets the application name shown in the index page,
+// which is the executable name by default.
+func (env *AdminEnvironment) SetApplicationName(name string) {
+	if env.parent != nil {
+		env.parent.SetApplicationName(name)
+		return
+	}
+	env.name = name
+}
+
+// SetIndexTemplate replaces the layout of the index page, e.g. for branding.
+// The template is executed with AdminIndexData.
+func (env *AdminEnvironment) SetIndexTemplate(t *template.Template) {
+	if env.parent != nil {
+		env.parent.SetIndexTemplate(t)
+		return
+	}
+	env.indexTemplate = t
+}
+
 // AddTask adds a new task to admin environment. AddTask is not concurrent-safe.
 func (env *AdminEnvironment) AddTask(task ...Task) {
+	if env.parent != nil {
+		for _, t := range task {
+			env.parent.AddTask(&mountedTask{Task: t, prefix: env.prefix})
+		}
+		return
+	}
 	env.tasks = append(env.tasks, task...)
 }
 
 // AddHandler registers a handler entry for admin page.
 func (env *AdminEnvironment) AddHandler(handler ...AdminHandler) {
+	if env.parent != nil {
+		for _, h := range handler {
+			env.parent.AddHandler(&mountedHandler{AdminHandler: h, prefix: env.prefix})
+		}
+		return
+	}
 	env.handlers = append(env.handlers, handler...)
 }
 
+// validate checks for duplicated handler paths and task names, which may
+// happen when environments are mounted.
+func (env *AdminEnvironment) validate() error {
+	paths := make(map[string]struct{}, len(env.handlers))
+	for _, h := range env.handlers {
+		if _, ok := paths[h.Path()]; ok {
+			return fmt.Errorf("admin: duplicated handler %s", h.Path())
+		}
+		paths[h.Path()] = struct{}{}
+	}
+	names := make(map[string]struct{}, len(env.tasks))
+	for _, task := range env.tasks {
+		if _, ok := names[task.Name()]; ok {
+			return fmt.Errorf("admin: duplicated task %s", task.Name())
+		}
+		names[task.Name()] = struct{}{}
+	}
+	if env.auth != nil {
+		if _, ok := env.Router.(filterRouter); !ok {
+			return fmt.Errorf("admin: router %T does not support authentication", env.Router)
+		}
+	}
+	return nil
+}
+
 // start registers all required HTTP handlers
 func (env *AdminEnvironment) start() {
-	env.Router.Handle("GET", "/", &adminIndex{
-		handlers:    env.handlers,
-		contextPath: env.Router.PathPrefix(),
-	})
+	// Authentication also applies to handlers registered directly to the
+	// router. validate ensures the router supports filters.
+	if router, ok := env.Router.(filterRouter); ok && env.auth != nil {
+		router.AddFilter(env.auth)
+	}
+	env.Router.Handle("GET", "/", &adminIndex{env: env})
 	// Registered handlers
 	for _, h := range env.handlers {
 		env.Router.Handle("*", h.Path(), h)
@@ -86,7 +199,9 @@ func (env *AdminEnvironment) start() {
 	// Registered tasks
 	for _, task := range env.tasks {
 		path := tasksPath + "/" + task.Name()
-		env.Router.Handle("POST", path, task)
+		h := newTaskHandler(task)
+		h.history = env.taskHistory
+		env.Router.Handle("POST", path, h)
 	}
 	env.logTasks()
 	env.logHealthChecks()
@@ -96,8 +211,15 @@ func (env *AdminEnvironment) start() {
 func (env *AdminEnvironment) logTasks() {
 	var buf bytes.Buffer
 	for _, task := range env.tasks {
+		var impl interface{} = task
+		if t, ok := impl.(*exclusiveTask); ok {
+			impl = t.Task
+		}
+		if t, ok := impl.(*paramTask); ok {
+			impl = t.ParamTask
+		}
 		fmt.Fprintf(&buf, "    %-7s %s%s/%s (%T)\n", "POST",
-			env.Router.PathPrefix(), tasksPath, task.Name(), task)
+			env.Router.PathPrefix(), tasksPath, task.Name(), impl)
 	}
 	GetLogger("melon").Infof("tasks =\n\n%s", buf.String())
 }
@@ -107,36 +229,77 @@ func (env *AdminEnvironment) logHealthChecks() {
 	names := env.HealthChecks.Names()
 	logger := GetLogger("melon")
 	logger.Debugf("health checks = %v", names)
-	if len(names) <= 0 {
+	// Readiness is registered by default.
+	n := 0
+	for _, name := range names {
+		if name != ReadinessHealthCheck {
+			n++
+		}
+	}
+	if n == 0 {
 		logger.Warnf(noHealthChecksWarning)
 	}
 }
 
-// Task is simply a HTTP Handler.
+// Task is simply a HTTP Handler. Its responses are text/plain by default,
+// tasks may declare another content type with a ContentType() string method.
 type Task interface {
 	Name() string
 	http.Handler
 }
 
+// AdminIndexData is the data of the admin index template.
+type AdminIndexData struct {
+	// Name is the application name.
+	Name string
+	// Handlers are the admin pages.
+	Handlers []AdminLink
+	// Tasks are triggered by POST requests to their URLs.
+	Tasks []AdminLink
+}
+
+// AdminLink is a handler or a task listed in the admin index page.
+type AdminLink struct {
+	Name string
+	URL  string
+}
+
+var adminIndexTemplate = template.Must(template.New("admin").Parse(adminHTML))
+
 // adminIndex is the home page of admin.
 type adminIndex struct {
-	handlers    []AdminHandler
-	contextPath string
+	env *AdminEnvironment
 }
 
 // ServeHTTP handles request to the root of Admin page
 func (handler *adminIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	env := handler.env
+	data := AdminIndexData{
+		Name: env.name,
+	}
+	if data.Name == "" {
+		data.Name = applicationName()
+	}
+	contextPath := env.Router.PathPrefix()
+	for _, h := range env.handlers {
+		data.Handlers = append(data.Handlers, AdminLink{Name: h.Name(), URL: contextPath + h.Path()})
+	}
+	for _, t := range env.tasks {
+		data.Tasks = append(data.Tasks, AdminLink{Name: t.Name(), URL: contextPath + tasksPath + "/" + t.Name()})
+	}
+	tmpl := env.indexTemplate
+	if tmpl == nil {
+		tmpl = adminIndexTemplate
+	}
 	var buf bytes.Buffer
-
-	for _, h := range handler.handlers {
-		fmt.Fprintf(&buf, "<li><a href=\"%[1]s%[2]s\">%[3]s</a></li>",
-			handler.contextPath, h.Path(), h.Name())
+	if err := tmpl.Execute(&buf, &data); err != nil {
+		GetLogger("melon").Errorf("could not render admin index: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
-
 	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
 	w.Header().Set("Content-Type", "text/html")
-
-	fmt.Fprintf(w, adminHTML, buf.String())
+	w.Write(buf.Bytes())
 }
 
 // healthCheckHandler is the http handler for /healthcheck page
@@ -152,10 +315,30 @@ func (handler *healthCheckHandler) Path() string {
 	return healthCheckPath
 }
 
+// ServeHTTP runs all health checks, or only the one given in query name.
+// Cached results are ignored with query force=true.
 func (handler *healthCheckHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
 
-	results := handler.registry.RunCheckers()
+	runChecker, runCheckers := handler.registry.RunChecker, handler.registry.RunCheckers
+	if r.URL.Query().Get("force") == "true" {
+		if registry, ok := handler.registry.(health.CacheRegistry); ok {
+			runChecker, runCheckers = registry.ForceRunChecker, registry.ForceRunCheckers
+		}
+	}
+	var results map[string]health.Result
+	if name := r.URL.Query().Get("name"); name != "" {
+		result, ok := runChecker(name)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "health check " + name + " not found"})
+			return
+		}
+		results = map[string]health.Result{name: result}
+	} else {
+		results = runCheckers()
+	}
 	if len(results) == 0 {
 		http.Error(w, "No health checks registered.", http.StatusNotImplemented)
 		return
@@ -164,36 +347,109 @@ func (handler *healthCheckHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 	if !isAllHealthy(results) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
-	first := true
-	w.Write([]byte("{"))
+	response := make(map[string]healthCheckResult, len(results))
 	for name, result := range results {
-		if first {
-			first = false
-		} else {
-			w.Write([]byte(","))
-		}
-		fmt.Fprintf(w, "\n%q: {\"Healthy\": %t", name, result.Healthy())
-		if result.Message() != "" {
-			fmt.Fprintf(w, ", \"Message\": %q", result.Message())
+		r := healthCheckResult{
+			Healthy:  result.Healthy(),
+			Critical: health.Critical(result),
+			Message:  result.Message(),
 		}
 		if result.Cause() != nil {
-			fmt.Fprintf(w, ", \"Cause\": %q", result.Cause())
+			r.Cause = result.Cause().Error()
+		}
+		if d := health.Duration(result); d > 0 {
+			r.Duration = d.String()
+		}
+		if ts := health.Timestamp(result); !ts.IsZero() {
+			r.Timestamp = ts.UTC().Format(time.RFC3339Nano)
 		}
-		w.Write([]byte("}"))
+		response[name] = r
 	}
-	w.Write([]byte("\n}\n"))
+	// Map keys are sorted by encoding/json.
+	enc := json.NewEncoder(w)
+	if r.URL.Query().Get("pretty") == "true" {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(response)
+}
+
+// healthCheckResult is the response of a health check.
+type healthCheckResult struct {
+	Healthy  bool
+	Critical bool
+	Message  string `json:",omitempty"`
+	Cause    string `json:",omitempty"`
+	Duration string `json:",omitempty"`
+	// Timestamp is when the result was computed.
+	Timestamp string `json:",omitempty"`
 }
 
-// isAllHealthy checks if all are healthy
+// isAllHealthy checks if all critical results are healthy.
 func isAllHealthy(results map[string]health.Result) bool {
 	for _, result := range results {
-		if !result.Healthy() {
+		if !result.Healthy() && health.Critical(result) {
 			return false
 		}
 	}
 	return true
 }
 
+// healthHistoryHandler displays transitions of health checks.
+type healthHistoryHandler struct {
+	registry health.Registry
+}
+
+func (handler *healthHistoryHandler) Name() string {
+	return "Healthcheck history"
+}
+
+func (handler *healthHistoryHandler) Path() string {
+	return healthHistoryPath
+}
+
+func (handler *healthHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+
+	registry, ok := handler.registry.(health.HistoryRegistry)
+	if !ok {
+		http.Error(w, "Health check history is not supported.", http.StatusNotImplemented)
+		return
+	}
+	type transition struct {
+		Healthy  bool
+		Message  string `json:",omitempty"`
+		Time     time.Time
+		Duration string
+	}
+	type check struct {
+		Healthy     bool
+		Flaps       int
+		Transitions []transition
+	}
+	checks := registry.History().Checks()
+	result := make(map[string]check, len(checks))
+	for name, c := range checks {
+		transitions := make([]transition, len(c.Transitions))
+		for i, t := range c.Transitions {
+			transitions[i] = transition{
+				Healthy:  t.Healthy,
+				Message:  t.Message,
+				Time:     t.Time,
+				Duration: t.Duration.String(),
+			}
+		}
+		result[name] = check{
+			Healthy:     c.Healthy,
+			Flaps:       c.Flaps,
+			Transitions: transitions,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
+
 // pingHandler handles ping request to admin /ping
 type pingHandler struct {
 }
@@ -212,7 +468,8 @@ func (handler *pingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("pong\n"))
 }
 
-// runtimeHandler displays runtime statistics.
+// runtimeHandler displays runtime statistics. It responds JSON when requested
+// with Accept: application/json or query format=json.
 type runtimeHandler struct {
 }
 
@@ -226,6 +483,10 @@ func (handler *runtimeHandler) Path() string {
 
 func (handler *runtimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		handler.serveJSON(w)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
 
 	fmt.Fprintf(w, "GOARCH: %s\nGOOS: %s\nVersion: %s\nNumCPU: %d\nNumCgoCall: %d\nNumGoroutine: %d\n",
@@ -248,7 +509,108 @@ func (handler *runtimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		m.NextGC, m.LastGC, m.PauseTotalNs, m.NumGC, m.EnableGC, m.DebugGC)
 }
 
-// gcTask performs a garbage collection
+func (handler *runtimeHandler) serveJSON(w http.ResponseWriter) {
+	// MemStats is embedded so that its fields are keyed by their names.
+	stats := struct {
+		GOARCH       string
+		GOOS         string
+		Version      string
+		NumCPU       int
+		NumCgoCall   int64
+		NumGoroutine int
+		runtime.MemStats
+	}{
+		GOARCH:       runtime.GOARCH,
+		GOOS:         runtime.GOOS,
+		Version:      runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		NumCgoCall:   runtime.NumCgoCall(),
+		NumGoroutine: runtime.NumGoroutine(),
+	}
+	runtime.ReadMemStats(&stats.MemStats)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&stats)
+}
+
+// threadsHandler dumps stacks of all goroutines. With query debug=N, it
+// writes the goroutine profile in pprof debug format N instead, e.g. debug=1
+// groups goroutines with the same stack.
+type threadsHandler struct {
+}
+
+func (handler *threadsHandler) Name() string {
+	return "Threads"
+}
+
+func (handler *threadsHandler) Path() string {
+	return threadsPath
+}
+
+func (handler *threadsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if v := r.URL.Query().Get("debug"); v != "" {
+		debug, err := strconv.Atoi(v)
+		if err != nil || debug < 0 {
+			http.Error(w, "invalid debug "+v, http.StatusBadRequest)
+			return
+		}
+		pprof.Lookup("goroutine").WriteTo(w, debug)
+		return
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			w.Write(buf[:n])
+			return
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// endpointsHandler lists application endpoints in the order they are matched.
+// Routes are listed in JSON with query format=json.
+type endpointsHandler struct {
+	server      *ServerEnvironment
+	content     staticContent
+	jsonContent staticContent
+}
+
+func (handler *endpointsHandler) Name() string {
+	return "Endpoints"
+}
+
+func (handler *endpointsHandler) Path() string {
+	return endpointsPath
+}
+
+func (handler *endpointsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Endpoints do not change after startup.
+	if r.URL.Query().Get("format") == "json" {
+		handler.jsonContent.serve(w, r, "application/json", func(w io.Writer) {
+			routes := handler.server.Routes()
+			if routes == nil {
+				routes = []RouteInfo{}
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(routes)
+		})
+		return
+	}
+	handler.content.serve(w, r, "text/plain", func(w io.Writer) {
+		if handler.server.Router == nil {
+			return
+		}
+		for _, e := range handler.server.Router.Endpoints() {
+			fmt.Fprintln(w, e)
+		}
+	})
+}
+
+// gcTask performs a garbage collection. With verbose=true, heap statistics
+// before and after the collection are also written.
 type gcTask struct {
 }
 
@@ -256,8 +618,160 @@ func (*gcTask) Name() string {
 	return gcTaskName
 }
 
-func (*gcTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Running GC...\n"))
+func (*gcTask) Execute(params url.Values, out io.Writer) error {
+	verbose := params.Get("verbose") == "true"
+	var m runtime.MemStats
+	if verbose {
+		runtime.ReadMemStats(&m)
+		fmt.Fprintf(out, "Before: HeapAlloc: %d HeapObjects: %d NumGC: %d\n", m.HeapAlloc, m.HeapObjects, m.NumGC)
+	}
+	io.WriteString(out, "Running GC...\n")
 	runtime.GC()
-	w.Write([]byte("Done!\n"))
+	if verbose {
+		runtime.ReadMemStats(&m)
+		fmt.Fprintf(out, "After: HeapAlloc: %d HeapObjects: %d NumGC: %d\n", m.HeapAlloc, m.HeapObjects, m.NumGC)
+	}
+	io.WriteString(out, "Done!\n")
+	return nil
+}
+
+// freeOSMemoryTask forces a garbage collection and returns as much memory to
+// the operating system as possible.
+type freeOSMemoryTask struct {
+}
+
+func (*freeOSMemoryTask) Name() string {
+	return freeOSMemoryTaskName
+}
+
+func (*freeOSMemoryTask) Execute(params url.Values, out io.Writer) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Fprintf(out, "Before: HeapReleased: %d HeapIdle: %d Sys: %d\n", m.HeapReleased, m.HeapIdle, m.Sys)
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&m)
+	fmt.Fprintf(out, "After: HeapReleased: %d HeapIdle: %d Sys: %d\n", m.HeapReleased, m.HeapIdle, m.Sys)
+	return nil
+}
+
+// heapProfileTask writes a heap profile in pprof format. With query file, the
+// profile is written to the file on the server, which must not exist,
+// instead of the response. With gc=true, garbage collection runs first so the
+// profile is up to date.
+type heapProfileTask struct {
+}
+
+func (*heapProfileTask) Name() string {
+	return heapProfileTaskName
+}
+
+func (*heapProfileTask) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (task *heapProfileTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("gc") == "true" {
+		runtime.GC()
+	}
+	profile := pprof.Lookup("heap")
+	name := r.Form.Get("file")
+	if name == "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="heap.pb.gz"`)
+		if err := profile.WriteTo(w, 0); err != nil {
+			GetLogger("melon").Errorf("could not write heap profile: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", defaultTaskContentType)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		http.Error(w, "Could not create heap profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = profile.WriteTo(f, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		http.Error(w, "Could not write heap profile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Heap profile written to %s\n", name)
+}
+
+// cpuProfileTask responds a CPU profile in pprof format collected for query
+// duration, 30 seconds by default. Only one CPU profile can run at a time.
+type cpuProfileTask struct {
+	maxDuration time.Duration
+	running     int32
+}
+
+func (*cpuProfileTask) Name() string {
+	return cpuProfileTaskName
+}
+
+func (*cpuProfileTask) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (task *cpuProfileTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	duration := defaultCPUProfileDuration
+	if v := r.FormValue("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid duration %q.", v), http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+	if task.maxDuration > 0 && duration > task.maxDuration {
+		duration = task.maxDuration
+	}
+	if !atomic.CompareAndSwapInt32(&task.running, 0, 1) {
+		http.Error(w, "A CPU profile is already running.", http.StatusConflict)
+		return
+	}
+	defer atomic.StoreInt32(&task.running, 0)
+	w.Header().Set("Content-Disposition", `attachment; filename="cpu.pb.gz"`)
+	// Profiling may also have been started elsewhere, e.g. net/http/pprof.
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Could not start CPU profile: "+err.Error(), http.StatusConflict)
+		return
+	}
+	t := time.NewTimer(duration)
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+		// Profile collected so far is still written.
+		t.Stop()
+	}
+	pprof.StopCPUProfile()
+}
+
+// clearHealthHistoryTask resets history of health checks.
+type clearHealthHistoryTask struct {
+	registry health.Registry
+}
+
+func (*clearHealthHistoryTask) Name() string {
+	return clearHealthHistoryTaskName
+}
+
+func (task *clearHealthHistoryTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	registry, ok := task.registry.(health.HistoryRegistry)
+	if !ok {
+		http.Error(w, "Health check history is not supported.", http.StatusNotImplemented)
+		return
+	}
+	if TaskCancelled(w, r) {
+		return
+	}
+	registry.History().Reset()
+	w.Write([]byte("Health check history cleared.\n"))
 }
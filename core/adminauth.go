@@ -0,0 +1,70 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// filterRouter is a Router supporting filters, which is required by
+// AdminEnvironment.SetAuth.
+type filterRouter interface {
+	Router
+	AddFilter(f filter.Filter)
+}
+
+// adminAuth requires HTTP Basic authentication for admin requests.
+// Credentials are kept as SHA-256 hashes so they are compared in constant
+// time regardless of their lengths.
+type adminAuth struct {
+	username [sha256.Size]byte
+	password [sha256.Size]byte
+	exempt   map[string]bool
+}
+
+// SetAuth requires HTTP Basic authentication with the given credentials for
+// all admin handlers and tasks, except for exemptPaths, e.g. "/ping" for load
+// balancers. It applies to the root admin environment when env is mounted.
+// The admin router must support filters, otherwise the environment fails to
+// start.
+func (env *AdminEnvironment) SetAuth(username, password string, exemptPaths ...string) {
+	if env.parent != nil {
+		prefixed := make([]string, len(exemptPaths))
+		for i, p := range exemptPaths {
+			prefixed[i] = env.prefix + p
+		}
+		env.parent.SetAuth(username, password, prefixed...)
+		return
+	}
+	auth := &adminAuth{
+		username: sha256.Sum256([]byte(username)),
+		password: sha256.Sum256([]byte(password)),
+		exempt:   make(map[string]bool, len(exemptPaths)),
+	}
+	for _, p := range exemptPaths {
+		auth.exempt[p] = true
+	}
+	env.auth = auth
+}
+
+func (a *adminAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.exempt[r.URL.Path] || a.authenticated(r) {
+		filter.Continue(w, r)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+	http.Error(w, "Credentials are required to access this resource.", http.StatusUnauthorized)
+}
+
+func (a *adminAuth) authenticated(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	u := sha256.Sum256([]byte(username))
+	p := sha256.Sum256([]byte(password))
+	// Both are always compared.
+	return subtle.ConstantTimeCompare(u[:], a.username[:])&subtle.ConstantTimeCompare(p[:], a.password[:]) == 1
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// chainRouter is a muxRouter supporting filters.
+type chainRouter struct {
+	muxRouter
+	chain *filter.Chain
+}
+
+func newChainRouter() *chainRouter {
+	r := &chainRouter{muxRouter: muxRouter{http.NewServeMux()}, chain: filter.NewChain()}
+	r.chain.Add(r.ServeMux)
+	return r
+}
+
+func (r *chainRouter) AddFilter(f filter.Filter) {
+	r.chain.Insert(f, r.chain.Length()-1)
+}
+
+func (r *chainRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.chain.ServeHTTP(w, req)
+}
+
+func TestAdminAuth(t *testing.T) {
+	env := NewEnvironment()
+	router := newChainRouter()
+	env.Admin.Router = router
+	env.Admin.SetAuth("admin", "secret", pingPath)
+	if err := env.Admin.validate(); err != nil {
+		t.Fatal(err)
+	}
+	router.Handle("GET", "/direct", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	env.Admin.start()
+
+	serve := func(method, path, username, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	for _, test := range []struct {
+		method, path       string
+		username, password string
+		status             int
+	}{
+		{"GET", healthCheckPath, "", "", http.StatusUnauthorized},
+		{"POST", tasksPath + "/gc", "", "", http.StatusUnauthorized},
+		{"GET", "/direct", "", "", http.StatusUnauthorized},
+		{"GET", "/", "admin", "wrong", http.StatusUnauthorized},
+		{"GET", "/", "other", "secret", http.StatusUnauthorized},
+		{"GET", "/", "admin", "secret", http.StatusOK},
+		{"POST", tasksPath + "/gc", "admin", "secret", http.StatusOK},
+		{"GET", "/direct", "admin", "secret", http.StatusOK},
+		{"GET", pingPath, "", "", http.StatusOK},
+	} {
+		w := serve(test.method, test.path, test.username, test.password)
+		if w.Code != test.status {
+			t.Fatalf("unexpected status of %s %s: %d, want %d", test.method, test.path, w.Code, test.status)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="Admin"` {
+			t.Fatalf("unexpected header: %v", w.Header())
+		}
+	}
+}
+
+func TestAdminAuthUnsupportedRouter(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.Router = muxRouter{http.NewServeMux()}
+	env.Admin.SetAuth("admin", "secret")
+	if err := env.Admin.validate(); err == nil {
+		t.Fatalf("expected error for router without filters")
+	}
+}
+
+func TestAdminAuthMounted(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.Router = newChainRouter()
+	child, err := env.Mount("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.Admin.SetAuth("admin", "secret", pingPath)
+	if env.Admin.auth == nil || !env.Admin.auth.exempt["/child"+pingPath] {
+		t.Fatalf("unexpected auth: %+v", env.Admin.auth)
+	}
+}
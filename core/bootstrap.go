@@ -3,10 +3,18 @@ Package core provides APIs and basic behaviors for a server stack like Dropwizar
 */
 package core
 
+import (
+	"fmt"
+	"time"
+)
+
 // Bootstrap contains everything required to bootstrap a command
 type Bootstrap struct {
 	Application Bundle
 	Arguments   []string
+	// Name is the application name shown in admin pages. The executable
+	// name is used if it is empty.
+	Name string
 
 	ConfigurationFactory ConfigurationFactory
 	ValidatorFactory     ValidatorFactory
@@ -39,7 +47,10 @@ func (bootstrap *Bootstrap) AddCommand(command Command) {
 // Run runs all registered bundles
 func (bootstrap *Bootstrap) Run(configuration interface{}, environment *Environment) error {
 	for _, bundle := range bootstrap.bundles {
-		if err := bundle.Run(configuration, environment); err != nil {
+		start := time.Now()
+		err := bundle.Run(configuration, environment)
+		environment.Lifecycle.RecordStartup(fmt.Sprintf("bundle %T", bundle), time.Since(start))
+		if err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/metrics"
+)
+
+// deprecationReportInterval is the period of logging usage of deprecated
+// routes.
+const deprecationReportInterval = 24 * time.Hour
+
+// Deprecation describes a deprecated route. Responses of the route include
+// headers Deprecation, Sunset and Link with relation successor-version.
+type Deprecation struct {
+	// Since is when the route was deprecated. Deprecation header is "true"
+	// if it is zero.
+	Since time.Time
+	// Sunset is when the route will be removed, which is optional.
+	Sunset time.Time
+	// Link is the URL of the successor version, which is optional.
+	Link string
+}
+
+// Deprecated is implemented by components whose routes are deprecated.
+// Deprecated returns nil if the route is not deprecated.
+type Deprecated interface {
+	Deprecated() *Deprecation
+}
+
+// DeprecationOf returns deprecation of handler or the handlers it wraps,
+// which are returned by their Unwrap method. It returns nil if none of them
+// is deprecated.
+func DeprecationOf(handler http.Handler) *Deprecation {
+	for handler != nil {
+		if d, ok := handler.(Deprecated); ok {
+			if deprecation := d.Deprecated(); deprecation != nil {
+				return deprecation
+			}
+		}
+		u, ok := handler.(interface{ Unwrap() http.Handler })
+		if !ok {
+			break
+		}
+		handler = u.Unwrap()
+	}
+	return nil
+}
+
+// SetHeaders sets deprecation headers of the response.
+func (d *Deprecation) SetHeaders(header http.Header) {
+	if d.Since.IsZero() {
+		header.Set("Deprecation", "true")
+	} else {
+		header.Set("Deprecation", d.Since.UTC().Format(http.TimeFormat))
+	}
+	if !d.Sunset.IsZero() {
+		header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		header.Add("Link", "<"+d.Link+`>; rel="successor-version"`)
+	}
+}
+
+// MarshalJSON omits unset fields.
+func (d *Deprecation) MarshalJSON() ([]byte, error) {
+	var v struct {
+		Since  *time.Time `json:",omitempty"`
+		Sunset *time.Time `json:",omitempty"`
+		Link   string     `json:",omitempty"`
+	}
+	if !d.Since.IsZero() {
+		v.Since = &d.Since
+	}
+	if !d.Sunset.IsZero() {
+		v.Sunset = &d.Sunset
+	}
+	v.Link = d.Link
+	return json.Marshal(&v)
+}
+
+// DeprecationTracker counts requests to deprecated routes so that they can be
+// removed when no longer used. Usage is counted in metrics
+// HTTP.Deprecated.<METHOD> <pattern> and logged daily while the application
+// is running.
+type DeprecationTracker struct {
+	mu     sync.Mutex
+	routes []*deprecatedRoute
+	done   chan struct{}
+}
+
+// NewDeprecationTracker allocates and returns a new DeprecationTracker.
+func NewDeprecationTracker() *DeprecationTracker {
+	return &DeprecationTracker{}
+}
+
+type deprecatedRoute struct {
+	name        string
+	deprecation Deprecation
+	counter     metrics.Counter
+
+	total    uint64
+	reported uint64
+}
+
+// Handler returns a handler setting deprecation headers and counting requests
+// of the route before serving next. A nil tracker only sets headers.
+func (t *DeprecationTracker) Handler(method, pattern string, d *Deprecation, next http.Handler) http.Handler {
+	h := &deprecatedHandler{
+		handler: next,
+		route: &deprecatedRoute{
+			name:        method + " " + pattern,
+			deprecation: *d,
+		},
+	}
+	if t != nil {
+		h.route.counter = metrics.Counter("HTTP.Deprecated." + h.route.name)
+		t.mu.Lock()
+		t.routes = append(t.routes, h.route)
+		t.mu.Unlock()
+	}
+	return h
+}
+
+// Usage returns the number of requests of each deprecated route, which is
+// named by method and pattern.
+func (t *DeprecationTracker) Usage() map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := make(map[string]uint64, len(t.routes))
+	for _, r := range t.routes {
+		usage[r.name] += atomic.LoadUint64(&r.total)
+	}
+	return usage
+}
+
+// start logs usage periodically if there are deprecated routes.
+func (t *DeprecationTracker) start(clock Clock) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.routes) == 0 || t.done != nil {
+		return
+	}
+	t.done = make(chan struct{})
+	go t.run(clock.NewTicker(deprecationReportInterval), t.done)
+}
+
+func (t *DeprecationTracker) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+}
+
+func (t *DeprecationTracker) run(ticker Ticker, done chan struct{}) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			t.report()
+		case <-done:
+			return
+		}
+	}
+}
+
+// report logs requests of deprecated routes since the last report, most
+// used routes first.
+func (t *DeprecationTracker) report() {
+	t.mu.Lock()
+	routes := append([]*deprecatedRoute(nil), t.routes...)
+	t.mu.Unlock()
+	type usage struct {
+		route  *deprecatedRoute
+		recent uint64
+		total  uint64
+	}
+	usages := make([]usage, len(routes))
+	for i, r := range routes {
+		total := atomic.LoadUint64(&r.total)
+		usages[i] = usage{route: r, recent: total - atomic.SwapUint64(&r.reported, total), total: total}
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].recent > usages[j].recent
+	})
+	logger := GetLogger("melon")
+	for _, u := range usages {
+		sunset := "none"
+		if !u.route.deprecation.Sunset.IsZero() {
+			sunset = u.route.deprecation.Sunset.UTC().Format(time.RFC3339)
+		}
+		logger.Infof("deprecated route %s: %d requests in the last %v, %d in total, sunset: %s",
+			u.route.name, u.recent, deprecationReportInterval, u.total, sunset)
+	}
+}
+
+// deprecatedHandler wraps handler of a deprecated route.
+type deprecatedHandler struct {
+	handler http.Handler
+	route   *deprecatedRoute
+}
+
+func (h *deprecatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.route.deprecation.SetHeaders(w.Header())
+	if h.route.counter != "" {
+		atomic.AddUint64(&h.route.total, 1)
+		h.route.counter.Add()
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// Deprecated returns deprecation of the route.
+func (h *deprecatedHandler) Deprecated() *Deprecation {
+	return &h.route.deprecation
+}
+
+// Unwrap returns the route handler.
+func (h *deprecatedHandler) Unwrap() http.Handler {
+	return h.handler
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codahale/metrics"
+)
+
+func TestDeprecationHeaders(t *testing.T) {
+	header := make(http.Header)
+	d := &Deprecation{}
+	d.SetHeaders(header)
+	if header.Get("Deprecation") != "true" || header.Get("Sunset") != "" || header.Get("Link") != "" {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	header = http.Header{"Link": {`</docs>; rel="help"`}}
+	d = &Deprecation{
+		Since:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Sunset: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Link:   "/v2/users",
+	}
+	d.SetHeaders(header)
+	if header.Get("Deprecation") != "Thu, 02 Jan 2020 03:04:05 GMT" ||
+		header.Get("Sunset") != "Sat, 02 Jan 2021 03:04:05 GMT" ||
+		len(header["Link"]) != 2 || header["Link"][1] != `</v2/users>; rel="successor-version"` {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	data, err := json.Marshal(&Deprecation{Link: "/v2"})
+	if err != nil || string(data) != `{"Link":"/v2"}` {
+		t.Fatalf("unexpected json: %s %v", data, err)
+	}
+}
+
+type deprecatedResource struct {
+	http.Handler
+	deprecation *Deprecation
+}
+
+func (r *deprecatedResource) Deprecated() *Deprecation {
+	return r.deprecation
+}
+
+func (r *deprecatedResource) Unwrap() http.Handler {
+	return r.Handler
+}
+
+func TestDeprecationOf(t *testing.T) {
+	d := &Deprecation{Link: "/v2"}
+	inner := &deprecatedResource{Handler: http.NotFoundHandler(), deprecation: d}
+	if DeprecationOf(inner) != d || DeprecationOf(&deprecatedResource{Handler: inner}) != d {
+		t.Fatalf("expected deprecation")
+	}
+	if DeprecationOf(http.NotFoundHandler()) != nil || DeprecationOf(&deprecatedResource{Handler: http.NotFoundHandler()}) != nil {
+		t.Fatalf("unexpected deprecation")
+	}
+}
+
+func TestDeprecationTracker(t *testing.T) {
+	metrics.Reset()
+	tracker := NewDeprecationTracker()
+	handler := tracker.Handler("GET", "/v1/users", &Deprecation{Link: "/v2/users"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users", nil))
+		if w.Body.String() != "ok" || w.Header().Get("Deprecation") != "true" ||
+			w.Header().Get("Link") != `</v2/users>; rel="successor-version"` {
+			t.Fatalf("unexpected response: %v %s", w.Header(), w.Body)
+		}
+	}
+	if usage := tracker.Usage(); usage["GET /v1/users"] != 3 {
+		t.Fatalf("unexpected usage: %v", usage)
+	}
+	counters, _ := metrics.Snapshot()
+	if counters["HTTP.Deprecated.GET /v1/users"] != 3 {
+		t.Fatalf("unexpected counters: %v", counters)
+	}
+	route := tracker.routes[0]
+	tracker.report()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/users", nil))
+	if route.total != 4 || route.reported != 3 {
+		t.Fatalf("unexpected route usage: %d %d", route.total, route.reported)
+	}
+	// Nil tracker only sets headers.
+	w := httptest.NewRecorder()
+	(*DeprecationTracker)(nil).Handler("GET", "/v1", &Deprecation{}, http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/v1", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("Deprecation") != "true" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/goburrow/melon/health"
+)
+
+const (
+	drainingPath = "/draining"
+
+	drainTaskName   = "drain"
+	undrainTaskName = "undrain"
+
+	// ReadinessHealthCheck is the name of the health check which fails while
+	// the application is draining.
+	ReadinessHealthCheck = "readiness"
+)
+
+// Drain marks the application as draining so that load balancers stop
+// sending new requests while the server keeps running. It is also called
+// when shutdown is requested. Drain is concurrent-safe.
+func (env *LifecycleEnvironment) Drain() {
+	if atomic.CompareAndSwapInt32(&env.draining, 0, 1) {
+		env.publish(LifecycleDraining, "")
+	}
+}
+
+// Undrain cancels Drain. It returns false if shutdown has been requested, in
+// which case the application keeps draining. Undrain is concurrent-safe.
+func (env *LifecycleEnvironment) Undrain() bool {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.shutdownReason != nil {
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&env.draining, 1, 0) {
+		env.publish(LifecycleUndrained, "")
+	}
+	return true
+}
+
+// Draining returns true if the application is draining.
+func (env *LifecycleEnvironment) Draining() bool {
+	return atomic.LoadInt32(&env.draining) != 0
+}
+
+// RequestStarted increases the number of in-flight requests. It must be
+// followed by RequestFinished.
+func (env *LifecycleEnvironment) RequestStarted() {
+	atomic.AddInt64(&env.inFlight, 1)
+}
+
+// RequestFinished decreases the number of in-flight requests.
+func (env *LifecycleEnvironment) RequestFinished() {
+	atomic.AddInt64(&env.inFlight, -1)
+}
+
+// InFlight returns the number of requests being processed by the application.
+func (env *LifecycleEnvironment) InFlight() int64 {
+	return atomic.LoadInt64(&env.inFlight)
+}
+
+// readinessCheck is unhealthy while the application is draining.
+type readinessCheck struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (c *readinessCheck) Check() health.Result {
+	if c.lifecycle.Draining() {
+		return health.ResultUnhealthy("draining", nil)
+	}
+	return health.Healthy
+}
+
+// drainingHandler displays the draining state and in-flight requests.
+type drainingHandler struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (handler *drainingHandler) Name() string {
+	return "Draining"
+}
+
+func (handler *drainingHandler) Path() string {
+	return drainingPath
+}
+
+func (handler *drainingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "text/plain")
+
+	fmt.Fprintf(w, "draining: %t\ninFlight: %d\n", handler.lifecycle.Draining(), handler.lifecycle.InFlight())
+}
+
+// drainTask marks the application as draining.
+type drainTask struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (*drainTask) Name() string {
+	return drainTaskName
+}
+
+func (t *drainTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.lifecycle.Drain()
+	GetLogger("melon").Infof("draining requested by %s", r.RemoteAddr)
+	w.Write([]byte("Draining...\n"))
+}
+
+// undrainTask cancels draining.
+type undrainTask struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (*undrainTask) Name() string {
+	return undrainTaskName
+}
+
+func (t *undrainTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !t.lifecycle.Undrain() {
+		http.Error(w, "Application is shutting down.", http.StatusConflict)
+		return
+	}
+	GetLogger("melon").Infof("undraining requested by %s", r.RemoteAddr)
+	w.Write([]byte("Undrained.\n"))
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseDuration parses a non-negative duration of configuration, e.g. 30s.
+// It returns def if value is empty.
+func ParseDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative duration %v", d)
+	}
+	return d, err
+}
+
+// ValidateDuration returns an error if value of the named configuration field
+// is neither empty nor a non-negative duration.
+func ValidateDuration(name, value string) error {
+	if _, err := ParseDuration(value, 0); err != nil {
+		return fmt.Errorf("%s must be a non-negative duration: %s", name, value)
+	}
+	return nil
+}
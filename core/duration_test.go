@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		d     time.Duration
+		ok    bool
+	}{
+		{"", time.Second, true},
+		{"0s", 0, true},
+		{"1m", time.Minute, true},
+		{"1", 0, false},
+		{"-1s", 0, false},
+	}
+	for _, test := range tests {
+		d, err := ParseDuration(test.value, time.Second)
+		if (err == nil) != test.ok || (test.ok && d != test.d) {
+			t.Fatalf("unexpected duration of %q: %v %v", test.value, d, err)
+		}
+	}
+	if err := ValidateDuration("Timeout", "-1s"); err == nil || err.Error() != "Timeout must be a non-negative duration: -1s" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -1,5 +1,13 @@
 package core
 
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/health"
+)
+
 // Managed is an interface for objects which need to be started and stopped as
 // the application is started or stopped.
 type Managed interface {
@@ -13,7 +21,32 @@ type Managed interface {
 
 // LifecycleEnvironment is an environment context to manage Managed objects.
 type LifecycleEnvironment struct {
+	// SlowThreshold is the duration of startup and shutdown steps to be
+	// warned about. It is disabled when zero.
+	SlowThreshold time.Duration
+	// LeakDetection enables reporting goroutines which are started after the
+	// application starts and still running after it stops.
+	LeakDetection bool
+	// LeakThreshold is the number of surviving goroutines tolerated.
+	LeakThreshold int
+
 	managedObjects []Managed
+
+	mu             sync.Mutex
+	shutdown       chan struct{}
+	shutdownReason *ShutdownReason
+
+	startup         breakdown
+	shutdownTimings breakdown
+	// goroutines is the baseline of leak detection.
+	goroutines GoroutineSnapshot
+
+	// events is the event bus of the environment.
+	events *EventBus
+
+	// draining and inFlight are accessed atomically.
+	draining int32
+	inFlight int64
 }
 
 // NewLifecycleEnvironment allocates and returns a new LifecycleEnvironment.
@@ -32,10 +65,13 @@ func (env *LifecycleEnvironment) start() {
 	// Starting managed objects in order.
 	for _, m := range env.managedObjects {
 		// Panic from a managed object will stop the application.
+		start := time.Now()
 		if err := m.Start(); err != nil {
 			GetLogger("melon").Errorf("error starting managed object %#v: %v", m, err)
 		}
+		env.RecordStartup(fmt.Sprintf("start %T", m), time.Since(start))
 	}
+	env.logBreakdown("startup", &env.startup)
 }
 
 // stop indicates the application has stopped.
@@ -43,8 +79,12 @@ func (env *LifecycleEnvironment) stop() {
 	// Stopping managed objects in reversed order.
 	for i := len(env.managedObjects) - 1; i >= 0; i-- {
 		// Panic from a managed object will NOT stop the application immediately.
-		stopManagedObject(env.managedObjects[i])
+		m := env.managedObjects[i]
+		start := time.Now()
+		stopManagedObject(m)
+		env.RecordShutdown(fmt.Sprintf("stop %T", m), time.Since(start))
 	}
+	env.logBreakdown("shutdown", &env.shutdownTimings)
 }
 
 func stopManagedObject(m Managed) {
@@ -69,29 +109,98 @@ type Environment struct {
 	Lifecycle *LifecycleEnvironment
 	// Admin controls administration tasks.
 	Admin *AdminEnvironment
+	// HealthMonitor stops the application when fatal health checks fail.
+	HealthMonitor *HealthMonitor
+	// Events dispatches events to subscribers, see Subscribe and Publish.
+	Events *EventBus
+	// Metrics registers application metrics shown at admin /metrics.
+	Metrics *MetricsRegistry
 	// Validator validates communication data structures.
 	Validator Validator
+	// IDGenerator generates request IDs. UUIDs are generated by default.
+	IDGenerator IDGenerator
+	// Mode adjusts defaults of components. It is ModeProduction by default.
+	Mode Mode
+	// ConfigurationSource describes where the configuration was read from,
+	// which is shown at admin /config.
+	ConfigurationSource string
+	// Clock is the source of time of time-dependent components.
+	// SystemClock is used when it is nil.
+	Clock Clock
+
+	// name is the mount name of a child environment.
+	name     string
+	children []*Environment
 }
 
 // NewEnvironment allocates and returns new Environment
 func NewEnvironment() *Environment {
-	return &Environment{
+	env := &Environment{
 		Server:    NewServerEnvironment(),
 		Lifecycle: NewLifecycleEnvironment(),
 		Admin:     NewAdminEnvironment(),
+		Metrics:   NewMetricsRegistry(),
+
+		IDGenerator: NewUUIDGenerator(),
+		Clock:       SystemClock,
 	}
+	env.Admin.AddHandler(&endpointsHandler{server: env.Server}, &endpointsOpenAPIHandler{server: env.Server},
+		&lifecycleHandler{env.Lifecycle}, &lifecycleGraphHandler{env.Lifecycle}, &modeHandler{env: env},
+		&drainingHandler{env.Lifecycle})
+	env.Admin.AddTask(&drainTask{env.Lifecycle}, &undrainTask{env.Lifecycle})
+	env.Admin.HealthChecks.Register(ReadinessHealthCheck, &readinessCheck{env.Lifecycle})
+	env.HealthMonitor = NewHealthMonitor(env.Admin.HealthChecks, env.Lifecycle)
+	env.Events = NewEventBus(0, 0)
+	env.Lifecycle.events = env.Events
+	// Event bus is stopped after all other managed objects.
+	env.Lifecycle.Manage(env.Events)
+	if r, ok := env.Admin.HealthChecks.(health.HistoryRegistry); ok {
+		r.History().SetListener(func(name string, t health.Transition) {
+			Publish(env, HealthEvent{Name: name, Healthy: t.Healthy, Message: t.Message})
+		})
+	}
+	return env
+}
+
+// NewID returns a new ID from IDGenerator of the environment. It falls back to
+// a UUID when the generator panics or returns an empty ID.
+// Environment therefore can be used as an IDGenerator.
+func (env *Environment) NewID() string {
+	return safeNewID(env.IDGenerator)
+}
+
+// GetClock returns Clock of the environment or SystemClock if it is not set.
+func (env *Environment) GetClock() Clock {
+	if env.Clock == nil {
+		return SystemClock
+	}
+	return env.Clock
 }
 
 // SetStarting calls onStarting of all registered event listeners.
 func (env *Environment) Start() error {
-	env.Server.start()
+	if err := env.Admin.validate(); err != nil {
+		return err
+	}
+	env.Lifecycle.detectLeaks()
+	env.handleComponents()
+	if err := env.Server.start(); err != nil {
+		return err
+	}
 	env.Admin.start()
 	env.Lifecycle.start()
+	env.HealthMonitor.start(env.GetClock())
+	env.Server.Deprecations.start(env.GetClock())
+	env.Lifecycle.publish(LifecycleStarted, "")
 	return nil
 }
 
 // SetStopped calls onStopped of all registered event listeners in descending order.
 func (env *Environment) Stop() error {
+	env.Lifecycle.publish(LifecycleStopping, "")
+	env.HealthMonitor.stop()
+	env.Server.Deprecations.stop()
 	env.Lifecycle.stop()
+	env.Lifecycle.reportLeaks()
 	return nil
 }
@@ -2,8 +2,18 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/goburrow/melon/health"
 )
 
 type writerManaged struct {
@@ -61,3 +71,385 @@ func TestPanicManagedObject(t *testing.T) {
 		t.Fatalf("unexpected stopping order %s", buf.String())
 	}
 }
+
+func TestHealthHistoryHandler(t *testing.T) {
+	env := NewAdminEnvironment()
+	healthy := false
+	env.HealthChecks.Register("db", health.CheckerFunc(func() health.Result {
+		if healthy {
+			return health.Healthy
+		}
+		return health.ResultUnhealthy("down", nil)
+	}))
+	env.HealthChecks.RunCheckers()
+	healthy = true
+	env.HealthChecks.RunCheckers()
+	env.HealthChecks.RunCheckers()
+
+	w := httptest.NewRecorder()
+	(&healthHistoryHandler{env.HealthChecks}).ServeHTTP(w, httptest.NewRequest("GET", healthHistoryPath, nil))
+	var result map[string]struct {
+		Healthy     bool
+		Flaps       int
+		Transitions []struct {
+			Healthy bool
+			Message string
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected response: %v %s", err, w.Body.String())
+	}
+	db := result["db"]
+	if !db.Healthy || db.Flaps != 1 || len(db.Transitions) != 2 || db.Transitions[0].Message != "down" {
+		t.Fatalf("unexpected history: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	(&clearHealthHistoryTask{env.HealthChecks}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+clearHealthHistoryTaskName, nil))
+	if len(env.HealthChecks.(health.HistoryRegistry).History().Checks()) != 0 {
+		t.Fatalf("history must be cleared")
+	}
+}
+
+func TestDraining(t *testing.T) {
+	env := NewEnvironment()
+	ready := func() bool {
+		result, ok := env.Admin.HealthChecks.RunChecker(ReadinessHealthCheck)
+		return ok && result.Healthy()
+	}
+	if env.Lifecycle.Draining() || !ready() {
+		t.Fatalf("unexpected draining")
+	}
+	w := httptest.NewRecorder()
+	(&drainTask{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+drainTaskName, nil))
+	if !env.Lifecycle.Draining() || ready() {
+		t.Fatalf("expected draining")
+	}
+	env.Lifecycle.RequestStarted()
+	w = httptest.NewRecorder()
+	(&drainingHandler{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("GET", drainingPath, nil))
+	if w.Body.String() != "draining: true\ninFlight: 1\n" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	env.Lifecycle.RequestFinished()
+
+	w = httptest.NewRecorder()
+	(&undrainTask{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+undrainTaskName, nil))
+	if w.Code != http.StatusOK || env.Lifecycle.Draining() || !ready() {
+		t.Fatalf("unexpected undraining: %d", w.Code)
+	}
+	// Undrain is refused once shutdown is requested.
+	env.Lifecycle.Shutdown(ShutdownTask, "test")
+	w = httptest.NewRecorder()
+	(&undrainTask{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+undrainTaskName, nil))
+	if w.Code != http.StatusConflict || !env.Lifecycle.Draining() || ready() {
+		t.Fatalf("unexpected undraining after shutdown: %d", w.Code)
+	}
+}
+
+func TestAdminStaticHandlersNotModified(t *testing.T) {
+	env := NewEnvironment()
+	handlers := []http.Handler{
+		&modeHandler{env: env},
+		&endpointsHandler{server: env.Server},
+	}
+	for _, h := range handlers {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			t.Fatalf("unexpected response of %T: %d %v", h, w.Code, w.Header())
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", `"other", `+etag)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("unexpected response of %T: %d %s", h, w.Code, w.Body.String())
+		}
+	}
+	// Health check is never cached.
+	env.Admin.HealthChecks.Register("db", health.CheckerFunc(func() health.Result { return health.Healthy }))
+	w := httptest.NewRecorder()
+	(&healthCheckHandler{env.Admin.HealthChecks}).ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "must-revalidate,no-cache,no-store" {
+		t.Fatalf("unexpected health check header: %v", w.Header())
+	}
+}
+
+func TestRuntimeHandler(t *testing.T) {
+	h := &runtimeHandler{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", runtimePath, nil))
+	if w.Header().Get("Content-Type") != "text/plain" ||
+		!strings.Contains(w.Body.String(), "NumGoroutine: ") || !strings.Contains(w.Body.String(), "HeapAlloc: ") {
+		t.Fatalf("unexpected response: %v %s", w.Header(), w.Body.String())
+	}
+	requests := []*http.Request{
+		httptest.NewRequest("GET", runtimePath+"?format=json", nil),
+		httptest.NewRequest("GET", runtimePath, nil),
+	}
+	requests[1].Header.Set("Accept", "application/json")
+	for _, r := range requests {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		var stats struct {
+			NumGoroutine int
+			HeapAlloc    uint64
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if w.Header().Get("Content-Type") != "application/json" || stats.NumGoroutine == 0 || stats.HeapAlloc == 0 {
+			t.Fatalf("unexpected response: %v %s", w.Header(), w.Body.String())
+		}
+	}
+}
+
+func TestThreadsHandler(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	go func() {
+		<-block
+	}()
+	h := &threadsHandler{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", threadsPath, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "TestThreadsHandler") || !strings.Contains(body, "[chan receive]") {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", threadsPath+"?debug=1", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "goroutine profile: total ") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", threadsPath+"?debug=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestHealthCheckHandlerByName(t *testing.T) {
+	env := NewEnvironment()
+	runs := 0
+	env.Admin.HealthChecks.Register("database", health.CheckerFunc(func() health.Result {
+		runs++
+		return health.ResultUnhealthy("slow", nil)
+	}))
+	h := &healthCheckHandler{env.Admin.HealthChecks}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath+"?name="+ReadinessHealthCheck, nil))
+	var results map[string]struct{ Healthy bool }
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(results) != 1 || !results[ReadinessHealthCheck].Healthy || runs != 0 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath+"?name=cache", nil))
+	var e map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" || e["error"] == "" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	results = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || len(results) != 2 || runs != 1 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHealthCheckHandlerJSON(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.HealthChecks.Register("db", health.CheckerFunc(func() health.Result {
+		return health.ResultUnhealthy("bad\x00byte", errors.New(`"quoted" \x`))
+	}))
+	h := &healthCheckHandler{env.Admin.HealthChecks}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	var results map[string]healthCheckResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("invalid JSON %s: %v", w.Body.String(), err)
+	}
+	db := results["db"]
+	if w.Code != http.StatusInternalServerError || db.Healthy || db.Message != "bad\x00byte" || db.Cause != `"quoted" \x` || db.Duration == "" || db.Timestamp == "" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if strings.Index(w.Body.String(), `"db"`) > strings.Index(w.Body.String(), `"`+ReadinessHealthCheck+`"`) {
+		t.Fatalf("unsorted response: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath+"?pretty=true", nil))
+	if !strings.HasPrefix(w.Body.String(), "{\n  \"db\": {\n") {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	env.Admin.HealthChecks.Unregister("db")
+	env.Admin.HealthChecks.Unregister(ReadinessHealthCheck)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	m := NewMetricsRegistry()
+	h := m.Histogram("Test.Histogram", 1, 100)
+	if m.Histogram("Test.Histogram", 1, 1000) != h {
+		t.Fatalf("unexpected new histogram")
+	}
+	if m.Timer("Test.Histogram").histogram != h {
+		t.Fatalf("unexpected timer histogram")
+	}
+	env := NewEnvironment()
+	child, err := env.Mount("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.Metrics != env.Metrics {
+		t.Fatalf("unexpected child metrics: %p", child.Metrics)
+	}
+}
+
+func TestHealthCheckHandlerForce(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.HealthChecks.Unregister(ReadinessHealthCheck)
+	var runs int
+	env.Admin.HealthChecks.Register("db", health.CheckerFunc(func() health.Result {
+		runs++
+		return health.Healthy
+	}))
+	env.Admin.HealthChecks.SetCacheTTL(time.Minute)
+	h := &healthCheckHandler{env.Admin.HealthChecks}
+	for _, target := range []string{healthCheckPath, healthCheckPath, healthCheckPath + "?name=db"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	if runs != 1 {
+		t.Fatalf("unexpected runs: %d", runs)
+	}
+	for _, target := range []string{healthCheckPath + "?force=true", healthCheckPath + "?name=db&force=true"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+	if runs != 3 {
+		t.Fatalf("unexpected runs: %d", runs)
+	}
+}
+
+func TestHealthCheckHandlerNonCritical(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.HealthChecks.RegisterNonCritical("cache", health.CheckerFunc(func() health.Result {
+		return health.ResultUnhealthy("down", nil)
+	}))
+	h := &healthCheckHandler{env.Admin.HealthChecks}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	var results map[string]healthCheckResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("invalid JSON %s: %v", w.Body.String(), err)
+	}
+	cache, ready := results["cache"], results[ReadinessHealthCheck]
+	if w.Code != http.StatusOK || cache.Healthy || cache.Critical || !ready.Critical {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	env.Admin.HealthChecks.Register("db", health.CheckerFunc(func() health.Result {
+		return health.ResultUnhealthy("down", nil)
+	}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", healthCheckPath, nil))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"db":{"Healthy":false,"Critical":true`) {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	defer func() {
+		buildInfo.info = nil
+	}()
+	h := &versionHandler{}
+	// Embedded build information
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", versionPath, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "GoVersion: "+runtime.Version()+"\n") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+
+	SetBuildInfo("1.2.0", "abc123", "2020-01-02T03:04:05Z")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", versionPath, nil))
+	expected := "Version: 1.2.0\nCommit: abc123\nBuildTime: 2020-01-02T03:04:05Z\n"
+	if w.Header().Get("Content-Type") != "text/plain" || !strings.Contains(w.Body.String(), expected) {
+		t.Fatalf("unexpected response: %v %s", w.Header(), w.Body)
+	}
+	r := httptest.NewRequest("GET", versionPath, nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var info BuildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || info.Version != "1.2.0" || info.Commit != "abc123" ||
+		info.BuildTime != "2020-01-02T03:04:05Z" || info.GoVersion != runtime.Version() || info.Name == "" {
+		t.Fatalf("unexpected response: %v %+v", w.Header(), info)
+	}
+}
+
+func TestAdminIndex(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.SetApplicationName("billing <api>")
+	env.Admin.AddTaskFunc("flush-cache", func(w io.Writer, r *http.Request) error {
+		return nil
+	})
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	for _, s := range []string{
+		"<title>billing &lt;api&gt; Operational Menu</title>",
+		"<h1>billing &lt;api&gt; Operational Menu</h1>",
+		`<li><a href="/ping">Ping</a></li>`,
+		`<form method="post" action="/tasks/gc"><button type="submit">gc</button> POST /tasks/gc</form>`,
+		`<form method="post" action="/tasks/flush-cache">`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("expected %s in response: %s", s, body)
+		}
+	}
+	// Custom template
+	env.Admin.SetIndexTemplate(template.Must(template.New("index").Parse(
+		`{{.Name}}:{{range .Tasks}} {{.Name}}={{.URL}}{{end}}`)))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.HasPrefix(w.Body.String(), "billing &lt;api&gt;: gc=/tasks/gc ") ||
+		!strings.HasSuffix(w.Body.String(), " flush-cache=/tasks/flush-cache") {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	env.Admin.SetIndexTemplate(template.Must(template.New("index").Parse(`{{.Missing}}`)))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ETag returns a strong entity tag of content.
+func ETag(content []byte) string {
+	h := fnv.New64a()
+	h.Write(content)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// NotModified sets ETag header of the response and responds 304 Not Modified
+// if the request has a matching If-None-Match header. It returns true if the
+// response has been written.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// staticContent is the content of an admin handler which does not change after
+// the application has started. It is rendered on the first request.
+type staticContent struct {
+	once sync.Once
+	body []byte
+	etag string
+}
+
+func (c *staticContent) serve(w http.ResponseWriter, r *http.Request, contentType string, render func(io.Writer)) {
+	c.once.Do(func() {
+		var buf bytes.Buffer
+		render(&buf)
+		c.body = buf.Bytes()
+		c.etag = ETag(c.body)
+	})
+	// Clients may store the content but must revalidate it.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", contentType)
+	if NotModified(w, r, c.etag) {
+		return
+	}
+	w.Write(c.body)
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/codahale/metrics"
+)
+
+const (
+	defaultEventQueueSize = 1024
+	defaultEventWorkers   = 1
+)
+
+// Phases of LifecycleEvent.
+const (
+	LifecycleStarted  = "started"
+	LifecycleDraining = "draining"
+	// LifecycleUndrained is published when draining is cancelled.
+	LifecycleUndrained = "undrained"
+	// LifecycleShutdown is published when shutdown is requested.
+	LifecycleShutdown = "shutdown"
+	LifecycleStopping = "stopping"
+)
+
+// LifecycleEvent is published to EventBus of the environment when the
+// application changes its lifecycle phase.
+type LifecycleEvent struct {
+	Phase string
+	// Detail is the shutdown reason for LifecycleShutdown.
+	Detail string
+}
+
+// HealthEvent is published to EventBus of the environment when a health
+// check changes its state.
+type HealthEvent struct {
+	Name    string
+	Healthy bool
+	Message string
+}
+
+// EventBus dispatches events to subscribers of their types asynchronously.
+// Events are queued until the bus starts and dropped when the queue is full
+// or the bus has stopped. EventBus is managed by the lifecycle of the
+// environment, so that queued events are delivered before it stops.
+type EventBus struct {
+	workers int
+	queue   chan event
+	dropped metrics.Counter
+
+	mu          sync.RWMutex
+	subscribers map[reflect.Type][]func(interface{})
+	started     bool
+	stopped     bool
+	wg          sync.WaitGroup
+
+	// droppedCount is accessed atomically.
+	droppedCount uint64
+}
+
+type event struct {
+	value    interface{}
+	handlers []func(interface{})
+}
+
+// NewEventBus allocates and returns a new EventBus with the given size of the
+// queue and number of workers dispatching events. Events are delivered in
+// order of publishing only with a single worker. Defaults, 1024 and 1, are
+// used for non positive values.
+func NewEventBus(queueSize, workers int) *EventBus {
+	if queueSize <= 0 {
+		queueSize = defaultEventQueueSize
+	}
+	if workers <= 0 {
+		workers = defaultEventWorkers
+	}
+	return &EventBus{
+		workers:     workers,
+		queue:       make(chan event, queueSize),
+		dropped:     metrics.Counter("Events.Dropped"),
+		subscribers: make(map[reflect.Type][]func(interface{})),
+	}
+}
+
+// Subscribe registers fn to receive events of type T published to the event
+// bus of env. Subscribe is concurrent-safe.
+func Subscribe[T any](env *Environment, fn func(T)) {
+	env.Events.subscribe(typeOf[T](), func(v interface{}) {
+		fn(v.(T))
+	})
+}
+
+// Publish queues event to be delivered to subscribers of type T. It returns
+// false if the event is dropped. Publish is concurrent-safe and does not block.
+func Publish[T any](env *Environment, event T) bool {
+	return env.Events.publish(typeOf[T](), event)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (b *EventBus) subscribe(t reflect.Type, handler func(interface{})) {
+	b.mu.Lock()
+	b.subscribers[t] = append(b.subscribers[t], handler)
+	b.mu.Unlock()
+}
+
+func (b *EventBus) publish(t reflect.Type, value interface{}) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	handlers := b.subscribers[t]
+	if len(handlers) == 0 {
+		return true
+	}
+	if !b.stopped {
+		select {
+		case b.queue <- event{value: value, handlers: handlers}:
+			return true
+		default:
+		}
+	}
+	b.dropped.Add()
+	atomic.AddUint64(&b.droppedCount, 1)
+	return false
+}
+
+// Dropped returns the number of dropped events.
+func (b *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.droppedCount)
+}
+
+// Start starts workers dispatching events.
+func (b *EventBus) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started || b.stopped {
+		return nil
+	}
+	b.started = true
+	b.wg.Add(b.workers)
+	for i := 0; i < b.workers; i++ {
+		go b.run()
+	}
+	return nil
+}
+
+// Stop delivers queued events and stops workers. Events published afterward
+// are dropped.
+func (b *EventBus) Stop() error {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return nil
+	}
+	b.stopped = true
+	started := b.started
+	// No events are queued after stopped is set.
+	close(b.queue)
+	b.mu.Unlock()
+	if started {
+		b.wg.Wait()
+	}
+	return nil
+}
+
+func (b *EventBus) run() {
+	defer b.wg.Done()
+	for e := range b.queue {
+		for _, handler := range e.handlers {
+			dispatchEvent(handler, e.value)
+		}
+	}
+}
+
+// dispatchEvent isolates panics of subscribers.
+func dispatchEvent(handler func(interface{}), value interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			GetLogger("melon").Errorf("event subscriber of %T panicked: %v", value, r)
+		}
+	}()
+	handler(value)
+}
+
+// publish publishes a lifecycle event if the lifecycle belongs to an
+// environment.
+func (env *LifecycleEnvironment) publish(phase, detail string) {
+	if env.events != nil {
+		env.events.publish(typeOf[LifecycleEvent](), LifecycleEvent{Phase: phase, Detail: detail})
+	}
+}
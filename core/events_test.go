@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/goburrow/melon/health"
+)
+
+// nopRouter discards all handlers.
+type nopRouter struct{}
+
+func (nopRouter) Handle(method, pattern string, handler http.Handler) {}
+func (nopRouter) PathPrefix() string                                  { return "" }
+func (nopRouter) Endpoints() []string                                 { return nil }
+
+type userCreated struct {
+	Name string
+}
+
+type userDeleted struct {
+	Name string
+}
+
+func TestEventBusRouting(t *testing.T) {
+	env := NewEnvironment()
+	var mu sync.Mutex
+	var created, deleted []string
+	Subscribe(env, func(e userCreated) {
+		mu.Lock()
+		created = append(created, e.Name)
+		mu.Unlock()
+	})
+	Subscribe(env, func(e *userDeleted) {
+		mu.Lock()
+		deleted = append(deleted, e.Name)
+		mu.Unlock()
+	})
+	Subscribe(env, func(e userCreated) {
+		panic("subscriber failed")
+	})
+	env.Events.Start()
+	if !Publish(env, userCreated{"a"}) || !Publish(env, &userDeleted{"b"}) || !Publish(env, userDeleted{"c"}) {
+		t.Fatal("unexpected dropped event")
+	}
+	env.Events.Stop()
+	if len(created) != 1 || created[0] != "a" || len(deleted) != 1 || deleted[0] != "b" {
+		t.Fatalf("unexpected events: %v %v", created, deleted)
+	}
+	if Publish(env, userCreated{"d"}) || env.Events.Dropped() != 1 {
+		t.Fatalf("event must be dropped after stop: %d", env.Events.Dropped())
+	}
+}
+
+func TestEventBusBackpressure(t *testing.T) {
+	env := NewEnvironment()
+	env.Events = NewEventBus(2, 1)
+	n := 0
+	Subscribe(env, func(e userCreated) {
+		n++
+	})
+	// Events are queued until the bus starts.
+	Publish(env, userCreated{"a"})
+	Publish(env, userCreated{"b"})
+	if Publish(env, userCreated{"c"}) || env.Events.Dropped() != 1 {
+		t.Fatalf("event must be dropped when queue is full: %d", env.Events.Dropped())
+	}
+	env.Events.Start()
+	env.Events.Stop()
+	if n != 2 {
+		t.Fatalf("unexpected delivered events: %d", n)
+	}
+}
+
+func TestEventBusFrameworkEvents(t *testing.T) {
+	env := NewEnvironment()
+	env.Server.Router = nopRouter{}
+	env.Admin.Router = nopRouter{}
+	var mu sync.Mutex
+	var phases []string
+	var checks []HealthEvent
+	Subscribe(env, func(e LifecycleEvent) {
+		mu.Lock()
+		phases = append(phases, e.Phase)
+		mu.Unlock()
+	})
+	Subscribe(env, func(e HealthEvent) {
+		mu.Lock()
+		checks = append(checks, e)
+		mu.Unlock()
+	})
+	env.Admin.HealthChecks.Register("db", health.CheckerFunc(func() health.Result {
+		return health.ResultUnhealthy("down", nil)
+	}))
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	env.Admin.HealthChecks.RunCheckers()
+	env.Admin.HealthChecks.RunCheckers()
+	env.Lifecycle.Drain()
+	env.Lifecycle.Undrain()
+	env.Lifecycle.Shutdown("test", "")
+	// Event bus is stopped last and delivers all queued events.
+	env.Stop()
+
+	expected := []string{LifecycleStarted, LifecycleDraining, LifecycleUndrained, LifecycleShutdown, LifecycleDraining, LifecycleStopping}
+	if len(phases) != len(expected) {
+		t.Fatalf("unexpected lifecycle events: %v", phases)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Fatalf("unexpected lifecycle events: %v", phases)
+		}
+	}
+	// readiness and db on the first run, no changes on the second.
+	if len(checks) != 2 {
+		t.Fatalf("unexpected health events: %+v", checks)
+	}
+	for _, c := range checks {
+		if c.Name == "db" && (c.Healthy || c.Message != "down") {
+			t.Fatalf("unexpected health event: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/health"
+)
+
+// ShutdownFatalHealth is the trigger of shutdown initiated by a fatal health
+// check.
+const ShutdownFatalHealth = "fatal health check"
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// HealthMonitor runs health checks in the background when fatal health checks
+// are registered. The application is shut down when a fatal health check
+// stays unhealthy longer than its grace period, so that the orchestrator can
+// replace the instance.
+type HealthMonitor struct {
+	// Interval is the period of running health checks, 10s by default.
+	Interval time.Duration
+	// DryRun only logs the shutdown which would be initiated.
+	DryRun bool
+
+	registry  health.Registry
+	lifecycle *LifecycleEnvironment
+	clock     Clock
+
+	mu    sync.Mutex
+	fatal map[string]*fatalCheck
+	done  chan struct{}
+
+	// parent is set when this monitor belongs to a mounted environment.
+	parent *HealthMonitor
+	prefix string
+}
+
+// fatalCheck is the state of a fatal health check.
+type fatalCheck struct {
+	grace time.Duration
+	// unhealthySince is zero when the health check is healthy.
+	unhealthySince time.Time
+	// reported is true when the shutdown has been logged in dry run.
+	reported bool
+}
+
+// NewHealthMonitor allocates and returns a new HealthMonitor running health
+// checks in registry.
+func NewHealthMonitor(registry health.Registry, lifecycle *LifecycleEnvironment) *HealthMonitor {
+	return &HealthMonitor{
+		registry:  registry,
+		lifecycle: lifecycle,
+		clock:     SystemClock,
+		fatal:     make(map[string]*fatalCheck),
+	}
+}
+
+// RegisterFatal registers checker as a health check whose failure for longer
+// than grace stops the application. RegisterFatal is concurrent-safe.
+func (m *HealthMonitor) RegisterFatal(name string, checker health.Checker, grace time.Duration) {
+	if m.parent != nil {
+		m.parent.RegisterFatal(m.prefix+name, checker, grace)
+		return
+	}
+	m.registry.Register(name, checker)
+	m.mu.Lock()
+	m.fatal[name] = &fatalCheck{grace: grace}
+	m.mu.Unlock()
+}
+
+// start runs health checks periodically if there are fatal health checks.
+func (m *HealthMonitor) start(clock Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.parent != nil || len(m.fatal) == 0 || m.done != nil {
+		return
+	}
+	m.clock = clock
+	interval := m.Interval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	m.done = make(chan struct{})
+	go m.run(interval, m.done)
+}
+
+// stop stops running health checks.
+func (m *HealthMonitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+}
+
+func (m *HealthMonitor) run(interval time.Duration, done chan struct{}) {
+	ticker := m.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			m.check(m.registry.RunCheckers())
+		case <-done:
+			return
+		}
+	}
+}
+
+// check updates states of fatal health checks from results and initiates
+// shutdown when one of them has been unhealthy longer than its grace period.
+func (m *HealthMonitor) check(results map[string]health.Result) {
+	now := m.clock.Now()
+	logger := GetLogger("melon")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, c := range m.fatal {
+		result, ok := results[name]
+		if !ok {
+			continue
+		}
+		if result.Healthy() {
+			if !c.unhealthySince.IsZero() {
+				logger.Infof("fatal health check %s recovered after %v", name, now.Sub(c.unhealthySince))
+				c.unhealthySince = time.Time{}
+				c.reported = false
+			}
+			continue
+		}
+		if c.unhealthySince.IsZero() {
+			c.unhealthySince = now
+			logger.Warnf("fatal health check %s is unhealthy, shutting down in %v: %s", name, c.grace, resultMessage(result))
+		}
+		elapsed := now.Sub(c.unhealthySince)
+		if elapsed < c.grace {
+			continue
+		}
+		detail := fmt.Sprintf("%s unhealthy for %v: %s", name, elapsed, resultMessage(result))
+		if m.DryRun {
+			if !c.reported {
+				c.reported = true
+				logger.Warnf("dry run: would shut down: fatal health check %s", detail)
+			}
+			continue
+		}
+		logger.Errorf("fatal health check %s, shutting down", detail)
+		m.lifecycle.Shutdown(ShutdownFatalHealth, detail)
+		return
+	}
+}
+
+func resultMessage(result health.Result) string {
+	msg := result.Message()
+	if result.Cause() != nil {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += result.Cause().Error()
+	}
+	return msg
+}
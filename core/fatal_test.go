@@ -0,0 +1,106 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/health"
+)
+
+// testClock is a Clock whose time is only changed by tests.
+type testClock struct {
+	Clock
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newTestHealthMonitor() (*Environment, *time.Time, *bool) {
+	env := NewEnvironment()
+	clock := &testClock{Clock: SystemClock, now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	env.HealthMonitor.clock = clock
+	healthy := true
+	env.HealthMonitor.RegisterFatal("db", health.CheckerFunc(func() health.Result {
+		if healthy {
+			return health.Healthy
+		}
+		return health.ResultUnhealthy("corrupted", nil)
+	}), time.Minute)
+	return env, &clock.now, &healthy
+}
+
+func TestFatalHealthCheck(t *testing.T) {
+	env, now, healthy := newTestHealthMonitor()
+	m := env.HealthMonitor
+	m.check(env.Admin.HealthChecks.RunCheckers())
+
+	*healthy = false
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	*now = now.Add(59 * time.Second)
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	if _, ok := env.Lifecycle.ShutdownReason(); ok {
+		t.Fatalf("unexpected shutdown within grace period")
+	}
+	*now = now.Add(time.Second)
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	reason, ok := env.Lifecycle.ShutdownReason()
+	if !ok || reason.Trigger != ShutdownFatalHealth || reason.Detail != "db unhealthy for 1m0s: corrupted" {
+		t.Fatalf("unexpected shutdown reason: %v %v", reason, ok)
+	}
+}
+
+func TestFatalHealthCheckRecovered(t *testing.T) {
+	env, now, healthy := newTestHealthMonitor()
+	m := env.HealthMonitor
+
+	*healthy = false
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	*now = now.Add(50 * time.Second)
+	*healthy = true
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	// Grace period restarts.
+	*healthy = false
+	*now = now.Add(20 * time.Second)
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	*now = now.Add(30 * time.Second)
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	if _, ok := env.Lifecycle.ShutdownReason(); ok {
+		t.Fatalf("unexpected shutdown after recovery")
+	}
+}
+
+func TestFatalHealthCheckDryRun(t *testing.T) {
+	env, now, healthy := newTestHealthMonitor()
+	m := env.HealthMonitor
+	m.DryRun = true
+
+	*healthy = false
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	*now = now.Add(2 * time.Minute)
+	m.check(env.Admin.HealthChecks.RunCheckers())
+	if _, ok := env.Lifecycle.ShutdownReason(); ok {
+		t.Fatalf("unexpected shutdown in dry run")
+	}
+	if !m.fatal["db"].reported {
+		t.Fatalf("shutdown must be reported in dry run")
+	}
+}
+
+func TestFatalHealthCheckMounted(t *testing.T) {
+	env := NewEnvironment()
+	child, err := env.Mount("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.HealthMonitor.RegisterFatal("db", health.CheckerFunc(func() health.Result {
+		return health.Healthy
+	}), time.Second)
+	if _, ok := env.HealthMonitor.fatal["billing/db"]; !ok {
+		t.Fatalf("unexpected fatal health checks: %v", env.HealthMonitor.fatal)
+	}
+	if r, ok := env.Admin.HealthChecks.RunChecker("billing/db"); !ok || !r.Healthy() {
+		t.Fatalf("unexpected health check result: %v", r)
+	}
+}
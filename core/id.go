@@ -0,0 +1,155 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford's Base32 alphabet used by ULID.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IDGenerator generates unique identifiers, e.g. for request IDs.
+type IDGenerator interface {
+	NewID() string
+}
+
+// uuidGenerator generates random (version 4) UUIDs.
+type uuidGenerator struct{}
+
+// NewUUIDGenerator returns an IDGenerator which generates random (version 4) UUIDs.
+func NewUUIDGenerator() IDGenerator {
+	return uuidGenerator{}
+}
+
+func (uuidGenerator) NewID() string {
+	return newUUID()
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant RFC 4122
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// ulidGenerator generates ULIDs (https://github.com/ulid/spec).
+type ulidGenerator struct {
+	monotonic bool
+
+	mu      sync.Mutex
+	lastMS  uint64
+	lastRnd [10]byte
+}
+
+// NewULIDGenerator returns an IDGenerator which generates lexicographically
+// sortable ULIDs.
+func NewULIDGenerator() IDGenerator {
+	return &ulidGenerator{}
+}
+
+// NewMonotonicULIDGenerator returns an IDGenerator which generates ULIDs
+// strictly increasing even within the same millisecond.
+// It is safe for concurrent use.
+func NewMonotonicULIDGenerator() IDGenerator {
+	return &ulidGenerator{monotonic: true}
+}
+
+func (g *ulidGenerator) NewID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if g.monotonic {
+		g.mu.Lock()
+		if ms <= g.lastMS && increment(g.lastRnd[:]) {
+			ms = g.lastMS
+		} else {
+			if _, err := rand.Read(g.lastRnd[:]); err != nil {
+				g.mu.Unlock()
+				panic(err)
+			}
+			if ms < g.lastMS {
+				// Clock went backwards.
+				ms = g.lastMS
+			}
+		}
+		g.lastMS = ms
+		copy(b[6:], g.lastRnd[:])
+		g.mu.Unlock()
+	} else {
+		if _, err := rand.Read(b[6:]); err != nil {
+			panic(err)
+		}
+	}
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	return encodeULID(&b)
+}
+
+// increment adds one to the big-endian number b and returns false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID encodes 128 bits into 26 characters, 5 bits each.
+func encodeULID(b *[16]byte) string {
+	var s [26]byte
+	var acc uint32
+	// The first character only has 3 bits, thus 2 leading zero bits.
+	bits := uint(2)
+	n := 0
+	for _, c := range b {
+		acc = acc<<8 | uint32(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			s[n] = crockford[(acc>>bits)&0x1f]
+			n++
+		}
+	}
+	return string(s[:])
+}
+
+// safeNewID calls g and falls back to a UUID when g panics or returns an
+// empty ID.
+func safeNewID(g IDGenerator) (id string) {
+	if g == nil {
+		return newUUID()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			GetLogger("melon").Warnf("id generator %T panicked: %v", g, r)
+			id = newUUID()
+		}
+	}()
+	id = g.NewID()
+	if id == "" {
+		GetLogger("melon").Warnf("id generator %T returned empty id", g)
+		id = newUUID()
+	}
+	return id
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestUUIDGenerator(t *testing.T) {
+	id := NewUUIDGenerator().NewID()
+	if !uuidPattern.MatchString(id) {
+		t.Fatalf("unexpected uuid: %v", id)
+	}
+}
+
+func TestULIDGenerator(t *testing.T) {
+	g := NewULIDGenerator()
+	id := g.NewID()
+	if !ulidPattern.MatchString(id) {
+		t.Fatalf("unexpected ulid: %v", id)
+	}
+	if id == g.NewID() {
+		t.Fatalf("duplicated ulid: %v", id)
+	}
+}
+
+func TestEncodeULID(t *testing.T) {
+	var b [16]byte
+	if s := encodeULID(&b); s != "00000000000000000000000000" {
+		t.Fatalf("unexpected ulid: %v", s)
+	}
+	for i := range b {
+		b[i] = 0xff
+	}
+	if s := encodeULID(&b); s != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Fatalf("unexpected ulid: %v", s)
+	}
+}
+
+func TestMonotonicULIDGenerator(t *testing.T) {
+	g := NewMonotonicULIDGenerator()
+	prev := g.NewID()
+	for i := 0; i < 1000; i++ {
+		id := g.NewID()
+		if id <= prev {
+			t.Fatalf("ulid is not increasing: %v <= %v", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestMonotonicULIDGeneratorConcurrent(t *testing.T) {
+	const workers, count = 8, 500
+	g := NewMonotonicULIDGenerator()
+	ids := make([][]string, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				ids[i] = append(ids[i], g.NewID())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]struct{}, workers*count)
+	for _, worker := range ids {
+		if !sort.StringsAreSorted(worker) {
+			t.Fatalf("ulids are not increasing: %v", worker)
+		}
+		for _, id := range worker {
+			if _, ok := seen[id]; ok {
+				t.Fatalf("duplicated ulid: %v", id)
+			}
+			seen[id] = struct{}{}
+		}
+	}
+}
+
+type panicGenerator struct{}
+
+func (panicGenerator) NewID() string {
+	panic("id")
+}
+
+type emptyGenerator struct{}
+
+func (emptyGenerator) NewID() string {
+	return ""
+}
+
+func TestIDGeneratorFallback(t *testing.T) {
+	env := NewEnvironment()
+	if id := env.NewID(); !uuidPattern.MatchString(id) {
+		t.Fatalf("unexpected default id: %v", id)
+	}
+	generators := []IDGenerator{nil, panicGenerator{}, emptyGenerator{}}
+	for _, g := range generators {
+		env.IDGenerator = g
+		if id := env.NewID(); !uuidPattern.MatchString(id) {
+			t.Fatalf("unexpected fallback id of %T: %v", g, id)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// leakSettleTime is how long goroutines are given to exit before leaks are
+// reported.
+var leakSettleTime = time.Second
+
+// benignGoroutines are function prefixes of goroutines which are expected to
+// survive the application, e.g. signal handling and client keep-alive.
+var benignGoroutines = []string{
+	"testing.",
+	"os/signal.",
+	"net/http.(*persistConn)",
+	"github.com/codahale/metrics",
+	"runtime.ensureSigM",
+	"runtime/trace.",
+}
+
+// GoroutineLeak is a group of goroutines with the same stack signature.
+type GoroutineLeak struct {
+	// Signature contains functions of the stack and the creator.
+	Signature string
+	Count     int
+}
+
+func (l GoroutineLeak) String() string {
+	return fmt.Sprintf("%d x %s", l.Count, l.Signature)
+}
+
+// GoroutineSnapshot is the set of goroutines running at a point of time.
+type GoroutineSnapshot map[string]struct{}
+
+// goroutine is a parsed goroutine stack.
+type goroutine struct {
+	id        string
+	signature string
+	funcs     []string
+}
+
+// SnapshotGoroutines records the running goroutines.
+func SnapshotGoroutines() GoroutineSnapshot {
+	goroutines := stackGoroutines()
+	s := make(GoroutineSnapshot, len(goroutines))
+	for _, g := range goroutines {
+		s[g.id] = struct{}{}
+	}
+	return s
+}
+
+// Leaks returns goroutines which are not in the snapshot, excluding the
+// calling goroutine and known-benign ones, grouped by their signatures.
+// A nil snapshot reports all goroutines.
+func (s GoroutineSnapshot) Leaks() []GoroutineLeak {
+	goroutines := stackGoroutines()
+	counts := make(map[string]int)
+	// The first goroutine is the current one.
+	for _, g := range goroutines[1:] {
+		if _, ok := s[g.id]; ok || g.benign() {
+			continue
+		}
+		counts[g.signature]++
+	}
+	leaks := make([]GoroutineLeak, 0, len(counts))
+	for sig, n := range counts {
+		leaks = append(leaks, GoroutineLeak{Signature: sig, Count: n})
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Count != leaks[j].Count {
+			return leaks[i].Count > leaks[j].Count
+		}
+		return leaks[i].Signature < leaks[j].Signature
+	})
+	return leaks
+}
+
+// WaitLeaks returns the leaks of s which still exist after waiting for
+// goroutines to exit up to timeout.
+func (s GoroutineSnapshot) WaitLeaks(threshold int, timeout time.Duration) []GoroutineLeak {
+	deadline := time.Now().Add(timeout)
+	for {
+		leaks := s.Leaks()
+		if countLeaks(leaks) <= threshold || time.Now().After(deadline) {
+			return leaks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func countLeaks(leaks []GoroutineLeak) int {
+	n := 0
+	for _, l := range leaks {
+		n += l.Count
+	}
+	return n
+}
+
+func (g *goroutine) benign() bool {
+	for _, f := range g.funcs {
+		for _, prefix := range benignGoroutines {
+			if strings.HasPrefix(f, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stackGoroutines returns all goroutines with the current one first.
+func stackGoroutines() []goroutine {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var goroutines []goroutine
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if g, ok := parseGoroutine(string(block)); ok {
+			goroutines = append(goroutines, g)
+		}
+	}
+	return goroutines
+}
+
+// parseGoroutine parses a stack like:
+//
+//	goroutine 7 [chan receive]:
+//	main.worker(0xc000010000)
+//		/src/main.go:10 +0x25
+//	created by main.main in goroutine 1
+//		/src/main.go:5 +0x3a
+func parseGoroutine(block string) (goroutine, bool) {
+	lines := strings.Split(strings.TrimSpace(block), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "goroutine ") {
+		return goroutine{}, false
+	}
+	var g goroutine
+	header := strings.Fields(lines[0])
+	if len(header) < 2 {
+		return goroutine{}, false
+	}
+	g.id = header[1]
+	for _, line := range lines[1:] {
+		if line == "" || line[0] == '\t' {
+			continue
+		}
+		if strings.HasPrefix(line, "created by ") {
+			line = strings.TrimPrefix(line, "created by ")
+			if i := strings.Index(line, " in goroutine "); i >= 0 {
+				line = line[:i]
+			}
+			g.funcs = append(g.funcs, line)
+			continue
+		}
+		if i := strings.LastIndexByte(line, '('); i > 0 {
+			line = line[:i]
+		}
+		g.funcs = append(g.funcs, line)
+	}
+	g.signature = strings.Join(g.funcs, " < ")
+	return g, true
+}
+
+// detectLeaks takes a snapshot of goroutines if leak detection is enabled.
+func (env *LifecycleEnvironment) detectLeaks() {
+	if env.LeakDetection {
+		env.goroutines = SnapshotGoroutines()
+	}
+}
+
+// reportLeaks logs goroutines which have survived since detectLeaks when
+// there are more than LeakThreshold of them.
+func (env *LifecycleEnvironment) reportLeaks() {
+	if env.goroutines == nil {
+		return
+	}
+	leaks := env.goroutines.WaitLeaks(env.LeakThreshold, leakSettleTime)
+	n := countLeaks(leaks)
+	if n <= env.LeakThreshold {
+		return
+	}
+	var buf bytes.Buffer
+	for _, l := range leaks {
+		fmt.Fprintf(&buf, "    %s\n", l)
+	}
+	GetLogger("melon").Warnf("%d goroutines leaked since startup =\n\n%s", n, buf.String())
+}
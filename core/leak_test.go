@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakyManaged starts goroutines which only exit when release is closed.
+type leakyManaged struct {
+	n       int
+	release chan struct{}
+}
+
+func (m *leakyManaged) Start() error {
+	for i := 0; i < m.n; i++ {
+		go leakyWorker(m.release)
+	}
+	return nil
+}
+
+func (m *leakyManaged) Stop() error {
+	return nil
+}
+
+func leakyWorker(release chan struct{}) {
+	<-release
+}
+
+func testLeakDetection(t *testing.T, n int) []string {
+	logger := &recordLogger{}
+	SetLoggerFactory(func(string) Logger { return logger })
+	defer SetLoggerFactory(getDefaultLogger)
+	settle := leakSettleTime
+	leakSettleTime = 50 * time.Millisecond
+	defer func() { leakSettleTime = settle }()
+
+	release := make(chan struct{})
+	defer close(release)
+	lifecycle := NewLifecycleEnvironment()
+	lifecycle.LeakDetection = true
+	lifecycle.LeakThreshold = 1
+	lifecycle.Manage(&leakyManaged{n: n, release: release})
+	lifecycle.detectLeaks()
+	lifecycle.start()
+	lifecycle.stop()
+	lifecycle.reportLeaks()
+	return logger.find("WARN ")
+}
+
+func TestLeakDetection(t *testing.T) {
+	warns := testLeakDetection(t, 3)
+	if len(warns) != 1 || !strings.Contains(warns[0], "3 goroutines leaked") ||
+		!strings.Contains(warns[0], "3 x github.com/goburrow/melon/core.leakyWorker < github.com/goburrow/melon/core.(*leakyManaged).Start") {
+		t.Fatalf("unexpected logs: %v", warns)
+	}
+}
+
+func TestLeakDetectionClean(t *testing.T) {
+	// One goroutine is tolerated by the threshold.
+	warns := testLeakDetection(t, 1)
+	for _, w := range warns {
+		if strings.Contains(w, "leaked") {
+			t.Fatalf("unexpected logs: %v", warns)
+		}
+	}
+}
+
+func TestParseGoroutine(t *testing.T) {
+	g, ok := parseGoroutine(`goroutine 7 [chan receive]:
+main.worker(0xc000010000)
+	/src/main.go:10 +0x25
+created by main.main in goroutine 1
+	/src/main.go:5 +0x3a`)
+	if !ok || g.id != "7" || g.signature != "main.worker < main.main" {
+		t.Fatalf("unexpected goroutine: %+v", g)
+	}
+}
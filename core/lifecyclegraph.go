@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const lifecycleGraphPath = "/lifecycle/graph"
+
+// Kinds of lifecycle graph nodes.
+const (
+	nodeStep        = "step"
+	nodeBundle      = "bundle"
+	nodeApplication = "application"
+	nodeManaged     = "managed"
+)
+
+// LifecycleGraph is the startup order of an application.
+type LifecycleGraph struct {
+	Nodes []LifecycleNode
+	Edges []LifecycleEdge
+}
+
+// LifecycleNode is a startup step, bundle, application or managed object.
+type LifecycleNode struct {
+	// ID is unique and stable among applications with the same structure.
+	ID   string
+	Kind string
+	Name string
+	// Start and Stop are the measured durations, zero when not recorded.
+	Start time.Duration `json:",omitempty"`
+	Stop  time.Duration `json:",omitempty"`
+}
+
+// LifecycleEdge means node From is started before node To.
+type LifecycleEdge struct {
+	From string
+	To   string
+}
+
+// Graph returns the order of startup steps recorded by RecordStartup and
+// managed objects, which are stopped in the reverse order.
+// Graph is concurrent-safe.
+func (env *LifecycleEnvironment) Graph() *LifecycleGraph {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	g := &LifecycleGraph{}
+	counts := make(map[string]int)
+	add := func(kind, name string, start time.Duration) int {
+		id := fmt.Sprintf("%s%d", kind, counts[kind])
+		counts[kind]++
+		if n := len(g.Nodes); n > 0 {
+			g.Edges = append(g.Edges, LifecycleEdge{From: g.Nodes[n-1].ID, To: id})
+		}
+		g.Nodes = append(g.Nodes, LifecycleNode{ID: id, Kind: kind, Name: name, Start: start})
+		return len(g.Nodes) - 1
+	}
+	// Managed objects are started in order after other steps.
+	managed := make([]int, 0, len(env.managedObjects))
+	for _, t := range env.startup.timings {
+		kind, name := nodeStep, t.Name
+		switch {
+		case strings.HasPrefix(t.Name, "bundle "):
+			kind, name = nodeBundle, strings.TrimPrefix(t.Name, "bundle ")
+		case strings.HasPrefix(t.Name, "application "):
+			kind, name = nodeApplication, strings.TrimPrefix(t.Name, "application ")
+		case strings.HasPrefix(t.Name, "start ") && len(managed) < len(env.managedObjects):
+			kind, name = nodeManaged, strings.TrimPrefix(t.Name, "start ")
+		}
+		i := add(kind, name, t.Duration)
+		if kind == nodeManaged {
+			managed = append(managed, i)
+		}
+	}
+	for _, m := range env.managedObjects[len(managed):] {
+		managed = append(managed, add(nodeManaged, fmt.Sprintf("%T", m), 0))
+	}
+	stopped := 0
+	for _, t := range env.shutdownTimings.timings {
+		if strings.HasPrefix(t.Name, "stop ") && stopped < len(managed) {
+			g.Nodes[managed[len(managed)-1-stopped]].Stop = t.Duration
+			stopped++
+		}
+	}
+	return g
+}
+
+// WriteDOT writes the graph in Graphviz DOT format. The output only depends
+// on the structure of the graph unless durations is true.
+func (g *LifecycleGraph) WriteDOT(buf *bytes.Buffer, durations bool) {
+	buf.WriteString("digraph lifecycle {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range g.Nodes {
+		label := n.Kind + "\\n" + dotEscaper.Replace(n.Name)
+		if durations {
+			if n.Start > 0 {
+				label += fmt.Sprintf("\\nstart %v", n.Start.Round(time.Microsecond))
+			}
+			if n.Stop > 0 {
+				label += fmt.Sprintf("\\nstop %v", n.Stop.Round(time.Microsecond))
+			}
+		}
+		fmt.Fprintf(buf, "\t%s [label=\"%s\"];\n", n.ID, label)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(buf, "\t%s -> %s;\n", e.From, e.To)
+	}
+	buf.WriteString("}\n")
+}
+
+// dotEscaper escapes DOT quoted strings.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// lifecycleGraphHandler displays the lifecycle graph in JSON, or DOT with
+// query format=dot. Durations are included in DOT with query durations=true.
+type lifecycleGraphHandler struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (handler *lifecycleGraphHandler) Name() string {
+	return "Lifecycle graph"
+}
+
+func (handler *lifecycleGraphHandler) Path() string {
+	return lifecycleGraphPath
+}
+
+func (handler *lifecycleGraphHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	g := handler.lifecycle.Graph()
+	query := r.URL.Query()
+	if query.Get("format") == "dot" {
+		var buf bytes.Buffer
+		g.WriteDOT(&buf, query.Get("durations") == "true")
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type dbManaged struct{ fastManaged }
+type cacheManaged struct{ fastManaged }
+
+type dbBundle struct{}
+
+func (*dbBundle) Initialize(*Bootstrap) {}
+
+func (*dbBundle) Run(_ interface{}, env *Environment) error {
+	env.Lifecycle.Manage(&dbManaged{})
+	return nil
+}
+
+type cacheBundle struct{}
+
+func (*cacheBundle) Initialize(*Bootstrap) {}
+
+func (*cacheBundle) Run(_ interface{}, env *Environment) error {
+	env.Lifecycle.Manage(&cacheManaged{})
+	return nil
+}
+
+func newGraphEnvironment(t *testing.T) *Environment {
+	env := &Environment{Lifecycle: NewLifecycleEnvironment()}
+	env.Lifecycle.RecordStartup("configuration", time.Millisecond)
+	bootstrap := &Bootstrap{}
+	bootstrap.AddBundle(&dbBundle{})
+	bootstrap.AddBundle(&cacheBundle{})
+	if err := bootstrap.Run(nil, env); err != nil {
+		t.Fatal(err)
+	}
+	env.Lifecycle.RecordStartup("application *main.app", time.Millisecond)
+	return env
+}
+
+func TestLifecycleGraph(t *testing.T) {
+	env := newGraphEnvironment(t)
+	env.Lifecycle.start()
+	env.Lifecycle.stop()
+
+	g := env.Lifecycle.Graph()
+	var nodes []LifecycleNode
+	for _, n := range g.Nodes {
+		if n.Start == 0 || n.Kind == nodeManaged && n.Stop == 0 {
+			t.Fatalf("unexpected durations: %+v", n)
+		}
+		nodes = append(nodes, LifecycleNode{ID: n.ID, Kind: n.Kind, Name: n.Name})
+	}
+	expected := []LifecycleNode{
+		{ID: "step0", Kind: nodeStep, Name: "configuration"},
+		{ID: "bundle0", Kind: nodeBundle, Name: "*core.dbBundle"},
+		{ID: "bundle1", Kind: nodeBundle, Name: "*core.cacheBundle"},
+		{ID: "application0", Kind: nodeApplication, Name: "*main.app"},
+		{ID: "managed0", Kind: nodeManaged, Name: "*core.dbManaged"},
+		{ID: "managed1", Kind: nodeManaged, Name: "*core.cacheManaged"},
+	}
+	if !reflect.DeepEqual(expected, nodes) {
+		t.Fatalf("unexpected nodes: %+v", nodes)
+	}
+	if len(g.Edges) != 5 || g.Edges[0] != (LifecycleEdge{"step0", "bundle0"}) ||
+		g.Edges[4] != (LifecycleEdge{"managed0", "managed1"}) {
+		t.Fatalf("unexpected edges: %+v", g.Edges)
+	}
+
+	w := httptest.NewRecorder()
+	(&lifecycleGraphHandler{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("GET", lifecycleGraphPath, nil))
+	var decoded LifecycleGraph
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("unexpected body: %v %s", err, w.Body)
+	}
+	if !reflect.DeepEqual(g, &decoded) {
+		t.Fatalf("unexpected graph: %+v", decoded)
+	}
+}
+
+func TestLifecycleGraphNotStarted(t *testing.T) {
+	env := newGraphEnvironment(t)
+	g := env.Lifecycle.Graph()
+	if len(g.Nodes) != 6 || g.Nodes[5].ID != "managed1" || g.Nodes[5].Start != 0 {
+		t.Fatalf("unexpected nodes: %+v", g.Nodes)
+	}
+}
+
+func TestLifecycleGraphDOT(t *testing.T) {
+	env := newGraphEnvironment(t)
+	env.Lifecycle.start()
+	golden, err := ioutil.ReadFile(filepath.Join("testdata", "lifecycle_graph.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	(&lifecycleGraphHandler{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("GET", lifecycleGraphPath+"?format=dot", nil))
+	if w.Header().Get("Content-Type") != "text/vnd.graphviz; charset=utf-8" {
+		t.Fatalf("unexpected content type: %v", w.Header())
+	}
+	if !bytes.Equal(golden, w.Body.Bytes()) {
+		t.Fatalf("unexpected DOT:\n%s", w.Body)
+	}
+	w = httptest.NewRecorder()
+	(&lifecycleGraphHandler{env.Lifecycle}).ServeHTTP(w, httptest.NewRequest("GET", lifecycleGraphPath+"?format=dot&durations=true", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte(`label="step\nconfiguration\nstart 1ms"`)) {
+		t.Fatalf("unexpected DOT:\n%s", w.Body)
+	}
+}
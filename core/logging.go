@@ -1,6 +1,13 @@
 package core
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const logLevelTaskName = "log-level"
 
 // Logger is an interface used for logging.
 type Logger interface {
@@ -51,3 +58,65 @@ func getDefaultLogger(name string) Logger {
 type LoggingFactory interface {
 	ConfigureLogging(*Environment) error
 }
+
+// LogLevels gets and changes levels of named loggers at runtime. Empty name
+// is the root logger. It is set by the logging factory with SetLogLevels.
+type LogLevels interface {
+	// Levels returns the accepted level names.
+	Levels() []string
+	Level(name string) string
+	SetLevel(name, level string) error
+}
+
+var logLevels LogLevels
+
+// SetLogLevels sets levels of loggers changed by task log-level.
+func SetLogLevels(l LogLevels) {
+	logLevels = l
+}
+
+// logLevelTask changes level of a logger, which is the root logger when
+// query logger is not given.
+type logLevelTask struct {
+}
+
+func (*logLevelTask) Name() string {
+	return logLevelTaskName
+}
+
+func (*logLevelTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	levels := logLevels
+	if levels == nil {
+		http.Error(w, "Log levels are not supported.", http.StatusNotImplemented)
+		return
+	}
+	name := r.FormValue("logger")
+	level := strings.ToUpper(r.FormValue("level"))
+	if !containsString(levels.Levels(), level) {
+		http.Error(w, fmt.Sprintf("Unsupported level %q. Accepted levels: %s", r.FormValue("level"),
+			strings.Join(levels.Levels(), ", ")), http.StatusBadRequest)
+		return
+	}
+	if TaskCancelled(w, r) {
+		return
+	}
+	previous := levels.Level(name)
+	if err := levels.SetLevel(name, level); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		name = "root"
+	}
+	GetLogger("melon").Infof("level of logger %s changed from %s to %s by %s", name, previous, level, r.RemoteAddr)
+	fmt.Fprintf(w, "%s: %s -> %s\n", name, previous, level)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
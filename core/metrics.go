@@ -1,6 +1,81 @@
 package core
 
+import (
+	"sync"
+	"time"
+
+	"github.com/codahale/metrics"
+)
+
 // MetricsFactory is a factory for configuring the metrics for the environment.
 type MetricsFactory interface {
 	ConfigureMetrics(*Environment) error
 }
+
+// MetricsRegistry registers application metrics. Metrics are kept in the
+// process-wide registry of package github.com/codahale/metrics, which is also
+// used by server components, and are displayed at admin /metrics.
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	histograms map[string]*metrics.Histogram
+}
+
+// NewMetricsRegistry allocates and returns a new MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		histograms: make(map[string]*metrics.Histogram),
+	}
+}
+
+// Counter returns the counter of name.
+func (m *MetricsRegistry) Counter(name string) MetricCounter {
+	return MetricCounter(name)
+}
+
+// Gauge returns the gauge of name.
+func (m *MetricsRegistry) Gauge(name string) metrics.Gauge {
+	return metrics.Gauge(name)
+}
+
+// Histogram returns the histogram of name recording values between min and
+// max with 3 significant figures. It is created on the first call, later
+// calls return the same histogram regardless of min and max.
+func (m *MetricsRegistry) Histogram(name string, min, max int64) *metrics.Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.histograms[name]
+	if !ok {
+		h = metrics.NewHistogram(name, min, max, 3)
+		m.histograms[name] = h
+	}
+	return h
+}
+
+// Timer returns the timer of name, which records durations in milliseconds
+// up to 3 minutes like the latency of resources.
+func (m *MetricsRegistry) Timer(name string) MetricTimer {
+	return MetricTimer{m.Histogram(name, 1, 1000*60*3)}
+}
+
+// MetricCounter is a monotonically increasing counter.
+type MetricCounter string
+
+// Inc increases the counter by n.
+func (c MetricCounter) Inc(n uint64) {
+	metrics.Counter(c).AddN(n)
+}
+
+// MetricTimer records durations to a histogram.
+type MetricTimer struct {
+	histogram *metrics.Histogram
+}
+
+// Update records duration d.
+func (t MetricTimer) Update(d time.Duration) {
+	t.histogram.RecordValue(int64(d / time.Millisecond))
+}
+
+// UpdateSince records the duration since start.
+func (t MetricTimer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const modePath = "/config"
+
+// Mode adjusts groups of defaults for either production or development.
+// Each of the defaults can still be set explicitly.
+type Mode int
+
+// Supported modes.
+const (
+	// ModeProduction hides error details, parses configuration strictly and
+	// disables debugging endpoints by default.
+	ModeProduction Mode = iota
+	// ModeDevelopment reveals error details, re-parses templates and pretty
+	// prints responses by default.
+	ModeDevelopment
+)
+
+// ParseMode returns Mode from its name: production or development.
+// Empty string is ModeProduction.
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "", "production":
+		return ModeProduction, nil
+	case "development":
+		return ModeDevelopment, nil
+	default:
+		return ModeProduction, fmt.Errorf("unsupported mode: %s", name)
+	}
+}
+
+func (m Mode) String() string {
+	if m == ModeDevelopment {
+		return "development"
+	}
+	return "production"
+}
+
+// ModeDefaults are the defaults adjusted by Mode.
+type ModeDefaults struct {
+	// ErrorDetail is used when server error detail is not configured.
+	ErrorDetail ErrorDetail
+	// StrictParsing rejects unknown fields in configuration files.
+	StrictParsing bool
+	// ReloadTemplates re-parses HTML templates of views on every render.
+	ReloadTemplates bool
+	// PrettyJSON indents JSON responses of views.
+	PrettyJSON bool
+	// Pprof enables /debug/pprof/ of the debug bundle.
+	Pprof bool
+	// ConsoleRequestLog logs requests to stdout when request log appenders
+	// are not configured.
+	ConsoleRequestLog bool
+}
+
+// Defaults returns defaults of the mode.
+func (m Mode) Defaults() ModeDefaults {
+	if m == ModeDevelopment {
+		return ModeDefaults{
+			ErrorDetail:       ErrorDetailStack,
+			ReloadTemplates:   true,
+			PrettyJSON:        true,
+			Pprof:             true,
+			ConsoleRequestLog: true,
+		}
+	}
+	return ModeDefaults{
+		ErrorDetail:   ErrorDetailNone,
+		StrictParsing: true,
+	}
+}
+
+// modeHandler displays the mode, its defaults and the configuration source.
+type modeHandler struct {
+	env     *Environment
+	content staticContent
+}
+
+func (handler *modeHandler) Name() string {
+	return "Configuration"
+}
+
+func (handler *modeHandler) Path() string {
+	return modePath
+}
+
+func (handler *modeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Mode and error detail do not change after startup.
+	handler.content.serve(w, r, "text/plain", func(w io.Writer) {
+		defaults := handler.env.Mode.Defaults()
+		fmt.Fprintf(w, "mode: %s\n", handler.env.Mode)
+		if handler.env.ConfigurationSource != "" {
+			fmt.Fprintf(w, "source: %s\n", handler.env.ConfigurationSource)
+		}
+		fmt.Fprintf(w, "\ndefaults:\n")
+		fmt.Fprintf(w, "    errorDetail: %s\n", defaults.ErrorDetail)
+		fmt.Fprintf(w, "    strictParsing: %t\n", defaults.StrictParsing)
+		fmt.Fprintf(w, "    reloadTemplates: %t\n", defaults.ReloadTemplates)
+		fmt.Fprintf(w, "    prettyJSON: %t\n", defaults.PrettyJSON)
+		fmt.Fprintf(w, "    pprof: %t\n", defaults.Pprof)
+		fmt.Fprintf(w, "    consoleRequestLog: %t\n", defaults.ConsoleRequestLog)
+		fmt.Fprintf(w, "\neffective:\n    errorDetail: %s\n", handler.env.Server.ErrorDetail)
+	})
+}
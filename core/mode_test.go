@@ -0,0 +1,56 @@
+package core
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]Mode{
+		"":            ModeProduction,
+		"production":  ModeProduction,
+		"development": ModeDevelopment,
+	}
+	for name, mode := range tests {
+		m, err := ParseMode(name)
+		if err != nil || m != mode {
+			t.Fatalf("unexpected mode of %q: %v %v", name, m, err)
+		}
+	}
+	if _, err := ParseMode("staging"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestModeDefaults(t *testing.T) {
+	production := ModeDefaults{
+		ErrorDetail:   ErrorDetailNone,
+		StrictParsing: true,
+	}
+	if d := ModeProduction.Defaults(); d != production {
+		t.Fatalf("unexpected production defaults: %+v", d)
+	}
+	development := ModeDefaults{
+		ErrorDetail:       ErrorDetailStack,
+		ReloadTemplates:   true,
+		PrettyJSON:        true,
+		Pprof:             true,
+		ConsoleRequestLog: true,
+	}
+	if d := ModeDevelopment.Defaults(); d != development {
+		t.Fatalf("unexpected development defaults: %+v", d)
+	}
+}
+
+func TestModeHandler(t *testing.T) {
+	env := NewEnvironment()
+	env.Mode = ModeDevelopment
+	env.ConfigurationSource = "file config.yaml"
+	w := httptest.NewRecorder()
+	(&modeHandler{env: env}).ServeHTTP(w, httptest.NewRequest("GET", modePath, nil))
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "mode: development\nsource: file config.yaml\n") || !strings.Contains(body, "errorDetail: stack\n") {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goburrow/melon/health"
+)
+
+// Mount creates a child environment scoped under the given name, which is
+// used as both the URL path prefix and the namespace of health checks, admin
+// handlers and tasks. Managed objects of the child join the lifecycle of env.
+// Mount is not concurrent-safe.
+func (env *Environment) Mount(name string) (*Environment, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return nil, fmt.Errorf("mount: name must not be empty")
+	}
+	for _, child := range env.children {
+		if child.name == name {
+			return nil, fmt.Errorf("mount: %s has already been mounted", name)
+		}
+	}
+	prefix := "/" + name
+	child := &Environment{
+		Server: &ServerEnvironment{
+			Router:       &mountedRouter{parent: env.Server.Router, prefix: prefix},
+			Deprecations: env.Server.Deprecations,
+		},
+		Lifecycle: env.Lifecycle,
+		Admin: &AdminEnvironment{
+			Router: &mountedRouter{parent: env.Admin.Router, prefix: prefix},
+			HealthChecks: &mountedRegistry{
+				parent: env.Admin.HealthChecks,
+				prefix: name + "/",
+			},
+			parent: env.Admin,
+			prefix: prefix,
+		},
+		HealthMonitor: &HealthMonitor{
+			parent: env.HealthMonitor,
+			prefix: name + "/",
+		},
+		Validator:   env.Validator,
+		IDGenerator: env,
+		Mode:        env.Mode,
+		Clock:       env.Clock,
+		Events:      env.Events,
+		Metrics:     env.Metrics,
+
+		name: name,
+	}
+	env.children = append(env.children, child)
+	return child, nil
+}
+
+// handleComponents handles components registered in the child environments.
+func (env *Environment) handleComponents() {
+	for _, child := range env.children {
+		child.handleComponents()
+		child.Server.handleComponents()
+	}
+}
+
+// mountedRouter prefixes all patterns registered to the parent router.
+type mountedRouter struct {
+	parent Router
+	prefix string
+}
+
+func (r *mountedRouter) Handle(method, pattern string, handler http.Handler) {
+	r.parent.Handle(method, r.prefix+pattern, handler)
+}
+
+func (r *mountedRouter) PathPrefix() string {
+	return r.parent.PathPrefix() + r.prefix
+}
+
+func (r *mountedRouter) Endpoints() []string {
+	return r.parent.Endpoints()
+}
+
+func (r *mountedRouter) Routes() []RouteInfo {
+	if l, ok := r.parent.(RouteLister); ok {
+		return l.Routes()
+	}
+	return nil
+}
+
+// mountedRegistry namespaces health checks registered to the parent registry.
+type mountedRegistry struct {
+	parent health.Registry
+	prefix string
+}
+
+func (r *mountedRegistry) Register(name string, healthCheck health.Checker) {
+	r.parent.Register(r.prefix+name, healthCheck)
+}
+
+func (r *mountedRegistry) RegisterNonCritical(name string, healthCheck health.Checker) {
+	r.parent.RegisterNonCritical(r.prefix+name, healthCheck)
+}
+
+func (r *mountedRegistry) Unregister(name string) {
+	r.parent.Unregister(r.prefix + name)
+}
+
+func (r *mountedRegistry) Names() []string {
+	var names []string
+	for _, name := range r.parent.Names() {
+		if strings.HasPrefix(name, r.prefix) {
+			names = append(names, name[len(r.prefix):])
+		}
+	}
+	return names
+}
+
+func (r *mountedRegistry) RunChecker(name string) (health.Result, bool) {
+	return r.parent.RunChecker(r.prefix + name)
+}
+
+// SetTimeout sets the timeout of the parent registry, which applies to all
+// health checks.
+func (r *mountedRegistry) SetTimeout(timeout time.Duration) {
+	r.parent.SetTimeout(timeout)
+}
+
+// SetCacheTTL sets the cache TTL of the parent registry, which applies to all
+// health checks.
+func (r *mountedRegistry) SetCacheTTL(ttl time.Duration) {
+	r.parent.SetCacheTTL(ttl)
+}
+
+func (r *mountedRegistry) RunCheckers() map[string]health.Result {
+	return r.runCheckers(r.RunChecker)
+}
+
+func (r *mountedRegistry) ForceRunChecker(name string) (health.Result, bool) {
+	if parent, ok := r.parent.(health.CacheRegistry); ok {
+		return parent.ForceRunChecker(r.prefix + name)
+	}
+	return r.RunChecker(name)
+}
+
+func (r *mountedRegistry) ForceRunCheckers() map[string]health.Result {
+	return r.runCheckers(r.ForceRunChecker)
+}
+
+func (r *mountedRegistry) runCheckers(run func(string) (health.Result, bool)) map[string]health.Result {
+	names := r.Names()
+	results := make(map[string]health.Result, len(names))
+	for _, name := range names {
+		if result, ok := run(name); ok {
+			results[name] = result
+		}
+	}
+	return results
+}
+
+// mountedHandler is an admin handler registered by a child environment.
+type mountedHandler struct {
+	AdminHandler
+	prefix string
+}
+
+func (h *mountedHandler) Path() string {
+	return h.prefix + h.AdminHandler.Path()
+}
+
+func (h *mountedHandler) Name() string {
+	return h.prefix[1:] + " " + h.AdminHandler.Name()
+}
+
+// mountedTask is a task registered by a child environment.
+type mountedTask struct {
+	Task
+	prefix string
+}
+
+func (t *mountedTask) Name() string {
+	return t.prefix[1:] + "/" + t.Task.Name()
+}
+
+func (t *mountedTask) ContentType() string {
+	if c, ok := t.Task.(interface{ ContentType() string }); ok {
+		return c.ContentType()
+	}
+	return ""
+}
+
+func (t *mountedTask) Timeout() time.Duration {
+	if c, ok := t.Task.(interface{ Timeout() time.Duration }); ok {
+		return c.Timeout()
+	}
+	return 0
+}
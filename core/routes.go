@@ -0,0 +1,192 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const endpointsOpenAPIPath = "/endpoints/openapi"
+
+// RouteInfo describes a route registered to the application router.
+type RouteInfo struct {
+	Method string
+	// Pattern includes the path prefix of the router.
+	Pattern string
+	// Component is the type of the registered component.
+	Component string
+	Tags      []string `json:",omitempty"`
+	// Deprecation is set if the route is deprecated.
+	Deprecation *Deprecation `json:",omitempty"`
+	// Consumes and Produces are the media types the route is restricted to.
+	Consumes []string `json:",omitempty"`
+	Produces []string `json:",omitempty"`
+}
+
+// Tagged is implemented by components which are tagged in their routes,
+// e.g. public, internal or a rate limit class.
+type Tagged interface {
+	Tags() []string
+}
+
+// Negotiated is implemented by components which only accept and respond the
+// given media types. Empty lists are not restricted.
+type Negotiated interface {
+	Consumes() []string
+	Produces() []string
+}
+
+// RouteLister is implemented by routers which can list their routes.
+type RouteLister interface {
+	// Routes returns registered routes in the order of precedence.
+	Routes() []RouteInfo
+}
+
+// Routes returns routes of the application router or nil if the router does
+// not support listing them.
+// Components are registered to the router when the server starts.
+func (env *ServerEnvironment) Routes() []RouteInfo {
+	if r, ok := env.Router.(RouteLister); ok {
+		return r.Routes()
+	}
+	return nil
+}
+
+// openAPIMethods are the operations of routes registered for any method.
+var openAPIMethods = []string{"get", "put", "post", "delete", "patch"}
+
+// endpointsOpenAPIHandler exports application routes as an OpenAPI document
+// which only contains paths, e.g. for configuring API gateways.
+// Wildcard routes are not supported by OpenAPI and are omitted.
+type endpointsOpenAPIHandler struct {
+	server  *ServerEnvironment
+	content staticContent
+}
+
+func (handler *endpointsOpenAPIHandler) Name() string {
+	return "Endpoints (OpenAPI)"
+}
+
+func (handler *endpointsOpenAPIHandler) Path() string {
+	return endpointsOpenAPIPath
+}
+
+func (handler *endpointsOpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Disposition", `attachment; filename="openapi.json"`)
+	handler.content.serve(w, r, "application/json", func(w io.Writer) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(openAPIDocument(handler.server.Routes()))
+	})
+}
+
+type openAPIOperation struct {
+	Tags        []string               `json:"tags,omitempty"`
+	Deprecated  bool                   `json:"deprecated,omitempty"`
+	Sunset      string                 `json:"x-sunset,omitempty"`
+	Component   string                 `json:"x-component"`
+	RequestBody map[string]interface{} `json:"requestBody,omitempty"`
+	Responses   map[string]interface{} `json:"responses"`
+}
+
+// openAPIContent returns content of request bodies or responses with the
+// media types.
+func openAPIContent(mediaTypes []string) map[string]interface{} {
+	content := make(map[string]interface{}, len(mediaTypes))
+	for _, m := range mediaTypes {
+		content[m] = map[string]interface{}{}
+	}
+	return content
+}
+
+func openAPIDocument(routes []RouteInfo) map[string]interface{} {
+	paths := make(map[string]map[string]openAPIOperation)
+	for _, route := range routes {
+		p, ok := openAPIPath(route.Pattern)
+		if !ok {
+			continue
+		}
+		response := map[string]interface{}{"description": ""}
+		if len(route.Produces) > 0 {
+			response["content"] = openAPIContent(route.Produces)
+		}
+		op := openAPIOperation{
+			Tags:      route.Tags,
+			Component: route.Component,
+			Responses: map[string]interface{}{
+				"default": response,
+			},
+		}
+		if len(route.Consumes) > 0 && route.Method != "GET" && route.Method != "HEAD" {
+			op.RequestBody = map[string]interface{}{"content": openAPIContent(route.Consumes)}
+		}
+		if d := route.Deprecation; d != nil {
+			op.Deprecated = true
+			if !d.Sunset.IsZero() {
+				op.Sunset = d.Sunset.UTC().Format(time.RFC3339)
+			}
+		}
+		methods := openAPIMethods
+		if route.Method != "" && route.Method != "*" {
+			methods = []string{strings.ToLower(route.Method)}
+		}
+		item, ok := paths[p]
+		if !ok {
+			item = make(map[string]openAPIOperation)
+			paths[p] = item
+		}
+		for _, m := range methods {
+			// Routes are in the order of precedence so the first one wins.
+			if _, ok := item[m]; !ok {
+				item[m] = op
+			}
+		}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "melon",
+			"version": "",
+		},
+		"paths": paths,
+	}
+}
+
+// openAPIPath removes types and regular expressions from path parameters of
+// pattern. It returns false for wildcard patterns.
+func openAPIPath(pattern string) (string, bool) {
+	if strings.HasSuffix(pattern, "*") {
+		return "", false
+	}
+	var buf strings.Builder
+	depth := 0
+	skip := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '{':
+			depth++
+			if depth == 1 {
+				skip = false
+				buf.WriteByte(c)
+				continue
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				buf.WriteByte(c)
+				continue
+			}
+		case ':':
+			if depth == 1 {
+				skip = true
+			}
+		}
+		if !skip {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), depth == 0
+}
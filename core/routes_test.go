@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// routeListerRouter lists the given routes.
+type routeListerRouter struct {
+	nopRouter
+	routes []RouteInfo
+}
+
+func (r *routeListerRouter) Routes() []RouteInfo {
+	return r.routes
+}
+
+func TestEndpointsManifest(t *testing.T) {
+	routes := []RouteInfo{
+		{Method: "GET", Pattern: "/api/users/{id:[0-9]{1,8}}", Component: "*main.userResource", Tags: []string{"public"}},
+		{Method: "DELETE", Pattern: "/api/users/{id:int}", Component: "*main.userResource", Tags: []string{"internal"},
+			Deprecation: &Deprecation{Sunset: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Link: "/api/v2/users"}},
+		{Method: "POST", Pattern: "/api/hooks", Component: "*main.hookResource",
+			Consumes: []string{"application/json"}, Produces: []string{"application/json"}},
+		{Method: "*", Pattern: "/api/health", Component: "*main.healthResource"},
+		{Method: "*", Pattern: "/legacy/*", Component: "*server.proxyHandler"},
+	}
+	env := NewEnvironment()
+	if env.Server.Routes() != nil {
+		t.Fatalf("unexpected routes: %+v", env.Server.Routes())
+	}
+	env.Server.Router = &routeListerRouter{routes: routes}
+	child, err := env.Mount("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(child.Server.Routes(), routes) {
+		t.Fatalf("unexpected routes of mounted environment: %+v", child.Server.Routes())
+	}
+
+	w := httptest.NewRecorder()
+	(&endpointsHandler{server: env.Server}).ServeHTTP(w, httptest.NewRequest("GET", "/endpoints?format=json", nil))
+	var manifest []RouteInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || !reflect.DeepEqual(manifest, routes) {
+		t.Fatalf("unexpected manifest: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	(&endpointsOpenAPIHandler{server: env.Server}).ServeHTTP(w, httptest.NewRequest("GET", endpointsOpenAPIPath, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Disposition") == "" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Tags       []string
+			Deprecated bool
+			Sunset     string `json:"x-sunset"`
+			Component  string `json:"x-component"`
+			Request    struct {
+				Content map[string]interface{}
+			} `json:"requestBody"`
+			Responses struct {
+				Default struct {
+					Content map[string]interface{}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Paths) != 3 {
+		t.Fatalf("unexpected paths: %s", w.Body.String())
+	}
+	user := doc.Paths["/api/users/{id}"]
+	if len(user) != 2 || !reflect.DeepEqual(user["get"].Tags, []string{"public"}) ||
+		!reflect.DeepEqual(user["delete"].Tags, []string{"internal"}) || user["get"].Component != "*main.userResource" {
+		t.Fatalf("unexpected user path: %+v", user)
+	}
+	if user["get"].Deprecated || !user["delete"].Deprecated || user["delete"].Sunset != "2030-01-01T00:00:00Z" {
+		t.Fatalf("unexpected deprecation: %+v", user)
+	}
+	hook := doc.Paths["/api/hooks"]["post"]
+	if len(hook.Request.Content) != 1 || hook.Request.Content["application/json"] == nil ||
+		len(hook.Responses.Default.Content) != 1 || hook.Responses.Default.Content["application/json"] == nil {
+		t.Fatalf("unexpected hook path: %+v", hook)
+	}
+	if user["get"].Responses.Default.Content != nil {
+		t.Fatalf("unexpected user path: %+v", user)
+	}
+	if len(doc.Paths["/api/health"]) != len(openAPIMethods) {
+		t.Fatalf("unexpected health path: %+v", doc.Paths["/api/health"])
+	}
+}
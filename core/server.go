@@ -8,7 +8,16 @@ import (
 
 // ResourceHandler handles the given HTTP resources.
 type ResourceHandler interface {
-	HandleResource(interface{})
+	// HandleResource returns true if the component is claimed by this
+	// handler, false if it is not supported.
+	HandleResource(interface{}) bool
+}
+
+// ResourceValidator is implemented by resource handlers which claim resources
+// that cannot be registered, e.g. with invalid paths. Environment fails to
+// start when ResourceError returns an error.
+type ResourceValidator interface {
+	ResourceError() error
 }
 
 // Router allows users to register a http.Handler.
@@ -26,11 +35,60 @@ type ServerFactory interface {
 	BuildServer(environment *Environment) (Managed, error)
 }
 
+// ErrorDetail is the level of details revealed in error responses.
+type ErrorDetail int
+
+// Supported levels of ErrorDetail.
+const (
+	// ErrorDetailNone responds generic error messages only.
+	ErrorDetailNone ErrorDetail = iota
+	// ErrorDetailMessage includes error messages.
+	ErrorDetailMessage
+	// ErrorDetailStack includes error messages and stack traces.
+	ErrorDetailStack
+)
+
+// ParseErrorDetail returns ErrorDetail from its name: none, message or stack.
+// Empty string is ErrorDetailNone.
+func ParseErrorDetail(name string) (ErrorDetail, error) {
+	switch name {
+	case "", "none":
+		return ErrorDetailNone, nil
+	case "message":
+		return ErrorDetailMessage, nil
+	case "stack":
+		return ErrorDetailStack, nil
+	default:
+		return ErrorDetailNone, fmt.Errorf("unsupported error detail: %s", name)
+	}
+}
+
+func (d ErrorDetail) String() string {
+	switch d {
+	case ErrorDetailMessage:
+		return "message"
+	case ErrorDetailStack:
+		return "stack"
+	default:
+		return "none"
+	}
+}
+
 // ServerEnvironment contains handlers for server and resources.
 type ServerEnvironment struct {
 	// Router belongs to the Server created by ServerFactory.
 	// The default implementation is DefaultServerHandler.
 	Router Router
+	// ErrorDetail is set by ServerFactory and should be respected by
+	// components writing error responses.
+	ErrorDetail ErrorDetail
+	// BroadcastResources gives every component to all resource handlers.
+	// By default, a component is only handled by the first handler which
+	// claims it.
+	BroadcastResources bool
+	// Deprecations tracks usage of deprecated routes. Routers supporting
+	// deprecation serve deprecated routes through its Handler.
+	Deprecations *DeprecationTracker
 
 	components       []interface{}
 	resourceHandlers []ResourceHandler
@@ -38,11 +96,13 @@ type ServerEnvironment struct {
 
 // NewServerEnvironment creates a new ServerEnvironment.
 func NewServerEnvironment() *ServerEnvironment {
-	return &ServerEnvironment{}
+	return &ServerEnvironment{
+		Deprecations: NewDeprecationTracker(),
+	}
 }
 
 // Register registers component to the environment. These components will be
-// handled by all handlers added by AddResourceHandler.
+// handled by handlers added by AddResourceHandler, the last added first.
 func (env *ServerEnvironment) Register(component ...interface{}) {
 	env.components = append(env.components, component...)
 }
@@ -53,18 +113,43 @@ func (env *ServerEnvironment) AddResourceHandler(handler ...ResourceHandler) {
 	env.resourceHandlers = append(env.resourceHandlers, handler...)
 }
 
-func (env *ServerEnvironment) start() {
-	for _, component := range env.components {
-		env.handle(component)
+func (env *ServerEnvironment) start() error {
+	env.handleComponents()
+	for _, h := range env.resourceHandlers {
+		if v, ok := h.(ResourceValidator); ok {
+			if err := v.ResourceError(); err != nil {
+				return err
+			}
+		}
 	}
 	env.logResources()
 	env.logEndpoints()
+	return nil
+}
+
+func (env *ServerEnvironment) handleComponents() {
+	for _, component := range env.components {
+		env.handle(component)
+	}
 }
 
 func (env *ServerEnvironment) handle(component interface{}) {
+	logger := GetLogger("melon")
+	claimed := false
 	// Last handler first
 	for i := len(env.resourceHandlers) - 1; i >= 0; i-- {
-		env.resourceHandlers[i].HandleResource(component)
+		h := env.resourceHandlers[i]
+		if !h.HandleResource(component) {
+			continue
+		}
+		logger.Debugf("resource %T is handled by %T", component, h)
+		claimed = true
+		if !env.BroadcastResources {
+			break
+		}
+	}
+	if !claimed {
+		logger.Warnf("resource %T is not handled by any resource handlers", component)
 	}
 }
 
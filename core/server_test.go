@@ -0,0 +1,59 @@
+package core
+
+import (
+	"testing"
+)
+
+// claimHandler claims components of the given kind and records all
+// components it has been given.
+type claimHandler struct {
+	kind    string
+	handled []interface{}
+}
+
+func (h *claimHandler) HandleResource(v interface{}) bool {
+	h.handled = append(h.handled, v)
+	s, ok := v.(string)
+	return ok && s == h.kind
+}
+
+func TestServerEnvironmentClaim(t *testing.T) {
+	logger := &recordLogger{}
+	SetLoggerFactory(func(string) Logger { return logger })
+	defer SetLoggerFactory(getDefaultLogger)
+
+	rest := &claimHandler{kind: "rest"}
+	rpc := &claimHandler{kind: "rpc"}
+	env := NewServerEnvironment()
+	env.AddResourceHandler(rest, rpc)
+	env.Register("rpc", "rest", 1)
+	env.handleComponents()
+	// rpc is added last so it is given components first and stops at claims.
+	if len(rpc.handled) != 3 {
+		t.Fatalf("unexpected components of rpc handler: %v", rpc.handled)
+	}
+	if len(rest.handled) != 2 || rest.handled[0] != "rest" || rest.handled[1] != 1 {
+		t.Fatalf("unexpected components of rest handler: %v", rest.handled)
+	}
+	warns := logger.find("WARN resource")
+	if len(warns) != 1 || warns[0] != "WARN resource int is not handled by any resource handlers" {
+		t.Fatalf("unexpected warnings: %v", warns)
+	}
+	debugs := logger.find("DEBUG resource")
+	if len(debugs) != 2 || debugs[0] != "DEBUG resource string is handled by *core.claimHandler" {
+		t.Fatalf("unexpected debug logs: %v", debugs)
+	}
+}
+
+func TestServerEnvironmentBroadcast(t *testing.T) {
+	rest := &claimHandler{kind: "rest"}
+	rpc := &claimHandler{kind: "rest"}
+	env := NewServerEnvironment()
+	env.BroadcastResources = true
+	env.AddResourceHandler(rest, rpc)
+	env.Register("rest")
+	env.handleComponents()
+	if len(rest.handled) != 1 || len(rpc.handled) != 1 {
+		t.Fatalf("unexpected components: %v %v", rest.handled, rpc.handled)
+	}
+}
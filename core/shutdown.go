@@ -0,0 +1,96 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Triggers of application shutdown.
+const (
+	ShutdownSignal      = "signal"
+	ShutdownTask        = "task"
+	ShutdownServerError = "server error"
+	ShutdownBundleError = "bundle error"
+	// ShutdownHandler is the trigger when the application is run as a
+	// http.Handler and its shutdown function is called.
+	ShutdownHandler = "handler"
+
+	shutdownTaskName = "shutdown"
+)
+
+// ShutdownReason describes why the application stopped.
+type ShutdownReason struct {
+	Trigger string
+	Detail  string `json:",omitempty"`
+	Time    time.Time
+}
+
+func (r ShutdownReason) String() string {
+	if r.Detail == "" {
+		return r.Trigger
+	}
+	return r.Trigger + ": " + r.Detail
+}
+
+// Shutdown requests the application to stop with the given trigger and detail.
+// The application starts draining. Only the first request is recorded.
+// Shutdown is concurrent-safe.
+func (env *LifecycleEnvironment) Shutdown(trigger, detail string) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.shutdownReason != nil {
+		return
+	}
+	env.shutdownReason = &ShutdownReason{
+		Trigger: trigger,
+		Detail:  detail,
+		Time:    time.Now(),
+	}
+	env.publish(LifecycleShutdown, env.shutdownReason.String())
+	env.Drain()
+	if env.shutdown == nil {
+		env.shutdown = make(chan struct{})
+	}
+	close(env.shutdown)
+}
+
+// ShutdownRequested returns a channel which is closed when Shutdown is called.
+func (env *LifecycleEnvironment) ShutdownRequested() <-chan struct{} {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.shutdown == nil {
+		env.shutdown = make(chan struct{})
+	}
+	return env.shutdown
+}
+
+// ShutdownReason returns the reason recorded by Shutdown.
+func (env *LifecycleEnvironment) ShutdownReason() (ShutdownReason, bool) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.shutdownReason == nil {
+		return ShutdownReason{}, false
+	}
+	return *env.shutdownReason, true
+}
+
+// shutdownTask stops the application.
+type shutdownTask struct {
+	lifecycle *LifecycleEnvironment
+}
+
+// NewShutdownTask returns an admin task which requests the application to stop.
+// It is not registered by default.
+func NewShutdownTask(lifecycle *LifecycleEnvironment) Task {
+	return &shutdownTask{lifecycle: lifecycle}
+}
+
+func (*shutdownTask) Name() string {
+	return shutdownTaskName
+}
+
+func (t *shutdownTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.lifecycle.Shutdown(ShutdownTask, fmt.Sprintf("requested by %s", r.RemoteAddr))
+	w.Write([]byte("Shutting down...\n"))
+}
@@ -0,0 +1,456 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTaskContentType = "text/plain; charset=utf-8"
+
+	taskHistoryPath = "/tasks/history"
+	// taskHistorySize is the number of recorded task executions.
+	taskHistorySize = 20
+	// maxTaskOutput is the maximum size of the recorded output of a task.
+	maxTaskOutput = 4096
+)
+
+// TaskOption configures a task created by NewTask.
+type TaskOption func(*task)
+
+// WithContentType declares Content-Type of the task responses, which is
+// text/plain by default.
+func WithContentType(contentType string) TaskOption {
+	return func(t *task) {
+		t.contentType = contentType
+	}
+}
+
+// WithTimeout cancels the context of the task request after the given
+// duration. Tasks are not limited by default.
+func WithTimeout(timeout time.Duration) TaskOption {
+	return func(t *task) {
+		t.timeout = timeout
+	}
+}
+
+// task is a named handler created by NewTask.
+type task struct {
+	name        string
+	handler     http.Handler
+	contentType string
+	timeout     time.Duration
+}
+
+// NewTask returns a Task running handler. Responses of all tasks are plain
+// text with content sniffing disabled unless a content type is declared.
+func NewTask(name string, handler http.Handler, options ...TaskOption) Task {
+	t := &task{
+		name:    name,
+		handler: handler,
+	}
+	for _, opt := range options {
+		opt(t)
+	}
+	return t
+}
+
+func (t *task) Name() string {
+	return t.name
+}
+
+func (t *task) ContentType() string {
+	return t.contentType
+}
+
+func (t *task) Timeout() time.Duration {
+	return t.timeout
+}
+
+func (t *task) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.handler.ServeHTTP(w, r)
+}
+
+// Exclusive returns a Task running task at most once at a time, e.g. tasks
+// which are harmful when triggered twice by two operators. Requests while it
+// is running respond with status 409. Duration of each execution is logged.
+func Exclusive(task Task) Task {
+	return &exclusiveTask{Task: task}
+}
+
+// exclusiveTask guards execution of a task.
+type exclusiveTask struct {
+	Task
+
+	mu      sync.Mutex
+	running bool
+	started time.Time
+}
+
+func (t *exclusiveTask) ContentType() string {
+	if c, ok := t.Task.(interface{ ContentType() string }); ok {
+		return c.ContentType()
+	}
+	return ""
+}
+
+func (t *exclusiveTask) Timeout() time.Duration {
+	if c, ok := t.Task.(interface{ Timeout() time.Duration }); ok {
+		return c.Timeout()
+	}
+	return 0
+}
+
+func (t *exclusiveTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	if t.running {
+		elapsed := time.Since(t.started)
+		t.mu.Unlock()
+		http.Error(w, fmt.Sprintf("Task %s has been running for %v", t.Name(), elapsed.Round(time.Millisecond)),
+			http.StatusConflict)
+		return
+	}
+	start := time.Now()
+	t.running = true
+	t.started = start
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.running = false
+		t.mu.Unlock()
+		GetLogger("melon").Infof("task %s completed in %v", t.Name(), time.Since(start))
+	}()
+	t.Task.ServeHTTP(w, r)
+}
+
+// AddTaskFunc adds a task whose output is always plain text. The task
+// responds with status 500 and the error message if f returns an error.
+// AddTaskFunc is not concurrent-safe.
+func (env *AdminEnvironment) AddTaskFunc(name string, f func(w io.Writer, r *http.Request) error) {
+	env.AddTaskContextFunc(name, func(ctx context.Context, w io.Writer, r *http.Request) error {
+		return f(w, r)
+	})
+}
+
+// AddTaskContextFunc is AddTaskFunc with the context of the task, which is
+// cancelled when the client goes away or the task times out. Long running
+// tasks should stop when it is done. Output of tasks returning after that is
+// kept in the task history.
+// AddTaskContextFunc is not concurrent-safe.
+func (env *AdminEnvironment) AddTaskContextFunc(name string, f func(ctx context.Context, w io.Writer, r *http.Request) error,
+	options ...TaskOption) {
+	env.AddTask(NewTask(name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := f(r.Context(), &buf, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(buf.Bytes())
+	}), options...))
+}
+
+// ParamTask is a task receiving parameters parsed from the query string and
+// form values of the request instead of handling the request itself.
+type ParamTask interface {
+	Name() string
+	// Execute runs the task, writing its output to out.
+	Execute(params url.Values, out io.Writer) error
+}
+
+// AddParamTask adds tasks receiving parameters. Output of a task is streamed
+// to the client. If the task returns an error before writing any output, it
+// responds with status 500 and the error message, otherwise the message is
+// appended to the output.
+// Like Task, a ParamTask may declare ContentType() string and
+// Timeout() time.Duration methods.
+// AddParamTask is not concurrent-safe.
+func (env *AdminEnvironment) AddParamTask(task ...ParamTask) {
+	for _, t := range task {
+		env.AddTask(&paramTask{t})
+	}
+}
+
+// paramTask is a Task running a ParamTask.
+type paramTask struct {
+	ParamTask
+}
+
+func (t *paramTask) ContentType() string {
+	if c, ok := t.ParamTask.(interface{ ContentType() string }); ok {
+		return c.ContentType()
+	}
+	return ""
+}
+
+func (t *paramTask) Timeout() time.Duration {
+	if c, ok := t.ParamTask.(interface{ Timeout() time.Duration }); ok {
+		return c.Timeout()
+	}
+	return 0
+}
+
+func (t *paramTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if TaskCancelled(w, r) {
+		return
+	}
+	out := &taskOutput{w: w}
+	if err := t.Execute(r.Form, out); err != nil {
+		if !out.written {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		GetLogger("melon").Warnf("task %s failed after writing output: %v", t.Name(), err)
+		io.WriteString(w, "\nError: "+err.Error()+"\n")
+	}
+}
+
+// taskOutput flushes task output to the client as it is written.
+type taskOutput struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (o *taskOutput) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	o.written = true
+	n, err := o.w.Write(b)
+	if f, ok := o.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// TaskCancelled responds status 503 and returns true if the context of the
+// task request is done. Tasks should check it before expensive steps.
+func TaskCancelled(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.Context().Err(); err != nil {
+		http.Error(w, "Task cancelled: "+err.Error(), http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
+
+// taskHandler sets default headers of task responses, cancels the task
+// request on timeout and records executions in history.
+type taskHandler struct {
+	Task
+	contentType string
+	timeout     time.Duration
+	history     *taskHistory
+}
+
+func newTaskHandler(t Task) *taskHandler {
+	h := &taskHandler{
+		Task:        t,
+		contentType: defaultTaskContentType,
+	}
+	if c, ok := t.(interface{ ContentType() string }); ok && c.ContentType() != "" {
+		h.contentType = c.ContentType()
+	}
+	if c, ok := t.(interface{ Timeout() time.Duration }); ok {
+		h.timeout = c.Timeout()
+	}
+	return h
+}
+
+func (h *taskHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", h.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Server cancels the request context when the client goes away.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if h.timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), h.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(r.Context())
+	}
+	defer cancel()
+	if h.history == nil {
+		h.Task.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+	rec := &taskRecorder{ResponseWriter: w}
+	e := taskExecution{
+		Name:  h.Name(),
+		Start: time.Now(),
+	}
+	h.Task.ServeHTTP(rec, r.WithContext(ctx))
+	e.Duration = time.Since(e.Start)
+	e.Status = rec.status
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	if err := ctx.Err(); err != nil {
+		e.Cancelled = err.Error()
+		GetLogger("melon").Warnf("task %s returned after it was cancelled: %v", h.Name(), err)
+	}
+	e.Output = rec.output.String()
+	h.history.add(e)
+}
+
+// taskRecorder keeps status and the beginning of the output of a task.
+type taskRecorder struct {
+	http.ResponseWriter
+	status int
+	output bytes.Buffer
+}
+
+func (w *taskRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *taskRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if n := maxTaskOutput - w.output.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.output.Write(b[:n])
+	}
+	// Client may have gone away, the output is still recorded.
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so that task output is streamed.
+func (w *taskRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// taskExecution is a task execution recorded in taskHistory.
+type taskExecution struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Status   int
+	// Cancelled is the reason the task context was done before the task
+	// returned, e.g. the client went away or the timeout expired.
+	Cancelled string `json:",omitempty"`
+	Output    string `json:",omitempty"`
+}
+
+// taskHistory keeps the recent task executions.
+type taskHistory struct {
+	mu         sync.Mutex
+	executions []taskExecution
+	// next is the position of the next execution when the ring is full.
+	next int
+}
+
+func (h *taskHistory) add(e taskExecution) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.executions) < taskHistorySize {
+		h.executions = append(h.executions, e)
+		return
+	}
+	h.executions[h.next] = e
+	h.next = (h.next + 1) % taskHistorySize
+}
+
+// list returns executions from the most recent.
+func (h *taskHistory) list() []taskExecution {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]taskExecution, 0, len(h.executions))
+	for i := len(h.executions) - 1; i >= 0; i-- {
+		list = append(list, h.executions[(h.next+i)%len(h.executions)])
+	}
+	return list
+}
+
+// taskInfo is a registered task listed by tasksHandler.
+type taskInfo struct {
+	Name string
+	URL  string
+}
+
+// tasksHandler lists registered tasks with their URLs. It responds JSON when
+// requested with Accept: application/json or query format=json.
+type tasksHandler struct {
+	env         *AdminEnvironment
+	content     staticContent
+	jsonContent staticContent
+}
+
+func (handler *tasksHandler) Name() string {
+	return "Tasks"
+}
+
+func (handler *tasksHandler) Path() string {
+	return tasksPath
+}
+
+func (handler *tasksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Tasks do not change after startup.
+	w.Header().Add("Vary", "Accept")
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		handler.jsonContent.serve(w, r, "application/json", func(w io.Writer) {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(handler.tasks())
+		})
+		return
+	}
+	handler.content.serve(w, r, "text/plain", func(w io.Writer) {
+		for _, t := range handler.tasks() {
+			fmt.Fprintf(w, "%-24s POST %s\n", t.Name, t.URL)
+		}
+	})
+}
+
+func (handler *tasksHandler) tasks() []taskInfo {
+	var prefix string
+	if handler.env.Router != nil {
+		prefix = handler.env.Router.PathPrefix()
+	}
+	tasks := make([]taskInfo, len(handler.env.tasks))
+	for i, t := range handler.env.tasks {
+		tasks[i] = taskInfo{
+			Name: t.Name(),
+			URL:  prefix + tasksPath + "/" + t.Name(),
+		}
+	}
+	return tasks
+}
+
+// taskHistoryHandler lists recent task executions with their outputs.
+type taskHistoryHandler struct {
+	history *taskHistory
+}
+
+func (handler *taskHistoryHandler) Name() string {
+	return "Task History"
+}
+
+func (handler *taskHistoryHandler) Path() string {
+	return taskHistoryPath
+}
+
+func (handler *taskHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(handler.history.list())
+}
@@ -0,0 +1,481 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskHeaders(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.SetMaxCPUProfileDuration(time.Millisecond)
+	env.Admin.AddTaskFunc("echo", func(w io.Writer, r *http.Request) error {
+		if r.FormValue("fail") != "" {
+			return errors.New("failed")
+		}
+		_, err := io.WriteString(w, "<script>"+r.FormValue("msg")+"</script>")
+		return err
+	})
+	for _, task := range env.Admin.tasks {
+		w := httptest.NewRecorder()
+		newTaskHandler(task).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+task.Name()+"?msg=hi", nil))
+		contentType := "text/plain; charset=utf-8"
+		if c, ok := task.(interface{ ContentType() string }); ok && c.ContentType() != "" {
+			contentType = c.ContentType()
+		}
+		if w.Header().Get("Content-Type") != contentType || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("unexpected headers of task %s: %v", task.Name(), w.Header())
+		}
+	}
+	w := httptest.NewRecorder()
+	newTaskHandler(env.Admin.tasks[len(env.Admin.tasks)-1]).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/echo?fail=1", nil))
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "failed\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTaskContentType(t *testing.T) {
+	task := NewTask("json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}), WithContentType("application/json"))
+	for _, task := range []Task{task, &mountedTask{Task: task, prefix: "/child"}} {
+		w := httptest.NewRecorder()
+		newTaskHandler(task).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/json", nil))
+		if w.Header().Get("Content-Type") != "application/json" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("unexpected headers of task %s: %v", task.Name(), w.Header())
+		}
+	}
+}
+
+func TestTaskCancelledByClient(t *testing.T) {
+	env := NewEnvironment()
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	env.Admin.AddTaskContextFunc("reindex", func(ctx context.Context, w io.Writer, r *http.Request) error {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		_, err := io.WriteString(w, "reindexed 10 of 100")
+		return err
+	})
+	h := newTaskHandler(env.Admin.tasks[len(env.Admin.tasks)-1])
+	h.history = env.Admin.taskHistory
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, "POST", server.URL, nil)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := http.DefaultClient.Do(r); err == nil {
+		t.Fatalf("error expected when client goes away")
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cancellation is not observed by the task")
+	}
+	var list []taskExecution
+	for i := 0; len(list) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("task execution is not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+		list = env.Admin.taskHistory.list()
+	}
+	e := list[0]
+	if e.Name != "reindex" || e.Cancelled == "" || e.Output != "reindexed 10 of 100" || e.Status != http.StatusOK {
+		t.Fatalf("unexpected execution: %+v", e)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	task := NewTask("slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		if TaskCancelled(w, r) {
+			return
+		}
+		w.Write([]byte("done"))
+	}), WithTimeout(10*time.Millisecond))
+	history := &taskHistory{}
+	for _, task := range []Task{task, &mountedTask{Task: task, prefix: "/child"}} {
+		h := newTaskHandler(task)
+		h.history = history
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/slow", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected response of %s: %d %s", task.Name(), w.Code, w.Body.String())
+		}
+	}
+	list := history.list()
+	if len(list) != 2 || list[0].Name != "child/slow" || list[1].Name != "slow" ||
+		list[0].Cancelled != context.DeadlineExceeded.Error() || list[0].Status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected history: %+v", list)
+	}
+}
+
+func TestExclusiveTask(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var executions int32
+	task := Exclusive(NewTask("flush", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&executions, 1)
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("flushed"))
+	}), WithContentType("application/json")))
+	h := newTaskHandler(task)
+	if h.contentType != "application/json" {
+		t.Fatalf("unexpected content type: %s", h.contentType)
+	}
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(first, httptest.NewRequest("POST", "/tasks/flush", nil))
+	}()
+	<-started
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/flush", nil))
+	if w.Code != http.StatusConflict || !strings.HasPrefix(w.Body.String(), "Task flush has been running for ") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	close(release)
+	<-done
+	if first.Code != http.StatusOK || first.Body.String() != "flushed" || executions != 1 {
+		t.Fatalf("unexpected response: %d %s %d", first.Code, first.Body.String(), executions)
+	}
+	// Task can run again once completed.
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/flush", nil))
+	if w.Code != http.StatusOK || executions != 2 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTaskHistorySize(t *testing.T) {
+	history := &taskHistory{}
+	for i := 0; i < taskHistorySize+5; i++ {
+		history.add(taskExecution{Status: i})
+	}
+	list := history.list()
+	if len(list) != taskHistorySize || list[0].Status != taskHistorySize+4 || list[taskHistorySize-1].Status != 5 {
+		t.Fatalf("unexpected history: %+v", list)
+	}
+}
+
+// muxRouter is a Router serving handlers by http.ServeMux.
+type muxRouter struct {
+	*http.ServeMux
+}
+
+func (r muxRouter) Handle(method, pattern string, handler http.Handler) {
+	r.ServeMux.Handle(pattern, handler)
+}
+
+func (muxRouter) PathPrefix() string {
+	return ""
+}
+
+func (muxRouter) Endpoints() []string {
+	return nil
+}
+
+func TestParamTaskGC(t *testing.T) {
+	env := NewEnvironment()
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/gc?verbose=true", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(body, "Before: HeapAlloc: ") ||
+		!strings.Contains(body, "\nAfter: HeapAlloc: ") || !strings.HasSuffix(body, "Done!\n") {
+		t.Fatalf("unexpected response: %d %s", w.Code, body)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/gc", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "Running GC...\nDone!\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != defaultTaskContentType {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+}
+
+func TestFreeOSMemoryTask(t *testing.T) {
+	env := NewEnvironment()
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+freeOSMemoryTaskName, nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != defaultTaskContentType ||
+		!strings.HasPrefix(body, "Before: HeapReleased: ") || !strings.Contains(body, "\nAfter: HeapReleased: ") {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), body)
+	}
+}
+
+func TestHeapProfileTask(t *testing.T) {
+	env := NewEnvironment()
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+heapProfileTaskName+"?gc=true", nil))
+	// Profiles are gzipped.
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" ||
+		!bytes.HasPrefix(w.Body.Bytes(), []byte{0x1f, 0x8b}) {
+		t.Fatalf("unexpected response: %d %v %q", w.Code, w.Header(), w.Body.Bytes())
+	}
+
+	dir, err := ioutil.TempDir("", "heapprofile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "heap.pb.gz")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+heapProfileTaskName+"?file="+url.QueryEscape(file), nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != defaultTaskContentType ||
+		w.Body.String() != "Heap profile written to "+file+"\n" {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Fatalf("unexpected file: %q %v", data, err)
+	}
+	// Existing files are not overwritten.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+heapProfileTaskName+"?file="+url.QueryEscape(file), nil))
+	if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+}
+
+func TestCPUProfileTask(t *testing.T) {
+	env := NewEnvironment()
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+cpuProfileTaskName+"?duration=100ms", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="cpu.pb.gz"` || w.Body.Len() == 0 {
+		t.Fatalf("unexpected response: %d %v %d", w.Code, w.Header(), w.Body.Len())
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+cpuProfileTaskName+"?duration=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	// Duration is capped.
+	env.Admin.SetMaxCPUProfileDuration(10 * time.Millisecond)
+	start := time.Now()
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+cpuProfileTaskName+"?duration=1h", nil))
+	if w.Code != http.StatusOK || time.Since(start) > 10*time.Second {
+		t.Fatalf("unexpected response: %d %v", w.Code, time.Since(start))
+	}
+	// Concurrent profiles
+	env.Admin.SetMaxCPUProfileDuration(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := httptest.NewRequest("POST", "/tasks/"+cpuProfileTaskName, nil).WithContext(ctx)
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	for atomic.LoadInt32(&env.Admin.cpuProfile.running) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/"+cpuProfileTaskName, nil))
+	cancel()
+	<-done
+	if w.Code != http.StatusConflict || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
+
+// echoTask writes the msg parameter and fails when fail is set.
+type echoTask struct{}
+
+func (echoTask) Name() string {
+	return "echo"
+}
+
+func (echoTask) Execute(params url.Values, out io.Writer) error {
+	io.WriteString(out, params.Get("msg"))
+	if params.Get("fail") != "" {
+		return errors.New(params.Get("fail"))
+	}
+	return nil
+}
+
+func TestParamTask(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.AddParamTask(echoTask{})
+	h := newTaskHandler(env.Admin.tasks[len(env.Admin.tasks)-1])
+	serve := func(url, form string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", url, strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// Form values take precedence over query string.
+	w := serve("/tasks/echo?msg=query", "msg=form")
+	if w.Code != http.StatusOK || w.Body.String() != "form" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("/tasks/echo?fail=broken", "")
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "broken\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("/tasks/echo?fail=broken&msg=partial", "")
+	if w.Code != http.StatusOK || w.Body.String() != "partial\nError: broken\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("/tasks/echo", "msg=%zz")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// streamTask calls written after writing its first line.
+type streamTask struct {
+	written func()
+}
+
+func (streamTask) Name() string {
+	return "stream"
+}
+
+func (t streamTask) Execute(params url.Values, out io.Writer) error {
+	io.WriteString(out, "first\n")
+	t.written()
+	io.WriteString(out, "second\n")
+	return nil
+}
+
+func TestParamTaskStreaming(t *testing.T) {
+	env := NewEnvironment()
+	w := httptest.NewRecorder()
+	flushed := false
+	env.Admin.AddParamTask(streamTask{written: func() {
+		flushed = w.Flushed
+	}})
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/stream", nil))
+	if !flushed || w.Body.String() != "first\nsecond\n" {
+		t.Fatalf("unexpected response: %t %s", flushed, w.Body.String())
+	}
+}
+
+func TestTasksHandler(t *testing.T) {
+	env := NewEnvironment()
+	env.Admin.AddParamTask(echoTask{})
+	mux := muxRouter{http.NewServeMux()}
+	env.Admin.Router = mux
+	env.Admin.start()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/tasks", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		!strings.Contains(w.Body.String(), "gc                       POST /tasks/gc\n") ||
+		!strings.HasSuffix(w.Body.String(), "echo                     POST /tasks/echo\n") {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	r := httptest.NewRequest("GET", "/tasks", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	var tasks []taskInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &tasks); err != nil {
+		t.Fatalf("unexpected response %s: %v", w.Body.String(), err)
+	}
+	if len(tasks) != len(env.Admin.tasks) || tasks[0] != (taskInfo{Name: "gc", URL: "/tasks/gc"}) ||
+		tasks[len(tasks)-1] != (taskInfo{Name: "echo", URL: "/tasks/echo"}) {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), `<a href="/tasks">Tasks</a>`) {
+		t.Fatalf("tasks link expected: %s", w.Body.String())
+	}
+}
+
+// fakeLogLevels keeps levels of loggers in a map.
+type fakeLogLevels map[string]string
+
+func (fakeLogLevels) Levels() []string {
+	return []string{"DEBUG", "INFO"}
+}
+
+func (l fakeLogLevels) Level(name string) string {
+	return l[name]
+}
+
+func (l fakeLogLevels) SetLevel(name, level string) error {
+	l[name] = level
+	return nil
+}
+
+func TestLogLevelTask(t *testing.T) {
+	serve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		(&logLevelTask{}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/log-level?"+query, nil))
+		return w
+	}
+	SetLogLevels(nil)
+	if w := serve("level=debug"); w.Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	levels := fakeLogLevels{"": "INFO", "melon/server": "INFO"}
+	SetLogLevels(levels)
+	defer SetLogLevels(nil)
+
+	w := serve("logger=melon/server&level=debug")
+	if w.Code != http.StatusOK || w.Body.String() != "melon/server: INFO -> DEBUG\n" || levels["melon/server"] != "DEBUG" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("logger=melon/server&level=INFO")
+	if w.Code != http.StatusOK || w.Body.String() != "melon/server: DEBUG -> INFO\n" || levels["melon/server"] != "INFO" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("level=debug")
+	if w.Code != http.StatusOK || w.Body.String() != "root: INFO -> DEBUG\n" || levels[""] != "DEBUG" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serve("logger=melon/server&level=verbose")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Accepted levels: DEBUG, INFO") || levels["melon/server"] != "INFO" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const lifecyclePath = "/lifecycle"
+
+// Timing is the duration of a step in application startup or shutdown.
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+// breakdown is a list of timings of a lifecycle phase.
+type breakdown struct {
+	timings []Timing
+	// completed is false while the phase is in progress.
+	completed bool
+}
+
+// sorted returns timings from the slowest one.
+func (b *breakdown) sorted() []Timing {
+	timings := make([]Timing, len(b.timings))
+	copy(timings, b.timings)
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].Duration > timings[j].Duration
+	})
+	return timings
+}
+
+func (b *breakdown) total() time.Duration {
+	var d time.Duration
+	for _, t := range b.timings {
+		d += t.Duration
+	}
+	return d
+}
+
+// writeTable writes timings sorted by duration to buf.
+func (b *breakdown) writeTable(buf *bytes.Buffer) {
+	for _, t := range b.sorted() {
+		fmt.Fprintf(buf, "    %12s  %s\n", t.Duration.Round(time.Microsecond), t.Name)
+	}
+}
+
+// RecordStartup records the duration of a startup step.
+// RecordStartup is concurrent-safe.
+func (env *LifecycleEnvironment) RecordStartup(name string, d time.Duration) {
+	env.mu.Lock()
+	env.startup.timings = append(env.startup.timings, Timing{name, d})
+	env.mu.Unlock()
+}
+
+// RecordShutdown records the duration of a shutdown step.
+// RecordShutdown is concurrent-safe.
+func (env *LifecycleEnvironment) RecordShutdown(name string, d time.Duration) {
+	env.mu.Lock()
+	env.shutdownTimings.timings = append(env.shutdownTimings.timings, Timing{name, d})
+	env.mu.Unlock()
+}
+
+// StartupTimings returns durations of startup steps from the slowest one.
+func (env *LifecycleEnvironment) StartupTimings() []Timing {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.startup.sorted()
+}
+
+// ShutdownTimings returns durations of shutdown steps from the slowest one.
+func (env *LifecycleEnvironment) ShutdownTimings() []Timing {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.shutdownTimings.sorted()
+}
+
+// logBreakdown logs timings of the completed phase and warns about steps
+// slower than SlowThreshold.
+func (env *LifecycleEnvironment) logBreakdown(phase string, b *breakdown) {
+	env.mu.Lock()
+	b.completed = true
+	var buf bytes.Buffer
+	b.writeTable(&buf)
+	total := b.total()
+	var slow []Timing
+	if env.SlowThreshold > 0 {
+		for _, t := range b.sorted() {
+			if t.Duration > env.SlowThreshold {
+				slow = append(slow, t)
+			}
+		}
+	}
+	env.mu.Unlock()
+
+	logger := GetLogger("melon")
+	logger.Infof("%s took %v =\n\n%s", phase, total.Round(time.Microsecond), buf.String())
+	for _, t := range slow {
+		logger.Warnf("%s: %s is slow: %v > %v", phase, t.Name, t.Duration, env.SlowThreshold)
+	}
+}
+
+// lifecycleHandler displays the most recent startup and shutdown timings.
+type lifecycleHandler struct {
+	lifecycle *LifecycleEnvironment
+}
+
+func (handler *lifecycleHandler) Name() string {
+	return "Lifecycle"
+}
+
+func (handler *lifecycleHandler) Path() string {
+	return lifecyclePath
+}
+
+func (handler *lifecycleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "text/plain")
+
+	env := handler.lifecycle
+	var buf bytes.Buffer
+	env.mu.Lock()
+	for _, phase := range []struct {
+		name string
+		b    *breakdown
+	}{
+		{"startup", &env.startup},
+		{"shutdown", &env.shutdownTimings},
+	} {
+		if len(phase.b.timings) == 0 {
+			continue
+		}
+		status := ""
+		if !phase.b.completed {
+			status = " (in progress)"
+		}
+		fmt.Fprintf(&buf, "%s: %v%s\n", phase.name, phase.b.total().Round(time.Microsecond), status)
+		phase.b.writeTable(&buf)
+		buf.WriteByte('\n')
+	}
+	env.mu.Unlock()
+	w.Write(buf.Bytes())
+}
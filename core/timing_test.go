@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type sleepManaged struct {
+	d time.Duration
+}
+
+func (m *sleepManaged) Start() error {
+	time.Sleep(m.d)
+	return nil
+}
+
+func (m *sleepManaged) Stop() error {
+	time.Sleep(m.d)
+	return nil
+}
+
+type fastManaged struct{}
+
+func (fastManaged) Start() error { return nil }
+func (fastManaged) Stop() error  { return nil }
+
+// recordLogger records logs of all levels.
+type recordLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	l.logs = append(l.logs, level+" "+fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) { l.log("DEBUG", format, args...) }
+func (l *recordLogger) Infof(format string, args ...interface{})  { l.log("INFO", format, args...) }
+func (l *recordLogger) Warnf(format string, args ...interface{})  { l.log("WARN", format, args...) }
+func (l *recordLogger) Errorf(format string, args ...interface{}) { l.log("ERROR", format, args...) }
+
+func (l *recordLogger) find(prefix string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+	for _, s := range l.logs {
+		if strings.HasPrefix(s, prefix) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func TestLifecycleTimings(t *testing.T) {
+	logger := &recordLogger{}
+	SetLoggerFactory(func(string) Logger { return logger })
+	defer SetLoggerFactory(getDefaultLogger)
+
+	lifecycle := NewLifecycleEnvironment()
+	lifecycle.SlowThreshold = 20 * time.Millisecond
+	lifecycle.Manage(fastManaged{})
+	lifecycle.Manage(&sleepManaged{50 * time.Millisecond})
+	lifecycle.RecordStartup("configuration", time.Millisecond)
+
+	lifecycle.start()
+	timings := lifecycle.StartupTimings()
+	if len(timings) != 3 {
+		t.Fatalf("unexpected startup timings: %+v", timings)
+	}
+	if timings[0].Name != "start *core.sleepManaged" || timings[0].Duration < 50*time.Millisecond {
+		t.Fatalf("unexpected slowest startup timing: %+v", timings[0])
+	}
+	infos := logger.find("INFO startup took")
+	if len(infos) != 1 {
+		t.Fatalf("unexpected startup logs: %v", logger.logs)
+	}
+	slow := strings.Index(infos[0], "start *core.sleepManaged")
+	fast := strings.Index(infos[0], "start core.fastManaged")
+	if slow < 0 || fast < 0 || slow > fast || !strings.Contains(infos[0], "configuration") {
+		t.Fatalf("unexpected startup table: %s", infos[0])
+	}
+	warns := logger.find("WARN startup:")
+	if len(warns) != 1 || !strings.Contains(warns[0], "start *core.sleepManaged is slow") {
+		t.Fatalf("unexpected startup warnings: %v", warns)
+	}
+
+	lifecycle.RecordShutdown("server drain", 2*time.Millisecond)
+	lifecycle.stop()
+	timings = lifecycle.ShutdownTimings()
+	if len(timings) != 3 || timings[0].Name != "stop *core.sleepManaged" {
+		t.Fatalf("unexpected shutdown timings: %+v", timings)
+	}
+	if len(logger.find("INFO shutdown took")) != 1 {
+		t.Fatalf("unexpected shutdown logs: %v", logger.logs)
+	}
+	warns = logger.find("WARN shutdown:")
+	if len(warns) != 1 || !strings.Contains(warns[0], "stop *core.sleepManaged is slow") {
+		t.Fatalf("unexpected shutdown warnings: %v", warns)
+	}
+}
+
+func TestLifecycleHandler(t *testing.T) {
+	lifecycle := NewLifecycleEnvironment()
+	lifecycle.RecordStartup("bundle a", 2*time.Second)
+	lifecycle.RecordStartup("bundle b", 3*time.Second)
+
+	handler := &lifecycleHandler{lifecycle}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", lifecyclePath, nil))
+	expected := "startup: 5s (in progress)\n" +
+		"              3s  bundle b\n" +
+		"              2s  bundle a\n\n"
+	if w.Body.String() != expected {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+const versionPath = "/version"
+
+// BuildInfo describes the running build.
+type BuildInfo struct {
+	Name      string
+	Version   string `json:",omitempty"`
+	Commit    string `json:",omitempty"`
+	BuildTime string `json:",omitempty"`
+	GoVersion string
+}
+
+var buildInfo struct {
+	mu   sync.RWMutex
+	info *BuildInfo
+}
+
+// SetBuildInfo sets the version of the application, usually injected at link
+// time with -ldflags "-X main.version=...". Without it, the version is read
+// from module and VCS information embedded by the Go toolchain.
+func SetBuildInfo(version, commit, buildTime string) {
+	info := &BuildInfo{
+		Name:      applicationName(),
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+	buildInfo.mu.Lock()
+	buildInfo.info = info
+	buildInfo.mu.Unlock()
+}
+
+// GetBuildInfo returns the build information set by SetBuildInfo or embedded
+// in the binary.
+func GetBuildInfo() BuildInfo {
+	buildInfo.mu.RLock()
+	info := buildInfo.info
+	buildInfo.mu.RUnlock()
+	if info != nil {
+		return *info
+	}
+	return readBuildInfo()
+}
+
+// readBuildInfo returns the main module version and VCS settings stamped by
+// go build.
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Name:      applicationName(),
+		GoVersion: runtime.Version(),
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Version = bi.Main.Version
+	modified := false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.BuildTime = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+func applicationName() string {
+	if len(os.Args) == 0 {
+		return ""
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// versionHandler displays the build information. It responds JSON when
+// requested with Accept: application/json or query format=json.
+type versionHandler struct {
+}
+
+func (handler *versionHandler) Name() string {
+	return "Version"
+}
+
+func (handler *versionHandler) Path() string {
+	return versionPath
+}
+
+func (handler *versionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Add("Vary", "Accept")
+	info := GetBuildInfo()
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&info)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Name: %s\nVersion: %s\nCommit: %s\nBuildTime: %s\nGoVersion: %s\n",
+		info.Name, info.Version, info.Commit, info.BuildTime, info.GoVersion)
+}
@@ -26,10 +26,16 @@ type corsFilter struct {
 	maxAge           string
 	exposedHeaders   string
 	allowCredentials bool
+
+	// matchRoute reports whether preflight requests of the path are answered.
+	matchRoute func(path string) bool
 }
 
 // NewFilter creates a new Filter providing support for Cross-Origin Resource Sharing.
 // By default, it allows all origins and method GET, HEAD and POST.
+// Allowed preflight requests are answered with 204 No Content without calling
+// the next handler. Responses to requests from other origins do not include
+// any CORS headers.
 func NewFilter(options ...Option) filter.Filter {
 	f := &corsFilter{
 		allowedOrigins: defaultOrigins,
@@ -49,9 +55,9 @@ func (f *corsFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
 	origin = f.validateOrigin(origin)
 	if origin != "" {
-		if r.Method == "OPTIONS" {
-			if f.handlePreflight(w.Header(), r.Header, origin) {
-				w.WriteHeader(http.StatusOK)
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if (f.matchRoute == nil || f.matchRoute(r.URL.Path)) && f.handlePreflight(w.Header(), r.Header, origin) {
+				w.WriteHeader(http.StatusNoContent)
 				return
 			}
 		} else {
@@ -61,17 +67,34 @@ func (f *corsFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filter.Continue(w, r)
 }
 
+// validateOrigin returns value of Access-Control-Allow-Origin header for the
+// origin or empty if it is not allowed.
 func (f *corsFilter) validateOrigin(origin string) string {
 	if origin != "" {
 		for _, v := range f.allowedOrigins {
-			if v == origin || v == "*" {
+			if v == "*" {
 				return v
 			}
+			if matchOrigin(v, origin) {
+				return origin
+			}
 		}
 	}
 	return ""
 }
 
+// matchOrigin matches origin with pattern, which can contain a wildcard for
+// subdomains, e.g. https://*.example.com.
+func matchOrigin(pattern, origin string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
 func (f *corsFilter) handlePreflight(rsp http.Header, req http.Header, origin string) bool {
 	reqMethod := req.Get("Access-Control-Request-Method")
 	if !inArray(f.allowedMethods, reqMethod) {
@@ -113,7 +136,9 @@ func (f *corsFilter) setCommonHeaders(rsp http.Header, origin string) {
 	if origin != "*" {
 		rsp.Add("Vary", "Origin")
 	}
-	if f.allowCredentials {
+	// Browsers do not send credentials to any origin, so they are only
+	// allowed for explicit origins.
+	if f.allowCredentials && origin != "*" {
 		rsp.Set("Access-Control-Allow-Credentials", "true")
 	}
 }
@@ -127,7 +152,8 @@ func inArray(arr []string, item string) bool {
 	return false
 }
 
-// WithAllowedOrigins sets origins allowed in Origin header.
+// WithAllowedOrigins sets origins allowed in Origin header. An origin can
+// contain a wildcard, e.g. https://*.example.com, and "*" allows all origins.
 func WithAllowedOrigins(origins ...string) Option {
 	return func(f *corsFilter) {
 		f.allowedOrigins = origins
@@ -164,6 +190,7 @@ func WithExposedHeaders(headers ...string) Option {
 }
 
 // WithAllowCredentials sets value "true" for Access-Control-Allow-Credentials header in CORS responses.
+// Credentials are only allowed for origins set explicitly with WithAllowedOrigins, not "*".
 func WithAllowCredentials() Option {
 	return func(f *corsFilter) {
 		f.allowCredentials = true
@@ -176,3 +203,12 @@ func WithMaxAge(seconds string) Option {
 		f.maxAge = seconds
 	}
 }
+
+// WithRouteMatcher only answers preflight requests of paths matched by match,
+// e.g. router.Router.Match. Other preflight requests are passed to the next
+// handler.
+func WithRouteMatcher(match func(path string) bool) Option {
+	return func(f *corsFilter) {
+		f.matchRoute = match
+	}
+}
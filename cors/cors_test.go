@@ -3,6 +3,7 @@ package cors
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/goburrow/melon/server/filter"
@@ -35,7 +36,7 @@ func TestPreflight(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if 200 != rsp.StatusCode {
+	if 204 != rsp.StatusCode {
 		t.Fatalf("unexpected status code: %d", rsp.StatusCode)
 	}
 	assertHeader(t, rsp.Header, "Access-Control-Allow-Origin", "*")
@@ -78,9 +79,87 @@ func TestSimple(t *testing.T) {
 	assertHeader(t, rsp.Header, "Access-Control-Expose-Headers", "Accept, Content-Length")
 }
 
+func TestWildcardOrigin(t *testing.T) {
+	f := NewFilter(WithAllowedOrigins("https://*.example.com"), WithAllowedMethods("GET", "PUT"),
+		WithAllowedHeaders("Content-Type"), WithMaxAge("600"))
+	chain := filter.NewChain()
+	chain.Add(f, http.HandlerFunc(ping))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("OPTIONS", "/ping", nil)
+	r.Header.Set("Origin", "https://api.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	chain.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	assertHeader(t, w.Header(), "Access-Control-Allow-Origin", "https://api.example.com")
+	assertHeader(t, w.Header(), "Access-Control-Allow-Methods", "GET, PUT")
+	assertHeader(t, w.Header(), "Access-Control-Allow-Headers", "Content-Type")
+	assertHeader(t, w.Header(), "Access-Control-Max-Age", "600")
+
+	for _, origin := range []string{"https://example.com", "http://api.example.com", "https://api.example.com.evil"} {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/ping", nil)
+		r.Header.Set("Origin", origin)
+		chain.ServeHTTP(w, r)
+		if w.Body.String() != "pong" {
+			t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+		}
+		for k := range w.Header() {
+			if strings.HasPrefix(k, "Access-Control-") {
+				t.Fatalf("unexpected header for %s: %v", origin, w.Header())
+			}
+		}
+	}
+}
+
+func TestRouteMatcher(t *testing.T) {
+	f := NewFilter(WithAllowedOrigins("http://localhost:8080"), WithAllowCredentials(), WithRouteMatcher(func(path string) bool {
+		return path == "/ping"
+	}))
+	chain := filter.NewChain()
+	chain.Add(f, http.HandlerFunc(ping))
+
+	preflight := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", path, nil)
+		r.Header.Set("Origin", "http://localhost:8080")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		chain.ServeHTTP(w, r)
+		return w
+	}
+	w := preflight("/ping")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+	assertHeader(t, w.Header(), "Access-Control-Allow-Origin", "http://localhost:8080")
+	assertHeader(t, w.Header(), "Access-Control-Allow-Credentials", "true")
+	w = preflight("/unknown")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+	assertHeader(t, w.Header(), "Access-Control-Allow-Origin", "")
+}
+
 func assertHeader(t *testing.T, headers http.Header, name string, expected string) {
 	header := headers.Get(name)
 	if expected != header {
 		t.Fatalf("unexpected %s: %v, expect: %v", name, header, expected)
 	}
 }
+
+func TestCredentialsAnyOrigin(t *testing.T) {
+	f := NewFilter(WithAllowCredentials())
+	chain := filter.NewChain()
+	chain.Add(f, http.HandlerFunc(ping))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/ping", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	chain.ServeHTTP(w, r)
+	// Origin is not echoed, so browsers do not send credentials.
+	assertHeader(t, w.Header(), "Access-Control-Allow-Origin", "*")
+	assertHeader(t, w.Header(), "Access-Control-Allow-Credentials", "")
+}
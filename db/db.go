@@ -0,0 +1,129 @@
+/*
+Package db provides a factory of managed sql.DB for melon applications.
+
+Factory can be embedded in the application configuration:
+
+	type AppConfig struct {
+		melon.Configuration
+		Database db.Factory
+	}
+
+The database returned by Build is closed when the application stops and its
+connectivity is reported by a health check named after the datasource.
+Database drivers must be imported by the application.
+*/
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+)
+
+const (
+	defaultName        = "database"
+	defaultPingTimeout = 5 * time.Second
+)
+
+// Factory is the configuration of a database.
+type Factory struct {
+	// Name is the name of the datasource used in health checks and logs,
+	// "database" by default.
+	Name string
+	// Driver is the registered name of the database driver, e.g. postgres.
+	Driver string `valid:"notempty"`
+	// DSN is the driver specific data source name.
+	DSN string `valid:"notempty"`
+
+	// MaxOpenConns and MaxIdleConns limit connections in the pool, see
+	// sql.DB. They are not changed if they are zero.
+	MaxOpenConns int `valid:"min=0"`
+	MaxIdleConns int `valid:"min=0"`
+	// ConnMaxLifetime is the maximum duration a connection is reused,
+	// e.g. 30m. Connections are reused forever if it is empty.
+	ConnMaxLifetime string
+	// PingTimeout limits the health check, 5s by default. It must be positive.
+	PingTimeout string
+}
+
+// Validate checks durations of the factory.
+func (f *Factory) Validate() error {
+	if err := core.ValidateDuration("ConnMaxLifetime", f.ConnMaxLifetime); err != nil {
+		return err
+	}
+	if d, err := core.ParseDuration(f.PingTimeout, defaultPingTimeout); err != nil || d == 0 {
+		return fmt.Errorf("PingTimeout must be a positive duration: %s", f.PingTimeout)
+	}
+	return nil
+}
+
+// Build opens the database and registers it to the lifecycle and health
+// checks of env.
+func (f *Factory) Build(env *core.Environment) (*sql.DB, error) {
+	name := f.Name
+	if name == "" {
+		name = defaultName
+	}
+	connMaxLifetime, err := core.ParseDuration(f.ConnMaxLifetime, 0)
+	if err != nil {
+		return nil, fmt.Errorf("db: invalid connection max lifetime of %s: %v", name, err)
+	}
+	pingTimeout, err := core.ParseDuration(f.PingTimeout, defaultPingTimeout)
+	if err == nil && pingTimeout == 0 {
+		err = fmt.Errorf("zero duration")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db: invalid ping timeout of %s: %v", name, err)
+	}
+	db, err := sql.Open(f.Driver, f.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("db: could not open %s: %v", name, err)
+	}
+	if f.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(f.MaxOpenConns)
+	}
+	if f.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(f.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	env.Lifecycle.Manage(&managedDB{name: name, db: db})
+	env.Admin.HealthChecks.Register(name, &healthCheck{db: db, timeout: pingTimeout})
+	return db, nil
+}
+
+// managedDB closes the database when the application stops.
+type managedDB struct {
+	name string
+	db   *sql.DB
+}
+
+// Start does nothing as connections are opened on demand.
+func (m *managedDB) Start() error {
+	return nil
+}
+
+// Stop closes the database.
+func (m *managedDB) Stop() error {
+	core.GetLogger("melon/db").Infof("closing %s", m.name)
+	return m.db.Close()
+}
+
+// healthCheck is healthy when the database can be pinged.
+type healthCheck struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (c *healthCheck) Check() health.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if err := c.db.PingContext(ctx); err != nil {
+		return health.ResultUnhealthy("database is unavailable", err)
+	}
+	return health.Healthy
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+)
+
+// stubDriver opens connections which can only be pinged.
+type stubDriver struct {
+	// down fails pings when it is not zero.
+	down int32
+}
+
+func (d *stubDriver) Open(name string) (driver.Conn, error) {
+	return &stubConn{driver: d}, nil
+}
+
+type stubConn struct {
+	driver *stubDriver
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *stubConn) Close() error {
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *stubConn) Ping(ctx context.Context) error {
+	if atomic.LoadInt32(&c.driver.down) != 0 {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+var testDriver = &stubDriver{}
+
+func init() {
+	sql.Register("melon-stub", testDriver)
+}
+
+func TestFactory(t *testing.T) {
+	env := core.NewEnvironment()
+	f := Factory{
+		Name:            "users",
+		Driver:          "melon-stub",
+		DSN:             "stub",
+		MaxOpenConns:    5,
+		ConnMaxLifetime: "1m",
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := f.Build(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.Stats(); stats.MaxOpenConnections != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	result, ok := env.Admin.HealthChecks.RunChecker("users")
+	if !ok || !result.Healthy() {
+		t.Fatalf("unexpected health check result: %v %v", result, ok)
+	}
+	atomic.StoreInt32(&testDriver.down, 1)
+	defer atomic.StoreInt32(&testDriver.down, 0)
+	result, ok = env.Admin.HealthChecks.RunChecker("users")
+	if !ok || result.Healthy() || result.Cause() == nil {
+		t.Fatalf("unexpected health check result: %v %v", result, ok)
+	}
+	env.Stop()
+	if err = db.Ping(); err == nil {
+		t.Fatal("database must be closed")
+	}
+}
+
+func TestInvalidFactory(t *testing.T) {
+	factories := []Factory{
+		{Driver: "melon-stub", DSN: "stub", ConnMaxLifetime: "1"},
+		{Driver: "melon-stub", DSN: "stub", PingTimeout: "-1s"},
+		{Driver: "melon-stub", DSN: "stub", PingTimeout: "0s"},
+		{Driver: "unknown", DSN: "stub"},
+	}
+	for _, f := range factories {
+		if _, err := f.Build(core.NewEnvironment()); err == nil {
+			t.Fatalf("error expected for %+v", f)
+		}
+	}
+	if err := factories[0].Validate(); err == nil || err.Error() != "ConnMaxLifetime must be a non-negative duration: 1" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := factories[2].Validate(); err == nil || err.Error() != "PingTimeout must be a positive duration: 0s" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
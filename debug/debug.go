@@ -20,21 +20,55 @@ const (
 
 // bundle adds pprof into admin environment.
 type bundle struct {
+	// pprof is nil when it is not set explicitly.
+	pprof *bool
+	// heapDump is nil when heap dumps are disabled.
+	heapDump *HeapDumpConfiguration
 }
 
+// Option is an option for the debug bundle.
+type Option func(b *bundle)
+
 // NewBundle allocates and returns a new debug bundle which will add /debug endpoint to application.
-func NewBundle() core.Bundle {
-	return &bundle{}
+// Profiling is only enabled in development mode unless WithPprof is given.
+func NewBundle(options ...Option) core.Bundle {
+	b := &bundle{}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b
+}
+
+// WithPprof sets whether /debug/pprof/ is registered.
+func WithPprof(enabled bool) Option {
+	return func(b *bundle) {
+		b.pprof = &enabled
+	}
 }
 
 // Initialize does nothing.
 func (b *bundle) Initialize(bootstrap *core.Bootstrap) {
 }
 
-// Run registers /debug/vars and /debug/pprof/.
+// Run registers /debug/vars, /debug/pprof/ and heap dumps if they are enabled.
 func (b *bundle) Run(conf interface{}, env *core.Environment) error {
 	env.Admin.AddHandler(&expvarHandler{})
+	if b.heapDump != nil {
+		dumper, err := newHeapDumper(b.heapDump, env.GetClock())
+		if err != nil {
+			return err
+		}
+		env.Admin.AddTask(&heapDumpTask{dumper})
+		env.Admin.AddHandler(&heapDumpHandler{dumper})
+	}
 
+	enabled := env.Mode.Defaults().Pprof
+	if b.pprof != nil {
+		enabled = *b.pprof
+	}
+	if !enabled {
+		return nil
+	}
 	pprofIndexHandler := &pprofHandler{}
 	env.Admin.AddHandler(pprofIndexHandler)
 	env.Admin.Router.Handle("*", pprofPath+"*", pprofIndexHandler)
@@ -13,6 +13,7 @@ import (
 
 func TestBundle(t *testing.T) {
 	env := core.NewEnvironment()
+	env.Mode = core.ModeDevelopment
 	handler := router.New()
 	env.Admin.Router = handler
 
@@ -38,3 +39,28 @@ func TestBundle(t *testing.T) {
 		t.Fatalf("unexpected body %s", body)
 	}
 }
+
+func TestBundlePprofMode(t *testing.T) {
+	tests := []struct {
+		mode    core.Mode
+		options []Option
+		status  int
+	}{
+		{core.ModeProduction, nil, http.StatusNotFound},
+		{core.ModeProduction, []Option{WithPprof(true)}, http.StatusOK},
+		{core.ModeDevelopment, []Option{WithPprof(false)}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		env := core.NewEnvironment()
+		env.Mode = test.mode
+		handler := router.New()
+		env.Admin.Router = handler
+		NewBundle(test.options...).Run(nil, env)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+		if w.Code != test.status {
+			t.Fatalf("unexpected status in %v mode: %d, want: %d", test.mode, w.Code, test.status)
+		}
+	}
+}
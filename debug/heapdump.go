@@ -0,0 +1,334 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+const (
+	heapDumpPath     = "/debug/heapdump"
+	heapDumpTaskName = "heap-dump"
+
+	// heapDumpSuffix is the extension of dump files, which are gzipped
+	// protocol buffers.
+	heapDumpSuffix = ".pb.gz"
+	// heapDumpTimeFormat is the timestamp in file names, which sorts
+	// lexicographically.
+	heapDumpTimeFormat = "20060102T150405.000Z"
+)
+
+// HeapDumpConfiguration enables the heap-dump admin task which writes a heap
+// profile to Directory, so profiles can be collected where pprof over HTTP is
+// not allowed.
+type HeapDumpConfiguration struct {
+	Directory string `valid:"notempty"`
+	// Profiles are written along with the heap profile, e.g. allocs or
+	// goroutine. More profiles can be requested with task query profile.
+	Profiles []string
+	// MaxFiles and MaxBytes limit dump files kept in Directory, the oldest
+	// ones are deleted first. Zero means no limit.
+	MaxFiles int `valid:"min=0"`
+	MaxBytes int64
+	// Download allows downloading dumps from the admin handler.
+	Download bool
+}
+
+// WithHeapDump enables the heap-dump task and the /debug/heapdump handler
+// listing dumps.
+func WithHeapDump(c *HeapDumpConfiguration) Option {
+	return func(b *bundle) {
+		b.heapDump = c
+	}
+}
+
+// heapDumper writes profiles to a directory.
+type heapDumper struct {
+	conf  HeapDumpConfiguration
+	clock core.Clock
+
+	mu sync.Mutex
+}
+
+func newHeapDumper(c *HeapDumpConfiguration, clock core.Clock) (*heapDumper, error) {
+	if c.Directory == "" {
+		return nil, fmt.Errorf("debug: heap dump directory must not be empty")
+	}
+	for _, name := range c.Profiles {
+		if pprof.Lookup(name) == nil {
+			return nil, fmt.Errorf("debug: unknown profile %s", name)
+		}
+	}
+	if err := os.MkdirAll(c.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("debug: could not create heap dump directory: %v", err)
+	}
+	return &heapDumper{conf: *c, clock: clock}, nil
+}
+
+// dumpFile is a profile written by heapDumper.
+type dumpFile struct {
+	Name string
+	Size int64
+	Time time.Time
+}
+
+// dump writes heap and the given profiles, then deletes the oldest dumps
+// exceeding the retention limits. It returns written and deleted files.
+func (d *heapDumper) dump(profiles []string) (written []dumpFile, deleted []string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now().UTC()
+	names := append([]string{"heap"}, profiles...)
+	// The heap profile shows statistics as of the most recent GC.
+	runtime.GC()
+	for _, name := range names {
+		f, err := d.write(name, now)
+		if err != nil {
+			return written, nil, err
+		}
+		written = append(written, f)
+	}
+	deleted, err = d.prune(written)
+	return written, deleted, err
+}
+
+// write writes profile to a temporary file which is renamed when completed,
+// so partially written dumps are never listed.
+func (d *heapDumper) write(profile string, t time.Time) (dumpFile, error) {
+	name := profile + "-" + t.Format(heapDumpTimeFormat) + heapDumpSuffix
+	path := filepath.Join(d.conf.Directory, name)
+	f, err := ioutil.TempFile(d.conf.Directory, "."+name)
+	if err != nil {
+		return dumpFile{}, err
+	}
+	err = pprof.Lookup(profile).WriteTo(f, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return dumpFile{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return dumpFile{}, err
+	}
+	return dumpFile{Name: name, Size: info.Size(), Time: t}, nil
+}
+
+// prune deletes the oldest dumps except keep until retention limits are met.
+func (d *heapDumper) prune(keep []dumpFile) ([]string, error) {
+	if d.conf.MaxFiles <= 0 && d.conf.MaxBytes <= 0 {
+		return nil, nil
+	}
+	files, err := d.list()
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, f := range keep {
+		kept[f.Name] = true
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	var deleted []string
+	count := len(files)
+	for _, f := range files {
+		if (d.conf.MaxFiles <= 0 || count <= d.conf.MaxFiles) && (d.conf.MaxBytes <= 0 || total <= d.conf.MaxBytes) {
+			break
+		}
+		if kept[f.Name] {
+			continue
+		}
+		if err = os.Remove(filepath.Join(d.conf.Directory, f.Name)); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, f.Name)
+		count--
+		total -= f.Size
+	}
+	return deleted, nil
+}
+
+// list returns dumps in the directory from the oldest one.
+func (d *heapDumper) list() ([]dumpFile, error) {
+	infos, err := ioutil.ReadDir(d.conf.Directory)
+	if err != nil {
+		return nil, err
+	}
+	var files []dumpFile
+	for _, info := range infos {
+		if t, ok := parseDumpName(info.Name()); ok && info.Mode().IsRegular() {
+			files = append(files, dumpFile{Name: info.Name(), Size: info.Size(), Time: t})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].Time.Equal(files[j].Time) {
+			return files[i].Time.Before(files[j].Time)
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// parseDumpName returns the time of dump file name profile-timestamp.pb.gz.
+func parseDumpName(name string) (time.Time, bool) {
+	if !strings.HasSuffix(name, heapDumpSuffix) {
+		return time.Time{}, false
+	}
+	name = strings.TrimSuffix(name, heapDumpSuffix)
+	idx := strings.LastIndex(name, "-")
+	if idx <= 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(heapDumpTimeFormat, name[idx+1:])
+	return t, err == nil
+}
+
+// heapDumpTask writes heap dumps. Query profile requests additional profiles.
+type heapDumpTask struct {
+	dumper *heapDumper
+}
+
+func (t *heapDumpTask) Name() string {
+	return heapDumpTaskName
+}
+
+func (t *heapDumpTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profiles := append([]string(nil), t.dumper.conf.Profiles...)
+	for _, name := range r.Form["profile"] {
+		if pprof.Lookup(name) == nil {
+			http.Error(w, fmt.Sprintf("Unknown profile %q.", name), http.StatusBadRequest)
+			return
+		}
+		if name != "heap" && !contains(profiles, name) {
+			profiles = append(profiles, name)
+		}
+	}
+	written, deleted, err := t.dumper.dump(profiles)
+	if err != nil {
+		logger().Errorf("could not write heap dump: %v", err)
+		http.Error(w, "Could not write heap dump: "+err.Error(), dumpErrorStatus(err))
+		return
+	}
+	var buf bytes.Buffer
+	for _, f := range written {
+		fmt.Fprintf(&buf, "%s %d\n", filepath.Join(t.dumper.conf.Directory, f.Name), f.Size)
+	}
+	for _, name := range deleted {
+		fmt.Fprintf(&buf, "deleted %s\n", filepath.Join(t.dumper.conf.Directory, name))
+	}
+	w.Write(buf.Bytes())
+}
+
+// dumpErrorStatus returns 507 Insufficient Storage when the disk is full.
+func dumpErrorStatus(err error) int {
+	if errors.Is(err, syscall.ENOSPC) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// heapDumpHandler lists dumps, in JSON with query format=json. A dump is
+// downloaded with query name when it is enabled.
+type heapDumpHandler struct {
+	dumper *heapDumper
+}
+
+func (h *heapDumpHandler) Name() string {
+	return "Heap dumps"
+}
+
+func (h *heapDumpHandler) Path() string {
+	return heapDumpPath
+}
+
+func (h *heapDumpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	if name := r.URL.Query().Get("name"); name != "" {
+		h.download(w, r, name)
+		return
+	}
+	files, err := h.dumper.list()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		if files == nil {
+			files = []dumpFile{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&buf, "%-48s %12d  %s\n", f.Name, f.Size, f.Time.Format(time.RFC3339))
+	}
+	w.Write(buf.Bytes())
+}
+
+func (h *heapDumpHandler) download(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.dumper.conf.Download {
+		http.Error(w, "Downloading heap dumps is disabled.", http.StatusForbidden)
+		return
+	}
+	// Only dump files directly in the directory can be downloaded.
+	if _, ok := parseDumpName(name); !ok || filepath.Base(name) != name {
+		http.Error(w, "Invalid heap dump name.", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(filepath.Join(h.dumper.conf.Directory, name))
+	if err != nil {
+		http.Error(w, "Heap dump not found.", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func logger() core.Logger {
+	return core.GetLogger("melon/debug")
+}
@@ -0,0 +1,155 @@
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+)
+
+func newTestHeapDumper(t *testing.T, c HeapDumpConfiguration) (*heapDumper, *melontest.FakeClock) {
+	dir, err := ioutil.TempDir("", "heapdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c.Directory = filepath.Join(dir, "dumps")
+	clock := melontest.NewFakeClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	d, err := newHeapDumper(&c, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, clock
+}
+
+func runHeapDumpTask(d *heapDumper, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	(&heapDumpTask{d}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/heap-dump"+query, nil))
+	return w
+}
+
+func TestHeapDumpTask(t *testing.T) {
+	d, _ := newTestHeapDumper(t, HeapDumpConfiguration{Profiles: []string{"goroutine"}})
+	w := runHeapDumpTask(d, "?profile=allocs")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected body: %s", w.Body)
+	}
+	for i, profile := range []string{"heap", "goroutine", "allocs"} {
+		var path string
+		var size int64
+		if _, err := fmt.Sscan(lines[i], &path, &size); err != nil {
+			t.Fatalf("unexpected line %q: %v", lines[i], err)
+		}
+		expected := filepath.Join(d.conf.Directory, profile+"-20200102T030405.000Z.pb.gz")
+		info, err := os.Stat(path)
+		if path != expected || err != nil || info.Size() != size || size == 0 {
+			t.Fatalf("unexpected dump %s %d: %v", path, size, err)
+		}
+	}
+	if w = runHeapDumpTask(d, "?profile=unknown"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestHeapDumpRetention(t *testing.T) {
+	d, clock := newTestHeapDumper(t, HeapDumpConfiguration{MaxFiles: 2})
+	var names []string
+	for i := 0; i < 4; i++ {
+		written, _, err := d.dump(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, written[0].Name)
+		clock.Add(time.Minute)
+	}
+	files, err := d.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != names[2] || files[1].Name != names[3] {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	// Limited by size, the new dump is always kept.
+	d.conf.MaxFiles = 0
+	d.conf.MaxBytes = 1
+	written, deleted, err := d.dump(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || deleted[0] != names[2] || deleted[1] != names[3] {
+		t.Fatalf("unexpected deleted files: %v", deleted)
+	}
+	files, _ = d.list()
+	if len(files) != 1 || files[0].Name != written[0].Name {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+}
+
+func TestHeapDumpHandler(t *testing.T) {
+	d, _ := newTestHeapDumper(t, HeapDumpConfiguration{})
+	// Files which are not dumps are ignored.
+	ioutil.WriteFile(filepath.Join(d.conf.Directory, "notes.txt"), []byte("x"), 0644)
+	written, _, err := d.dump(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &heapDumpHandler{d}
+	serve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", heapDumpPath+query, nil))
+		return w
+	}
+	w := serve("")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), written[0].Name+" ") || strings.Count(w.Body.String(), "\n") != 1 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = serve("?format=json")
+	var files []dumpFile
+	if err = json.Unmarshal(w.Body.Bytes(), &files); err != nil || len(files) != 1 || files[0].Size != written[0].Size {
+		t.Fatalf("unexpected response: %s %v", w.Body, err)
+	}
+	if w = serve("?name=" + written[0].Name); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+
+	d.conf.Download = true
+	w = serve("?name=" + written[0].Name)
+	if w.Code != http.StatusOK || int64(w.Body.Len()) != written[0].Size ||
+		w.Header().Get("Content-Disposition") != `attachment; filename="`+written[0].Name+`"` {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	for _, name := range []string{"notes.txt", "../" + written[0].Name, "heap-20200102T030405.000Z.pb.gz.tmp"} {
+		if w = serve("?name=" + name); w.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected status of %s: %d", name, w.Code)
+		}
+	}
+	if w = serve("?name=heap-20000102T030405.000Z.pb.gz"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestHeapDumpError(t *testing.T) {
+	d, _ := newTestHeapDumper(t, HeapDumpConfiguration{})
+	os.RemoveAll(d.conf.Directory)
+	w := runHeapDumpTask(d, "")
+	if w.Code != http.StatusInternalServerError || !strings.HasPrefix(w.Body.String(), "Could not write heap dump: ") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	err := &os.PathError{Op: "write", Path: "heap", Err: syscall.ENOSPC}
+	if status := dumpErrorStatus(fmt.Errorf("dump: %w", err)); status != http.StatusInsufficientStorage {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"github.com/goburrow/melon"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/views"
+)
+
+// User is data model for user.
+type User struct {
+	Name string `valid:"notempty"`
+	Age  int    `valid:"min=13"`
+}
+
+// app manages users with generic CRUD resources.
+type app struct{}
+
+// Initialize adds support for RESTful API.
+func (a *app) Initialize(b *core.Bootstrap) {
+	b.AddBundle(views.NewBundle(views.NewJSONProvider()))
+}
+
+func (a *app) Run(conf interface{}, env *core.Environment) error {
+	env.Server.Register(views.CRUD[User]("/users", views.NewMemoryStore[User](), views.WithTimerMetric("Users")))
+	return nil
+}
+
+// To run the application:
+//  $ go run crud.go server config.json
+//
+// And try these commands to create, retrieve, update and delete an user:
+//  curl -i -XPOST -H'Content-Type: application/json' -d'{"name":"foo","age":20}' 'http://localhost:8080/users'
+//  curl -XGET 'http://localhost:8080/users/1'
+//  curl -XGET 'http://localhost:8080/users?offset=0&limit=10'
+//  curl -XPUT -H'Content-Type: application/json' -d'{"name":"foo","age":21}' 'http://localhost:8080/users/1'
+//  curl -XDELETE 'http://localhost:8080/users/1'
+func main() {
+	if err := melon.Run(&app{}, os.Args[1:]); err != nil {
+		panic(err) // Show stacks
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/goburrow/melon"
+	"github.com/goburrow/melon/views"
+)
+
+func hello(r *http.Request) (interface{}, error) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "world"
+	}
+	greeting := r.URL.Query().Get("greeting")
+	if greeting == "" {
+		greeting = "hello"
+	}
+	shout, err := views.QueryBool(r, "shout", false)
+	if err != nil {
+		// Responds 400 Bad Request.
+		return nil, err
+	}
+	if shout {
+		greeting = strings.ToUpper(greeting)
+	}
+	return map[string]string{greeting: name}, nil
+}
+
+// Run application without configuration file:
+//  go run quickstart.go
+//
+// Then open these links in browser for application and admin page respectively:
+//   http://localhost:8080/hello?name=melon&greeting=hi&shout=true
+//   http://localhost:8081/
+//
+// A configuration file can still be given:
+//  go run quickstart.go server config.json
+func main() {
+	err := melon.New("quickstart").
+		Resource(views.NewResource("GET", "/hello", views.HandlerFunc(hello))).
+		HealthCheck("self", func() error { return nil }).
+		Run()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,155 @@
+package melon
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/goburrow/melon/configuration"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server"
+)
+
+// HandlerOption is an option of Handler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	adminPrefix string
+}
+
+// WithAdminPrefix serves admin handlers under prefix, e.g. "/admin".
+// Admin is not served by default.
+func WithAdminPrefix(prefix string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.adminPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// Handler runs the application without listening, e.g. in serverless
+// functions or tests, and returns its http.Handler. config is the content of
+// the configuration file in JSON, or YAML if supported by the application.
+// Connectors in the configuration are ignored.
+//
+// The returned shutdown function stops managed objects of the application.
+func Handler(app core.Bundle, config []byte, options ...HandlerOption) (http.Handler, func(), error) {
+	var opts handlerOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	bootstrap := newBootstrap(app, nil)
+	app.Initialize(bootstrap)
+
+	command, err := parseHandlerConfiguration(bootstrap, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	environment := core.NewEnvironment()
+	environment.Validator = command.validator
+	if c, ok := command.configuration.(modeConfigurable); ok {
+		environment.Mode, _ = c.EnvironmentMode()
+	}
+	if f, ok := bootstrap.ConfigurationFactory.(*configuration.Factory); ok {
+		environment.ConfigurationSource = f.Source()
+	}
+	environment.Admin.SetApplicationName(bootstrap.Name)
+	if err = runHandlerEnvironment(bootstrap, command.configuration, environment); err != nil {
+		environment.Stop()
+		return nil, nil, err
+	}
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() {
+			environment.Lifecycle.Shutdown(core.ShutdownHandler, "")
+			environment.Stop()
+		})
+	}
+	h := &appHandler{
+		app: environment.Server.Router.(http.Handler),
+	}
+	if opts.adminPrefix != "" {
+		h.adminPrefix = opts.adminPrefix
+		h.admin = http.StripPrefix(opts.adminPrefix, environment.Admin.Router.(http.Handler))
+	}
+	return h, shutdown, nil
+}
+
+// parseHandlerConfiguration parses and validates config. The default server
+// is used when it is not configured.
+func parseHandlerConfiguration(bootstrap *core.Bootstrap, config []byte) (*configurationCommand, error) {
+	factory, ok := bootstrap.ConfigurationFactory.(*configuration.Factory)
+	if !ok {
+		return nil, fmt.Errorf("melon: unsupported configuration factory %T", bootstrap.ConfigurationFactory)
+	}
+	command := &configurationCommand{}
+	var err error
+	command.validator, err = bootstrap.ValidatorFactory.BuildValidator(bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	if len(config) > 0 {
+		ext := ".yaml"
+		if trimmed := bytes.TrimSpace(config); len(trimmed) > 0 && trimmed[0] == '{' {
+			ext = ".json"
+		}
+		command.configuration, err = factory.Parse(ext, config)
+	} else {
+		factory.SetOptional(true)
+		command.configuration, err = factory.BuildConfiguration(bootstrap)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := command.configuration.(core.Configuration); ok {
+		if f, ok := c.ServerFactory().(*server.Factory); ok && f.Value() == nil {
+			f.SetValue(server.NewDefaultFactory())
+		}
+	}
+	if err = command.validate(); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// runHandlerEnvironment configures the environment and runs the application
+// like the server command but the server is not started.
+func runHandlerEnvironment(bootstrap *core.Bootstrap, config interface{}, environment *core.Environment) error {
+	if err := configureLifecycle(config, environment); err != nil {
+		return err
+	}
+	c := config.(core.Configuration)
+	if err := c.LoggingFactory().ConfigureLogging(environment); err != nil {
+		return err
+	}
+	if err := c.MetricsFactory().ConfigureMetrics(environment); err != nil {
+		return err
+	}
+	// Server is built for routers and filters only.
+	if _, err := c.ServerFactory().BuildServer(environment); err != nil {
+		return err
+	}
+	logger().Infof("running as http.Handler, connectors are ignored")
+	if err := bootstrap.Run(config, environment); err != nil {
+		return err
+	}
+	if err := bootstrap.Application.Run(config, environment); err != nil {
+		return err
+	}
+	return environment.Start()
+}
+
+// appHandler routes requests to application or admin router.
+type appHandler struct {
+	app         http.Handler
+	admin       http.Handler
+	adminPrefix string
+}
+
+func (h *appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.admin != nil && (r.URL.Path == h.adminPrefix || strings.HasPrefix(r.URL.Path, h.adminPrefix+"/")) {
+		h.admin.ServeHTTP(w, r)
+		return
+	}
+	h.app.ServeHTTP(w, r)
+}
@@ -0,0 +1,84 @@
+package melon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/views"
+)
+
+type handlerTestApp struct {
+	stopped int
+}
+
+func (app *handlerTestApp) Initialize(bootstrap *core.Bootstrap) {
+	bootstrap.AddBundle(views.NewBundle(views.NewJSONProvider()))
+}
+
+func (app *handlerTestApp) Run(conf interface{}, env *core.Environment) error {
+	env.Server.Register(views.NewResource("GET", "/hello", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return "world", nil
+	})))
+	env.Lifecycle.Manage(app)
+	return nil
+}
+
+func (app *handlerTestApp) Start() error {
+	return nil
+}
+
+func (app *handlerTestApp) Stop() error {
+	app.stopped++
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	app := &handlerTestApp{}
+	config := []byte(`{"server": {"type": "SimpleServer", "connector": {"addr": ":-1"}}}`)
+	h, shutdown, err := Handler(app, config, WithAdminPrefix("/admin/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/hello", http.StatusOK, `"world"` + "\n"},
+		{"/admin/ping", http.StatusOK, "pong\n"},
+		{"/ping", http.StatusNotFound, ""},
+		{"/administrator/ping", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status || (test.body != "" && w.Body.String() != test.body) {
+			t.Fatalf("unexpected response of %s: %d %s", test.path, w.Code, w.Body.String())
+		}
+	}
+	shutdown()
+	shutdown()
+	if app.stopped != 1 {
+		t.Fatalf("unexpected stopped: %d", app.stopped)
+	}
+}
+
+func TestHandlerDefaultConfiguration(t *testing.T) {
+	h, shutdown, err := Handler(&handlerTestApp{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/admin/ping", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
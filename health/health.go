@@ -3,7 +3,11 @@ Package health helps check health of applications in production.
 */
 package health
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
 
 // Result is the result of a health check being run.
 type Result interface {
@@ -35,6 +39,57 @@ var (
 	Healthy (Result) = &result{healthy: true}
 )
 
+// timedResult is a result with the duration of running the health check and
+// the time it was computed.
+type timedResult struct {
+	Result
+	duration    time.Duration
+	timestamp   time.Time
+	nonCritical bool
+}
+
+func (r *timedResult) Duration() time.Duration {
+	return r.duration
+}
+
+func (r *timedResult) Timestamp() time.Time {
+	return r.timestamp
+}
+
+func (r *timedResult) Critical() bool {
+	return !r.nonCritical
+}
+
+// Duration returns how long the health check producing result took, or zero
+// if it is unknown. Results of Registry include their durations.
+func Duration(result Result) time.Duration {
+	if r, ok := result.(interface{ Duration() time.Duration }); ok {
+		return r.Duration()
+	}
+	return 0
+}
+
+// Timestamp returns when result was computed, which is earlier than the
+// time it is returned if it was cached, or zero time if it is unknown.
+// Results of Registry include their timestamps.
+func Timestamp(result Result) time.Time {
+	if r, ok := result.(interface{ Timestamp() time.Time }); ok {
+		return r.Timestamp()
+	}
+	return time.Time{}
+}
+
+// Critical returns whether result is of a critical health check, whose
+// failure makes the application unhealthy. Results are critical unless they
+// are returned by Registry for health checks registered with
+// RegisterNonCritical.
+func Critical(result Result) bool {
+	if r, ok := result.(interface{ Critical() bool }); ok {
+		return r.Critical()
+	}
+	return true
+}
+
 // ResultHealthy creates a new healthy result with given message.
 func ResultHealthy(message string) Result {
 	return &result{
@@ -68,28 +123,70 @@ func (f CheckerFunc) Check() Result {
 
 // Registry is a registry for health checks.
 type Registry interface {
-	// Register registers an application health check.
+	// Register registers a critical application health check.
 	Register(name string, healthCheck Checker)
+	// RegisterNonCritical registers an application health check whose
+	// failure is reported without making the application unhealthy.
+	RegisterNonCritical(name string, healthCheck Checker)
 	// Unregister unregisters an application health check.
 	Unregister(name string)
 	// Names returns name of all registered health checks.
 	Names() []string
-	// RunChecker runs the health check with the given name.
-	RunChecker(name string) Result
+	// RunChecker runs the health check with the given name. It returns false
+	// if the health check is not registered.
+	RunChecker(name string) (Result, bool)
 	// RunCheckers runs the registered health checks and returns a map of the results.
 	RunCheckers() map[string]Result
+	// SetTimeout sets the maximum duration of running a health check, after
+	// which it is reported unhealthy. Zero means no timeout.
+	SetTimeout(timeout time.Duration)
+	// SetCacheTTL makes RunChecker and RunCheckers return the previous result
+	// of a health check which has run within ttl. Zero disables caching.
+	SetCacheTTL(ttl time.Duration)
+}
+
+// CacheRegistry is a Registry which can bypass its cached results.
+type CacheRegistry interface {
+	Registry
+	// ForceRunChecker runs the health check with the given name regardless
+	// of its cached result.
+	ForceRunChecker(name string) (Result, bool)
+	// ForceRunCheckers runs all health checks regardless of cached results.
+	ForceRunCheckers() map[string]Result
 }
 
 // defaultRegistry implements Registry interface.
 type defaultRegistry struct {
 	mu       sync.Mutex
 	checkers map[string]Checker
+	timeout  time.Duration
+	history  *History
+	// nonCritical contains names of non-critical health checks.
+	nonCritical map[string]bool
+
+	cacheTTL time.Duration
+	cache    map[string]*cachedResult
+	// now is the time cached results are compared to.
+	now func() time.Time
+}
+
+// cachedResult is the latest result of a health check.
+type cachedResult struct {
+	result Result
+	// running is closed when the running health check returns, nil if it is
+	// not running.
+	running chan struct{}
 }
 
-// NewRegistry creates a new health check registry.
+// NewRegistry creates a new health check registry, which also implements
+// HistoryRegistry and CacheRegistry.
 func NewRegistry() Registry {
 	return &defaultRegistry{
-		checkers: make(map[string]Checker),
+		checkers:    make(map[string]Checker),
+		history:     NewHistory(DefaultHistorySize),
+		nonCritical: make(map[string]bool),
+		cache:       make(map[string]*cachedResult),
+		now:         time.Now,
 	}
 }
 
@@ -99,6 +196,16 @@ func (registry *defaultRegistry) Register(name string, healthCheck Checker) {
 	defer registry.mu.Unlock()
 
 	registry.checkers[name] = healthCheck
+	delete(registry.nonCritical, name)
+}
+
+// RegisterNonCritical registers a non-critical application health check.
+func (registry *defaultRegistry) RegisterNonCritical(name string, healthCheck Checker) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.checkers[name] = healthCheck
+	registry.nonCritical[name] = true
 }
 
 // Unregister unregisters an application health check.
@@ -107,6 +214,8 @@ func (registry *defaultRegistry) Unregister(name string) {
 	defer registry.mu.Unlock()
 
 	delete(registry.checkers, name)
+	delete(registry.nonCritical, name)
+	delete(registry.cache, name)
 }
 
 // Names returns name of all registered health checks.
@@ -122,15 +231,39 @@ func (registry *defaultRegistry) Names() []string {
 }
 
 // RunChecker runs the health check with the given name.
-func (registry *defaultRegistry) RunChecker(name string) Result {
+func (registry *defaultRegistry) RunChecker(name string) (Result, bool) {
+	return registry.runChecker(name, false)
+}
+
+// ForceRunChecker runs the health check with the given name regardless of
+// its cached result.
+func (registry *defaultRegistry) ForceRunChecker(name string) (Result, bool) {
+	return registry.runChecker(name, true)
+}
+
+func (registry *defaultRegistry) runChecker(name string, force bool) (Result, bool) {
 	registry.mu.Lock()
-	defer registry.mu.Unlock()
+	checker, ok := registry.checkers[name]
+	registry.mu.Unlock()
 
-	health, ok := registry.checkers[name]
 	if !ok {
-		return ResultUnhealthy("healthcheck: "+name+" not found", nil)
+		return nil, false
 	}
-	return health.Check()
+	return registry.run(map[string]Checker{name: checker}, force)[name], true
+}
+
+// SetTimeout sets the maximum duration of running a health check.
+func (registry *defaultRegistry) SetTimeout(timeout time.Duration) {
+	registry.mu.Lock()
+	registry.timeout = timeout
+	registry.mu.Unlock()
+}
+
+// SetCacheTTL sets the duration results of health checks are cached.
+func (registry *defaultRegistry) SetCacheTTL(ttl time.Duration) {
+	registry.mu.Lock()
+	registry.cacheTTL = ttl
+	registry.mu.Unlock()
 }
 
 // checkerResult wraps result and name of health check
@@ -139,30 +272,148 @@ type checkerResult struct {
 	result Result
 }
 
-// RunCheckers runs all the registered health checks.
+// RunCheckers runs all the registered health checks concurrently.
 func (registry *defaultRegistry) RunCheckers() map[string]Result {
+	return registry.runCheckers(false)
+}
+
+// ForceRunCheckers runs all the registered health checks concurrently
+// regardless of cached results.
+func (registry *defaultRegistry) ForceRunCheckers() map[string]Result {
+	return registry.runCheckers(true)
+}
+
+func (registry *defaultRegistry) runCheckers(force bool) map[string]Result {
+	registry.mu.Lock()
+	checkers := make(map[string]Checker, len(registry.checkers))
+	for name, checker := range registry.checkers {
+		checkers[name] = checker
+	}
+	registry.mu.Unlock()
+
+	results := registry.run(checkers, force)
+	registry.history.Record(results)
+	return results
+}
+
+// run runs checkers and marks results of non-critical health checks.
+func (registry *defaultRegistry) run(checkers map[string]Checker, force bool) map[string]Result {
+	results := registry.runCached(checkers, force)
 	registry.mu.Lock()
 	defer registry.mu.Unlock()
+	for name, result := range results {
+		if r, ok := result.(*timedResult); ok && registry.nonCritical[name] {
+			marked := *r
+			marked.nonCritical = true
+			results[name] = &marked
+		}
+	}
+	return results
+}
 
-	resultChan := make(chan checkerResult)
-	defer close(resultChan)
+// runCached runs checkers whose results are not cached. When caching is enabled, a
+// health check is only run once at a time and concurrent callers wait for its
+// result instead.
+func (registry *defaultRegistry) runCached(checkers map[string]Checker, force bool) map[string]Result {
+	registry.mu.Lock()
+	timeout := registry.timeout
+	ttl := registry.cacheTTL
+	if ttl <= 0 {
+		registry.mu.Unlock()
+		return runCheckers(checkers, timeout)
+	}
+	now := registry.now()
+	results := make(map[string]Result, len(checkers))
+	stale := make(map[string]Checker)
+	waiting := make(map[string]*cachedResult)
+	for name, checker := range checkers {
+		c, ok := registry.cache[name]
+		if !ok {
+			c = &cachedResult{}
+			registry.cache[name] = c
+		}
+		switch {
+		case c.running != nil:
+			waiting[name] = c
+		case !force && c.result != nil && now.Sub(Timestamp(c.result)) < ttl:
+			results[name] = c.result
+		default:
+			c.running = make(chan struct{})
+			waiting[name] = c
+			stale[name] = checker
+		}
+	}
+	registry.mu.Unlock()
 
-	for name, checker := range registry.checkers {
-		go runChecker(resultChan, name, checker)
+	if len(stale) > 0 {
+		fresh := runCheckers(stale, timeout)
+		registry.mu.Lock()
+		for name := range stale {
+			c := waiting[name]
+			c.result = fresh[name]
+			close(c.running)
+			c.running = nil
+		}
+		registry.mu.Unlock()
+	}
+	for name, c := range waiting {
+		registry.mu.Lock()
+		running := c.running
+		registry.mu.Unlock()
+		if running != nil {
+			<-running
+		}
+		registry.mu.Lock()
+		results[name] = c.result
+		registry.mu.Unlock()
 	}
+	return results
+}
 
-	results := make(map[string]Result, len(registry.checkers))
-	for i := len(registry.checkers); i > 0; i-- {
+// runCheckers runs checkers in their own goroutines and waits for them at
+// most timeout if it is positive. Health checks which have not returned are
+// reported unhealthy and left running.
+func runCheckers(checkers map[string]Checker, timeout time.Duration) map[string]Result {
+	// Buffered so that timed out checkers do not block.
+	resultChan := make(chan checkerResult, len(checkers))
+	for name, checker := range checkers {
+		go runChecker(resultChan, name, checker)
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	results := make(map[string]Result, len(checkers))
+	for i := len(checkers); i > 0; i-- {
 		select {
 		case r := <-resultChan:
 			results[r.name] = r.result
+		case <-deadline:
+			for name := range checkers {
+				if _, ok := results[name]; !ok {
+					results[name] = &timedResult{
+						Result:    ResultUnhealthy(fmt.Sprintf("timed out after %v", timeout), nil),
+						duration:  timeout,
+						timestamp: time.Now(),
+					}
+				}
+			}
+			return results
 		}
 	}
 	return results
 }
 
+// History returns transitions of health checks run by RunCheckers.
+func (registry *defaultRegistry) History() *History {
+	return registry.history
+}
+
 func runChecker(c chan checkerResult, name string, checker Checker) {
 	r := checkerResult{name: name}
+	start := time.Now()
 	defer func() {
 		if v := recover(); v != nil {
 			if err, ok := v.(error); ok {
@@ -173,6 +424,8 @@ func runChecker(c chan checkerResult, name string, checker Checker) {
 				r.result = ResultUnhealthy("panic", nil)
 			}
 		}
+		end := time.Now()
+		r.result = &timedResult{Result: r.result, duration: end.Sub(start), timestamp: end}
 		c <- r
 	}()
 	r.result = checker.Check()
@@ -5,7 +5,10 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func assertEquals(t *testing.T, expected, actual interface{}) {
@@ -65,7 +68,8 @@ func TestHealthy(t *testing.T) {
 
 	health := &stubHealthCheck{healthy: true}
 	registry.Register("Component 1", health)
-	result := registry.RunChecker("Component 1")
+	result, ok := registry.RunChecker("Component 1")
+	assertEquals(t, true, ok)
 	assertEquals(t, true, result.Healthy())
 	assertEquals(t, "healthy", result.Message())
 }
@@ -75,11 +79,19 @@ func TestUnhealthy(t *testing.T) {
 
 	health := &stubHealthCheck{healthy: false}
 	registry.Register("Component 1", health)
-	result := registry.RunChecker("Component 1")
+	result, _ := registry.RunChecker("Component 1")
 	assertEquals(t, false, result.Healthy())
 	assertEquals(t, "unhealthy", result.Message())
 }
 
+func TestUnknownHealthCheck(t *testing.T) {
+	registry := NewRegistry()
+
+	result, ok := registry.RunChecker("Component 1")
+	assertEquals(t, false, ok)
+	assertEquals(t, nil, result)
+}
+
 func TestMultipleHealthChecks(t *testing.T) {
 	registry := NewRegistry()
 
@@ -123,3 +135,134 @@ func TestRecover(t *testing.T) {
 	assertEquals(t, "error", results["3"].Cause().Error())
 	assertEquals(t, true, results["4"].Healthy())
 }
+
+func TestHealthCheckTimeout(t *testing.T) {
+	registry := NewRegistry()
+	registry.SetTimeout(20 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	registry.Register("slow", CheckerFunc(func() Result {
+		<-release
+		return Healthy
+	}))
+	registry.Register("fast", &stubHealthCheck{healthy: true})
+
+	start := time.Now()
+	results := registry.RunCheckers()
+	if time.Since(start) > time.Second {
+		t.Fatalf("unexpected duration: %v", time.Since(start))
+	}
+	assertEquals(t, 2, len(results))
+	assertEquals(t, true, results["fast"].Healthy())
+	assertEquals(t, false, results["slow"].Healthy())
+	assertEquals(t, "timed out after 20ms", results["slow"].Message())
+
+	result, ok := registry.RunChecker("slow")
+	assertEquals(t, true, ok)
+	assertEquals(t, "timed out after 20ms", result.Message())
+}
+
+func TestCacheTTL(t *testing.T) {
+	registry := NewRegistry().(*defaultRegistry)
+	var offset time.Duration
+	registry.now = func() time.Time {
+		return time.Now().Add(offset)
+	}
+	var runs int32
+	registry.Register("db", CheckerFunc(func() Result {
+		atomic.AddInt32(&runs, 1)
+		return Healthy
+	}))
+	registry.Register("cache", &stubHealthCheck{healthy: true})
+	registry.SetCacheTTL(10 * time.Second)
+
+	first := registry.RunCheckers()
+	computed := Timestamp(first["db"])
+	if computed.IsZero() {
+		t.Fatalf("unexpected timestamp: %v", computed)
+	}
+	offset = 5 * time.Second
+	results := registry.RunCheckers()
+	if runs != 1 || results["db"] != first["db"] || !Timestamp(results["db"]).Equal(computed) {
+		t.Fatalf("unexpected cached result: %d %v", runs, results)
+	}
+	if result, _ := registry.RunChecker("db"); result != first["db"] || runs != 1 {
+		t.Fatalf("unexpected cached result: %d %v", runs, result)
+	}
+	if result, _ := registry.ForceRunChecker("db"); result == first["db"] || runs != 2 {
+		t.Fatalf("unexpected forced result: %d %v", runs, result)
+	}
+	offset = 20 * time.Second
+	registry.RunCheckers()
+	if runs != 3 {
+		t.Fatalf("unexpected runs of stale result: %d", runs)
+	}
+	registry.ForceRunCheckers()
+	if runs != 4 {
+		t.Fatalf("unexpected runs of forced results: %d", runs)
+	}
+	registry.SetCacheTTL(0)
+	registry.RunCheckers()
+	registry.RunCheckers()
+	if runs != 6 {
+		t.Fatalf("unexpected runs without cache: %d", runs)
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	registry := NewRegistry()
+	registry.SetCacheTTL(time.Minute)
+	var runs int32
+	release := make(chan struct{})
+	registry.Register("db", CheckerFunc(func() Result {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return Healthy
+	}))
+	var wg sync.WaitGroup
+	results := make([]Result, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = registry.RunCheckers()["db"]
+		}(i)
+	}
+	for atomic.LoadInt32(&runs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if runs != 1 {
+		t.Fatalf("unexpected runs: %d", runs)
+	}
+	for i, r := range results {
+		if r == nil || r != results[0] {
+			t.Fatalf("unexpected result %d: %v", i, r)
+		}
+	}
+}
+
+func TestNonCritical(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("db", &stubHealthCheck{healthy: true})
+	registry.RegisterNonCritical("cache", &stubHealthCheck{healthy: false})
+
+	results := registry.RunCheckers()
+	assertEquals(t, true, Critical(results["db"]))
+	assertEquals(t, false, Critical(results["cache"]))
+	assertEquals(t, false, results["cache"].Healthy())
+	assertEquals(t, true, Critical(ResultUnhealthy("unhealthy", nil)))
+	healthy, _ := registry.(HistoryRegistry).History().LastRun()
+	assertEquals(t, true, healthy)
+
+	result, _ := registry.RunChecker("cache")
+	assertEquals(t, false, Critical(result))
+
+	registry.Register("cache", &stubHealthCheck{healthy: false})
+	results = registry.RunCheckers()
+	assertEquals(t, true, Critical(results["cache"]))
+	healthy, _ = registry.(HistoryRegistry).History().LastRun()
+	assertEquals(t, false, healthy)
+}
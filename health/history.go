@@ -0,0 +1,216 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistorySize is the number of transitions kept for each health check.
+	DefaultHistorySize = 20
+
+	flapWindowMinutes = 60
+)
+
+// Transition is a change of the state of a health check. The first result of
+// a health check is also recorded as a transition.
+type Transition struct {
+	Healthy bool
+	Message string `json:",omitempty"`
+	Time    time.Time
+	// Duration is how long the health check stayed in this state.
+	Duration time.Duration
+}
+
+// CheckHistory is the history of a health check.
+type CheckHistory struct {
+	Healthy bool
+	// Flaps is the number of state changes in the last hour.
+	Flaps       int
+	Transitions []Transition
+}
+
+// History records transitions of health checks. Only state changes are
+// recorded so memory is bounded regardless of how often checks are run.
+type History struct {
+	size int
+	now  func() time.Time
+
+	mu     sync.Mutex
+	checks map[string]*checkHistory
+	// lastRun is the time of the last recorded results and lastHealthy is
+	// true if all of them were healthy.
+	lastRun     time.Time
+	lastHealthy bool
+	// listener is notified of transitions.
+	listener func(name string, t Transition)
+}
+
+// HistoryRegistry is a Registry keeping history of its health checks.
+type HistoryRegistry interface {
+	Registry
+	History() *History
+}
+
+// checkHistory is a ring of transitions of a health check.
+type checkHistory struct {
+	transitions []Transition
+	// next is the position of the next transition when the ring is full.
+	next int
+	last *Transition
+	// flaps counts state changes per minute.
+	flaps [flapWindowMinutes]struct {
+		minute int64
+		count  int
+	}
+}
+
+// NewHistory creates a History keeping the given number of transitions for
+// each health check.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &History{
+		size:   size,
+		now:    time.Now,
+		checks: make(map[string]*checkHistory),
+	}
+}
+
+// SetListener sets the function called with new transitions after they are
+// recorded.
+func (h *History) SetListener(listener func(name string, t Transition)) {
+	h.mu.Lock()
+	h.listener = listener
+	h.mu.Unlock()
+}
+
+// Record records results of health checks.
+func (h *History) Record(results map[string]Result) {
+	now := h.now()
+	var changes map[string]Transition
+	h.mu.Lock()
+	listener := h.listener
+	defer func() {
+		h.mu.Unlock()
+		for name, t := range changes {
+			listener(name, t)
+		}
+	}()
+	h.lastRun = now
+	h.lastHealthy = true
+	for name, result := range results {
+		if !result.Healthy() && Critical(result) {
+			h.lastHealthy = false
+		}
+		c, ok := h.checks[name]
+		if !ok {
+			c = &checkHistory{
+				transitions: make([]Transition, 0, h.size),
+			}
+			h.checks[name] = c
+		}
+		if c.last != nil {
+			if c.last.Healthy == result.Healthy() {
+				continue
+			}
+			c.last.Duration = now.Sub(c.last.Time)
+			c.addFlap(now)
+		}
+		t := Transition{
+			Healthy: result.Healthy(),
+			Message: resultMessage(result),
+			Time:    now,
+		}
+		c.add(t)
+		if listener != nil {
+			if changes == nil {
+				changes = make(map[string]Transition)
+			}
+			changes[name] = t
+		}
+	}
+}
+
+// Reset clears all history.
+func (h *History) Reset() {
+	h.mu.Lock()
+	h.checks = make(map[string]*checkHistory)
+	h.lastRun = time.Time{}
+	h.lastHealthy = false
+	h.mu.Unlock()
+}
+
+// LastRun returns whether all critical results were healthy when they were last
+// recorded and the time of recording, which is zero if nothing is recorded.
+func (h *History) LastRun() (bool, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastHealthy, h.lastRun
+}
+
+// Checks returns history of all recorded health checks with transitions
+// ordered from the oldest.
+func (h *History) Checks() map[string]CheckHistory {
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	checks := make(map[string]CheckHistory, len(h.checks))
+	for name, c := range h.checks {
+		transitions := make([]Transition, 0, len(c.transitions))
+		transitions = append(transitions, c.transitions[c.next:]...)
+		transitions = append(transitions, c.transitions[:c.next]...)
+		last := &transitions[len(transitions)-1]
+		last.Duration = now.Sub(last.Time)
+		checks[name] = CheckHistory{
+			Healthy:     last.Healthy,
+			Flaps:       c.flapCount(now),
+			Transitions: transitions,
+		}
+	}
+	return checks
+}
+
+func (c *checkHistory) add(t Transition) {
+	if len(c.transitions) < cap(c.transitions) {
+		c.transitions = append(c.transitions, t)
+		c.last = &c.transitions[len(c.transitions)-1]
+		return
+	}
+	c.transitions[c.next] = t
+	c.last = &c.transitions[c.next]
+	c.next = (c.next + 1) % len(c.transitions)
+}
+
+func (c *checkHistory) addFlap(now time.Time) {
+	minute := now.Unix() / 60
+	b := &c.flaps[minute%flapWindowMinutes]
+	if b.minute != minute {
+		b.minute = minute
+		b.count = 0
+	}
+	b.count++
+}
+
+func (c *checkHistory) flapCount(now time.Time) int {
+	minute := now.Unix() / 60
+	n := 0
+	for _, b := range c.flaps {
+		if minute-b.minute < flapWindowMinutes {
+			n += b.count
+		}
+	}
+	return n
+}
+
+func resultMessage(result Result) string {
+	msg := result.Message()
+	if result.Cause() != nil {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += result.Cause().Error()
+	}
+	return msg
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestHistoryFlapping(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	registry := NewRegistry().(*defaultRegistry)
+	registry.history = NewHistory(3)
+	registry.history.now = clock.Now
+	check := &stubHealthCheck{healthy: true}
+	registry.Register("flapping", check)
+	registry.Register("stable", &stubHealthCheck{healthy: true})
+
+	// Healthy and unhealthy every 10 minutes, checked every minute.
+	for i := 0; i < 50; i++ {
+		check.healthy = (i/10)%2 == 0
+		registry.RunCheckers()
+		clock.now = clock.now.Add(time.Minute)
+	}
+	checks := registry.History().Checks()
+	flapping := checks["flapping"]
+	if !flapping.Healthy || flapping.Flaps != 4 {
+		t.Fatalf("unexpected history: %+v", flapping)
+	}
+	if len(flapping.Transitions) != 3 {
+		t.Fatalf("unexpected transitions: %+v", flapping.Transitions)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, tr := range flapping.Transitions {
+		expected := start.Add(time.Duration(20+i*10) * time.Minute)
+		if tr.Time != expected || tr.Healthy != (i%2 == 0) || tr.Duration != 10*time.Minute {
+			t.Fatalf("unexpected transition %d: %+v", i, tr)
+		}
+	}
+	if flapping.Transitions[1].Message != "unhealthy" {
+		t.Fatalf("unexpected message: %+v", flapping.Transitions[0])
+	}
+	stable := checks["stable"]
+	if !stable.Healthy || stable.Flaps != 0 || len(stable.Transitions) != 1 || stable.Transitions[0].Duration != 50*time.Minute {
+		t.Fatalf("unexpected history: %+v", stable)
+	}
+	// Flaps older than one hour are not counted.
+	clock.now = clock.now.Add(45 * time.Minute)
+	if n := registry.History().Checks()["flapping"].Flaps; n != 1 {
+		t.Fatalf("unexpected flaps: %d", n)
+	}
+	registry.History().Reset()
+	if len(registry.History().Checks()) != 0 {
+		t.Fatalf("history must be cleared")
+	}
+}
@@ -0,0 +1,69 @@
+package melon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+// LifecycleConfiguration configures startup and shutdown timings, shutdown
+// initiated by fatal health checks and goroutine leak detection.
+type LifecycleConfiguration struct {
+	// SlowThreshold is the duration of startup and shutdown steps to be
+	// warned about, e.g. "5s". It is disabled when empty.
+	SlowThreshold string
+	// HealthCheckInterval is the period of running health checks in the
+	// background when fatal health checks are registered, 10s by default.
+	HealthCheckInterval string
+	// HealthCheckTimeout is the maximum duration of each health check, after
+	// which it is reported unhealthy. It is disabled when empty.
+	HealthCheckTimeout string
+	// FatalHealthDryRun only logs the shutdown fatal health checks would
+	// initiate.
+	FatalHealthDryRun bool
+	// LeakDetection reports goroutines started after startup which are still
+	// running at shutdown, when there are more than LeakThreshold of them.
+	LeakDetection bool
+	LeakThreshold int `valid:"min=0"`
+}
+
+// lifecycleConfigurable is implemented by configurations providing
+// LifecycleConfiguration, e.g. Configuration.
+type lifecycleConfigurable interface {
+	LifecycleConfiguration() *LifecycleConfiguration
+}
+
+// configureLifecycle applies LifecycleConfiguration from config if available.
+func configureLifecycle(config interface{}, env *core.Environment) error {
+	c, ok := config.(lifecycleConfigurable)
+	if !ok {
+		return nil
+	}
+	lc := c.LifecycleConfiguration()
+	if lc.SlowThreshold != "" {
+		d, err := time.ParseDuration(lc.SlowThreshold)
+		if err != nil {
+			return fmt.Errorf("lifecycle: invalid slow threshold: %v", err)
+		}
+		env.Lifecycle.SlowThreshold = d
+	}
+	if lc.HealthCheckInterval != "" {
+		d, err := time.ParseDuration(lc.HealthCheckInterval)
+		if err != nil {
+			return fmt.Errorf("lifecycle: invalid health check interval: %v", err)
+		}
+		env.HealthMonitor.Interval = d
+	}
+	if lc.HealthCheckTimeout != "" {
+		d, err := time.ParseDuration(lc.HealthCheckTimeout)
+		if err != nil {
+			return fmt.Errorf("lifecycle: invalid health check timeout: %v", err)
+		}
+		env.Admin.HealthChecks.SetTimeout(d)
+	}
+	env.HealthMonitor.DryRun = lc.FatalHealthDryRun
+	env.Lifecycle.LeakDetection = lc.LeakDetection
+	env.Lifecycle.LeakThreshold = lc.LeakThreshold
+	return nil
+}
@@ -78,10 +78,45 @@ func (factory *Factory) ConfigureLogging(env *core.Environment) error {
 	core.SetLoggerFactory(func(name string) core.Logger {
 		return gol.GetLogger(name)
 	})
+	core.SetLogLevels(golLevels{})
 	env.Admin.AddTask(&logTask{})
 	return nil
 }
 
+// golLevels changes levels of gol loggers.
+type golLevels struct{}
+
+func (golLevels) Levels() []string {
+	return levelNames()
+}
+
+func (golLevels) Level(name string) string {
+	if name == "" {
+		name = gol.RootLoggerName
+	}
+	logger, ok := gol.GetLogger(name).(*gol.DefaultLogger)
+	if !ok {
+		return ""
+	}
+	return gol.LevelString(logger.Level())
+}
+
+func (golLevels) SetLevel(name, level string) error {
+	if name == "" {
+		name = gol.RootLoggerName
+	}
+	logLevel, ok := getLogLevel(level)
+	if !ok {
+		return fmt.Errorf("logging: unsupported level %s", level)
+	}
+	logger, ok := gol.GetLogger(name).(*gol.DefaultLogger)
+	if !ok {
+		return fmt.Errorf("logging: logger %s is not supported", name)
+	}
+	logger.SetLevel(logLevel)
+	return nil
+}
+
 func (factory *Factory) configureLevels() error {
 	// Change default log level
 	if factory.Level != "" {
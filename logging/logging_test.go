@@ -40,3 +40,23 @@ func TestGetLogLevel(t *testing.T) {
 		t.Fatal("Should not found")
 	}
 }
+
+func TestGolLevels(t *testing.T) {
+	levels := golLevels{}
+	previous := levels.Level("melon/server")
+	if err := levels.SetLevel("melon/server", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	if level := levels.Level("melon/server"); level != "DEBUG" {
+		t.Fatalf("unexpected level: %s", level)
+	}
+	if err := levels.SetLevel("melon/server", previous); err != nil {
+		t.Fatal(err)
+	}
+	if level := levels.Level("melon/server"); level != previous {
+		t.Fatalf("unexpected level: %s, want %s", level, previous)
+	}
+	if err := levels.SetLevel("", "verbose"); err == nil {
+		t.Fatal("error expected for unsupported level")
+	}
+}
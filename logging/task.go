@@ -3,15 +3,19 @@ package logging
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/goburrow/gol"
+	"github.com/goburrow/melon/core"
 )
 
 const (
 	logTaskName = "log"
 )
 
-// logTask gets and sets logger level
+// logTask gets and sets levels of multiple loggers. The root logger is used
+// when query logger is not given. Task log-level of core changes one logger.
 type logTask struct {
 }
 
@@ -22,27 +26,52 @@ func (*logTask) Name() string {
 func (*logTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	// Can have multiple loggers
-	loggers, ok := query["logger"]
-	if !ok || len(loggers) == 0 {
-		return
+	loggers := query["logger"]
+	if len(loggers) == 0 {
+		loggers = []string{gol.RootLoggerName}
 	}
 	// But only one level
 	level := query.Get("level")
-	if level != "" {
-		logLevel, ok := getLogLevel(level)
-		if !ok {
-			http.Error(w, "Unsupported level "+level, http.StatusBadRequest)
-			return
-		}
+	if level == "" {
+		// Print level of each logger
 		for _, name := range loggers {
-			setLogLevel(name, logLevel)
+			if logger, ok := gol.GetLogger(name).(*gol.DefaultLogger); ok {
+				fmt.Fprintf(w, "%s: %s\n", name, gol.LevelString(logger.Level()))
+			}
 		}
+		return
+	}
+	logLevel, ok := getLogLevel(level)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported level %s. Accepted levels: %s", level, strings.Join(levelNames(), ", ")),
+			http.StatusBadRequest)
+		return
 	}
-	// Print level of each logger
+	if core.TaskCancelled(w, r) {
+		return
+	}
+	// Print previous and new level of each logger
 	for _, name := range loggers {
 		logger, ok := gol.GetLogger(name).(*gol.DefaultLogger)
-		if ok {
-			fmt.Fprintf(w, "%s: %s\n", name, gol.LevelString(logger.Level()))
+		if !ok {
+			continue
 		}
+		previous := gol.LevelString(logger.Level())
+		logger.SetLevel(logLevel)
+		core.GetLogger("melon/logging").Infof("level of logger %s changed from %s to %s by %s",
+			name, previous, gol.LevelString(logLevel), r.RemoteAddr)
+		fmt.Fprintf(w, "%s: %s -> %s\n", name, previous, gol.LevelString(logLevel))
+	}
+}
+
+// levelNames returns supported level names from the most verbose one.
+func levelNames() []string {
+	names := make([]string, 0, len(logLevels))
+	for name := range logLevels {
+		names = append(names, name)
 	}
+	sort.Slice(names, func(i, j int) bool {
+		return logLevels[names[i]] < logLevels[names[j]]
+	})
+	return names
 }
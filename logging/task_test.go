@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/gol"
+)
+
+func TestLogTask(t *testing.T) {
+	serve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		(&logTask{}).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/log?"+query, nil))
+		return w
+	}
+	logger := gol.GetLogger("melon/task-test").(*gol.DefaultLogger)
+	logger.SetLevel(gol.Info)
+
+	w := serve("logger=melon/task-test&level=debug")
+	if w.Code != http.StatusOK || w.Body.String() != "melon/task-test: INFO -> DEBUG\n" || logger.Level() != gol.Debug {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = serve("logger=melon/task-test")
+	if w.Code != http.StatusOK || w.Body.String() != "melon/task-test: DEBUG\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = serve("logger=melon/task-test&level=INFO")
+	if w.Code != http.StatusOK || w.Body.String() != "melon/task-test: DEBUG -> INFO\n" || logger.Level() != gol.Info {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = serve("logger=melon/task-test&level=verbose")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Accepted levels: ALL, TRACE, DEBUG, INFO, WARN, ERROR, OFF") ||
+		logger.Level() != gol.Info {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	// Root logger
+	root := gol.GetLogger(gol.RootLoggerName).(*gol.DefaultLogger)
+	w = serve("")
+	if w.Code != http.StatusOK || w.Body.String() != "root: "+gol.LevelString(root.Level())+"\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
@@ -14,28 +14,33 @@ import (
 
 // Run executes application with given arguments
 func Run(app core.Bundle, args []string) error {
-	bootstrap := core.Bootstrap{
-		Application:          app,
-		Arguments:            args,
-		ConfigurationFactory: configuration.NewFactory(&Configuration{}),
-		ValidatorFactory:     validation.NewFactory(),
-	}
+	bootstrap := newBootstrap(app, args)
 	// Register default server commands
 	bootstrap.AddCommand(&checkCommand{})
 	bootstrap.AddCommand(&serverCommand{})
 
-	app.Initialize(&bootstrap)
+	app.Initialize(bootstrap)
 	if len(args) > 0 {
 		for _, command := range bootstrap.Commands() {
 			if command.Name() == args[0] {
-				return command.Run(&bootstrap)
+				return command.Run(bootstrap)
 			}
 		}
 	}
-	printHelp(&bootstrap)
+	printHelp(bootstrap)
 	return nil
 }
 
+// newBootstrap returns a Bootstrap with the default configuration and validator.
+func newBootstrap(app core.Bundle, args []string) *core.Bootstrap {
+	return &core.Bootstrap{
+		Application:          app,
+		Arguments:            args,
+		ConfigurationFactory: configuration.NewFactory(&Configuration{}),
+		ValidatorFactory:     validation.NewFactory(),
+	}
+}
+
 func printHelp(bootstrap *core.Bootstrap) {
 	fmt.Fprintln(os.Stdout, "Available commands:")
 	for _, command := range bootstrap.Commands() {
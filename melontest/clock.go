@@ -0,0 +1,177 @@
+package melontest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+// FakeClock is a core.Clock whose time only changes when Add or Set is
+// called. Timers, tickers and sleeps fire when the time reaches them.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+var _ core.Clock = (*FakeClock)(nil)
+
+// fakeWaiter is a pending timer, ticker or sleep.
+type fakeWaiter struct {
+	deadline time.Time
+	// period is non-zero for tickers.
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFakeClock returns a FakeClock starting at t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Add advances the fake time by d and fires due timers, tickers and sleeps.
+func (c *FakeClock) Add(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set changes the fake time to t and fires due timers, tickers and sleeps.
+// Each ticker fires at most once like time.Ticker dropping ticks for slow
+// receivers.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+	})
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		select {
+		case w.c <- t:
+		default:
+		}
+		if w.period > 0 {
+			for !w.deadline.After(t) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of pending timers, tickers and sleeps, so that
+// tests can wait for a goroutine to block on the clock before advancing it.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func (c *FakeClock) add(w *fakeWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.period == 0 && !w.deadline.After(c.now) {
+		select {
+		case w.c <- c.now:
+		default:
+		}
+		return
+	}
+	c.waiters = append(c.waiters, w)
+}
+
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, v := range c.waiters {
+		if v == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NewTimer creates a timer firing when the fake time reaches d from now.
+func (c *FakeClock) NewTimer(d time.Duration) core.Timer {
+	t := &fakeTimer{
+		clock: c,
+		w:     &fakeWaiter{deadline: c.Now().Add(d), c: make(chan time.Time, 1)},
+	}
+	c.add(t.w)
+	return t
+}
+
+// NewTicker creates a ticker firing every d of the fake time.
+// It panics if d is not positive.
+func (c *FakeClock) NewTicker(d time.Duration) core.Ticker {
+	if d <= 0 {
+		panic("melontest: non-positive interval for NewTicker")
+	}
+	t := &fakeTicker{
+		clock: c,
+		w:     &fakeWaiter{deadline: c.Now().Add(d), period: d, c: make(chan time.Time, 1)},
+	}
+	c.add(t.w)
+	return t
+}
+
+// Sleep blocks until the fake time has advanced by d or ctx is done.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	w     *fakeWaiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.remove(t.w)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.clock.remove(t.w)
+	t.w.deadline = t.clock.Now().Add(d)
+	t.clock.add(t.w)
+	return active
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.remove(t.w)
+}
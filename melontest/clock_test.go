@@ -0,0 +1,79 @@
+package melontest
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	// An entry expiring after its TTL.
+	ttl := clock.NewTimer(30 * time.Second)
+	clock.Add(29 * time.Second)
+	select {
+	case <-ttl.C():
+		t.Fatal("unexpected expiry before ttl")
+	default:
+	}
+	clock.Add(time.Second)
+	select {
+	case now := <-ttl.C():
+		if !now.Equal(start.Add(30 * time.Second)) {
+			t.Fatalf("unexpected time: %v", now)
+		}
+	default:
+		t.Fatal("expiry expected after ttl")
+	}
+	if ttl.Stop() {
+		t.Fatal("unexpected active timer")
+	}
+	if !clock.Now().Equal(start.Add(30 * time.Second)) {
+		t.Fatalf("unexpected now: %v", clock.Now())
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ticker := clock.NewTicker(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		clock.Add(10 * time.Second)
+		select {
+		case <-ticker.C():
+		default:
+			t.Fatalf("tick %d expected", i)
+		}
+	}
+	ticker.Stop()
+	clock.Add(10 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick after stop")
+	default:
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Fatalf("unexpected waiters: %d", n)
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	done := make(chan error, 1)
+	go func() {
+		done <- clock.Sleep(context.Background(), time.Minute)
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Add(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clock.Sleep(ctx, time.Minute); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Package melontest provides utilities for testing melon applications.
+*/
+package melontest
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+// leakTimeout is how long goroutines are given to exit.
+const leakTimeout = time.Second
+
+// TestingT is the subset of testing.TB used by this package.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertNoLeaks fails the test if goroutines other than the calling one and
+// known-benign ones are still running, e.g. at the end of a test:
+//
+//	defer melontest.AssertNoLeaks(t)
+func AssertNoLeaks(t TestingT) {
+	t.Helper()
+	var baseline core.GoroutineSnapshot
+	leaks := baseline.WaitLeaks(0, leakTimeout)
+	if len(leaks) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for _, l := range leaks {
+		fmt.Fprintf(&buf, "\n    %s", l)
+	}
+	t.Errorf("goroutines leaked:%s", buf.String())
+}
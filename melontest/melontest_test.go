@@ -0,0 +1,39 @@
+package melontest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func leakyWorker(release chan struct{}) {
+	<-release
+}
+
+func TestAssertNoLeaks(t *testing.T) {
+	release := make(chan struct{})
+	go leakyWorker(release)
+
+	ft := &fakeT{}
+	AssertNoLeaks(ft)
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "1 x github.com/goburrow/melon/melontest.leakyWorker") {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+	close(release)
+
+	ft = &fakeT{}
+	AssertNoLeaks(ft)
+	if len(ft.errors) != 0 {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+}
@@ -0,0 +1,340 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	latencyPath = "/metrics/prometheus"
+	latencyName = "http_request_duration_seconds"
+	// latencySummaryName is the name of summaries, which must be a different
+	// metric family from histograms.
+	latencySummaryName = "http_request_duration_summary_seconds"
+	// latencyOtherRoute is the route label of requests not matching any
+	// configured route.
+	latencyOtherRoute = "*"
+	// summarySamples is the number of latest observations of a route
+	// quantiles are computed from.
+	summarySamples = 1024
+
+	contentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// DefaultLatencyBuckets are the default upper bounds of latency histogram
+// buckets, same as the default buckets of Prometheus clients.
+var DefaultLatencyBuckets = []string{"5ms", "10ms", "25ms", "50ms", "100ms", "250ms", "500ms", "1s", "2.5s", "5s", "10s"}
+
+// LatencyConfiguration enables request latency metrics in the Prometheus text
+// format at /metrics/prometheus of the admin server.
+// Requests not matching any of Routes are recorded with route "*".
+type LatencyConfiguration struct {
+	Enabled bool
+	// Buckets are upper bounds of histogram buckets, e.g. "100ms".
+	// DefaultLatencyBuckets are used when it is empty.
+	Buckets []string
+	// Quantiles outputs summaries with the given quantiles, e.g. 0.99,
+	// instead of histograms. Quantiles are computed from the latest 1024
+	// requests of each route.
+	Quantiles []float64
+	// Routes overrides Buckets or Quantiles of routes matching their patterns.
+	// Patterns have the same format as SLO route patterns.
+	Routes []LatencyRouteConfiguration
+}
+
+// LatencyRouteConfiguration overrides latency buckets or quantiles of routes
+// matching Pattern.
+type LatencyRouteConfiguration struct {
+	Pattern   string `valid:"notempty"`
+	Buckets   []string
+	Quantiles []float64
+}
+
+// Build returns a new Latency recorder.
+func (c *LatencyConfiguration) Build() (*Latency, error) {
+	buckets, err := parseBuckets(c.Buckets)
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 {
+		buckets, _ = parseBuckets(DefaultLatencyBuckets)
+	}
+	if err = validateQuantiles(c.Quantiles); err != nil {
+		return nil, err
+	}
+	l := &Latency{
+		clock: core.SystemClock,
+		other: newLatencyRoute(latencyOtherRoute, buckets, c.Quantiles),
+	}
+	for _, rc := range c.Routes {
+		routeBuckets, err := parseBuckets(rc.Buckets)
+		if err != nil {
+			return nil, err
+		}
+		if err = validateQuantiles(rc.Quantiles); err != nil {
+			return nil, err
+		}
+		quantiles := c.Quantiles
+		if len(rc.Quantiles) > 0 {
+			quantiles = rc.Quantiles
+		} else if len(routeBuckets) > 0 {
+			quantiles = nil
+		}
+		if len(routeBuckets) == 0 {
+			routeBuckets = buckets
+		}
+		l.routes = append(l.routes, newLatencyRoute(rc.Pattern, routeBuckets, quantiles))
+	}
+	return l, nil
+}
+
+func parseBuckets(values []string) ([]float64, error) {
+	buckets := make([]float64, len(values))
+	for i, v := range values {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: invalid latency bucket %s: %v", v, err)
+		}
+		buckets[i] = d.Seconds()
+		if i > 0 && buckets[i] <= buckets[i-1] {
+			return nil, fmt.Errorf("metrics: latency buckets must be increasing: %v", values)
+		}
+	}
+	return buckets, nil
+}
+
+func validateQuantiles(quantiles []float64) error {
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			return fmt.Errorf("metrics: invalid latency quantile %v", q)
+		}
+	}
+	return nil
+}
+
+// Latency records latency of requests per route.
+// It implements filter.Filter.
+type Latency struct {
+	routes []*latencyRoute
+	other  *latencyRoute
+	clock  core.Clock
+}
+
+var _ filter.Filter = (*Latency)(nil)
+
+// ServeHTTP records latency of the request to the first matching route.
+// The trace ID of the W3C traceparent header, if any, is kept as an exemplar.
+func (l *Latency) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := l.match(r.URL.Path)
+	start := l.clock.Now()
+	filter.Continue(w, r)
+	end := l.clock.Now()
+	route.observe(end.Sub(start).Seconds(), traceID(r.Header.Get("traceparent")), end)
+}
+
+func (l *Latency) match(path string) *latencyRoute {
+	for _, route := range l.routes {
+		if matchPattern(route.pattern, path) {
+			return route
+		}
+	}
+	return l.other
+}
+
+// Handler returns the admin handler which exposes latency metrics.
+func (l *Latency) Handler() core.AdminHandler {
+	return &latencyHandler{l}
+}
+
+// latencyRoute is either a histogram or a summary when quantiles is set.
+type latencyRoute struct {
+	pattern   string
+	buckets   []float64
+	quantiles []float64
+
+	mu    sync.Mutex
+	count uint64
+	sum   float64
+	// counts are non-cumulative counts of buckets and +Inf.
+	counts    []uint64
+	exemplars []exemplar
+	// samples is a ring of the latest observations for summaries.
+	samples []float64
+	next    int
+}
+
+// exemplar is the latest traced observation of a bucket.
+type exemplar struct {
+	traceID string
+	value   float64
+	time    time.Time
+}
+
+func newLatencyRoute(pattern string, buckets, quantiles []float64) *latencyRoute {
+	r := &latencyRoute{
+		pattern:   pattern,
+		buckets:   buckets,
+		quantiles: quantiles,
+	}
+	if len(quantiles) > 0 {
+		r.samples = make([]float64, 0, summarySamples)
+	} else {
+		r.counts = make([]uint64, len(buckets)+1)
+		r.exemplars = make([]exemplar, len(buckets)+1)
+	}
+	return r
+}
+
+func (r *latencyRoute) observe(v float64, traceID string, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	r.sum += v
+	if r.samples != nil {
+		if len(r.samples) < cap(r.samples) {
+			r.samples = append(r.samples, v)
+		} else {
+			r.samples[r.next] = v
+			r.next = (r.next + 1) % len(r.samples)
+		}
+		return
+	}
+	i := sort.SearchFloat64s(r.buckets, v)
+	r.counts[i]++
+	if traceID != "" {
+		r.exemplars[i] = exemplar{traceID: traceID, value: v, time: t}
+	}
+}
+
+// write writes the route as metric name in Prometheus text format, including
+// exemplars when openMetrics is true.
+func (r *latencyRoute) write(w *bufio.Writer, name string, openMetrics bool) {
+	label := "route=\"" + labelEscaper.Replace(r.pattern) + "\""
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.samples != nil {
+		sorted := append([]float64(nil), r.samples...)
+		sort.Float64s(sorted)
+		for _, q := range r.quantiles {
+			fmt.Fprintf(w, "%s{%s,quantile=\"%s\"} %s\n", name, label, formatFloat(q), formatFloat(quantile(sorted, q)))
+		}
+	} else {
+		var cumulative uint64
+		for i, n := range r.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(r.buckets) {
+				le = formatFloat(r.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d", name, label, le, cumulative)
+			if e := r.exemplars[i]; openMetrics && e.traceID != "" {
+				fmt.Fprintf(w, " # {trace_id=\"%s\"} %s %.3f", e.traceID, formatFloat(e.value), float64(e.time.UnixNano())/1e9)
+			}
+			w.WriteByte('\n')
+		}
+	}
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, label, formatFloat(r.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, r.count)
+}
+
+// labelEscaper escapes label values in Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quantile returns the q-quantile of sorted values using the nearest rank.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// traceID returns the trace ID of a W3C traceparent header, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or empty string
+// if the header is invalid.
+func traceID(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	for _, c := range parts[1] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return ""
+		}
+	}
+	return parts[1]
+}
+
+// latencyHandler exposes latency metrics in the Prometheus text format, or
+// OpenMetrics with exemplars when it is accepted by the client.
+type latencyHandler struct {
+	latency *Latency
+}
+
+func (h *latencyHandler) Name() string {
+	return "Prometheus"
+}
+
+func (h *latencyHandler) Path() string {
+	return latencyPath
+}
+
+func (h *latencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypePrometheus)
+	}
+	var histograms, summaries []*latencyRoute
+	for _, route := range append(h.latency.routes, h.latency.other) {
+		if route.samples == nil {
+			histograms = append(histograms, route)
+		} else {
+			summaries = append(summaries, route)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	writeFamily(bw, latencyName, "histogram", histograms, openMetrics)
+	writeFamily(bw, latencySummaryName, "summary", summaries, openMetrics)
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	bw.Flush()
+}
+
+func writeFamily(w *bufio.Writer, name, typ string, routes []*latencyRoute, openMetrics bool) {
+	if len(routes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s Latency of HTTP requests.\n", name)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, route := range routes {
+		route.write(w, name, openMetrics)
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newTestLatency(t *testing.T, config LatencyConfiguration) (func(url, traceparent string), *Latency) {
+	clock := melontest.NewFakeClock(time.Unix(1600000000, 0))
+	latency, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latency.clock = clock
+	// Handler takes latency (ms) from query.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ms, _ := strconv.Atoi(r.URL.Query().Get("latency"))
+		clock.Add(time.Duration(ms) * time.Millisecond)
+	}
+	chain := filter.NewChain()
+	chain.Add(latency, http.HandlerFunc(handler))
+	request := func(url, traceparent string) {
+		r := httptest.NewRequest("GET", url, nil)
+		if traceparent != "" {
+			r.Header.Set("traceparent", traceparent)
+		}
+		chain.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	return request, latency
+}
+
+func exposition(latency *Latency, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", latencyPath, nil)
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	latency.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func assertLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, "\n"+line+"\n") {
+			t.Fatalf("%q expected in:\n%s", line, body)
+		}
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	request, latency := newTestLatency(t, LatencyConfiguration{
+		Buckets: []string{"10ms", "100ms"},
+		Routes: []LatencyRouteConfiguration{
+			{Pattern: "/users/{id}", Buckets: []string{"50ms", "1s"}},
+			{Pattern: "/report", Quantiles: []float64{0.5, 0.9}},
+		},
+	})
+	request("/users/1?latency=20", "")
+	request("/users/2?latency=2000", "")
+	request("/other?latency=5", "")
+	request("/other?latency=50", "")
+	for i := 1; i <= 10; i++ {
+		request("/report?latency="+strconv.Itoa(i*100), "")
+	}
+	w := exposition(latency, "")
+	if w.Header().Get("Content-Type") != contentTypePrometheus {
+		t.Fatalf("unexpected content type: %v", w.Header())
+	}
+	body := w.Body.String()
+	assertLines(t, body,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{route="/users/{id}",le="0.05"} 1`,
+		`http_request_duration_seconds_bucket{route="/users/{id}",le="1"} 1`,
+		`http_request_duration_seconds_bucket{route="/users/{id}",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{route="/users/{id}"} 2`,
+		`http_request_duration_seconds_bucket{route="*",le="0.01"} 1`,
+		`http_request_duration_seconds_bucket{route="*",le="0.1"} 2`,
+		`http_request_duration_seconds_sum{route="*"} 0.055`,
+		"# TYPE http_request_duration_summary_seconds summary",
+		`http_request_duration_summary_seconds{route="/report",quantile="0.5"} 0.5`,
+		`http_request_duration_summary_seconds{route="/report",quantile="0.9"} 0.9`,
+		`http_request_duration_summary_seconds_count{route="/report"} 10`,
+	)
+	if strings.Contains(body, "# EOF") || strings.Contains(body, `le="0.005"`) {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+}
+
+func TestLatencyExemplars(t *testing.T) {
+	request, latency := newTestLatency(t, LatencyConfiguration{})
+	request("/traced?latency=30", testTraceParent)
+	request("/untraced?latency=300", "")
+	request("/invalid?latency=3000", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+
+	w := exposition(latency, "application/openmetrics-text; version=1.0.0")
+	if w.Header().Get("Content-Type") != contentTypeOpenMetrics {
+		t.Fatalf("unexpected content type: %v", w.Header())
+	}
+	body := w.Body.String()
+	assertLines(t, body,
+		`http_request_duration_seconds_bucket{route="*",le="0.05"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.03 1600000000.030`,
+		`http_request_duration_seconds_bucket{route="*",le="0.5"} 2`,
+		`http_request_duration_seconds_bucket{route="*",le="5"} 3`,
+	)
+	if !strings.HasSuffix(body, "\n# EOF\n") || strings.Count(body, "trace_id") != 1 {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+	// Exemplars are not supported by Prometheus text format.
+	body = exposition(latency, "text/plain").Body.String()
+	if strings.Contains(body, "trace_id") || strings.Contains(body, "# EOF") {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+}
+
+func TestInvalidLatencyConfiguration(t *testing.T) {
+	configs := []LatencyConfiguration{
+		{Buckets: []string{"1"}},
+		{Buckets: []string{"1s", "100ms"}},
+		{Quantiles: []float64{1.5}},
+		{Routes: []LatencyRouteConfiguration{{Pattern: "/", Quantiles: []float64{-1}}}},
+	}
+	for _, c := range configs {
+		if _, err := c.Build(); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+}
@@ -6,9 +6,10 @@ package metrics
 import (
 	"expvar"
 	"net/http"
+	"strings"
 
 	// Package metrics registers metrics to expvar
-	_ "github.com/codahale/metrics"
+	"github.com/codahale/metrics"
 	_ "github.com/codahale/metrics/runtime"
 	"github.com/goburrow/melon/core"
 )
@@ -16,6 +17,8 @@ import (
 const (
 	metricsPath = "/metrics"
 	metricsVar  = "metrics"
+
+	requestsCounterPrefix = "HTTP.Requests."
 )
 
 // metricsHandler displays expvars.
@@ -30,8 +33,10 @@ func (handler *metricsHandler) Path() string {
 	return metricsPath
 }
 
+// ServeHTTP responds 304 Not Modified when no metrics have changed since the
+// ETag given in If-None-Match.
 func (*metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache")
 
 	val := expvar.Get(metricsVar)
 	if val == nil {
@@ -39,17 +44,75 @@ func (*metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(val.String()))
+	body := []byte(val.String())
+	if core.NotModified(w, r, core.ETag(body)) {
+		return
+	}
+	w.Write(body)
 }
 
 // Factory implements core.MetricsFactory interface.
 type Factory struct {
-	Frequency string
+	Frequency   string
+	SLO         SLOConfiguration
+	RouteHealth RouteHealthConfiguration
+	Latency     LatencyConfiguration
 }
 
 // Configure registers metrics handler to admin environment.
 func (factory *Factory) ConfigureMetrics(env *core.Environment) error {
 	env.Admin.AddHandler(&metricsHandler{})
+	metrics.Gauge("Lifecycle.Draining").SetFunc(func() int64 {
+		if env.Lifecycle.Draining() {
+			return 1
+		}
+		return 0
+	})
+	metrics.Gauge("Lifecycle.InFlight").SetFunc(env.Lifecycle.InFlight)
+	if len(factory.SLO.Routes) > 0 {
+		slo, err := factory.SLO.Build()
+		if err != nil {
+			return err
+		}
+		slo.clock = env.GetClock()
+		// SLO filter is added to application router when the server starts.
+		env.Server.Register(slo)
+		env.Admin.AddHandler(slo.Handler())
+	}
+	if len(factory.RouteHealth.Routes) > 0 {
+		health, err := factory.RouteHealth.Build()
+		if err != nil {
+			return err
+		}
+		health.clock = env.GetClock()
+		env.Server.Register(health)
+		env.Admin.AddHandler(health.Handler())
+	}
+	if factory.Latency.Enabled {
+		latency, err := factory.Latency.Build()
+		if err != nil {
+			return err
+		}
+		latency.clock = env.GetClock()
+		env.Server.Register(latency)
+		env.Admin.AddHandler(latency.Handler())
+	}
 	// TODO: configure frequency in metrics.
 	return nil
 }
+
+// RequestCount returns the total number of requests served by resources.
+func RequestCount() uint64 {
+	counters, _ := metrics.Snapshot()
+	var n uint64
+	for name, count := range counters {
+		if strings.HasPrefix(name, requestsCounterPrefix) {
+			n += count
+		}
+	}
+	return n
+}
+
+func logger() core.Logger {
+	return core.GetLogger("melon/metrics")
+}
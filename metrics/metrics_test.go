@@ -1,5 +1,76 @@
 package metrics
 
-import "github.com/goburrow/melon/core"
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+)
 
 var _ core.MetricsFactory = (*Factory)(nil)
+
+// publishMetrics publishes metrics to expvar if it is not done by package
+// metrics.
+func publishMetrics() {
+	if expvar.Get(metricsVar) == nil {
+		expvar.Publish(metricsVar, expvar.Func(func() interface{} {
+			counters, gauges := metrics.Snapshot()
+			return map[string]interface{}{"Counters": counters, "Gauges": gauges}
+		}))
+	}
+}
+
+func TestMetricsHandlerNotModified(t *testing.T) {
+	publishMetrics()
+	serve := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", metricsPath, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		(&metricsHandler{}).ServeHTTP(w, r)
+		return w
+	}
+	w := serve("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.Len() == 0 {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	w = serve(etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	metrics.Counter("Test.ETag").Add()
+	w = serve(etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestMetricsHandlerRegistry(t *testing.T) {
+	publishMetrics()
+	env := core.NewEnvironment()
+	env.Metrics.Counter("Test.Orders").Inc(1)
+	env.Metrics.Counter("Test.Orders").Inc(2)
+	env.Metrics.Gauge("Test.Queue").Set(5)
+
+	w := httptest.NewRecorder()
+	(&metricsHandler{}).ServeHTTP(w, httptest.NewRequest("GET", metricsPath, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	var body struct {
+		Counters map[string]uint64
+		Gauges   map[string]int64
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected body: %v %s", err, w.Body)
+	}
+	if body.Counters["Test.Orders"] != 3 || body.Gauges["Test.Queue"] != 5 {
+		t.Fatalf("unexpected metrics: %+v", body)
+	}
+}
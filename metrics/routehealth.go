@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	routeHealthPath = "/route-health"
+
+	defaultRouteHealthWindow      = time.Minute
+	defaultRouteHealthThreshold   = 0.5
+	defaultRouteHealthMinRequests = 10
+	defaultSampleInterval         = time.Second
+	// routeHealthBuckets is the number of buckets in the window of a route.
+	routeHealthBuckets = 10
+
+	redactedValue = "[REDACTED]"
+)
+
+// defaultRedactHeaders are request headers never logged in samples.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// RouteHealthConfiguration enables sampled logging of failing requests of
+// routes whose error rates are too high.
+// Patterns of Routes have the same format as SLO route patterns.
+type RouteHealthConfiguration struct {
+	// Window is the sliding window of error rate, default is 1m.
+	Window string
+	// Threshold is the error rate which enables sampling, default is 0.5.
+	Threshold float64 `valid:"min=0,max=1"`
+	// MinRequests is the number of requests in the window required to
+	// enable sampling, default is 10.
+	MinRequests int
+	// SampleInterval is the minimum interval between logged samples of a
+	// route, default is 1s.
+	SampleInterval string
+	// RedactHeaders are request headers which values are not logged in
+	// addition to Authorization, Proxy-Authorization and Cookie.
+	RedactHeaders []string
+	Routes        []string
+}
+
+// Build returns a new route health filter.
+func (c *RouteHealthConfiguration) Build() (*RouteHealth, error) {
+	window := defaultRouteHealthWindow
+	if c.Window != "" {
+		d, err := time.ParseDuration(c.Window)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: invalid route health window %s: %v", c.Window, err)
+		}
+		window = d
+	}
+	if window < routeHealthBuckets*time.Second {
+		return nil, fmt.Errorf("metrics: route health window must be at least %v: %v", routeHealthBuckets*time.Second, window)
+	}
+	interval := defaultSampleInterval
+	if c.SampleInterval != "" {
+		d, err := time.ParseDuration(c.SampleInterval)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: invalid route health sample interval %s: %v", c.SampleInterval, err)
+		}
+		interval = d
+	}
+	h := &RouteHealth{
+		threshold:      c.Threshold,
+		minRequests:    c.MinRequests,
+		sampleInterval: interval,
+		redact:         make(map[string]bool),
+		clock:          core.SystemClock,
+	}
+	if h.threshold <= 0 {
+		h.threshold = defaultRouteHealthThreshold
+	}
+	if h.minRequests <= 0 {
+		h.minRequests = defaultRouteHealthMinRequests
+	}
+	for _, k := range defaultRedactHeaders {
+		h.redact[k] = true
+	}
+	for _, k := range c.RedactHeaders {
+		h.redact[http.CanonicalHeaderKey(k)] = true
+	}
+	for _, pattern := range c.Routes {
+		h.routes = append(h.routes, &healthRoute{
+			pattern:    pattern,
+			bucketSize: window / routeHealthBuckets,
+		})
+	}
+	return h, nil
+}
+
+// RouteHealth tracks error rates of configured routes. When the error rate of
+// a route exceeds the threshold, its failing requests are logged at a bounded
+// rate until the error rate recovers.
+// It implements filter.Filter.
+type RouteHealth struct {
+	threshold      float64
+	minRequests    int
+	sampleInterval time.Duration
+	redact         map[string]bool
+	routes         []*healthRoute
+	clock          core.Clock
+}
+
+var _ filter.Filter = (*RouteHealth)(nil)
+var _ core.AdminHandler = (*routeHealthHandler)(nil)
+
+// ServeHTTP records the request to the first matching route.
+func (h *RouteHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := h.match(r.URL.Path)
+	if route == nil {
+		filter.Continue(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	start := h.clock.Now()
+	filter.Continue(sw, r)
+	end := h.clock.Now()
+	failed := sw.status >= http.StatusInternalServerError
+	if route.record(h, end, failed) {
+		h.logSample(route, r, sw.status, end.Sub(start))
+	}
+}
+
+func (h *RouteHealth) match(path string) *healthRoute {
+	for _, route := range h.routes {
+		if matchPattern(route.pattern, path) {
+			return route
+		}
+	}
+	return nil
+}
+
+func (h *RouteHealth) logSample(route *healthRoute, r *http.Request, status int, latency time.Duration) {
+	names := make([]string, 0, len(r.Header))
+	for k := range r.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for i, k := range names {
+		if i > 0 {
+			headers.WriteString(", ")
+		}
+		v := strings.Join(r.Header[k], ",")
+		if h.redact[k] {
+			v = redactedValue
+		}
+		fmt.Fprintf(&headers, "%s: %s", k, v)
+	}
+	logger().Warnf("route %s: sampled %s %s %d %v error=%v headers={%s}",
+		route.pattern, r.Method, r.URL.RequestURI(), status, latency, filter.Error(r), headers.String())
+}
+
+// Handler returns the admin handler which displays health of routes.
+func (h *RouteHealth) Handler() core.AdminHandler {
+	return &routeHealthHandler{h}
+}
+
+// RouteHealthStatus is the error rate of a route in the current window.
+type RouteHealthStatus struct {
+	Pattern   string
+	Requests  uint64
+	Errors    uint64
+	ErrorRate float64
+	// Sampling is true when failing requests are being logged.
+	Sampling bool
+	// Since is the time of the last sampling state change.
+	Since time.Time `json:",omitempty"`
+	// Samples is the number of logged samples.
+	Samples uint64
+}
+
+// Status returns health of all routes.
+func (h *RouteHealth) Status() []RouteHealthStatus {
+	t := h.clock.Now()
+	status := make([]RouteHealthStatus, len(h.routes))
+	for i, route := range h.routes {
+		status[i] = route.status(h, t)
+	}
+	return status
+}
+
+// healthRoute keeps request and error counts of a route in a ring of buckets.
+type healthRoute struct {
+	pattern    string
+	bucketSize time.Duration
+
+	mu         sync.Mutex
+	buckets    [routeHealthBuckets]healthBucket
+	sampling   bool
+	since      time.Time
+	lastSample time.Time
+	samples    uint64
+}
+
+type healthBucket struct {
+	index    int64
+	requests uint64
+	errors   uint64
+}
+
+// record adds a request and returns true if the request should be logged.
+func (r *healthRoute) record(h *RouteHealth, t time.Time, failed bool) bool {
+	index := t.UnixNano() / int64(r.bucketSize)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := &r.buckets[index%routeHealthBuckets]
+	if b.index != index {
+		*b = healthBucket{index: index}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+	r.update(h, t)
+	if !r.sampling || !failed || t.Sub(r.lastSample) < h.sampleInterval {
+		return false
+	}
+	r.lastSample = t
+	r.samples++
+	return true
+}
+
+// counts returns numbers of requests and errors in the window.
+func (r *healthRoute) counts(t time.Time) (requests, errors uint64) {
+	index := t.UnixNano() / int64(r.bucketSize)
+	for _, b := range r.buckets {
+		if b.index > index-routeHealthBuckets && b.index <= index {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	return
+}
+
+// update changes sampling state according to the current error rate.
+func (r *healthRoute) update(h *RouteHealth, t time.Time) {
+	requests, errors := r.counts(t)
+	var rate float64
+	if requests > 0 {
+		rate = float64(errors) / float64(requests)
+	}
+	if !r.sampling && requests >= uint64(h.minRequests) && rate >= h.threshold {
+		r.sampling = true
+		r.since = t
+		logger().Warnf("route %s: error rate %.2f of %d requests exceeds %.2f, sampling failed requests",
+			r.pattern, rate, requests, h.threshold)
+	} else if r.sampling && rate < h.threshold {
+		r.sampling = false
+		r.since = t
+		logger().Infof("route %s: error rate %.2f of %d requests recovered, stop sampling",
+			r.pattern, rate, requests)
+	}
+}
+
+func (r *healthRoute) status(h *RouteHealth, t time.Time) RouteHealthStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.update(h, t)
+	status := RouteHealthStatus{
+		Pattern:  r.pattern,
+		Sampling: r.sampling,
+		Since:    r.since,
+		Samples:  r.samples,
+	}
+	status.Requests, status.Errors = r.counts(t)
+	if status.Requests > 0 {
+		status.ErrorRate = float64(status.Errors) / float64(status.Requests)
+	}
+	return status
+}
+
+// routeHealthHandler displays route health status.
+type routeHealthHandler struct {
+	health *RouteHealth
+}
+
+func (h *routeHealthHandler) Name() string {
+	return "Route Health"
+}
+
+func (h *routeHealthHandler) Path() string {
+	return routeHealthPath
+}
+
+func (h *routeHealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(h.health.Status()); err != nil {
+		logger().Errorf("could not encode route health status: %v", err)
+	}
+}
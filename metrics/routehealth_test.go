@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+func TestRouteHealth(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC))
+	config := RouteHealthConfiguration{
+		Window:      "10s",
+		MinRequests: 5,
+		Routes:      []string{"/user/{name}"},
+	}
+	health, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	health.clock = clock
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			filter.SetError(r, errors.New("database is down"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	chain := filter.NewChain()
+	chain.Add(health, http.HandlerFunc(handler))
+	request := func(url string) {
+		chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	// Burst of errors
+	for i := 0; i < 10; i++ {
+		request("/user/foo?fail=1")
+	}
+	request("/other?fail=1")
+	status := health.Status()
+	if len(status) != 1 || !status[0].Sampling || status[0].Requests != 10 || status[0].Errors != 10 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	// Sampled at most once per second.
+	if status[0].Samples != 1 {
+		t.Fatalf("unexpected samples: %+v", status[0])
+	}
+	clock.Add(time.Second)
+	request("/user/foo?fail=1")
+	request("/user/foo?fail=1")
+	if status = health.Status(); status[0].Samples != 2 {
+		t.Fatalf("unexpected samples: %+v", status[0])
+	}
+	// Recovery
+	for i := 0; i < 20; i++ {
+		request("/user/foo")
+	}
+	status = health.Status()
+	if status[0].Sampling || !status[0].Since.Equal(clock.Now()) {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+	request("/user/foo?fail=1")
+	if status = health.Status(); status[0].Samples != 2 {
+		t.Fatalf("unexpected samples: %+v", status[0])
+	}
+}
+
+func TestRouteHealthRecoveryWithoutRequests(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC))
+	config := RouteHealthConfiguration{
+		Window: "1m",
+		Routes: []string{"/*"},
+	}
+	health, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	health.clock = clock
+	chain := filter.NewChain()
+	chain.Add(health, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	for i := 0; i < defaultRouteHealthMinRequests; i++ {
+		chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if status := health.Status(); !status[0].Sampling {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+	clock.Add(time.Minute)
+
+	w := httptest.NewRecorder()
+	health.Handler().ServeHTTP(w, httptest.NewRequest("GET", routeHealthPath, nil))
+	var status []RouteHealthStatus
+	if err = json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Sampling || status[0].Requests != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestInvalidRouteHealth(t *testing.T) {
+	configs := []RouteHealthConfiguration{
+		{Window: "1"},
+		{Window: "1s"},
+		{SampleInterval: "1"},
+	}
+	for _, c := range configs {
+		if _, err := c.Build(); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+}
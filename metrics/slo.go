@@ -0,0 +1,291 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gometrics "github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	sloPath = "/slo"
+
+	defaultSLOWindow = time.Hour
+	sloBucketSize    = time.Minute
+)
+
+// SLOConfiguration defines service level objectives of application routes.
+type SLOConfiguration struct {
+	// Window is the sliding window of compliance, default is 1h.
+	Window string
+	Routes []SLORouteConfiguration
+}
+
+// SLORouteConfiguration is the objective of routes matching Pattern.
+// Pattern is either an exact path, a path with "{name}" segments, or a prefix
+// ending with "*".
+// A request is good when its response status is not 5xx and it completed
+// within Latency (if set).
+type SLORouteConfiguration struct {
+	Pattern      string  `valid:"notempty"`
+	Availability float64 `valid:"min=0,max=1"`
+	Latency      string
+}
+
+// Build returns a new SLO recorder.
+func (c *SLOConfiguration) Build() (*SLO, error) {
+	window := defaultSLOWindow
+	if c.Window != "" {
+		d, err := time.ParseDuration(c.Window)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: invalid slo window %s: %v", c.Window, err)
+		}
+		window = d
+	}
+	if window < sloBucketSize {
+		return nil, fmt.Errorf("metrics: slo window must be at least %v: %v", sloBucketSize, window)
+	}
+	slo := &SLO{
+		window: window,
+		clock:  core.SystemClock,
+	}
+	for _, rc := range c.Routes {
+		var latency time.Duration
+		if rc.Latency != "" {
+			d, err := time.ParseDuration(rc.Latency)
+			if err != nil {
+				return nil, fmt.Errorf("metrics: invalid slo latency %s: %v", rc.Latency, err)
+			}
+			latency = d
+		}
+		slo.routes = append(slo.routes, newSLORoute(rc.Pattern, rc.Availability, latency, window))
+	}
+	return slo, nil
+}
+
+// SLO records good and bad requests of configured routes.
+// It implements filter.Filter and core.AdminHandler.
+type SLO struct {
+	window time.Duration
+	routes []*sloRoute
+	clock  core.Clock
+}
+
+var _ filter.Filter = (*SLO)(nil)
+var _ core.AdminHandler = (*sloHandler)(nil)
+
+// ServeHTTP records the request to the first matching route.
+func (s *SLO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := s.match(r.URL.Path)
+	if route == nil {
+		filter.Continue(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	start := s.clock.Now()
+	filter.Continue(sw, r)
+	end := s.clock.Now()
+	route.record(end, end.Sub(start), sw.status)
+}
+
+func (s *SLO) match(path string) *sloRoute {
+	for _, route := range s.routes {
+		if matchPattern(route.pattern, path) {
+			return route
+		}
+	}
+	return nil
+}
+
+// Handler returns the admin handler which summarizes current compliance.
+func (s *SLO) Handler() core.AdminHandler {
+	return &sloHandler{s}
+}
+
+// SLOStatus is the compliance of a route in the current window.
+type SLOStatus struct {
+	Pattern      string
+	Availability float64
+	Latency      string `json:",omitempty"`
+	Window       string
+	Good         uint64
+	Bad          uint64
+	// Compliance is the ratio of good requests, 1 when there is no request.
+	Compliance float64
+	// BurnRate is the speed of error budget consumption, 1 means the budget
+	// will be exhausted exactly at the end of the window.
+	BurnRate float64
+}
+
+// Status returns compliance of all routes.
+func (s *SLO) Status() []SLOStatus {
+	t := s.clock.Now()
+	status := make([]SLOStatus, len(s.routes))
+	for i, route := range s.routes {
+		status[i] = route.status(t)
+	}
+	return status
+}
+
+// sloRoute keeps per-minute buckets in a ring.
+type sloRoute struct {
+	pattern      string
+	availability float64
+	latency      time.Duration
+	window       time.Duration
+
+	goodCounter gometrics.Counter
+	badCounter  gometrics.Counter
+
+	mu      sync.Mutex
+	buckets []sloBucket
+}
+
+type sloBucket struct {
+	minute int64
+	good   uint64
+	bad    uint64
+}
+
+func newSLORoute(pattern string, availability float64, latency, window time.Duration) *sloRoute {
+	return &sloRoute{
+		pattern:      pattern,
+		availability: availability,
+		latency:      latency,
+		window:       window,
+
+		goodCounter: gometrics.Counter("SLO." + pattern + ".Good"),
+		badCounter:  gometrics.Counter("SLO." + pattern + ".Bad"),
+
+		buckets: make([]sloBucket, int(window/sloBucketSize)),
+	}
+}
+
+func (r *sloRoute) record(t time.Time, latency time.Duration, status int) {
+	good := status < http.StatusInternalServerError && (r.latency <= 0 || latency <= r.latency)
+	if good {
+		r.goodCounter.Add()
+	} else {
+		r.badCounter.Add()
+	}
+	minute := t.Unix() / int64(sloBucketSize/time.Second)
+
+	r.mu.Lock()
+	b := &r.buckets[minute%int64(len(r.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	if good {
+		b.good++
+	} else {
+		b.bad++
+	}
+	r.mu.Unlock()
+}
+
+func (r *sloRoute) status(t time.Time) SLOStatus {
+	status := SLOStatus{
+		Pattern:      r.pattern,
+		Availability: r.availability,
+		Window:       r.window.String(),
+		Compliance:   1,
+	}
+	if r.latency > 0 {
+		status.Latency = r.latency.String()
+	}
+	minute := t.Unix() / int64(sloBucketSize/time.Second)
+	oldest := minute - int64(len(r.buckets)) + 1
+
+	r.mu.Lock()
+	for _, b := range r.buckets {
+		if b.minute >= oldest && b.minute <= minute {
+			status.Good += b.good
+			status.Bad += b.bad
+		}
+	}
+	r.mu.Unlock()
+
+	if total := status.Good + status.Bad; total > 0 {
+		status.Compliance = float64(status.Good) / float64(total)
+		if budget := 1 - r.availability; budget > 0 {
+			status.BurnRate = (1 - status.Compliance) / budget
+		}
+	}
+	return status
+}
+
+// matchPattern checks if path matches pattern which can contain "{name}"
+// segments or end with "*".
+func matchPattern(pattern, path string) bool {
+	wildcard := strings.HasSuffix(pattern, "*")
+	if wildcard {
+		pattern = pattern[:len(pattern)-1]
+	}
+	ps := strings.Split(pattern, "/")
+	ss := strings.Split(path, "/")
+	if len(ss) < len(ps) || (!wildcard && len(ss) != len(ps)) {
+		return false
+	}
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if ss[i] == "" {
+				return false
+			}
+		} else if wildcard && i == len(ps)-1 {
+			if !strings.HasPrefix(ss[i], p) {
+				return false
+			}
+		} else if p != ss[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// statusWriter records response status.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher.
+func (w *statusWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// sloHandler displays SLO status.
+type sloHandler struct {
+	slo *SLO
+}
+
+func (h *sloHandler) Name() string {
+	return "SLO"
+}
+
+func (h *sloHandler) Path() string {
+	return sloPath
+}
+
+func (h *sloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(h.slo.Status()); err != nil {
+		logger().Errorf("could not encode slo status: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/user", "/user", true},
+		{"/user", "/users", false},
+		{"/user/{name}", "/user/foo", true},
+		{"/user/{name}", "/user/", false},
+		{"/user/{name}", "/user/foo/bar", false},
+		{"/user/*", "/user/foo/bar", true},
+		{"/user/*", "/user", false},
+		{"/user/{name}/*", "/user/foo/bar", true},
+		{"/static/a*", "/static/abc", true},
+	}
+	for _, test := range tests {
+		if test.match != matchPattern(test.pattern, test.path) {
+			t.Errorf("unexpected match result: %+v", test)
+		}
+	}
+}
+
+func TestSLO(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC))
+	config := SLOConfiguration{
+		Window: "10m",
+		Routes: []SLORouteConfiguration{
+			{Pattern: "/user/{name}", Availability: 0.9, Latency: "100ms"},
+			{Pattern: "/*", Availability: 0.99},
+		},
+	}
+	slo, err := config.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slo.clock = clock
+	// Handler responds status from query and takes latency (ms) from query.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		latency, _ := strconv.Atoi(r.URL.Query().Get("latency"))
+		clock.Add(time.Duration(latency) * time.Millisecond)
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		if status > 0 {
+			w.WriteHeader(status)
+		}
+	}
+	chain := filter.NewChain()
+	chain.Add(slo, http.HandlerFunc(handler))
+	request := func(url string) {
+		chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	for i := 0; i < 7; i++ {
+		request("/user/foo")
+	}
+	request("/user/foo?latency=200")
+	request("/user/foo?status=500")
+	request("/user/foo?status=404")
+	request("/other?status=503")
+	request("/other")
+
+	status := slo.Status()
+	if len(status) != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status[0].Good != 8 || status[0].Bad != 2 || status[0].Compliance != 0.8 {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+	if status[0].BurnRate < 1.99 || status[0].BurnRate > 2.01 {
+		t.Fatalf("unexpected burn rate: %+v", status[0])
+	}
+	if status[1].Good != 1 || status[1].Bad != 1 || status[1].Compliance != 0.5 {
+		t.Fatalf("unexpected status: %+v", status[1])
+	}
+	// Older buckets are out of the window.
+	clock.Add(5 * time.Minute)
+	request("/user/foo")
+	clock.Add(6 * time.Minute)
+	status = slo.Status()
+	if status[0].Good != 1 || status[0].Bad != 0 || status[0].Compliance != 1 {
+		t.Fatalf("unexpected status: %+v", status[0])
+	}
+	if status[1].Good != 0 || status[1].Bad != 0 || status[1].Compliance != 1 {
+		t.Fatalf("unexpected status: %+v", status[1])
+	}
+	// Admin handler
+	w := httptest.NewRecorder()
+	slo.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/slo", nil))
+	var result []SLOStatus
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0].Pattern != "/user/{name}" || result[0].Window != "10m0s" {
+		t.Fatalf("unexpected result: %s", w.Body.String())
+	}
+}
+
+func TestSLOInvalidConfiguration(t *testing.T) {
+	configs := []SLOConfiguration{
+		{Window: "1"},
+		{Window: "1s"},
+		{Routes: []SLORouteConfiguration{{Pattern: "/", Latency: "x"}}},
+	}
+	for _, config := range configs {
+		if _, err := config.Build(); err == nil {
+			t.Errorf("error expected: %+v", config)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package melon
+
+import (
+	"github.com/goburrow/melon/core"
+)
+
+// Mount runs the given application as a sub-application of env under path
+// prefix. Routes of the child are prefixed, its health checks and admin tasks
+// are namespaced (e.g. "billing/database") and its managed objects join the
+// lifecycle of the parent environment.
+//
+// Configuration is not shared with the child, thus its Run method is called
+// with nil configuration. Mount should be called in the Run method of the
+// parent application.
+func Mount(env *core.Environment, prefix string, app core.Bundle) error {
+	child, err := env.Mount(prefix)
+	if err != nil {
+		return err
+	}
+	bootstrap := core.Bootstrap{
+		Application: app,
+	}
+	app.Initialize(&bootstrap)
+	err = bootstrap.Run(nil, child)
+	if err != nil {
+		return err
+	}
+	return app.Run(nil, child)
+}
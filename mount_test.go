@@ -0,0 +1,136 @@
+package melon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server/router"
+)
+
+type recordManaged struct {
+	name string
+	buf  *bytes.Buffer
+}
+
+func (m *recordManaged) Start() error {
+	m.buf.WriteString("+" + m.name)
+	return nil
+}
+
+func (m *recordManaged) Stop() error {
+	m.buf.WriteString("-" + m.name)
+	return nil
+}
+
+type childApp struct {
+	name string
+	buf  *bytes.Buffer
+}
+
+func (a *childApp) Initialize(*core.Bootstrap) {
+}
+
+func (a *childApp) Run(_ interface{}, env *core.Environment) error {
+	env.Server.Router.Handle("GET", "/hello", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + a.name))
+	}))
+	env.Admin.HealthChecks.Register("database", health.CheckerFunc(func() health.Result {
+		return health.Healthy
+	}))
+	env.Lifecycle.Manage(&recordManaged{a.name, a.buf})
+	return nil
+}
+
+func newMountEnvironment() *core.Environment {
+	env := core.NewEnvironment()
+	env.Server.Router = router.New()
+	env.Admin.Router = router.New()
+	return env
+}
+
+func TestMount(t *testing.T) {
+	var buf bytes.Buffer
+	env := newMountEnvironment()
+	env.Lifecycle.Manage(&recordManaged{"parent", &buf})
+	if err := Mount(env, "/billing", &childApp{"billing", &buf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Mount(env, "shipping", &childApp{"shipping", &buf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if "+parent+billing+shipping" != buf.String() {
+		t.Fatalf("unexpected starting order: %s", buf.String())
+	}
+	// Routing
+	server := httptest.NewServer(env.Server.Router.(http.Handler))
+	defer server.Close()
+	for _, name := range []string{"billing", "shipping"} {
+		res, err := http.Get(server.URL + "/" + name + "/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "hello "+name != string(body) {
+			t.Fatalf("unexpected body: %s", body)
+		}
+	}
+	// Health checks
+	names := env.Admin.HealthChecks.Names()
+	sort.Strings(names)
+	if "billing/database,readiness,shipping/database" != strings.Join(names, ",") {
+		t.Fatalf("unexpected health checks: %v", names)
+	}
+	buf.Reset()
+	env.Stop()
+	if "-shipping-billing-parent" != buf.String() {
+		t.Fatalf("unexpected stopping order: %s", buf.String())
+	}
+}
+
+type namedTask struct {
+	name string
+}
+
+func (t *namedTask) Name() string {
+	return t.name
+}
+
+func (t *namedTask) ServeHTTP(http.ResponseWriter, *http.Request) {
+}
+
+func TestMountCollision(t *testing.T) {
+	env := newMountEnvironment()
+	if err := Mount(env, "billing", &childApp{"billing", &bytes.Buffer{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Mount(env, "/billing/", &childApp{"billing", &bytes.Buffer{}}); err == nil {
+		t.Fatal("error expected")
+	}
+	// Child task "flush" of "tasks" and parent task "tasks/flush".
+	child, err := env.Mount("tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.Admin.AddTask(&namedTask{"flush"})
+	env.Admin.AddTask(&namedTask{"tasks/flush"})
+	if err = env.Start(); err == nil {
+		t.Fatal("error expected")
+	}
+	if err.Error() != "admin: duplicated task tasks/flush" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
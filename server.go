@@ -1,16 +1,16 @@
 package melon
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/goburrow/melon/configuration"
 	"github.com/goburrow/melon/core"
 )
 
-const (
-	maxBannerSize = 50 * 1024 // 50KB
-)
-
 // serverCommand implements Command.
 type serverCommand struct {
 	configurationCommand
@@ -26,17 +26,41 @@ func (command *serverCommand) Description() string {
 	return "runs the application as an HTTP server"
 }
 
-// Run runs the command with the given bootstrap.
+// Run runs the command with the given bootstrap. SIGINT and SIGTERM stop the
+// server gracefully: in-flight requests are drained until the shutdown
+// timeout of the server, then managed objects are stopped and Run returns.
+// Another signal while draining closes remaining connections immediately.
 func (command *serverCommand) Run(bootstrap *core.Bootstrap) error {
 	// Parse configuration
+	started := time.Now()
 	err := command.configurationCommand.Run(bootstrap)
 	if err != nil {
 		logger().Errorf("could not run server: %v", err)
 		return err
 	}
+	reporter := newShutdownReporter(command.configurationCommand.configuration)
+	reporter.logPrevious()
 	// Create environment
 	environment := core.NewEnvironment()
 	environment.Validator = command.configurationCommand.validator
+	if c, ok := command.configurationCommand.configuration.(modeConfigurable); ok {
+		// Mode has been validated when parsing configuration.
+		environment.Mode, _ = c.EnvironmentMode()
+	}
+	if f, ok := bootstrap.ConfigurationFactory.(*configuration.Factory); ok {
+		environment.ConfigurationSource = f.Source()
+	}
+	environment.Admin.SetApplicationName(bootstrap.Name)
+	environment.Lifecycle.RecordStartup("configuration", time.Since(started))
+	if err = configureLifecycle(command.configurationCommand.configuration, environment); err != nil {
+		logger().Errorf("could not run server: %v", err)
+		return err
+	}
+	// Shutdown is reported after all managed objects are stopped.
+	var stopErr error
+	defer func() {
+		reporter.report(environment.Lifecycle, stopErr)
+	}()
 	defer environment.Stop()
 	// Config other factories that affect this environment.
 	configuration := command.configurationCommand.configuration.(core.Configuration)
@@ -52,90 +76,57 @@ func (command *serverCommand) Run(bootstrap *core.Bootstrap) error {
 	}
 	// Always run Stop() method on managed objects.
 	// Build server
+	started = time.Now()
 	server, err := configuration.ServerFactory().BuildServer(environment)
+	environment.Lifecycle.RecordStartup("server build", time.Since(started))
 	if err != nil {
 		logger().Errorf("could not run server: %v", err)
 		return err
 	}
 	// Now can start everything
-	printBanner()
+	printBanner(command.configurationCommand.configuration)
 	// Run all bundles in bootstrap
 	err = bootstrap.Run(command.configurationCommand.configuration, environment)
 	if err != nil {
 		logger().Errorf("could not run bootstrap: %v", err)
+		environment.Lifecycle.Shutdown(core.ShutdownBundleError, err.Error())
 		return err
 	}
 	// Run application
+	started = time.Now()
 	err = bootstrap.Application.Run(command.configurationCommand.configuration, environment)
+	environment.Lifecycle.RecordStartup(fmt.Sprintf("application %T", bootstrap.Application), time.Since(started))
 	if err != nil {
 		logger().Errorf("could not run application: %v", err)
+		environment.Lifecycle.Shutdown(core.ShutdownBundleError, err.Error())
 		return err
 	}
 	err = environment.Start()
 	if err != nil {
 		logger().Errorf("could not start environment: %v", err)
+		environment.Lifecycle.Shutdown(core.ShutdownBundleError, err.Error())
 		return err
 	}
 	// Handle signal
 	sigCh := make(chan os.Signal, 1)
-	defer close(sigCh)
-	signal.Notify(sigCh, os.Interrupt)
-	go func() {
-		for sig := range sigCh {
-			logger().Debugf("received signal %v", sig)
-			err := server.Stop()
-			if err != nil {
-				logger().Errorf("could not stop server: %v", err)
-			}
-			return
-		}
-	}()
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
+	stopped := waitShutdown(sigCh, done, environment.Lifecycle, server)
 	// Start is blocking
 	err = server.Start()
 	if err != nil {
 		logger().Errorf("could not start server: %v", err)
+		environment.Lifecycle.Shutdown(core.ShutdownServerError, err.Error())
 		return err
 	}
-	return nil
-}
-
-// printBanner prints application banner to the given logger
-func printBanner() {
-	banner := readBanner()
-	if banner == "" {
-		logger().Infof("starting")
-	} else {
-		logger().Infof("starting\n%s", banner)
-	}
-}
-
-// readBanner read contents of a banner found in the current directory.
-// A banner is a .txt file which has the same name with the running application.
-func readBanner() string {
-	banner, err := readFileContents(os.Args[0]+".txt", maxBannerSize)
-	if err != nil {
-		return ""
-	}
-	return banner
-}
-
-// readFileContents read contents with a limit of maximum bytes
-func readFileContents(file string, maxBytes int) (string, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	n := maxBytes
-	if fi, err := f.Stat(); err == nil {
-		if int(fi.Size()) < n {
-			n = int(fi.Size())
+	// Wait for draining if the server is being stopped.
+	if reason, ok := environment.Lifecycle.ShutdownReason(); ok {
+		stopErr = <-stopped
+		if reason.Trigger == core.ShutdownFatalHealth {
+			return &ExitError{Code: ExitCodeFatalHealth, Reason: reason}
 		}
 	}
-	buf := make([]byte, n)
-	n, err = f.Read(buf)
-	if err != nil {
-		return "", err
-	}
-	return string(buf[0:n]), nil
+	return nil
 }
@@ -0,0 +1,86 @@
+/*
+Package bodylimit provides a filter limiting the size of request bodies.
+
+Reading more than the limit fails with *http.MaxBytesError, which views
+respond with status 413. Handlers accepting larger bodies, e.g. uploads, can
+raise the limit of a request with SetLimit before reading its body.
+*/
+package bodylimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// DefaultLimit is the maximum size of request bodies in bytes when it is not
+// configured.
+const DefaultLimit = 10 << 20
+
+// limitFilter wraps request bodies.
+type limitFilter struct {
+	limit int64
+}
+
+// NewFilter returns a Filter limiting request bodies to limit bytes.
+func NewFilter(limit int64) filter.Filter {
+	return &limitFilter{limit: limit}
+}
+
+func (f *limitFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		filter.Continue(w, r)
+		return
+	}
+	b := &body{
+		w:     w,
+		rc:    r.Body,
+		limit: f.limit,
+	}
+	r.Body = b
+	filter.Continue(w, r.WithContext(context.WithValue(r.Context(), bodyContextKey, b)))
+}
+
+// body applies the limit when it is first read.
+type body struct {
+	w      http.ResponseWriter
+	rc     io.ReadCloser
+	limit  int64
+	reader io.ReadCloser
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		b.reader = http.MaxBytesReader(b.w, b.rc, b.limit)
+	}
+	return b.reader.Read(p)
+}
+
+func (b *body) Close() error {
+	return b.rc.Close()
+}
+
+// SetLimit changes the limit of the body of request r to n bytes. It must be
+// called before the body is read and returns false otherwise, or if the
+// request is not limited by the filter.
+func SetLimit(r *http.Request, n int64) bool {
+	b, ok := r.Context().Value(bodyContextKey).(*body)
+	if !ok || b.reader != nil {
+		return false
+	}
+	b.limit = n
+	return true
+}
+
+// contextKey is a value for use with context.WithValue
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return "melon/server context value " + c.name
+}
+
+var bodyContextKey = &contextKey{"bodylimit"}
@@ -0,0 +1,60 @@
+package bodylimit
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+func serve(limit int64, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	chain := filter.NewChain()
+	chain.Add(NewFilter(limit), handler)
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	return w
+}
+
+func TestLimit(t *testing.T) {
+	read := func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		var e *http.MaxBytesError
+		if errors.As(err, &e) {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(b)
+	}
+	if w := serve(5, "12345", read); w.Code != http.StatusOK || w.Body.String() != "12345" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if w := serve(5, "123456", read); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	w := serve(5, "123456", func(w http.ResponseWriter, r *http.Request) {
+		if !SetLimit(r, 10) {
+			t.Errorf("limit must be set")
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if SetLimit(r, 20) {
+			t.Errorf("limit must not be set after reading body")
+		}
+		w.Write(b)
+	})
+	if w.Body.String() != "123456" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if SetLimit(httptest.NewRequest("POST", "/", strings.NewReader("")), 10) {
+		t.Fatal("limit must not be set without filter")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSClientConfiguration configures TLS of outgoing connections, e.g. to proxy
+// upstreams, for services with a private CA or requiring client certificates.
+type TLSClientConfiguration struct {
+	// CAFile and CAPEM are PEM encoded certificates of CAs trusted to sign
+	// server certificates instead of the system roots.
+	CAFile string
+	CAPEM  string
+	// CertFile and KeyFile are the client certificate, which is reloaded
+	// when either file changes.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name verified in server certificates.
+	ServerName string
+	// InsecureSkipVerify disables verification of server certificates.
+	// It must only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// Build returns a new tls.Config. It fails if the CA or client certificate
+// cannot be loaded.
+func (c *TLSClientConfiguration) Build() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" || c.CAPEM != "" {
+		pool := x509.NewCertPool()
+		if c.CAFile != "" {
+			pem, err := ioutil.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("server: could not read CA file: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("server: no certificates found in CA file %s", c.CAFile)
+			}
+		}
+		if c.CAPEM != "" && !pool.AppendCertsFromPEM([]byte(c.CAPEM)) {
+			return nil, fmt.Errorf("server: no certificates found in CA PEM")
+		}
+		config.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("server: client certificate requires both cert file and key file")
+	}
+	if c.CertFile != "" {
+		cert := &clientCertificate{certFile: c.CertFile, keyFile: c.KeyFile}
+		if err := cert.load(); err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = cert.get
+	}
+	if c.InsecureSkipVerify {
+		logger().Warnf("TLS verification of server certificates is disabled, connections are NOT secure")
+	}
+	return config, nil
+}
+
+// clientCertificate is a client certificate reloaded when its files are
+// modified.
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// load loads the certificate if files have been modified since last loaded.
+func (c *clientCertificate) load() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return fmt.Errorf("server: could not load client certificate: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("server: could not load client certificate: %v", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *clientCertificate) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// get returns the latest certificate, or the previous one if the modified
+// files are invalid, e.g. while they are being replaced.
+func (c *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := c.load(); err != nil {
+		logger().Warnf("%v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+)
+
+// testCA signs client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// writeClientCert writes a client certificate with the common name signed by
+// the CA and returns paths of the certificate and key.
+func (ca *testCA) writeClientCert(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+// newMTLSServer starts a TLS server requiring client certificates signed by
+// ca, which responds the common name of the client.
+func newMTLSServer(ca *testCA) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "clienttls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func serverCAPEM(srv *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+}
+
+func TestTLSClientConfiguration(t *testing.T) {
+	ca := newTestCA(t)
+	srv := newMTLSServer(ca)
+	defer srv.Close()
+	dir := tempDir(t)
+	certFile, keyFile := ca.writeClientCert(t, dir, "client1")
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, []byte(serverCAPEM(srv)), 0600)
+
+	get := func(c *TLSClientConfiguration) (string, error) {
+		config, err := c.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		return string(body), err
+	}
+	// Success
+	body, err := get(&TLSClientConfiguration{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil || body != "client1" {
+		t.Fatalf("unexpected response: %q %v", body, err)
+	}
+	// Missing client certificate
+	if _, err = get(&TLSClientConfiguration{CAPEM: serverCAPEM(srv)}); err == nil {
+		t.Fatalf("expected error without client certificate")
+	}
+	// Wrong CA
+	if _, err = get(&TLSClientConfiguration{CAPEM: string(ca.pem), CertFile: certFile, KeyFile: keyFile}); err == nil {
+		t.Fatalf("expected error with wrong CA")
+	}
+	// Server name override
+	if _, err = get(&TLSClientConfiguration{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "other.example"}); err == nil {
+		t.Fatalf("expected error with wrong server name")
+	}
+	body, err = get(&TLSClientConfiguration{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com"})
+	if err != nil || body != "client1" {
+		t.Fatalf("unexpected response: %q %v", body, err)
+	}
+	body, err = get(&TLSClientConfiguration{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile})
+	if err != nil || body != "client1" {
+		t.Fatalf("unexpected response: %q %v", body, err)
+	}
+}
+
+func TestTLSClientConfigurationReload(t *testing.T) {
+	ca := newTestCA(t)
+	srv := newMTLSServer(ca)
+	defer srv.Close()
+	dir := tempDir(t)
+	certFile, keyFile := ca.writeClientCert(t, dir, "client1")
+
+	config, err := (&TLSClientConfiguration{CAPEM: serverCAPEM(srv), CertFile: certFile, KeyFile: keyFile}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		// New transport for new connections.
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return string(body)
+	}
+	if body := get(); body != "client1" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	ca.writeClientCert(t, dir, "client2")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if body := get(); body != "client2" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	// Invalid files keep the previous certificate.
+	ioutil.WriteFile(keyFile, []byte("invalid"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	if body := get(); body != "client2" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
+
+func TestInvalidTLSClientConfiguration(t *testing.T) {
+	ca := newTestCA(t)
+	dir := tempDir(t)
+	certFile, _ := ca.writeClientCert(t, dir, "client1")
+	otherDir := tempDir(t)
+	_, otherKeyFile := ca.writeClientCert(t, otherDir, "client2")
+
+	configs := []TLSClientConfiguration{
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAPEM: "invalid"},
+		{CertFile: certFile},
+		{CertFile: certFile, KeyFile: otherKeyFile},
+		{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")},
+	}
+	for _, c := range configs {
+		if _, err := c.Build(); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+	config := RoutesConfiguration{Proxies: []ProxyConfiguration{
+		{Prefix: "/a", Upstream: "https://localhost", TLS: &TLSClientConfiguration{CertFile: certFile, KeyFile: otherKeyFile}},
+	}}
+	if err := config.Build(core.NewEnvironment(), router.New()); err == nil {
+		t.Fatalf("error expected for mismatched key pair")
+	}
+}
+
+func TestProxyRoutesTLS(t *testing.T) {
+	ca := newTestCA(t)
+	srv := newMTLSServer(ca)
+	defer srv.Close()
+	dir := tempDir(t)
+	certFile, keyFile := ca.writeClientCert(t, dir, "proxy")
+
+	config := RoutesConfiguration{
+		Proxies: []ProxyConfiguration{
+			{
+				Prefix:   "/internal",
+				Upstream: srv.URL,
+				TLS:      &TLSClientConfiguration{CAPEM: serverCAPEM(srv), CertFile: certFile, KeyFile: keyFile},
+			},
+			{
+				// Default TLS configuration does not trust the test server.
+				Prefix:   "/public",
+				Upstream: srv.URL,
+			},
+		},
+	}
+	handler := router.New()
+	if err := config.Build(core.NewEnvironment(), handler); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/internal", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "proxy" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/public", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
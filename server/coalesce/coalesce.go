@@ -0,0 +1,330 @@
+/*
+Package coalesce provides a filter sharing one handler execution among
+concurrent identical GET requests, so that a burst of requests to a hot
+resource, e.g. after its cache expires, does not fan out to the backend.
+
+The first request of a key is the leader and runs the handler as usual. Other
+requests of the same key arriving before it completes are followers, which
+wait and receive a copy of the status, the headers added by the handler and
+the body of the leader's response. Headers set by outer filters, such as
+request IDs, are kept per request, and each request is still logged
+separately. Responses must therefore only depend on the request method, path
+and the selected parameters and headers of the rule.
+*/
+package coalesce
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	// DefaultMaxBodySize is the maximum size of shared response bodies when
+	// it is not set in the rule.
+	DefaultMaxBodySize = 1 << 20
+
+	leadersCounter   = "HTTP.Coalesce.Leaders"
+	followersCounter = "HTTP.Coalesce.Followers"
+)
+
+// Rule enables coalescing of GET requests whose paths start with PathPrefix.
+type Rule struct {
+	// PathPrefix limits the rule to some paths. Empty prefix matches all.
+	PathPrefix string
+	// Params are query parameters included in the key. All parameters are
+	// included when it is empty.
+	Params []string
+	// Headers are request headers included in the key, e.g. Accept.
+	// Requests with Authorization or Cookie headers are never coalesced
+	// unless these headers are included.
+	Headers []string
+	// MaxBodySize is the maximum size in bytes of shared responses, larger
+	// responses are not shared. DefaultMaxBodySize is used if it is zero.
+	MaxBodySize int
+	// ShareErrors shares responses other than 200 OK and responses of
+	// requests with errors set by filter.SetError.
+	ShareErrors bool
+}
+
+// key returns the coalescing key of request r or false if it must not be
+// coalesced.
+func (rule *Rule) key(r *http.Request) (string, bool) {
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(h) != "" && !containsFold(rule.Headers, h) {
+			return "", false
+		}
+	}
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(0)
+	b.WriteString(r.URL.Path)
+	b.WriteByte(0)
+	query := r.URL.Query()
+	if len(rule.Params) > 0 {
+		selected := make(url.Values, len(rule.Params))
+		for _, p := range rule.Params {
+			if v, ok := query[p]; ok {
+				selected[p] = v
+			}
+		}
+		query = selected
+	}
+	// Encode sorts parameters by name.
+	b.WriteString(query.Encode())
+	for _, h := range rule.Headers {
+		values := r.Header.Values(h)
+		if len(values) > 1 {
+			values = append([]string(nil), values...)
+			sort.Strings(values)
+		}
+		b.WriteByte(0)
+		b.WriteString(strings.Join(values, ","))
+	}
+	return b.String(), true
+}
+
+func (rule *Rule) maxBodySize() int {
+	if rule.MaxBodySize > 0 {
+		return rule.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// coalesceFilter tracks in-flight requests by keys.
+type coalesceFilter struct {
+	rules []Rule
+
+	leaders   metrics.Counter
+	followers metrics.Counter
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is an in-flight leader request.
+type call struct {
+	done chan struct{}
+	// waiting is the number of followers, guarded by coalesceFilter.mu.
+	waiting int
+	// res is nil when the response cannot be shared.
+	res *response
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Option is an option for the coalescing Filter.
+type Option func(f *coalesceFilter)
+
+// WithRule adds a rule to the filter. The first rule matching the request
+// path is used.
+func WithRule(rule Rule) Option {
+	return func(f *coalesceFilter) {
+		f.rules = append(f.rules, rule)
+	}
+}
+
+// NewFilter allocates and returns a new Filter coalescing requests. Numbers
+// of leader requests and of followers receiving shared responses are counted
+// in HTTP.Coalesce.Leaders and HTTP.Coalesce.Followers.
+func NewFilter(options ...Option) filter.Filter {
+	f := &coalesceFilter{
+		leaders:   metrics.Counter(leadersCounter),
+		followers: metrics.Counter(followersCounter),
+		calls:     make(map[string]*call),
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
+}
+
+func (f *coalesceFilter) match(r *http.Request) *Rule {
+	if r.Method != http.MethodGet {
+		return nil
+	}
+	for i := range f.rules {
+		if strings.HasPrefix(r.URL.Path, f.rules[i].PathPrefix) {
+			return &f.rules[i]
+		}
+	}
+	return nil
+}
+
+func (f *coalesceFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rule := f.match(r)
+	if rule == nil {
+		filter.Continue(w, r)
+		return
+	}
+	key, ok := rule.key(r)
+	if !ok {
+		filter.Continue(w, r)
+		return
+	}
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		c.waiting++
+		f.mu.Unlock()
+		f.follow(c, w, r)
+		return
+	}
+	c := &call{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mu.Unlock()
+	f.lead(c, key, rule, w, r)
+}
+
+// lead runs the handler and records the response for followers. Followers
+// run the handler themselves if it panics.
+func (f *coalesceFilter) lead(c *call, key string, rule *Rule, w http.ResponseWriter, r *http.Request) {
+	f.leaders.Add()
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	rw := &responseWriter{
+		ResponseWriter: w,
+		before:         w.Header().Clone(),
+		maxBodySize:    rule.maxBodySize(),
+	}
+	filter.Continue(rw, r)
+	if rw.status == 0 {
+		rw.recordHeader(http.StatusOK)
+	}
+	if rw.overflow || r.Context().Err() != nil {
+		return
+	}
+	if !rule.ShareErrors && (rw.status != http.StatusOK || filter.Error(r) != nil) {
+		return
+	}
+	c.res = &response{
+		status: rw.status,
+		header: rw.header,
+		body:   rw.body.Bytes(),
+	}
+}
+
+// follow waits for the leader and writes its response, or runs the handler
+// if the response cannot be shared.
+func (f *coalesceFilter) follow(c *call, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		// Client has gone.
+		return
+	}
+	if c.res == nil {
+		filter.Continue(w, r)
+		return
+	}
+	f.followers.Add()
+	header := w.Header()
+	for k, v := range c.res.header {
+		header[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(c.res.status)
+	w.Write(c.res.body)
+}
+
+// responseWriter writes through and records the response of the leader.
+type responseWriter struct {
+	http.ResponseWriter
+	// before is the header set by outer filters, which is not shared.
+	before      http.Header
+	maxBodySize int
+
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.recordHeader(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// recordHeader records headers which have been added or changed by the
+// handler.
+func (w *responseWriter) recordHeader(status int) {
+	w.status = status
+	w.header = make(http.Header)
+	for k, v := range w.ResponseWriter.Header() {
+		if !equal(w.before[k], v) {
+			w.header[k] = append([]string(nil), v...)
+		}
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if w.body.Len()+len(p) > w.maxBodySize {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. Hijacked responses are not shared.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.overflow = true
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("not a Hijacker")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
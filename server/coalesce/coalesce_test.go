@@ -0,0 +1,317 @@
+package coalesce
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/server/filter"
+)
+
+// outerFilter sets a per-request header like request ID filter.
+func outerFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+	filter.Continue(w, r)
+}
+
+func newTestChain(f filter.Filter, handler http.HandlerFunc) *filter.Chain {
+	chain := filter.NewChain()
+	chain.Add(http.HandlerFunc(outerFilter), f, handler)
+	return chain
+}
+
+// waitFollowers waits until n followers are waiting for leaders.
+func waitFollowers(t *testing.T, f *coalesceFilter, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		waiting := 0
+		for _, c := range f.calls {
+			waiting += c.waiting
+		}
+		f.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d followers", n)
+}
+
+// serveConcurrently sends n requests built by newRequest while the handler
+// is blocked until all followers are waiting.
+func serveConcurrently(t *testing.T, f *coalesceFilter, handler http.HandlerFunc, n int,
+	newRequest func(i int) *http.Request) []*httptest.ResponseRecorder {
+	started := make(chan struct{}, n)
+	release := make(chan struct{})
+	chain := newTestChain(f, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		handler(w, r)
+	})
+	recorders := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	serve := func(i int) {
+		defer wg.Done()
+		recorders[i] = httptest.NewRecorder()
+		chain.ServeHTTP(recorders[i], newRequest(i))
+	}
+	wg.Add(n)
+	go serve(0)
+	<-started
+	for i := 1; i < n; i++ {
+		go serve(i)
+	}
+	waitFollowers(t, f, n-1)
+	close(release)
+	wg.Wait()
+	return recorders
+}
+
+func TestCoalesce(t *testing.T) {
+	metrics.Reset()
+	f := NewFilter(WithRule(Rule{PathPrefix: "/hot"})).(*coalesceFilter)
+	var executions int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&executions, 1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Execution", fmt.Sprint(n))
+		w.Write([]byte("hot "))
+		w.Write([]byte("data"))
+	}
+	const n = 50
+	recorders := serveConcurrently(t, f, handler, n, func(i int) *http.Request {
+		r := httptest.NewRequest("GET", "/hot?a=1&b=2", nil)
+		r.Header.Set("X-Request-Id", fmt.Sprint(i))
+		return r
+	})
+	if executions != 1 {
+		t.Fatalf("unexpected handler executions: %d", executions)
+	}
+	for i, w := range recorders {
+		if w.Code != http.StatusOK || w.Body.String() != "hot data" {
+			t.Fatalf("unexpected response %d: %d %s", i, w.Code, w.Body)
+		}
+		header := w.Result().Header
+		if header.Get("Content-Type") != "text/plain" || header.Get("X-Execution") != "1" ||
+			header.Get("X-Request-Id") != fmt.Sprint(i) {
+			t.Fatalf("unexpected header %d: %v", i, header)
+		}
+	}
+	counters, _ := metrics.Snapshot()
+	if counters[leadersCounter] != 1 || counters[followersCounter] != n-1 {
+		t.Fatalf("unexpected counters: %v", counters)
+	}
+	if len(f.calls) != 0 {
+		t.Fatalf("unexpected calls: %v", f.calls)
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	rule := Rule{Params: []string{"q", "page"}, Headers: []string{"Accept"}}
+	newRequest := func(target, accept string) *http.Request {
+		r := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return r
+	}
+	key := func(r *http.Request) string {
+		k, ok := rule.key(r)
+		if !ok {
+			t.Fatalf("request must be coalesced: %v", r.URL)
+		}
+		return k
+	}
+	base := key(newRequest("/search?q=go&page=1&_=123", "text/html"))
+	same := []*http.Request{
+		newRequest("/search?page=1&q=go", "text/html"),
+		newRequest("/search?q=go&page=1&_=456", "text/html"),
+	}
+	for _, r := range same {
+		if key(r) != base {
+			t.Fatalf("expected same key: %v", r.URL)
+		}
+	}
+	different := []*http.Request{
+		newRequest("/search?q=go&page=2", "text/html"),
+		newRequest("/search?q=go", "text/html"),
+		newRequest("/search?q=go&page=1", "application/json"),
+		newRequest("/search2?q=go&page=1", "text/html"),
+	}
+	for _, r := range different {
+		if key(r) == base {
+			t.Fatalf("expected different key: %v %v", r.URL, r.Header)
+		}
+	}
+	r := newRequest("/search?q=go", "")
+	r.Header.Set("Authorization", "Bearer x")
+	if _, ok := rule.key(r); ok {
+		t.Fatalf("request with authorization must not be coalesced")
+	}
+	rule.Headers = append(rule.Headers, "authorization")
+	if _, ok := rule.key(r); !ok {
+		t.Fatalf("request with authorization in key must be coalesced")
+	}
+	// All parameters by default
+	rule = Rule{}
+	if k1, _ := rule.key(newRequest("/a?x=1&y=2", "")); k1 == key(newRequest("/a?x=1", "")) {
+		t.Fatalf("expected different key")
+	}
+}
+
+func TestCoalesceNotShared(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name: "error",
+			rule: Rule{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "set error",
+			rule: Rule{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				filter.SetError(r, fmt.Errorf("error"))
+				w.Write([]byte("error"))
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "large body",
+			rule: Rule{MaxBodySize: 10},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("0123456789"))
+				w.Write([]byte("a"))
+			},
+			status: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewFilter(WithRule(test.rule)).(*coalesceFilter)
+			var executions int32
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&executions, 1)
+				test.handler(w, r)
+			}
+			const n = 5
+			// Only the leader is blocked, followers run the handler
+			// themselves after it completes.
+			recorders := serveConcurrently(t, f, handler, n, func(i int) *http.Request {
+				return httptest.NewRequest("GET", "/", nil)
+			})
+			if executions != n {
+				t.Fatalf("unexpected handler executions: %d", executions)
+			}
+			for _, w := range recorders {
+				if w.Code != test.status {
+					t.Fatalf("unexpected status: %d", w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestCoalesceShareErrors(t *testing.T) {
+	f := NewFilter(WithRule(Rule{ShareErrors: true})).(*coalesceFilter)
+	var executions int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&executions, 1)
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+	recorders := serveConcurrently(t, f, handler, 5, func(i int) *http.Request {
+		return httptest.NewRequest("GET", "/", nil)
+	})
+	if executions != 1 {
+		t.Fatalf("unexpected handler executions: %d", executions)
+	}
+	for _, w := range recorders {
+		if w.Code != http.StatusNotFound || w.Body.String() != "not found\n" {
+			t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+		}
+	}
+}
+
+func TestCoalesceLeaderPanic(t *testing.T) {
+	f := NewFilter(WithRule(Rule{})).(*coalesceFilter)
+	var executions int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&executions, 1) == 1 {
+			panic("leader")
+		}
+		w.Write([]byte("ok"))
+	}
+	recovery := func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recover() != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		filter.Continue(w, r)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	chain := filter.NewChain()
+	chain.Add(http.HandlerFunc(recovery), f, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&executions) == 0 {
+			close(started)
+			<-release
+		}
+		handler(w, r)
+	}))
+	leader := httptest.NewRecorder()
+	follower := httptest.NewRecorder()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		chain.ServeHTTP(leader, httptest.NewRequest("GET", "/", nil))
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		chain.ServeHTTP(follower, httptest.NewRequest("GET", "/", nil))
+	}()
+	waitFollowers(t, f, 1)
+	close(release)
+	wg.Wait()
+	if leader.Code != http.StatusInternalServerError || follower.Code != http.StatusOK || follower.Body.String() != "ok" {
+		t.Fatalf("unexpected response: %d %d %s", leader.Code, follower.Code, follower.Body)
+	}
+}
+
+func TestCoalesceSkipped(t *testing.T) {
+	f := NewFilter(WithRule(Rule{PathPrefix: "/hot"}))
+	var executions int32
+	chain := newTestChain(f, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&executions, 1)
+		w.Write([]byte(r.Method))
+	})
+	for _, r := range []*http.Request{
+		httptest.NewRequest("POST", "/hot", strings.NewReader("")),
+		httptest.NewRequest("GET", "/cold", nil),
+	} {
+		w := httptest.NewRecorder()
+		chain.ServeHTTP(w, r)
+		if w.Body.String() != r.Method {
+			t.Fatalf("unexpected response: %s", w.Body)
+		}
+	}
+	if executions != 2 {
+		t.Fatalf("unexpected handler executions: %d", executions)
+	}
+}
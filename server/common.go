@@ -3,62 +3,298 @@ package server
 import (
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"reflect"
+	"text/template"
+	"time"
 
 	"github.com/goburrow/gol/file/rotation"
 	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/cors"
 	"github.com/goburrow/melon/logging"
+	"github.com/goburrow/melon/server/bodylimit"
 	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/forwarded"
 	"github.com/goburrow/melon/server/gzip"
 	slogging "github.com/goburrow/melon/server/logging"
 	"github.com/goburrow/melon/server/recovery"
 	"github.com/goburrow/melon/server/router"
 )
 
+const defaultAdminGzipMinSize = 1024
+
 // commonFactory is the shared configuration of DefaultFactory and
 // SimpleFactory.
 type commonFactory struct {
+	RequestID  RequestIDConfiguration
 	RequestLog RequestLogConfiguration
 	Gzip       GzipConfiguration
+	// AdminGzip is configured separately from application and disabled by
+	// default. It is ignored when Filters contains GzipFilter, which is also
+	// applied to admin.
+	AdminGzip GzipConfiguration
+	// ErrorDetail is either none, message or stack. The default depends on
+	// the mode of the environment.
+	ErrorDetail string
+	// Filters overrides the default filter pipeline with the filters in the
+	// given order. RequestID, RequestLog, Gzip and Headers are ignored when
+	// it is set. CORSFilter, ReadOnlyFilter, QuotaFilter, RateLimitFilter and
+	// TimeoutFilter are not applied to admin.
+	Filters []FilterConfiguration
+	// Routes are redirects and proxies registered to the application router.
+	Routes RoutesConfiguration
+	// Headers is the policy of response headers, e.g. removing Server header.
+	// It is ignored when Filters is set.
+	Headers []HeaderRuleConfiguration
+	// HealthEndpoint exposes aggregate health on the application connectors.
+	HealthEndpoint HealthEndpointConfiguration
+	// AdminSelfCheck detects a wedged admin port.
+	AdminSelfCheck AdminSelfCheckConfiguration
+	// ForwardedHeaders applies X-Forwarded-Proto and X-Forwarded-Host from
+	// trusted proxies, which are used by router.BaseURL.
+	// It is ignored when Filters is set.
+	ForwardedHeaders ForwardedHeadersConfiguration
+	// CORS enables Cross-Origin Resource Sharing of the application. It is
+	// not applied to admin.
+	CORS CORSConfiguration
+	// MaxRequestBodySize limits request bodies of the application in bytes,
+	// 10MB by default. Negative value disables the limit. Resources can
+	// raise the limit of a request with bodylimit.SetLimit.
+	MaxRequestBodySize int64
+	// ShutdownTimeout is the maximum duration of draining in-flight requests
+	// when the server stops, 30s by default. Remaining connections are closed
+	// after it.
+	ShutdownTimeout string
+}
+
+func newCommonFactory() commonFactory {
+	return commonFactory{
+		AdminGzip: GzipConfiguration{
+			MinSize: defaultAdminGzipMinSize,
+		},
+	}
 }
 
-// AddFilters adds request log and panic recovery to the filter chain
-// of the given handlers.
+// newServer returns a server with the configured shutdown timeout.
+func (f *commonFactory) newServer() (*server, error) {
+	s := newServer()
+	if f.ShutdownTimeout != "" {
+		d, err := time.ParseDuration(f.ShutdownTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("server: invalid shutdown timeout %s", f.ShutdownTimeout)
+		}
+		s.shutdownTimeout = d
+	}
+	return s, nil
+}
+
+// AddFilters adds request ID, request log and panic recovery, or the
+// configured filters, to the filter chain of the given handlers. Built-in
+// filters are added under their names, e.g. RecoveryFilterName.
 func (f *commonFactory) AddFilters(env *core.Environment, handlers ...*router.Router) error {
-	// Request log must be first as handler panic should be recorded.
+	errorDetail := env.Mode.Defaults().ErrorDetail
+	if f.ErrorDetail != "" {
+		var err error
+		errorDetail, err = core.ParseErrorDetail(f.ErrorDetail)
+		if err != nil {
+			return err
+		}
+	}
+	env.Server.ErrorDetail = errorDetail
+	if len(f.Filters) > 0 {
+		filters, err := buildFilters(env, f.Filters, nil)
+		if err != nil {
+			return err
+		}
+		for _, ft := range filters {
+			if err = addFilter(handlers, ft.name, ft.filter); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	forwardedFilter, err := f.ForwardedHeaders.Build()
+	if err != nil {
+		return err
+	}
+	if forwardedFilter != nil {
+		if err = addFilter(handlers, ForwardedHeadersFilterName, forwardedFilter); err != nil {
+			return err
+		}
+	}
+	// Header policy governs responses of all other filters.
+	if len(f.Headers) > 0 {
+		headerFilter := buildHeaderFilter(f.Headers)
+		if err = addFilter(handlers, HeaderPolicyFilterName, headerFilter); err != nil {
+			return err
+		}
+	}
+	// Request ID is assigned before it is logged.
+	if f.RequestID.Enabled {
+		requestIDFilter := newRequestIDFilter(env, f.RequestID.Header)
+		if err = addFilter(handlers, RequestIDFilterName, requestIDFilter); err != nil {
+			return err
+		}
+	}
+	// Request log must be before recovery as handler panic should be recorded.
 	requestLogFilter, err := f.RequestLog.Build(env)
 	if err != nil {
 		return err
 	}
 	if requestLogFilter != nil {
-		for _, h := range handlers {
-			h.AddFilter(requestLogFilter)
+		if err = addFilter(handlers, RequestLogFilterName, requestLogFilter); err != nil {
+			return err
 		}
 	}
 	// Recover
-	recoveryFilter := recovery.NewFilter()
+	recoveryFilter := recovery.NewFilter(recovery.WithErrorDetail(errorDetail))
+	return addFilter(handlers, RecoveryFilterName, recoveryFilter)
+}
+
+// AddApplicationFilters adds the configured filters which only apply to the
+// application, e.g. CORS and quotas, to the application handler.
+// They are added after other configured filters.
+func (f *commonFactory) AddApplicationFilters(env *core.Environment, appHandler *router.Router) error {
+	if len(f.Filters) == 0 {
+		return nil
+	}
+	filters, err := buildFilters(env, f.Filters, appHandler)
+	if err != nil {
+		return err
+	}
+	for _, ft := range filters {
+		if err = addFilter([]*router.Router{appHandler}, ft.name, ft.filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFilter adds filter f to the handlers under the given name. Filters
+// without names, i.e. not built-in ones, are added unnamed.
+func addFilter(handlers []*router.Router, name string, f filter.Filter) error {
 	for _, h := range handlers {
-		h.AddFilter(recoveryFilter)
+		if name == "" {
+			h.AddFilter(f)
+		} else if err := h.AddNamedFilter(name, f); err != nil {
+			return err
+		}
 	}
-	// Gzip
-	if f.Gzip.Enabled {
-		gzipFilter := gzip.NewFilter()
-		for _, h := range handlers {
-			h.AddFilter(gzipFilter)
+	return nil
+}
+
+// AddGzipFilters adds response compression to application and admin handlers
+// according to their own configuration.
+func (f *commonFactory) AddGzipFilters(appHandler, adminHandler *router.Router) error {
+	if f.Gzip.Enabled && len(f.Filters) == 0 {
+		if err := appHandler.AddNamedFilter(GzipFilterName, f.Gzip.Build()); err != nil {
+			return err
 		}
 	}
+	if f.AdminGzip.Enabled && !f.hasFilter(GzipFilterName) {
+		return adminHandler.AddNamedFilter(GzipFilterName, f.AdminGzip.Build())
+	}
 	return nil
 }
 
+// hasFilter returns true if the configured filters contain the named filter.
+func (f *commonFactory) hasFilter(name string) bool {
+	for _, config := range f.Filters {
+		if filterNames[reflect.TypeOf(config.Value())] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddCORSFilter adds the CORS filter to the application handler if it is
+// enabled. Preflight requests are only answered for registered routes.
+func (f *commonFactory) AddCORSFilter(appHandler *router.Router) error {
+	if f.CORS.Enabled {
+		corsFilter, err := f.CORS.newFilter(cors.WithRouteMatcher(appHandler.Match))
+		if err != nil {
+			return err
+		}
+		return appHandler.AddNamedFilter(CORSFilterName, corsFilter)
+	}
+	return nil
+}
+
+// AddBodyLimitFilter adds the request body size limit to the application
+// handler unless it is disabled.
+func (f *commonFactory) AddBodyLimitFilter(appHandler *router.Router) {
+	limit := f.MaxRequestBodySize
+	if limit == 0 {
+		limit = bodylimit.DefaultLimit
+	}
+	if limit > 0 {
+		appHandler.AddFilter(bodylimit.NewFilter(limit))
+	}
+}
+
+// buildHealth builds the admin self check, which requests adminPath of
+// adminHandler, and the health endpoint of the application.
+func (f *commonFactory) buildHealth(env *core.Environment, appHandler *router.Router, adminHandler http.Handler, adminPath string) error {
+	selfCheck, err := f.AdminSelfCheck.Build(env, adminHandler, adminPath)
+	if err != nil {
+		return err
+	}
+	return f.HealthEndpoint.build(env, appHandler, selfCheck)
+}
+
+// ForwardedHeadersConfiguration trusts forwarded headers of requests from
+// TrustedProxies, which are CIDRs. Forwarded headers are removed from
+// requests of other clients.
+type ForwardedHeadersConfiguration struct {
+	TrustedProxies []string
+}
+
+// Build returns nil Filter if no proxies are trusted.
+func (f *ForwardedHeadersConfiguration) Build() (filter.Filter, error) {
+	if len(f.TrustedProxies) == 0 {
+		return nil, nil
+	}
+	networks := make([]*net.IPNet, len(f.TrustedProxies))
+	for i, cidr := range f.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid trusted proxy %s: %v", cidr, err)
+		}
+		networks[i] = network
+	}
+	return forwarded.NewFilter(networks...), nil
+}
+
+// RequestIDConfiguration indicates whether server should assign an ID to
+// requests without X-Request-Id header. IDs are generated by IDGenerator of
+// the environment, which are random UUIDs by default.
+type RequestIDConfiguration struct {
+	Enabled bool
+	// Header is the header of request IDs, X-Request-Id by default.
+	Header string
+}
+
 // RequestLogConfiguration is the configuration for the server request log.
 // It utilized the configuration of logging appenders.
 type RequestLogConfiguration struct {
+	// Appenders defaults to console depending on the mode of the environment.
+	// An empty list disables request log.
 	Appenders []logging.AppenderConfiguration
+	// Format is either common, combined or a template of
+	// github.com/goburrow/melon/server/logging.Entry, e.g.
+	// "{{.Method}} {{.URI}} {{.Status}} {{.Latency}}". It defaults to combined
+	// followed by latency in milliseconds and request ID.
+	Format string
 }
 
 // Build returns nil Filter if no appenders are set.
-func (f *RequestLogConfiguration) Build(_ *core.Environment) (filter.Filter, error) {
+func (f *RequestLogConfiguration) Build(env *core.Environment) (filter.Filter, error) {
 	var writers []io.Writer
+	if f.Appenders == nil && env.Mode.Defaults().ConsoleRequestLog {
+		writers = append(writers, os.Stdout)
+	}
 
 	for _, appender := range f.Appenders {
 		switch appenderFactory := appender.Value().(type) {
@@ -82,13 +318,27 @@ func (f *RequestLogConfiguration) Build(_ *core.Environment) (filter.Filter, err
 		// No request log
 		return nil, nil
 	}
+	var options []slogging.Option
+	switch f.Format {
+	case "":
+	case "common":
+		options = append(options, slogging.WithCommonFormat())
+	case "combined":
+		options = append(options, slogging.WithCombinedFormat())
+	default:
+		t, err := template.New("requestlog").Parse(f.Format)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid request log format: %v", err)
+		}
+		options = append(options, slogging.WithTemplate(t))
+	}
 	var w io.Writer
 	if len(writers) > 1 {
 		w = io.MultiWriter(writers...)
 	} else {
 		w = writers[0]
 	}
-	return slogging.NewFilter(w), nil
+	return slogging.NewFilter(w, options...), nil
 }
 
 func buildConsoleWriter(config *logging.ConsoleAppenderFactory) (io.Writer, error) {
@@ -124,9 +374,40 @@ func buildFileWriter(config *logging.FileAppenderFactory) (io.Writer, error) {
 	return writer, nil
 }
 
+// CORSConfiguration indicates whether the application supports Cross-Origin
+// Resource Sharing.
+type CORSConfiguration struct {
+	Enabled bool
+	CORSFilterFactory
+}
+
 // GzipConfiguration indicates whether server should compress http response.
 type GzipConfiguration struct {
 	Enabled bool
+	// MinSize is the minimum size in bytes of responses to be compressed.
+	MinSize int `valid:"min=0"`
+	// Encodings are the supported encodings in the order of preference when
+	// clients accept them equally, gzip by default. Other encodings, e.g. br,
+	// must be registered with gzip.RegisterEncoding.
+	Encodings []string
+	// Levels are compression levels of encodings, e.g. {"gzip": 6}.
+	Levels map[string]int
+}
+
+// Build returns a gzip filter.
+func (f *GzipConfiguration) Build() filter.Filter {
+	return newGzipFilter(f.MinSize, f.Encodings, f.Levels)
+}
+
+func newGzipFilter(minSize int, encodings []string, levels map[string]int) filter.Filter {
+	options := []gzip.Option{gzip.WithMinSize(minSize)}
+	if len(encodings) > 0 {
+		options = append(options, gzip.WithEncodings(encodings...))
+	}
+	for name, level := range levels {
+		options = append(options, gzip.WithLevel(name, level))
+	}
+	return gzip.NewFilter(options...)
 }
 
 // resourceHandler allows user to register server filter.
@@ -142,8 +423,11 @@ func newResourceHandler(router *router.Router) *resourceHandler {
 	}
 }
 
-func (h *resourceHandler) HandleResource(v interface{}) {
+// HandleResource claims filters.
+func (h *resourceHandler) HandleResource(v interface{}) bool {
 	if r, ok := v.(filter.Filter); ok {
 		h.router.AddFilter(r)
+		return true
 	}
+	return false
 }
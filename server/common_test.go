@@ -1,10 +1,18 @@
 package server
 
 import (
+	"archive/zip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
 	"testing"
 
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/logging"
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/requestid"
 	"github.com/goburrow/melon/server/router"
 )
 
@@ -19,6 +27,38 @@ func TestCommonFactory(t *testing.T) {
 	}
 }
 
+func TestNamedBuiltinFilters(t *testing.T) {
+	env := core.NewEnvironment()
+	factory := commonFactory{}
+	factory.RequestID.Enabled = true
+	handler := router.New()
+	if err := factory.AddFilters(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	captureID := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, requestid.Get(r))
+		filter.Continue(w, r)
+	})
+	if err := handler.InsertFilterBefore(RequestIDFilterName, captureID); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InsertFilterAfter(RequestIDFilterName, captureID); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InsertFilterBefore(GzipFilterName, captureID); err == nil {
+		t.Fatal("error expected for missing gzip filter")
+	}
+	if !handler.RemoveFilter(RecoveryFilterName) {
+		t.Fatal("recovery filter expected")
+	}
+	handler.Handle("GET", "/", http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(ids) != 2 || ids[0] != "" || ids[1] == "" {
+		t.Fatalf("unexpected request IDs: %q", ids)
+	}
+}
+
 func TestRequestLogConfiguration(t *testing.T) {
 	appender := logging.AppenderConfiguration{}
 	appender.SetValue(&logging.ConsoleAppenderFactory{})
@@ -39,6 +79,27 @@ func TestRequestLogConfiguration(t *testing.T) {
 	}
 }
 
+func TestRequestLogFormat(t *testing.T) {
+	appender := logging.AppenderConfiguration{}
+	appender.SetValue(&logging.ConsoleAppenderFactory{})
+	for _, format := range []string{"", "common", "combined", "{{.Method}} {{.Status}}"} {
+		config := RequestLogConfiguration{
+			Appenders: []logging.AppenderConfiguration{appender},
+			Format:    format,
+		}
+		if f, err := config.Build(core.NewEnvironment()); err != nil || f == nil {
+			t.Fatalf("unexpected filter of format %q: %#v %v", format, f, err)
+		}
+	}
+	config := RequestLogConfiguration{
+		Appenders: []logging.AppenderConfiguration{appender},
+		Format:    "{{.Method",
+	}
+	if _, err := config.Build(core.NewEnvironment()); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
 func TestNoRequestLogFactory(t *testing.T) {
 	env := core.NewEnvironment()
 	config := RequestLogConfiguration{}
@@ -50,3 +111,219 @@ func TestNoRequestLogFactory(t *testing.T) {
 		t.Fatalf("unexpected filter %#v", filter)
 	}
 }
+
+func TestErrorDetailConfiguration(t *testing.T) {
+	env := core.NewEnvironment()
+	factory := commonFactory{ErrorDetail: "stack"}
+	err := factory.AddFilters(env, router.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Server.ErrorDetail != core.ErrorDetailStack {
+		t.Fatalf("unexpected error detail: %v", env.Server.ErrorDetail)
+	}
+	factory.ErrorDetail = "all"
+	err = factory.AddFilters(env, router.New())
+	if err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	factory := newCommonFactory()
+	factory.CORS.Enabled = true
+	factory.CORS.AllowedOrigins = []string{"https://*.example.com"}
+	factory.CORS.AllowedMethods = []string{"GET", "DELETE"}
+
+	appHandler := router.New()
+	adminHandler := router.New()
+	factory.AddCORSFilter(appHandler)
+	testCORS(t, appHandler, adminHandler)
+}
+
+func TestCORSFilters(t *testing.T) {
+	factory := newCommonFactory()
+	factory.Filters = parseFilters(t, `[
+		{"type": "RecoveryFilter"},
+		{"type": "CORSFilter", "allowedOrigins": ["https://*.example.com"], "allowedMethods": ["GET", "DELETE"]}
+	]`)
+	env := core.NewEnvironment()
+	appHandler := router.New()
+	adminHandler := router.New()
+	if err := factory.AddFilters(env, appHandler, adminHandler); err != nil {
+		t.Fatal(err)
+	}
+	if err := factory.AddApplicationFilters(env, appHandler); err != nil {
+		t.Fatal(err)
+	}
+	testCORS(t, appHandler, adminHandler)
+}
+
+// testCORS checks that preflight requests are only answered for routes of
+// appHandler.
+func testCORS(t *testing.T, appHandler, adminHandler *router.Router) {
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	appHandler.Handle("*", "/users/{id}", handler)
+	adminHandler.Handle("*", "/tasks/gc", handler)
+
+	tests := []struct {
+		handler http.Handler
+		path    string
+		status  int
+		origin  string
+	}{
+		{appHandler, "/users/1", http.StatusNoContent, "https://app.example.com"},
+		{appHandler, "/groups/1", http.StatusNotFound, ""},
+		{adminHandler, "/tasks/gc", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		called = false
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", test.path, nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "DELETE")
+		test.handler.ServeHTTP(w, r)
+		if w.Code != test.status || w.Header().Get("Access-Control-Allow-Origin") != test.origin {
+			t.Fatalf("unexpected response %s: %d %v", test.path, w.Code, w.Header())
+		}
+		if called != (test.status == http.StatusOK) {
+			t.Fatalf("unexpected handler call %s: %v", test.path, called)
+		}
+	}
+}
+
+func TestAdminGzipFilters(t *testing.T) {
+	factory := newCommonFactory()
+	factory.AdminGzip.Enabled = true
+	factory.Filters = parseFilters(t, `[{"type": "GzipFilter"}]`)
+	env := core.NewEnvironment()
+	appHandler := router.New()
+	adminHandler := router.New()
+	if err := factory.AddFilters(env, appHandler, adminHandler); err != nil {
+		t.Fatal(err)
+	}
+	// GzipFilter of Filters is used for admin.
+	if err := factory.AddGzipFilters(appHandler, adminHandler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminGzip(t *testing.T) {
+	factory := newCommonFactory()
+	if factory.AdminGzip.Enabled {
+		t.Fatal("admin gzip must be disabled by default")
+	}
+	factory.AdminGzip.Enabled = true
+	factory.AdminGzip.MinSize = 256
+
+	appHandler := router.New()
+	adminHandler := router.New()
+	factory.AddGzipFilters(appHandler, adminHandler)
+
+	adminHandler.Handle("POST", "/tasks/goroutines", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	}))
+	adminHandler.Handle("GET", "/diagnostics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		zw := zip.NewWriter(w)
+		f, _ := zw.Create("goroutines.txt")
+		pprof.Lookup("goroutine").WriteTo(f, 2)
+		zw.Close()
+	}))
+	adminHandler.Handle("GET", "/healthcheck", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"deadlock": {"Healthy": true}}`))
+	}))
+	appHandler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	}))
+
+	tests := []struct {
+		handler  http.Handler
+		method   string
+		path     string
+		encoding string
+	}{
+		{adminHandler, "POST", "/tasks/goroutines", "gzip"},
+		{adminHandler, "GET", "/diagnostics", ""},
+		{adminHandler, "GET", "/healthcheck", ""},
+		{appHandler, "GET", "/", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		test.handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status code: %v", test.path, w.Code)
+		}
+		if w.Header().Get("Content-Encoding") != test.encoding {
+			t.Fatalf("%s: unexpected content encoding: %v", test.path, w.Header())
+		}
+	}
+}
+
+func TestModeDefaults(t *testing.T) {
+	tests := []struct {
+		mode        core.Mode
+		errorDetail string
+		expected    core.ErrorDetail
+	}{
+		{core.ModeProduction, "", core.ErrorDetailNone},
+		{core.ModeDevelopment, "", core.ErrorDetailStack},
+		{core.ModeDevelopment, "message", core.ErrorDetailMessage},
+		{core.ModeProduction, "stack", core.ErrorDetailStack},
+	}
+	for _, test := range tests {
+		env := core.NewEnvironment()
+		env.Mode = test.mode
+		factory := commonFactory{ErrorDetail: test.errorDetail}
+		if err := factory.AddFilters(env, router.New()); err != nil {
+			t.Fatal(err)
+		}
+		if env.Server.ErrorDetail != test.expected {
+			t.Fatalf("unexpected error detail in %v mode: %v, want: %v", test.mode, env.Server.ErrorDetail, test.expected)
+		}
+	}
+	// Request log
+	env := core.NewEnvironment()
+	env.Mode = core.ModeDevelopment
+	config := RequestLogConfiguration{}
+	if f, err := config.Build(env); err != nil || f == nil {
+		t.Fatalf("unexpected request log filter in development mode: %#v %v", f, err)
+	}
+	config.Appenders = []logging.AppenderConfiguration{}
+	if f, err := config.Build(env); err != nil || f != nil {
+		t.Fatalf("unexpected request log filter: %#v %v", f, err)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	for _, test := range []struct {
+		limit int64
+		code  int
+	}{
+		{0, http.StatusOK},
+		{4, http.StatusRequestEntityTooLarge},
+		{-1, http.StatusOK},
+	} {
+		factory := newCommonFactory()
+		factory.MaxRequestBodySize = test.limit
+		appHandler := router.New()
+		factory.AddBodyLimitFilter(appHandler)
+		appHandler.Handle("POST", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ioutil.ReadAll(r.Body); err != nil {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+			}
+		}))
+		w := httptest.NewRecorder()
+		appHandler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("12345")))
+		if w.Code != test.code {
+			t.Fatalf("unexpected status of limit %d: %d", test.limit, w.Code)
+		}
+	}
+}
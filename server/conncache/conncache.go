@@ -0,0 +1,130 @@
+/*
+Package conncache memoizes results derived from request headers per client
+connection. Keep-alive connections usually repeat the same Accept and
+Authorization headers, so parsing or verifying them once per connection saves
+work on every following request.
+
+The cache is enabled by setting ConnContext to http.Server.ConnContext.
+Without it, Load always parses.
+*/
+package conncache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxEntries is the maximum number of cached results of a connection.
+	MaxEntries = 16
+	// MaxValueSize is the maximum size of header values which are cached.
+	MaxValueSize = 8192
+)
+
+// Cache keeps one result for each key, which is only returned for the exact
+// header value it was derived from. It is concurrent-safe as HTTP/2 serves
+// requests of a connection concurrently.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	value  string
+	result interface{}
+	// expires is zero if the result does not expire.
+	expires time.Time
+}
+
+// New returns an empty Cache.
+func New() *Cache {
+	return &Cache{entries: make(map[string]entry)}
+}
+
+// Get returns the result of key stored for header value. It returns false if
+// the value has changed or the result has expired at now.
+func (c *Cache) Get(key, value string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || e.value != value {
+		return nil, false
+	}
+	if !e.expires.IsZero() && !now.Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.result, true
+}
+
+// Put stores result of key derived from header value, replacing the result
+// of the previous value. Results are not stored if value is larger than
+// MaxValueSize or the cache has MaxEntries other keys.
+// A non-zero expires is the time the result must be derived again.
+func (c *Cache) Put(key, value string, result interface{}, expires time.Time) {
+	if len(value) > MaxValueSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= MaxEntries {
+		return
+	}
+	c.entries[key] = entry{value: value, result: result, expires: expires}
+}
+
+// Len returns the number of cached results.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// contextKey is a value for use with context.WithValue
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return "melon/server context value " + c.name
+}
+
+var cacheContextKey = &contextKey{"conncache"}
+
+// ConnContext attaches a new Cache to the context of connection c.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return NewContext(ctx, New())
+}
+
+// NewContext returns a new context carrying cache.
+func NewContext(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, cacheContextKey, cache)
+}
+
+// FromContext returns the Cache of the connection or nil if it is not
+// enabled.
+func FromContext(ctx context.Context) *Cache {
+	c, _ := ctx.Value(cacheContextKey).(*Cache)
+	return c
+}
+
+// Load returns the result of parse for the value of the request header,
+// which is parsed only once per connection while the value does not change.
+// Results of Load must be immutable as they are shared by requests.
+func Load(r *http.Request, header string, parse func(value string) interface{}) interface{} {
+	value := r.Header.Get(header)
+	c := FromContext(r.Context())
+	if c == nil {
+		return parse(value)
+	}
+	key := http.CanonicalHeaderKey(header)
+	if result, ok := c.Get(key, value, time.Time{}); ok {
+		return result
+	}
+	result := parse(value)
+	c.Put(key, value, result, time.Time{})
+	return result
+}
@@ -0,0 +1,87 @@
+package conncache
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheValueChange(t *testing.T) {
+	c := New()
+	c.Put("Accept", "text/html", 1, time.Time{})
+	if v, ok := c.Get("Accept", "text/html", time.Now()); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v %v", v, ok)
+	}
+	if v, ok := c.Get("Accept", "application/json", time.Now()); ok {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	c.Put("Accept", "application/json", 2, time.Time{})
+	if v, ok := c.Get("Accept", "text/html", time.Now()); ok {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("unexpected length: %d", c.Len())
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New()
+	c.Put("Authorization", "Bearer x", "user", now.Add(time.Minute))
+	if _, ok := c.Get("Authorization", "Bearer x", now.Add(59*time.Second)); !ok {
+		t.Fatalf("unexpected expired result")
+	}
+	if _, ok := c.Get("Authorization", "Bearer x", now.Add(time.Minute)); ok {
+		t.Fatalf("unexpected result after expiry")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("unexpected length: %d", c.Len())
+	}
+}
+
+func TestCacheLimits(t *testing.T) {
+	c := New()
+	c.Put("large", strings.Repeat("a", MaxValueSize+1), 1, time.Time{})
+	if c.Len() != 0 {
+		t.Fatalf("unexpected length: %d", c.Len())
+	}
+	for i := 0; i < MaxEntries+1; i++ {
+		c.Put(string(rune('a'+i)), "v", i, time.Time{})
+	}
+	if c.Len() != MaxEntries {
+		t.Fatalf("unexpected length: %d", c.Len())
+	}
+	// Existing keys are still updated.
+	c.Put("a", "w", 100, time.Time{})
+	if v, ok := c.Get("a", "w", time.Now()); !ok || v != 100 {
+		t.Fatalf("unexpected result: %v %v", v, ok)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	var parsed int
+	parse := func(v string) interface{} {
+		parsed++
+		return strings.ToUpper(v)
+	}
+	ctx := NewContext(context.Background(), New())
+	for i, accept := range []string{"text/html", "text/html", "application/json"} {
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		r.Header.Set("Accept", accept)
+		if v := Load(r, "accept", parse); v != strings.ToUpper(accept) {
+			t.Fatalf("unexpected result %d: %v", i, v)
+		}
+	}
+	if parsed != 2 {
+		t.Fatalf("unexpected parse count: %d", parsed)
+	}
+	// Without cache.
+	r := httptest.NewRequest("GET", "/", nil)
+	Load(r, "Accept", parse)
+	Load(r, "Accept", parse)
+	if parsed != 4 {
+		t.Fatalf("unexpected parse count: %d", parsed)
+	}
+}
@@ -14,8 +14,11 @@ type DefaultFactory struct {
 	AdminConnectors       []Connector `valid:"notempty"`
 }
 
-func newDefaultFactory() *DefaultFactory {
+// NewDefaultFactory returns a DefaultFactory with application and admin
+// connectors listening on localhost:8080 and localhost:8081 respectively.
+func NewDefaultFactory() *DefaultFactory {
 	return &DefaultFactory{
+		commonFactory: newCommonFactory(),
 		ApplicationConnectors: []Connector{
 			Connector{
 				Type: "http",
@@ -34,7 +37,7 @@ func newDefaultFactory() *DefaultFactory {
 // Build creates a server listening on diffent ports for application and admin.
 func (factory *DefaultFactory) BuildServer(env *core.Environment) (core.Managed, error) {
 	// Application
-	appHandler := router.New()
+	appHandler := router.New(router.WithDeprecationTracker(env.Server.Deprecations))
 	env.Server.Router = appHandler
 	env.Server.AddResourceHandler(newResourceHandler(appHandler))
 
@@ -46,8 +49,31 @@ func (factory *DefaultFactory) BuildServer(env *core.Environment) (core.Managed,
 	if err != nil {
 		return nil, err
 	}
+	err = factory.commonFactory.AddApplicationFilters(env, appHandler)
+	if err != nil {
+		return nil, err
+	}
+	if err := factory.commonFactory.AddGzipFilters(appHandler, adminHandler); err != nil {
+		return nil, err
+	}
+	if err := factory.commonFactory.AddCORSFilter(appHandler); err != nil {
+		return nil, err
+	}
+	factory.commonFactory.AddBodyLimitFilter(appHandler)
+	appHandler.AddFilter(newDrainFilter(env.Lifecycle))
+	err = factory.commonFactory.Routes.Build(env, appHandler)
+	if err != nil {
+		return nil, err
+	}
+	err = factory.commonFactory.buildHealth(env, appHandler, adminHandler, adminPingPath)
+	if err != nil {
+		return nil, err
+	}
 
-	server := newServer()
+	server, err := factory.commonFactory.newServer()
+	if err != nil {
+		return nil, err
+	}
 	err = server.addConnectors(appHandler, factory.ApplicationConnectors)
 	if err != nil {
 		return nil, err
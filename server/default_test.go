@@ -1,6 +1,8 @@
 package server
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/goburrow/melon/core"
@@ -26,3 +28,35 @@ func TestDefaultFactory(t *testing.T) {
 		t.Fatal("Admin.ServerHandler is nil")
 	}
 }
+
+func TestDrainFilter(t *testing.T) {
+	env := core.NewEnvironment()
+	factory := NewDefaultFactory()
+	_, err := factory.BuildServer(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inFlight int64
+	env.Server.Router.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight = env.Lifecycle.InFlight()
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		env.Server.Router.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+	w := serve()
+	if w.Header().Get("Connection") != "" || inFlight != 1 {
+		t.Fatalf("unexpected response: %v %d", w.Header(), inFlight)
+	}
+	env.Lifecycle.Drain()
+	w = serve()
+	if w.Header().Get("Connection") != "close" {
+		t.Fatalf("unexpected response: %v", w.Header())
+	}
+	env.Lifecycle.Undrain()
+	w = serve()
+	if w.Header().Get("Connection") != "" || env.Lifecycle.InFlight() != 0 {
+		t.Fatalf("unexpected response: %v %d", w.Header(), env.Lifecycle.InFlight())
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+// drainFilter counts in-flight requests of the application and asks clients
+// to close their connections while the application is draining, so that load
+// balancers move them to other instances.
+type drainFilter struct {
+	lifecycle *core.LifecycleEnvironment
+}
+
+func newDrainFilter(lifecycle *core.LifecycleEnvironment) filter.Filter {
+	return &drainFilter{lifecycle: lifecycle}
+}
+
+func (f *drainFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lifecycle.RequestStarted()
+	defer f.lifecycle.RequestFinished()
+	if f.lifecycle.Draining() {
+		w.Header().Set("Connection", "close")
+	}
+	filter.Continue(w, r)
+}
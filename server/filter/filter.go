@@ -5,6 +5,8 @@ package filter
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 )
 
@@ -16,8 +18,13 @@ type Filter interface {
 }
 
 // Chain is a http.Handler that executes all filters.
+// Filters can be named so that other filters are inserted around them.
 type Chain struct {
 	filters []Filter
+	// names are the names of filters, empty for unnamed ones.
+	names []string
+	// err is the error of handling the request given to SetError.
+	err error
 }
 
 // NewChain allocates and returns a new Chain.
@@ -40,6 +47,21 @@ func (chain *Chain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // Add adds the given filter into the end of the chain.
 func (chain *Chain) Add(f ...Filter) {
 	chain.filters = append(chain.filters, f...)
+	chain.names = append(chain.names, make([]string, len(f))...)
+}
+
+// AddNamed adds filter f with the given name into the end of the chain.
+// Names must be unique in the chain.
+func (chain *Chain) AddNamed(name string, f Filter) error {
+	if name == "" {
+		return fmt.Errorf("filter: name must not be empty")
+	}
+	if chain.index(name) >= 0 {
+		return fmt.Errorf("filter: duplicated filter %s", name)
+	}
+	chain.filters = append(chain.filters, f)
+	chain.names = append(chain.names, name)
+	return nil
 }
 
 // Insert inserts the filter at the idx position.
@@ -47,10 +69,63 @@ func (chain *Chain) Insert(f Filter, idx int) bool {
 	if idx < 0 || idx >= len(chain.filters) {
 		return false
 	}
+	chain.insert(idx, f)
+	return true
+}
+
+// InsertBefore inserts filter f right before the filter with the given name.
+func (chain *Chain) InsertBefore(name string, f Filter) error {
+	idx := chain.index(name)
+	if idx < 0 {
+		return fmt.Errorf("filter: filter %s not found", name)
+	}
+	chain.insert(idx, f)
+	return nil
+}
+
+// InsertAfter inserts filter f right after the filter with the given name.
+func (chain *Chain) InsertAfter(name string, f Filter) error {
+	idx := chain.index(name)
+	if idx < 0 {
+		return fmt.Errorf("filter: filter %s not found", name)
+	}
+	chain.insert(idx+1, f)
+	return nil
+}
+
+// Remove removes the filter with the given name and reports whether it was
+// in the chain.
+func (chain *Chain) Remove(name string) bool {
+	idx := chain.index(name)
+	if idx < 0 {
+		return false
+	}
+	chain.filters = append(chain.filters[:idx:idx], chain.filters[idx+1:]...)
+	chain.names = append(chain.names[:idx:idx], chain.names[idx+1:]...)
+	return true
+}
+
+// insert inserts unnamed filter f at the idx position.
+func (chain *Chain) insert(idx int, f Filter) {
 	chain.filters = append(chain.filters, nil)
 	copy(chain.filters[idx+1:], chain.filters[idx:])
 	chain.filters[idx] = f
-	return true
+	chain.names = append(chain.names, "")
+	copy(chain.names[idx+1:], chain.names[idx:])
+	chain.names[idx] = ""
+}
+
+// index returns the position of the filter with the given name or -1.
+func (chain *Chain) index(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, n := range chain.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
 }
 
 // Length returns length of the chain.
@@ -69,6 +144,43 @@ func Continue(w http.ResponseWriter, r *http.Request) {
 	f.ServeHTTP(w, r)
 }
 
+// SetError records the error of handling request r, e.g. by an error mapper,
+// so that filters can report it when the chain returns.
+func SetError(r *http.Request, err error) {
+	if chain := fromContext(r.Context()); chain != nil {
+		chain.err = err
+	}
+}
+
+// Error returns the error recorded by SetError.
+func Error(r *http.Request) error {
+	if chain := fromContext(r.Context()); chain != nil {
+		return chain.err
+	}
+	return nil
+}
+
+// problem is a problem details object of RFC 7807.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// WriteProblem writes a problem+json response of RFC 7807 with the given
+// status and detail. It is used by filters which reject requests.
+func WriteProblem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
+
 // If is a filter which executes the underlying filter only when requests/responses
 // meet specific condition.
 type If struct {
@@ -1,6 +1,8 @@
 package filter
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -85,3 +87,91 @@ func TesIf(t *testing.T) {
 		t.Fatalf("unexpected body: %v", w.Body.String())
 	}
 }
+
+func TestError(t *testing.T) {
+	var err error
+	chain := NewChain()
+	chain.Add(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Continue(w, r)
+		err = Error(r)
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetError(r, errors.New("failed"))
+	}))
+	chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err == nil || err.Error() != "failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = Error(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNamedFilter(t *testing.T) {
+	chain := NewChain()
+	if err := chain.AddNamed("log", testFilter("L")); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.AddNamed("recovery", testFilter("R")); err != nil {
+		t.Fatal(err)
+	}
+	chain.Add(endHandler)
+	if err := chain.AddNamed("log", testFilter("X")); err == nil {
+		t.Fatal("error expected for duplicated name")
+	}
+	if err := chain.InsertBefore("log", testFilter("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.InsertAfter("log", testFilter("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.InsertAfter("recovery", testFilter("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.InsertBefore("gzip", testFilter("X")); err == nil || err.Error() != "filter: filter gzip not found" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertChain(t, chain, "aLbRcEND")
+
+	if !chain.Remove("log") {
+		t.Fatal("log filter expected to be removed")
+	}
+	if chain.Remove("log") {
+		t.Fatal("log filter is not expected in the chain")
+	}
+	if err := chain.InsertBefore("recovery", testFilter("d")); err != nil {
+		t.Fatal(err)
+	}
+	assertChain(t, chain, "abdRcEND")
+	// Name can be used again after removal.
+	if err := chain.AddNamed("log", testFilter("L")); err != nil {
+		t.Fatal(err)
+	}
+	if chain.Length() != 7 {
+		t.Fatalf("unexpected length: %d", chain.Length())
+	}
+}
+
+func assertChain(t *testing.T, chain *Chain, expected string) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	chain.ServeHTTP(w, r)
+	if expected != w.Body.String() {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteProblem(w, http.StatusServiceUnavailable, "Try again later.")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	expected := problem{"about:blank", "Service Unavailable", http.StatusServiceUnavailable, "Try again later."}
+	if p != expected {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Package filtertest provides utilities for testing HTTP filters.
+
+A Harness runs requests through a chain of filters ending with a terminal
+handler which records what it saw:
+
+	h := filtertest.NewHarness(myFilter)
+	res := h.Request("GET", "/", filtertest.WithHeader("X-Api-Key", "abc"))
+	res.AssertReached(t)
+	res.AssertRequestHeader(t, "X-Tenant", "example")
+*/
+package filtertest
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// TerminalBody is the response body of the default terminal handler.
+const TerminalBody = "terminal"
+
+// TestingT is the subset of testing.TB used by this package.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Harness runs requests through filters and a terminal handler.
+type Harness struct {
+	filters []filter.Filter
+	handler http.Handler
+	keys    []interface{}
+}
+
+// NewHarness returns a Harness for the given filters. The terminal handler
+// responds 200 with TerminalBody unless it is changed by SetHandler.
+func NewHarness(filters ...filter.Filter) *Harness {
+	return &Harness{
+		filters: filters,
+		handler: http.HandlerFunc(serveTerminal),
+	}
+}
+
+// SetHandler sets the handler called by the terminal, e.g. to test how
+// filters react to its response or panic.
+func (h *Harness) SetHandler(handler http.Handler) *Harness {
+	h.handler = handler
+	return h
+}
+
+// CaptureContext sets the keys of context values recorded by the terminal.
+func (h *Harness) CaptureContext(keys ...interface{}) *Harness {
+	h.keys = append(h.keys, keys...)
+	return h
+}
+
+// RequestOption modifies the request sent by Harness.
+type RequestOption func(r *http.Request)
+
+// WithHeader sets a request header.
+func WithHeader(key, value string) RequestOption {
+	return func(r *http.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// WithBody sets the request body.
+func WithBody(body string) RequestOption {
+	return func(r *http.Request) {
+		r.Body = ioutil.NopCloser(strings.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+}
+
+// WithRemoteAddr sets the network address of the client.
+func WithRemoteAddr(addr string) RequestOption {
+	return func(r *http.Request) {
+		r.RemoteAddr = addr
+	}
+}
+
+// WithRequest applies f to the request, e.g. to add context values.
+func WithRequest(f func(r *http.Request) *http.Request) RequestOption {
+	return func(r *http.Request) {
+		*r = *f(r)
+	}
+}
+
+// Request sends a request through the filters and returns the result.
+func (h *Harness) Request(method, path string, options ...RequestOption) *Result {
+	r := httptest.NewRequest(method, path, nil)
+	for _, opt := range options {
+		opt(r)
+	}
+	res := &Result{}
+	terminal := func(w http.ResponseWriter, r *http.Request) {
+		res.Reached = true
+		res.Request = r
+		if len(h.keys) > 0 {
+			res.Context = make(map[interface{}]interface{}, len(h.keys))
+			for _, k := range h.keys {
+				if v := r.Context().Value(k); v != nil {
+					res.Context[k] = v
+				}
+			}
+		}
+		h.handler.ServeHTTP(w, r)
+	}
+	chain := filter.NewChain()
+	for _, f := range h.filters {
+		chain.Add(f)
+	}
+	chain.Add(http.HandlerFunc(terminal))
+
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, r)
+	w.Flush()
+	res.Status = w.Code
+	res.Header = w.Header()
+	res.Body = w.Body.String()
+	return res
+}
+
+func serveTerminal(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, TerminalBody)
+}
+
+// Result is the outcome of a request sent by Harness.
+type Result struct {
+	Status int
+	Header http.Header
+	Body   string
+
+	// Reached is true when the terminal handler was called.
+	Reached bool
+	// Request is the request seen by the terminal handler, nil if it was
+	// not reached.
+	Request *http.Request
+	// Context contains the captured context values which were set.
+	Context map[interface{}]interface{}
+}
+
+// AssertReached fails the test if a filter did not call the next one.
+func (r *Result) AssertReached(t TestingT) {
+	t.Helper()
+	if !r.Reached {
+		t.Errorf("terminal handler not reached: %d %s", r.Status, r.Body)
+	}
+}
+
+// AssertShortCircuited fails the test if the terminal handler was called or
+// the response status is not status.
+func (r *Result) AssertShortCircuited(t TestingT, status int) {
+	t.Helper()
+	if r.Reached {
+		t.Errorf("terminal handler unexpectedly reached: %s %s", r.Request.Method, r.Request.URL)
+	}
+	if r.Status != status {
+		t.Errorf("unexpected status: %d, want %d", r.Status, status)
+	}
+}
+
+// AssertHeader fails the test if the response header key is not value.
+// An empty value asserts the header is absent.
+func (r *Result) AssertHeader(t TestingT, key, value string) {
+	t.Helper()
+	if v := r.Header.Get(key); v != value {
+		t.Errorf("unexpected response header %s: %q, want %q", key, v, value)
+	}
+}
+
+// AssertRequestHeader fails the test if the request header key seen by the
+// terminal handler is not value.
+func (r *Result) AssertRequestHeader(t TestingT, key, value string) {
+	t.Helper()
+	if !r.Reached {
+		t.Errorf("terminal handler not reached for request header %s", key)
+		return
+	}
+	if v := r.Request.Header.Get(key); v != value {
+		t.Errorf("unexpected request header %s: %q, want %q", key, v, value)
+	}
+}
+
+// AssertContext fails the test if the captured context value of key is not
+// equal to value.
+func (r *Result) AssertContext(t TestingT, key, value interface{}) {
+	t.Helper()
+	v, ok := r.Context[key]
+	if !ok {
+		t.Errorf("context value %v not captured", key)
+		return
+	}
+	if !reflect.DeepEqual(v, value) {
+		t.Errorf("unexpected context value %v: %#v, want %#v", key, v, value)
+	}
+}
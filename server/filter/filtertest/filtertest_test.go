@@ -0,0 +1,91 @@
+package filtertest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+type contextKey string
+
+// authFilter rejects requests without a token and passes the token as a
+// context value and a request header otherwise.
+func authFilter(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Token")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("X-Authenticated", "true")
+	r.Header.Set("X-User", "user-"+token)
+	ctx := context.WithValue(r.Context(), contextKey("token"), token)
+	filter.Continue(w, r.WithContext(ctx))
+}
+
+func TestHarnessShortCircuit(t *testing.T) {
+	h := NewHarness(http.HandlerFunc(authFilter))
+	res := h.Request("GET", "/")
+	res.AssertShortCircuited(t, http.StatusUnauthorized)
+	res.AssertHeader(t, "X-Authenticated", "")
+	if res.Request != nil || strings.TrimSpace(res.Body) != "unauthorized" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	ft := &fakeT{}
+	res.AssertReached(ft)
+	res.AssertRequestHeader(ft, "X-User", "")
+	if len(ft.errors) != 2 {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+
+	res = h.Request("GET", "/", WithHeader("X-Token", "abc"))
+	ft = &fakeT{}
+	res.AssertShortCircuited(ft, http.StatusUnauthorized)
+	if len(ft.errors) != 2 || !strings.Contains(ft.errors[0], "unexpectedly reached") {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+}
+
+func TestHarnessContext(t *testing.T) {
+	h := NewHarness(http.HandlerFunc(authFilter)).CaptureContext(contextKey("token"), contextKey("other"))
+	res := h.Request("POST", "/users", WithHeader("X-Token", "abc"), WithBody("{}"))
+	res.AssertReached(t)
+	res.AssertHeader(t, "X-Authenticated", "true")
+	res.AssertRequestHeader(t, "X-User", "user-abc")
+	res.AssertContext(t, contextKey("token"), "abc")
+	if res.Status != http.StatusOK || res.Body != TerminalBody || res.Request.Method != "POST" || res.Request.ContentLength != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	ft := &fakeT{}
+	res.AssertContext(ft, contextKey("token"), "xyz")
+	res.AssertContext(ft, contextKey("other"), nil)
+	if len(ft.errors) != 2 || !strings.Contains(ft.errors[1], "not captured") {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+}
+
+func TestHarnessHandler(t *testing.T) {
+	h := NewHarness().SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	res := h.Request("GET", "/", WithRemoteAddr("10.0.0.1:1234"))
+	res.AssertReached(t)
+	if res.Status != http.StatusTeapot || res.Body != "" || res.Request.RemoteAddr != "10.0.0.1:1234" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
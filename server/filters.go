@@ -0,0 +1,504 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/goburrow/dynamic"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/cors"
+	"github.com/goburrow/melon/server/coalesce"
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/header"
+	"github.com/goburrow/melon/server/quota"
+	"github.com/goburrow/melon/server/ratelimit"
+	"github.com/goburrow/melon/server/readonly"
+	"github.com/goburrow/melon/server/recovery"
+	"github.com/goburrow/melon/server/requestid"
+	"github.com/goburrow/melon/server/router"
+	"github.com/goburrow/melon/server/timeout"
+)
+
+// Names of built-in filters which can be used once. They are the types of
+// filters in the configuration, and the names under which these filters are
+// added to the routers, so that applications can insert their filters around
+// them, e.g. with router.InsertFilterBefore(server.RequestLogFilterName, f).
+const (
+	RequestIDFilterName        = "RequestIDFilter"
+	RequestLogFilterName       = "RequestLogFilter"
+	RecoveryFilterName         = "RecoveryFilter"
+	GzipFilterName             = "GzipFilter"
+	CORSFilterName             = "CORSFilter"
+	HeaderPolicyFilterName     = "HeaderPolicyFilter"
+	ForwardedHeadersFilterName = "ForwardedHeadersFilter"
+)
+
+// Types of other built-in filters in the configuration. They are added to the
+// routers without names, as some of them can be configured more than once,
+// e.g. quotas per hour and per day.
+const (
+	QuotaFilterName     = "QuotaFilter"
+	ReadOnlyFilterName  = "ReadOnlyFilter"
+	CoalesceFilterName  = "CoalesceFilter"
+	RateLimitFilterName = "RateLimitFilter"
+	TimeoutFilterName   = "TimeoutFilter"
+)
+
+const (
+	defaultQuotaSnapshotInterval = time.Minute
+	quotaTaskName                = "quota"
+	readOnlyTaskName             = "read-only"
+)
+
+// filterNames maps types of registered filter factories to their names.
+var filterNames = make(map[reflect.Type]string)
+
+func init() {
+	RegisterFilter(RequestIDFilterName, func() FilterFactory { return &RequestIDFilterFactory{} })
+	RegisterFilter(RequestLogFilterName, func() FilterFactory { return &RequestLogFilterFactory{} })
+	RegisterFilter(RecoveryFilterName, func() FilterFactory { return &RecoveryFilterFactory{} })
+	RegisterFilter(GzipFilterName, func() FilterFactory { return &GzipFilterFactory{} })
+	RegisterFilter(CORSFilterName, func() FilterFactory { return &CORSFilterFactory{} })
+	RegisterFilter(HeaderPolicyFilterName, func() FilterFactory { return &HeaderPolicyFilterFactory{} })
+	RegisterFilter(ForwardedHeadersFilterName, func() FilterFactory { return &ForwardedHeadersFilterFactory{} })
+	RegisterFilter(QuotaFilterName, func() FilterFactory { return &QuotaFilterFactory{} })
+	RegisterFilter(ReadOnlyFilterName, func() FilterFactory { return &ReadOnlyFilterFactory{} })
+	RegisterFilter(CoalesceFilterName, func() FilterFactory { return &CoalesceFilterFactory{} })
+	RegisterFilter(RateLimitFilterName, func() FilterFactory { return &RateLimitFilterFactory{} })
+	RegisterFilter(TimeoutFilterName, func() FilterFactory { return &TimeoutFilterFactory{} })
+}
+
+// FilterFactory builds a server filter from its configuration.
+type FilterFactory interface {
+	BuildFilter(env *core.Environment) (filter.Filter, error)
+}
+
+// applicationFilterFactory is implemented by factories of application-only
+// filters which need the application router.
+type applicationFilterFactory interface {
+	buildApplicationFilter(env *core.Environment, appHandler *router.Router) (filter.Filter, error)
+}
+
+// RegisterFilter registers a filter factory so that it can be referenced by
+// name in the filters of server configuration.
+// RegisterFilter is not concurrent-safe and should be called in init functions.
+func RegisterFilter(name string, newFactory func() FilterFactory) {
+	filterNames[reflect.TypeOf(newFactory())] = name
+	dynamic.Register(name, func() interface{} {
+		return newFactory()
+	})
+}
+
+// FilterConfiguration is an union of registered filter factories.
+type FilterConfiguration struct {
+	dynamic.Type
+}
+
+// filterRank returns the required position of the named filter. Forwarded
+// headers are applied before any other filters use the request. Header
+// policy is next so that it governs all responses. Request ID and
+// request log are outside of recovery so that panics are logged with request
+// IDs. All other filters must be inside recovery.
+func filterRank(name string) int {
+	switch name {
+	case ForwardedHeadersFilterName:
+		return -2
+	case HeaderPolicyFilterName:
+		return -1
+	case RequestIDFilterName:
+		return 0
+	case RequestLogFilterName:
+		return 1
+	case RecoveryFilterName:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func isBuiltinFilter(name string) bool {
+	switch name {
+	case RequestIDFilterName, RequestLogFilterName, RecoveryFilterName, GzipFilterName, CORSFilterName, HeaderPolicyFilterName,
+		ForwardedHeadersFilterName:
+		return true
+	default:
+		return false
+	}
+}
+
+// namedFilter is a built filter with its name, which is empty unless it is a
+// built-in filter.
+type namedFilter struct {
+	name   string
+	filter filter.Filter
+}
+
+// isApplicationFilter reports whether the named filter only applies to the
+// application. Admin must stay reachable, e.g. to leave read-only mode or for
+// health checks of load balancers, and admin tasks such as cpu-profile may run
+// longer than request timeouts. CORS is only for application resources.
+func isApplicationFilter(name string) bool {
+	switch name {
+	case ReadOnlyFilterName, QuotaFilterName, TimeoutFilterName, RateLimitFilterName, CORSFilterName:
+		return true
+	default:
+		return false
+	}
+}
+
+// buildFilters validates filters ordering and builds them, either the ones
+// only applied to appHandler or the others when appHandler is nil.
+// Built-in filters can only be used once.
+func buildFilters(env *core.Environment, configs []FilterConfiguration, appHandler *router.Router) ([]namedFilter, error) {
+	names := make([]string, len(configs))
+	for i, config := range configs {
+		if _, ok := config.Value().(FilterFactory); !ok {
+			return nil, fmt.Errorf("server: unsupported filter %#v", config.Value())
+		}
+		name := filterNames[reflect.TypeOf(config.Value())]
+		for j := 0; j < i; j++ {
+			if names[j] == name && isBuiltinFilter(name) {
+				return nil, fmt.Errorf("server: duplicated filter %s", name)
+			}
+			if filterRank(names[j]) > filterRank(name) {
+				return nil, fmt.Errorf("server: filter %s must be before %s", name, names[j])
+			}
+		}
+		names[i] = name
+	}
+	filters := make([]namedFilter, 0, len(configs))
+	for i, config := range configs {
+		if isApplicationFilter(names[i]) != (appHandler != nil) {
+			continue
+		}
+		var f filter.Filter
+		var err error
+		if factory, ok := config.Value().(applicationFilterFactory); ok && appHandler != nil {
+			f, err = factory.buildApplicationFilter(env, appHandler)
+		} else {
+			f, err = config.Value().(FilterFactory).BuildFilter(env)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("server: could not build filter %s: %v", names[i], err)
+		}
+		if f != nil {
+			nf := namedFilter{filter: f}
+			if isBuiltinFilter(names[i]) {
+				nf.name = names[i]
+			}
+			filters = append(filters, nf)
+		}
+	}
+	return filters, nil
+}
+
+// RequestIDFilterFactory builds a filter which assigns IDs to requests.
+type RequestIDFilterFactory struct {
+	// Header is the header of request IDs, X-Request-Id by default.
+	Header string
+}
+
+// BuildFilter returns a request ID filter using IDGenerator of the environment.
+func (f *RequestIDFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	return newRequestIDFilter(env, f.Header), nil
+}
+
+func newRequestIDFilter(env *core.Environment, header string) filter.Filter {
+	if header != "" {
+		return requestid.NewFilter(env, requestid.WithHeader(header))
+	}
+	return requestid.NewFilter(env)
+}
+
+// RequestLogFilterFactory builds a request log filter.
+type RequestLogFilterFactory struct {
+	RequestLogConfiguration
+}
+
+// BuildFilter returns nil Filter if no appenders are set.
+func (f *RequestLogFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	return f.RequestLogConfiguration.Build(env)
+}
+
+// RecoveryFilterFactory builds a panic recovery filter.
+type RecoveryFilterFactory struct{}
+
+// BuildFilter returns a recovery filter respecting ErrorDetail of the server environment.
+func (*RecoveryFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	return recovery.NewFilter(recovery.WithErrorDetail(env.Server.ErrorDetail)), nil
+}
+
+// GzipFilterFactory builds a response compression filter.
+type GzipFilterFactory struct {
+	// MinSize is the minimum size in bytes of responses to be compressed.
+	MinSize int `valid:"min=0"`
+	// Encodings are the supported encodings in the order of preference.
+	// br and zstd require importing packages gzip/brotli and gzip/zstd.
+	Encodings []string
+	// Levels are compression levels of encodings.
+	Levels map[string]int
+}
+
+// BuildFilter returns a gzip filter.
+func (f *GzipFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	return newGzipFilter(f.MinSize, f.Encodings, f.Levels), nil
+}
+
+// CORSFilterFactory builds a Cross-Origin Resource Sharing filter.
+// Default settings of cors package are used for empty fields.
+type CORSFilterFactory struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           string
+}
+
+// BuildFilter returns a CORS filter.
+func (f *CORSFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	return f.newFilter()
+}
+
+// buildApplicationFilter returns a CORS filter only answering preflight
+// requests of routes registered to appHandler.
+func (f *CORSFilterFactory) buildApplicationFilter(env *core.Environment, appHandler *router.Router) (filter.Filter, error) {
+	return f.newFilter(cors.WithRouteMatcher(appHandler.Match))
+}
+
+// newFilter returns a CORS filter. AllowCredentials requires explicit
+// AllowedOrigins as every website would be allowed otherwise.
+func (f *CORSFilterFactory) newFilter(options ...cors.Option) (filter.Filter, error) {
+	if f.AllowCredentials {
+		if len(f.AllowedOrigins) == 0 {
+			return nil, fmt.Errorf("server: CORS AllowCredentials requires AllowedOrigins")
+		}
+		for _, origin := range f.AllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("server: CORS AllowCredentials is not allowed with origin *")
+			}
+		}
+	}
+	if len(f.AllowedOrigins) > 0 {
+		options = append(options, cors.WithAllowedOrigins(f.AllowedOrigins...))
+	}
+	if len(f.AllowedMethods) > 0 {
+		options = append(options, cors.WithAllowedMethods(f.AllowedMethods...))
+	}
+	if len(f.AllowedHeaders) > 0 {
+		options = append(options, cors.WithAllowedHeaders(f.AllowedHeaders...))
+	}
+	if len(f.ExposedHeaders) > 0 {
+		options = append(options, cors.WithExposedHeaders(f.ExposedHeaders...))
+	}
+	if f.AllowCredentials {
+		options = append(options, cors.WithAllowCredentials())
+	}
+	if f.MaxAge != "" {
+		options = append(options, cors.WithMaxAge(f.MaxAge))
+	}
+	return cors.NewFilter(options...), nil
+}
+
+// HeaderPolicyFilterFactory builds a filter enforcing response headers.
+type HeaderPolicyFilterFactory struct {
+	Rules []HeaderRuleConfiguration
+}
+
+// BuildFilter returns a header policy filter.
+func (f *HeaderPolicyFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	return buildHeaderFilter(f.Rules), nil
+}
+
+// ForwardedHeadersFilterFactory builds a filter trusting forwarded headers
+// from proxies.
+type ForwardedHeadersFilterFactory struct {
+	ForwardedHeadersConfiguration
+}
+
+// BuildFilter returns nil Filter if no proxies are trusted.
+func (f *ForwardedHeadersFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	return f.ForwardedHeadersConfiguration.Build()
+}
+
+// QuotaFilterFactory builds a filter limiting requests of API keys per hour,
+// day or month. Usage is kept in memory and saved to SnapshotFile if given.
+// Admin task quota shows and resets usage of a key.
+type QuotaFilterFactory struct {
+	Limit int64 `valid:"min=1"`
+	// Window is hour, day or month.
+	Window string `valid:"notempty"`
+	// TimeZone of windows, UTC by default.
+	TimeZone string
+	// KeyHeader is the request header of API keys, X-Api-Key by default.
+	KeyHeader string
+	// SnapshotFile keeps usage across restarts.
+	SnapshotFile string
+	// SnapshotInterval is the period of saving SnapshotFile, 1m by default.
+	SnapshotInterval string
+	// MaxKeys is the number of keys whose usage is kept, 100000 by default.
+	// Requests of other keys are not counted.
+	MaxKeys int `valid:"min=0"`
+	// Name distinguishes admin tasks of multiple quotas, which are
+	// quota-<name>, or quota when it is empty.
+	Name string
+}
+
+// BuildFilter returns a quota filter.
+func (f *QuotaFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	window, err := quota.NewWindow(f.Window, f.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	store := quota.NewMemoryStore(f.MaxKeys)
+	if f.SnapshotFile != "" {
+		interval := defaultQuotaSnapshotInterval
+		if f.SnapshotInterval != "" {
+			interval, err = time.ParseDuration(f.SnapshotInterval)
+			if err != nil {
+				return nil, fmt.Errorf("server: invalid quota snapshot interval: %v", err)
+			}
+		}
+		env.Lifecycle.Manage(quota.NewSnapshot(store, f.SnapshotFile, interval, env.GetClock()))
+	}
+	options := []quota.Option{quota.WithClock(env.GetClock())}
+	if f.KeyHeader != "" {
+		options = append(options, quota.WithKeyHeader(f.KeyHeader))
+	}
+	q := quota.New(store, f.Limit, window, options...)
+	taskName := quotaTaskName
+	if f.Name != "" {
+		taskName += "-" + f.Name
+	}
+	env.Admin.AddTask(quota.NewTask(taskName, q))
+	return q.Filter(), nil
+}
+
+// RateLimitFilterFactory builds a filter limiting request rates of each
+// client IP or API key with token buckets.
+type RateLimitFilterFactory struct {
+	// RequestsPerSecond is the rate buckets are refilled.
+	RequestsPerSecond float64 `valid:"min=0"`
+	// Burst is the size of buckets, 1 by default.
+	Burst int `valid:"min=0"`
+	// KeyHeader limits requests by the request header instead of client IP,
+	// e.g. X-Api-Key.
+	KeyHeader string
+	// MaxKeys is the number of most recently seen keys whose buckets are
+	// kept, 10000 by default.
+	MaxKeys int `valid:"min=0"`
+}
+
+// BuildFilter returns a rate limiting filter.
+func (f *RateLimitFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	if f.RequestsPerSecond <= 0 {
+		return nil, fmt.Errorf("server: rate limit requires positive requests per second")
+	}
+	options := []ratelimit.Option{
+		ratelimit.WithClock(env.GetClock()),
+		ratelimit.WithMetrics(env.Metrics),
+	}
+	if f.KeyHeader != "" {
+		options = append(options, ratelimit.WithKeyHeader(f.KeyHeader))
+	}
+	if f.MaxKeys > 0 {
+		options = append(options, ratelimit.WithMaxKeys(f.MaxKeys))
+	}
+	return ratelimit.NewFilter(f.RequestsPerSecond, f.Burst, options...), nil
+}
+
+// TimeoutFilterFactory builds a filter cancelling requests which have not
+// started responding in time, responding status 503 to them.
+type TimeoutFilterFactory struct {
+	// Timeout is the maximum duration before responding, e.g. 30s.
+	Timeout string `valid:"notempty"`
+}
+
+// BuildFilter returns a request timeout filter.
+func (f *TimeoutFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	d, err := time.ParseDuration(f.Timeout)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("server: invalid request timeout %s", f.Timeout)
+	}
+	return timeout.NewFilter(d, timeout.WithClock(env.GetClock())), nil
+}
+
+// ReadOnlyFilterFactory builds a filter rejecting mutating requests while
+// the application is in read-only mode. Admin task read-only toggles the
+// mode and /readonly on the admin server displays it.
+type ReadOnlyFilterFactory struct {
+	// ExemptPaths are paths still accepting mutating requests, e.g.
+	// /auth/login. A path ending with "*" matches all paths with the prefix.
+	ExemptPaths []string
+	// HealthCheck enters read-only mode while the named health check is
+	// unhealthy.
+	HealthCheck string
+}
+
+// BuildFilter returns a read-only mode filter.
+func (f *ReadOnlyFilterFactory) BuildFilter(env *core.Environment) (filter.Filter, error) {
+	m := readonly.New(readonly.WithExemptPaths(f.ExemptPaths...))
+	if f.HealthCheck != "" {
+		m.BindHealthCheck(env, f.HealthCheck)
+	}
+	env.Admin.AddParamTask(readonly.NewTask(readOnlyTaskName, m))
+	env.Admin.AddHandler(m.Handler())
+	return m.Filter(), nil
+}
+
+// CoalesceFilterFactory builds a filter sharing one handler execution among
+// concurrent identical GET requests.
+type CoalesceFilterFactory struct {
+	Rules []CoalesceRuleConfiguration `valid:"notempty"`
+}
+
+// BuildFilter returns a request coalescing filter.
+func (f *CoalesceFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	options := make([]coalesce.Option, len(f.Rules))
+	for i, r := range f.Rules {
+		options[i] = coalesce.WithRule(coalesce.Rule{
+			PathPrefix:  r.PathPrefix,
+			Params:      r.Params,
+			Headers:     r.Headers,
+			MaxBodySize: r.MaxBodySize,
+			ShareErrors: r.ShareErrors,
+		})
+	}
+	return coalesce.NewFilter(options...), nil
+}
+
+// CoalesceRuleConfiguration coalesces GET requests under PathPrefix with the
+// same path, Params and Headers. All query parameters are compared if Params
+// is empty. Responses larger than MaxBodySize, 1MB by default, and responses
+// other than 200 OK are not shared unless ShareErrors is set.
+type CoalesceRuleConfiguration struct {
+	PathPrefix  string
+	Params      []string
+	Headers     []string
+	MaxBodySize int `valid:"min=0"`
+	ShareErrors bool
+}
+
+// HeaderRuleConfiguration removes, sets or defaults response headers of
+// requests under PathPrefix. Set overrides values from handlers while Default
+// only fills missing headers.
+type HeaderRuleConfiguration struct {
+	PathPrefix string
+	Remove     []string
+	Set        map[string]string
+	Default    map[string]string
+}
+
+func buildHeaderFilter(rules []HeaderRuleConfiguration) filter.Filter {
+	options := make([]header.Option, len(rules))
+	for i, r := range rules {
+		options[i] = header.WithRule(header.Rule{
+			PathPrefix: r.PathPrefix,
+			Remove:     r.Remove,
+			Set:        r.Set,
+			Default:    r.Default,
+		})
+	}
+	return header.NewFilter(options...)
+}
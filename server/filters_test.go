@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/router"
+)
+
+// markFilterFactory is a custom filter writing its name.
+type markFilterFactory struct {
+	Name string
+	buf  *bytes.Buffer
+}
+
+func (f *markFilterFactory) BuildFilter(*core.Environment) (filter.Filter, error) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.buf.WriteString(f.Name)
+		filter.Continue(w, r)
+	}), nil
+}
+
+var markFilterBuf bytes.Buffer
+
+func init() {
+	RegisterFilter("MarkFilter", func() FilterFactory {
+		return &markFilterFactory{buf: &markFilterBuf}
+	})
+}
+
+func parseFilters(t *testing.T, data string) []FilterConfiguration {
+	var configs []FilterConfiguration
+	if err := json.Unmarshal([]byte(data), &configs); err != nil {
+		t.Fatal(err)
+	}
+	return configs
+}
+
+func TestFiltersCustomOrder(t *testing.T) {
+	markFilterBuf.Reset()
+	factory := newCommonFactory()
+	factory.Gzip.Enabled = true
+	factory.Filters = parseFilters(t, `[
+		{"type": "RequestIDFilter"},
+		{"type": "RecoveryFilter"},
+		{"type": "MarkFilter", "name": "b"},
+		{"type": "GzipFilter", "minSize": 1024},
+		{"type": "MarkFilter", "name": "a"}
+	]`)
+	env := core.NewEnvironment()
+	appHandler := router.New()
+	adminHandler := router.New()
+	if err := factory.AddFilters(env, appHandler, adminHandler); err != nil {
+		t.Fatal(err)
+	}
+	factory.AddGzipFilters(appHandler, adminHandler)
+	appHandler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	appHandler.ServeHTTP(w, r)
+	if markFilterBuf.String() != "ba" {
+		t.Fatalf("unexpected filter order: %v", markFilterBuf.String())
+	}
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Fatalf("request id expected: %v", w.Header())
+	}
+	// Response is smaller than MinSize of GzipFilter.
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "ok" {
+		t.Fatalf("unexpected response: %v %v", w.Header(), w.Body.String())
+	}
+}
+
+func TestFiltersInvalidName(t *testing.T) {
+	var configs []FilterConfiguration
+	err := json.Unmarshal([]byte(`[{"type": "NoSuchFilter"}]`), &configs)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	// Registered types which are not filters.
+	configs = parseFilters(t, `[{"type": "SimpleServer"}]`)
+	_, err = buildFilters(core.NewEnvironment(), configs, nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported filter") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFiltersConstraints(t *testing.T) {
+	tests := map[string]string{
+		`[{"type": "GzipFilter"}, {"type": "RecoveryFilter"}]`:                                          "filter RecoveryFilter must be before GzipFilter",
+		`[{"type": "RecoveryFilter"}, {"type": "RequestLogFilter"}]`:                                    "filter RequestLogFilter must be before RecoveryFilter",
+		`[{"type": "RequestLogFilter"}, {"type": "RequestIDFilter"}]`:                                   "filter RequestIDFilter must be before RequestLogFilter",
+		`[{"type": "MarkFilter"}, {"type": "RecoveryFilter"}]`:                                          "filter RecoveryFilter must be before MarkFilter",
+		`[{"type": "RecoveryFilter"}, {"type": "RecoveryFilter"}]`:                                      "duplicated filter RecoveryFilter",
+		`[{"type": "CORSFilter"}, {"type": "GzipFilter"}]`:                                              "",
+		`[{"type": "CORSFilter", "AllowCredentials": true}]`:                                            "CORS AllowCredentials requires AllowedOrigins",
+		`[{"type": "CORSFilter", "AllowCredentials": true, "AllowedOrigins": ["*"]}]`:                   "CORS AllowCredentials is not allowed with origin *",
+		`[{"type": "CORSFilter", "AllowCredentials": true, "AllowedOrigins": ["https://example.com"]}]`: "",
+		`[{"type": "RequestIDFilter"}, {"type": "RecoveryFilter"}]`:                                     "",
+	}
+	for data, msg := range tests {
+		_, err := buildFilters(core.NewEnvironment(), parseFilters(t, data), router.New())
+		if msg == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", data, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: unexpected error: %v, want: %v", data, err, msg)
+		}
+	}
+}
+
+func TestHeaderPolicyFilter(t *testing.T) {
+	factory := newCommonFactory()
+	factory.RequestID.Enabled = true
+	factory.Headers = []HeaderRuleConfiguration{
+		{Remove: []string{"Server"}, Default: map[string]string{"X-Request-Id": "none"}},
+	}
+	env := core.NewEnvironment()
+	handler := router.New()
+	if err := factory.AddFilters(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "melon")
+		w.Write([]byte("ok"))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	id := w.Header().Get("X-Request-Id")
+	if w.Header().Get("Server") != "" || id == "" || id == "none" {
+		t.Fatalf("unexpected header: %v", w.Header())
+	}
+	// Header policy must be the first filter.
+	factory.Filters = parseFilters(t, `[{"type": "RequestIDFilter"}, {"type": "HeaderPolicyFilter"}]`)
+	if err := factory.AddFilters(env, router.New()); err == nil {
+		t.Fatalf("error expected")
+	}
+}
+
+func TestCoalesceFilter(t *testing.T) {
+	factory := newCommonFactory()
+	factory.Filters = parseFilters(t, `[
+		{"type": "RequestIDFilter"},
+		{"type": "CoalesceFilter", "rules": [{"pathPrefix": "/api/", "params": ["q"], "maxBodySize": 1024}]}
+	]`)
+	env := core.NewEnvironment()
+	handler := router.New()
+	if err := factory.AddFilters(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle("GET", "/api/search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("q")))
+	}))
+	for _, q := range []string{"a", "b"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/search?q="+q, nil))
+		if w.Code != http.StatusOK || w.Body.String() != q || w.Header().Get("X-Request-Id") == "" {
+			t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+		}
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	factory := newCommonFactory()
+	factory.Filters = parseFilters(t, `[
+		{"type": "RateLimitFilter", "requestsPerSecond": 1, "burst": 2, "keyHeader": "X-Api-Key"}
+	]`)
+	env := core.NewEnvironment()
+	handler, admin := router.New(), router.New()
+	if err := factory.AddFilters(env, handler, admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := factory.AddApplicationFilters(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	admin.Handle("GET", "/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		// Admin requests do not spend tokens.
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/ping", nil)
+		r.Header.Set("X-Api-Key", "a")
+		admin.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected admin status %d: %d", i, w.Code)
+		}
+	}
+	for i, code := range []int{200, 200, 429} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Api-Key", "a")
+		handler.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected status %d: %d", i, w.Code)
+		}
+	}
+	factory.Filters = parseFilters(t, `[{"type": "RateLimitFilter"}]`)
+	if err := factory.AddApplicationFilters(env, router.New()); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestTimeoutFilter(t *testing.T) {
+	factory := newCommonFactory()
+	factory.Filters = parseFilters(t, `[{"type": "TimeoutFilter", "timeout": "10ms"}]`)
+	handler := router.New()
+	if err := factory.AddApplicationFilters(core.NewEnvironment(), handler); err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle("GET", "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	factory.Filters = parseFilters(t, `[{"type": "TimeoutFilter", "timeout": "30"}]`)
+	if err := factory.AddApplicationFilters(core.NewEnvironment(), router.New()); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestTimeoutFilterAdmin(t *testing.T) {
+	factory := NewDefaultFactory()
+	factory.Filters = parseFilters(t, `[{"type": "RecoveryFilter"}, {"type": "TimeoutFilter", "timeout": "10ms"}]`)
+	env := core.NewEnvironment()
+	if _, err := factory.BuildServer(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	// Admin tasks are not limited by request timeout.
+	w := httptest.NewRecorder()
+	env.Admin.Router.(http.Handler).ServeHTTP(w, httptest.NewRequest("POST", "/tasks/cpu-profile?duration=50ms", nil))
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	env.Server.Router.Handle("GET", "/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	w = httptest.NewRecorder()
+	env.Server.Router.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestReadOnlyFilter(t *testing.T) {
+	factory := NewDefaultFactory()
+	factory.Filters = parseFilters(t, `[{"type": "RecoveryFilter"}, {"type": "ReadOnlyFilter"}]`)
+	env := core.NewEnvironment()
+	if _, err := factory.BuildServer(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	env.Server.Router.Handle("POST", "/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	serve := func(h core.Router, path string) int {
+		w := httptest.NewRecorder()
+		h.(http.Handler).ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w.Code
+	}
+	if status := serve(env.Admin.Router, "/tasks/read-only?enabled=true"); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status := serve(env.Server.Router, "/users"); status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	// Admin is not read-only so that the mode can be left.
+	if status := serve(env.Admin.Router, "/tasks/read-only?enabled=false"); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status := serve(env.Server.Router, "/users"); status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestQuotaFilters(t *testing.T) {
+	factory := NewDefaultFactory()
+	factory.Filters = parseFilters(t, `[
+		{"type": "QuotaFilter", "limit": 10, "window": "hour", "name": "hourly"},
+		{"type": "QuotaFilter", "limit": 100, "window": "day", "name": "daily"}
+	]`)
+	env := core.NewEnvironment()
+	if _, err := factory.BuildServer(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/tasks/quota-daily?key=a", nil)
+	r.Header.Set("X-Api-Key", "a")
+	env.Admin.Router.(http.Handler).ServeHTTP(w, r)
+	// Admin requests are not counted.
+	if w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "" || !strings.Contains(w.Body.String(), "Used: 0") {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+}
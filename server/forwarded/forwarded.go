@@ -0,0 +1,77 @@
+/*
+Package forwarded provides a filter which applies X-Forwarded-Proto and
+X-Forwarded-Host headers set by trusted proxies to requests.
+*/
+package forwarded
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	xForwardedProto = "X-Forwarded-Proto"
+	xForwardedHost  = "X-Forwarded-Host"
+)
+
+// forwardedFilter trusts forwarded headers of requests from proxies.
+type forwardedFilter struct {
+	trusted []*net.IPNet
+}
+
+// NewFilter returns a Filter which sets scheme of the request URL and host of
+// the request from X-Forwarded-Proto and X-Forwarded-Host when the client is
+// in one of trusted networks. The headers are removed from requests of other
+// clients so that handlers do not use them.
+func NewFilter(trusted ...*net.IPNet) filter.Filter {
+	return &forwardedFilter{trusted: trusted}
+}
+
+func (f *forwardedFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.isTrusted(r.RemoteAddr) {
+		r.Header.Del(xForwardedProto)
+		r.Header.Del(xForwardedHost)
+		// Scheme of absolute request URIs is not trusted either.
+		r.URL.Scheme = ""
+		filter.Continue(w, r)
+		return
+	}
+	switch proto := strings.ToLower(lastValue(r.Header.Get(xForwardedProto))); proto {
+	case "http", "https":
+		r.URL.Scheme = proto
+	}
+	if host := lastValue(r.Header.Get(xForwardedHost)); host != "" {
+		r.Host = host
+	}
+	filter.Continue(w, r)
+}
+
+func (f *forwardedFilter) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range f.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastValue returns the value set by the closest proxy, which is the last
+// one in a comma-separated list. Values before it are sent by the client or
+// appended by further hops and cannot be trusted.
+func lastValue(v string) string {
+	if i := strings.LastIndexByte(v, ','); i >= 0 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,71 @@
+package forwarded
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/router"
+)
+
+func TestForwarded(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	var base, location string
+	handler := router.New(router.WithPathPrefix("/application"))
+	handler.AddFilter(NewFilter(network))
+	handler.Handle("POST", "/users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base = router.BaseURL(r).String()
+		location, _ = router.AbsoluteURLFor(r, "/users/{id:int}", "id", "1")
+	}))
+	tests := []struct {
+		remoteAddr string
+		base       string
+	}{
+		{"10.0.0.1:1234", "https://example.com/application"},
+		{"192.0.2.1:1234", "http://internal:8080/application"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://internal:8080/application/users", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Forwarded-Proto", "HTTPS")
+		r.Header.Set("X-Forwarded-Host", "example.com")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if base != test.base || location != test.base+"/users/1" {
+			t.Fatalf("unexpected URL of %s: %s %s", test.remoteAddr, base, location)
+		}
+	}
+}
+
+func TestUntrustedHeadersRemoved(t *testing.T) {
+	var proto string
+	chain := filter.NewChain()
+	chain.Add(NewFilter(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Header.Get("X-Forwarded-Proto")
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	chain.ServeHTTP(httptest.NewRecorder(), r)
+	if proto != "" {
+		t.Fatalf("unexpected header: %s", proto)
+	}
+}
+
+func TestSpoofedForwardedHeaders(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	var scheme, host string
+	chain := filter.NewChain()
+	chain.Add(NewFilter(network), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, host = r.URL.Scheme, r.Host
+	}))
+	r := httptest.NewRequest("GET", "http://internal:8080/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	// Client sends its own values, which the proxy appends to.
+	r.Header.Set("X-Forwarded-Proto", "http, https")
+	r.Header.Set("X-Forwarded-Host", "evil.example, example.com")
+	chain.ServeHTTP(httptest.NewRecorder(), r)
+	if scheme != "https" || host != "example.com" {
+		t.Fatalf("unexpected URL: %s %s", scheme, host)
+	}
+}
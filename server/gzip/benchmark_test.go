@@ -0,0 +1,40 @@
+package gzip_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/gzip"
+	_ "github.com/goburrow/melon/server/gzip/brotli"
+	_ "github.com/goburrow/melon/server/gzip/zstd"
+)
+
+// BenchmarkEncodings compares throughput of the filter with all encodings,
+// including br and zstd which are registered by their packages.
+func BenchmarkEncodings(b *testing.B) {
+	body := bytes.Repeat([]byte(`{"name":"melon","tags":["fruit","sweet"]},`), 1000)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	encodings := []string{gzip.EncodingGzip, gzip.EncodingDeflate, gzip.EncodingBrotli, gzip.EncodingZstd}
+	for _, name := range append([]string{"identity"}, encodings...) {
+		b.Run(name, func(b *testing.B) {
+			chain := filter.NewChain()
+			chain.Add(gzip.NewFilter(gzip.WithEncodings(encodings...)), h)
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Accept-Encoding", name)
+				chain.ServeHTTP(w, r)
+				if name != "identity" && w.Header().Get("Content-Encoding") != name {
+					b.Fatalf("unexpected content encoding: %v", w.Header())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Package brotli adds br encoding to the compression filter using
+// github.com/andybalholm/brotli. Importing this package registers the
+// encoding, which then can be set in Encodings of the gzip filter:
+//
+//	import _ "github.com/goburrow/melon/server/gzip/brotli"
+package brotli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/goburrow/melon/server/gzip"
+)
+
+func init() {
+	gzip.RegisterEncoding(gzip.EncodingBrotli, newEncoder)
+}
+
+// newEncoder creates a brotli writer. Accepted levels are from 1 (best speed)
+// to 11 (best compression), 0 is the default level of brotli.
+func newEncoder(w io.Writer, level int) (gzip.Encoder, error) {
+	if level == 0 {
+		level = brotli.DefaultCompression
+	}
+	if level < brotli.BestSpeed || level > brotli.BestCompression {
+		return nil, fmt.Errorf("brotli: invalid compression level: %d", level)
+	}
+	return brotli.NewWriterLevel(w, level), nil
+}
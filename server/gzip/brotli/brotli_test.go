@@ -0,0 +1,53 @@
+package brotli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/gzip"
+)
+
+func TestBrotli(t *testing.T) {
+	chain := filter.NewChain()
+	chain.Add(gzip.NewFilter(gzip.WithEncodings(gzip.EncodingBrotli, gzip.EncodingGzip)),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	// Encoders are reused by following requests.
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip, br")
+		chain.ServeHTTP(w, r)
+		if "br" != w.Header().Get("Content-Encoding") {
+			t.Fatalf("unexpected content encoding: %v", w.Header())
+		}
+		if "Accept-Encoding" != w.Header().Get("Vary") {
+			t.Fatalf("unexpected vary: %v", w.Header())
+		}
+		body, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "ok" != string(body) {
+			t.Fatalf("unexpected body: %s", body)
+		}
+	}
+}
+
+func TestNewEncoderLevel(t *testing.T) {
+	for _, level := range []int{0, 1, 11} {
+		if _, err := newEncoder(ioutil.Discard, level); err != nil {
+			t.Fatalf("unexpected error for level %d: %v", level, err)
+		}
+	}
+	for _, level := range []int{-1, 12} {
+		if _, err := newEncoder(ioutil.Discard, level); err == nil {
+			t.Fatalf("error expected for level %d", level)
+		}
+	}
+}
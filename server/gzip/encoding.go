@@ -0,0 +1,160 @@
+package gzip
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder compresses data written to it. Encoders are pooled and reused for
+// other responses with Reset.
+type Encoder interface {
+	io.WriteCloser
+	// Flush writes any buffered data to the underlying writer.
+	Flush() error
+	// Reset discards the state of the encoder and makes it write to w.
+	Reset(w io.Writer)
+}
+
+// NewEncoderFunc creates an Encoder writing to w at the given compression
+// level. Level 0 is the default level of the encoding.
+type NewEncoderFunc func(w io.Writer, level int) (Encoder, error)
+
+// Supported content codings
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+)
+
+// defaultEncodings are the encodings used when they are not set. Other
+// encodings, e.g. br, must be registered and set explicitly.
+// Packages gzip/brotli and gzip/zstd register br and zstd.
+var defaultEncodings = []string{EncodingGzip}
+
+var encoders = map[string]NewEncoderFunc{
+	EncodingGzip: func(w io.Writer, level int) (Encoder, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	},
+	EncodingDeflate: func(w io.Writer, level int) (Encoder, error) {
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		return flate.NewWriter(w, level)
+	},
+}
+
+// RegisterEncoding registers an encoding using a third-party library so that
+// it can be negotiated by the filter. See packages gzip/brotli and gzip/zstd.
+// RegisterEncoding is not concurrent-safe and should be called in init functions.
+func RegisterEncoding(name string, newEncoder NewEncoderFunc) {
+	encoders[name] = newEncoder
+}
+
+// encoderPool reuses encoders of an encoding at a level.
+type encoderPool struct {
+	name  string
+	level int
+	pool  sync.Pool
+}
+
+func newEncoderPool(name string, level int) (*encoderPool, error) {
+	newEncoder := encoders[name]
+	// Check the level once so that encoders can be created later without errors.
+	enc, err := newEncoder(ioutil.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+	p := &encoderPool{
+		name:  name,
+		level: level,
+	}
+	p.pool.Put(enc)
+	return p, nil
+}
+
+func (p *encoderPool) get(w io.Writer) Encoder {
+	if enc, ok := p.pool.Get().(Encoder); ok {
+		enc.Reset(w)
+		return enc
+	}
+	enc, _ := encoders[p.name](w, p.level)
+	return enc
+}
+
+func (p *encoderPool) put(enc Encoder) {
+	// Pooled encoders must not keep the response writer.
+	enc.Reset(ioutil.Discard)
+	p.pool.Put(enc)
+}
+
+// negotiate returns the index of the preferred encoding accepted by the
+// Accept-Encoding header, or -1 if none of them is acceptable.
+// Encodings with the highest quality value are preferred, then the order of
+// names.
+func negotiate(acceptEncoding string, names []string) int {
+	if acceptEncoding == "" {
+		return -1
+	}
+	qualities := parseAcceptEncoding(acceptEncoding)
+	wildcard, hasWildcard := qualities["*"]
+	best, bestQ := -1, 0.0
+	for i, name := range names {
+		q, ok := qualities[name]
+		if !ok {
+			if !hasWildcard {
+				continue
+			}
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding returns quality values of codings in lower case.
+// Invalid quality values are treated as 0.
+func parseAcceptEncoding(s string) map[string]float64 {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") || strings.HasPrefix(p, "Q=") {
+				v, err := strconv.ParseFloat(p[2:], 64)
+				if err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+				q = v
+			}
+		}
+		qualities[name] = q
+	}
+	return qualities
+}
+
+// sortedEncodings returns names of registered encodings.
+func sortedEncodings() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,160 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// testEncoder is registered as br in tests, using deflate as the format.
+type testEncoder struct {
+	*flate.Writer
+}
+
+func (e testEncoder) Reset(w io.Writer) {
+	e.Writer.Reset(w)
+}
+
+func registerTestEncoding(t *testing.T) {
+	// Package brotli may also be registered in benchmarks.
+	previous, registered := encoders[EncodingBrotli]
+	RegisterEncoding(EncodingBrotli, func(w io.Writer, level int) (Encoder, error) {
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		return testEncoder{fw}, err
+	})
+	t.Cleanup(func() {
+		if registered {
+			encoders[EncodingBrotli] = previous
+		} else {
+			delete(encoders, EncodingBrotli)
+		}
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	names := []string{"br", "gzip", "deflate"}
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"deflate, gzip", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"BR, GZIP", "br"},
+		{"*", "br"},
+		{"*;q=0.5, gzip;q=0.8", "gzip"},
+		{"*, br;q=0", "gzip"},
+		{"gzip;q=0, deflate;q=0, br;q=0", ""},
+		{"gzip;q=invalid", ""},
+		{"zstd", ""},
+	}
+	for _, test := range tests {
+		encoding := ""
+		if i := negotiate(test.acceptEncoding, names); i >= 0 {
+			encoding = names[i]
+		}
+		if encoding != test.encoding {
+			t.Errorf("unexpected encoding for %q: want %q, got %q", test.acceptEncoding, test.encoding, encoding)
+		}
+	}
+}
+
+// TestEncodings uses compress as an unregistered encoding since zstd may be
+// registered in benchmarks.
+func TestEncodings(t *testing.T) {
+	registerTestEncoding(t)
+	f := NewFilter(WithEncodings("compress", "br", "gzip", "deflate"), WithLevel("gzip", gzip.BestSpeed))
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Vary", "Accept-Encoding")
+		handler(w, r)
+	}
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		etag           string
+	}{
+		{"gzip, br, compress", "br", `W/"v1"`},
+		{"br;q=0, gzip", "gzip", `W/"v1"`},
+		{"deflate", "deflate", `W/"v1"`},
+		{"compress", "", `"v1"`},
+	}
+	for _, test := range tests {
+		// Encoders are reused from pools in the second round.
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			chain := filter.NewChain()
+			chain.Add(f, http.HandlerFunc(h))
+			chain.ServeHTTP(w, r)
+
+			header := w.Result().Header
+			if header.Get("Content-Encoding") != test.encoding || header.Get("ETag") != test.etag {
+				t.Fatalf("unexpected header for %q: %v", test.acceptEncoding, header)
+			}
+			if len(header["Vary"]) != 1 {
+				t.Fatalf("unexpected vary header: %v", header["Vary"])
+			}
+			var reader io.Reader = w.Body
+			switch test.encoding {
+			case "gzip":
+				gr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gr
+			case "br", "deflate":
+				reader = flate.NewReader(w.Body)
+			}
+			body, err := ioutil.ReadAll(reader)
+			if err != nil || string(body) != "ok" {
+				t.Fatalf("unexpected body for %q: %q %v", test.acceptEncoding, body, err)
+			}
+		}
+	}
+}
+
+func TestDefaultEncodings(t *testing.T) {
+	registerTestEncoding(t)
+	f := NewFilter()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	chain := filter.NewChain()
+	chain.Add(f, http.HandlerFunc(handler))
+	chain.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("unexpected header: %v", w.Header())
+	}
+}
+
+func TestEncoderPoolReset(t *testing.T) {
+	p, err := newEncoderPool(EncodingGzip, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := p.get(&buf)
+	enc.Write([]byte("ok"))
+	enc.Close()
+	n := buf.Len()
+	p.put(enc)
+	// Writing to the pooled encoder does not reach the previous writer.
+	enc.Write([]byte("more"))
+	enc.Close()
+	if buf.Len() != n {
+		t.Fatalf("pooled encoder keeps writer: %d %d", n, buf.Len())
+	}
+}
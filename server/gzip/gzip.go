@@ -1,45 +1,127 @@
-// Package gzip provides gzip support for melon server.
+// Package gzip provides response compression for melon server. gzip and
+// deflate are supported by default. Encodings br and zstd are added by
+// importing packages gzip/brotli and gzip/zstd, others with RegisterEncoding.
 package gzip
 
 import (
 	"bufio"
-	"compress/gzip"
 	"errors"
+	"mime"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/server/filter"
 )
 
-// gzipFilter is a filter which compress http responses using gzip.
-type gzipFilter struct{}
+// compressedContentTypes are media types which are not worth compressing again.
+var compressedContentTypes = map[string]struct{}{
+	"application/gzip":   {},
+	"application/x-gzip": {},
+	"application/zip":    {},
+}
+
+// gzipFilter is a filter which compress http responses using the encoding
+// negotiated with Accept-Encoding header.
+type gzipFilter struct {
+	minSize   int
+	encodings []string
+	levels    map[string]int
+	// pools are encoders of encodings in the same order.
+	pools []*encoderPool
+}
+
+// Option is an option for gzip Filter.
+type Option func(f *gzipFilter)
+
+// WithMinSize sets the minimum size in bytes of responses to be compressed.
+// Smaller responses are buffered and sent uncompressed.
+func WithMinSize(size int) Option {
+	return func(f *gzipFilter) {
+		f.minSize = size
+	}
+}
+
+// WithEncodings sets the supported encodings in the order of preference when
+// clients accept them equally, gzip by default. Encodings which are not
+// registered are ignored.
+func WithEncodings(names ...string) Option {
+	return func(f *gzipFilter) {
+		f.encodings = names
+	}
+}
+
+// WithLevel sets the compression level of the encoding.
+func WithLevel(name string, level int) Option {
+	return func(f *gzipFilter) {
+		if f.levels == nil {
+			f.levels = make(map[string]int)
+		}
+		f.levels[name] = level
+	}
+}
 
-// NewFilter allocates and returns a new Filter which compresses HTTP responses using gzip.
-func NewFilter() filter.Filter {
-	return &gzipFilter{}
+// NewFilter allocates and returns a new Filter which compresses HTTP responses
+// using the encoding preferred by the client.
+// Responses which are already compressed (e.g. application/zip) are not compressed.
+func NewFilter(options ...Option) filter.Filter {
+	f := &gzipFilter{
+		encodings: defaultEncodings,
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	names := make([]string, 0, len(f.encodings))
+	for _, name := range f.encodings {
+		name = strings.ToLower(name)
+		if _, ok := encoders[name]; !ok {
+			logger().Warnf("unsupported encoding %s, registered: %v", name, sortedEncodings())
+			continue
+		}
+		pool, err := newEncoderPool(name, f.levels[name])
+		if err != nil {
+			logger().Warnf("invalid level of encoding %s, using default: %v", name, err)
+			pool, _ = newEncoderPool(name, 0)
+		}
+		names = append(names, name)
+		f.pools = append(f.pools, pool)
+	}
+	f.encodings = names
+	return f
 }
 
 func (f *gzipFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ae := r.Header.Get("Accept-Encoding")
-	if ae != "" && strings.Contains(ae, "gzip") {
-		gzWriter := &responseWriter{
+	if i := negotiate(r.Header.Get("Accept-Encoding"), f.encodings); i >= 0 {
+		encWriter := &responseWriter{
 			ResponseWriter: w,
-			gz:             gzip.NewWriter(w),
+			pool:           f.pools[i],
+			minSize:        f.minSize,
 		}
-		defer gzWriter.gz.Close()
-		w = gzWriter
+		defer encWriter.close()
+		w = encWriter
 	}
 	filter.Continue(w, r)
 }
 
+// responseWriter only creates encoder when the header is written so that
+// handlers can opt out of compression by setting response header
+// "X-Accel-Buffering: no" or their own "Content-Encoding".
+// When minSize is set and the response size is unknown, the decision is
+// deferred until minSize bytes have been written.
 type responseWriter struct {
 	http.ResponseWriter
 
-	gz *gzip.Writer
+	pool    *encoderPool
+	enc     Encoder
+	minSize int
 
 	headerWritten bool
+	// pending is true when the header is held until the response size is known.
+	pending bool
+	status  int
+	buf     []byte
 }
 
 func (w *responseWriter) Write(p []byte) (int, error) {
@@ -49,26 +131,130 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 	if !w.headerWritten {
 		w.WriteHeader(http.StatusOK)
 	}
-	return w.gz.Write(p)
+	if w.pending {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		w.pending = false
+		w.startEncoding(w.status)
+		buf := w.buf
+		w.buf = nil
+		if _, err := w.enc.Write(buf); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.enc.Write(p)
 }
 
 func (w *responseWriter) WriteHeader(status int) {
-	w.Header().Set("Content-Encoding", "gzip")
-	w.Header().Add("Vary", "Accept-Encoding")
-	// FIXME: Correct content length for small response.
-	w.Header().Del("Content-Length")
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	if !w.shouldCompress() {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.minSize > 0 {
+		size, err := strconv.Atoi(w.Header().Get("Content-Length"))
+		if err != nil {
+			w.pending = true
+			w.status = status
+			return
+		}
+		if size < w.minSize {
+			w.ResponseWriter.WriteHeader(status)
+			return
+		}
+	}
+	w.startEncoding(status)
+}
 
+// shouldCompress returns false if the handler has opted out or the content
+// is already compressed.
+func (w *responseWriter) shouldCompress() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("X-Accel-Buffering") == "no" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	_, ok := compressedContentTypes[mediaType]
+	return !ok
+}
+
+// startEncoding writes the header for a compressed response. Strong ETag
+// is weakened as the compressed representation is not byte-identical.
+func (w *responseWriter) startEncoding(status int) {
+	header := w.Header()
+	header.Set("Content-Encoding", w.pool.name)
+	if !hasToken(header["Vary"], "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	w.enc = w.pool.get(w.ResponseWriter)
 	w.ResponseWriter.WriteHeader(status)
-	w.headerWritten = true
+}
+
+// hasToken reports whether the comma-separated header values contain token.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.EqualFold(t, token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writePending sends the buffered response uncompressed.
+func (w *responseWriter) writePending() {
+	w.pending = false
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// close flushes remaining compressed data.
+func (w *responseWriter) close() {
+	if w.pending {
+		w.writePending()
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			logger().Warnf("%s response writer close: %v", w.pool.name, err)
+		}
+		w.pool.put(w.enc)
+		w.enc = nil
+	}
 }
 
 // Flush implements http.Flusher.
+// A response which is still below the minimum size is sent uncompressed.
 func (w *responseWriter) Flush() {
-	err := w.gz.Flush()
-	if err != nil {
-		core.GetLogger("melon/server").Warnf("gzip response writer flush: %v", err)
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending {
+		w.writePending()
+	}
+	if w.enc != nil {
+		err := w.enc.Flush()
+		if err != nil {
+			logger().Warnf("%s response writer flush: %v", w.pool.name, err)
+		}
 	}
-
 	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
 		fl.Flush()
 	}
@@ -89,3 +275,7 @@ func (w *responseWriter) CloseNotify() <-chan bool {
 	}
 	panic("not a CloseNotifier")
 }
+
+func logger() core.Logger {
+	return core.GetLogger("melon/server")
+}
@@ -1,6 +1,7 @@
 package gzip
 
 import (
+	"bytes"
 	"compress/gzip"
 	"io/ioutil"
 	"net/http"
@@ -94,3 +95,88 @@ func TestGZipResponse(t *testing.T) {
 		t.Fatalf("unexpected body: %v", body)
 	}
 }
+
+func TestGZipOptOut(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	optOut := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Accel-Buffering", "no")
+		handler(w, r)
+	}
+	chain := filter.NewChain()
+	chain.Add(NewFilter(), http.HandlerFunc(optOut))
+	chain.ServeHTTP(w, r)
+	if "" != w.HeaderMap.Get("Content-Encoding") {
+		t.Fatalf("unexpected content encoding: %v", w.HeaderMap)
+	}
+	if "ok" != w.Body.String() {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
+
+func TestGZipCompressedContent(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	zipped := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		handler(w, r)
+	}
+	chain := filter.NewChain()
+	chain.Add(NewFilter(), http.HandlerFunc(zipped))
+	chain.ServeHTTP(w, r)
+	if "" != w.HeaderMap.Get("Content-Encoding") {
+		t.Fatalf("unexpected content encoding: %v", w.HeaderMap)
+	}
+	if "ok" != w.Body.String() {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
+
+func TestGZipMinSize(t *testing.T) {
+	large := bytes.Repeat([]byte("melon "), 100)
+	tests := []struct {
+		body     [][]byte
+		encoding string
+	}{
+		{[][]byte{[]byte("ok")}, ""},
+		{[][]byte{large[:50], large[50:]}, "gzip"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		h := func(w http.ResponseWriter, r *http.Request) {
+			for _, b := range test.body {
+				w.Write(b)
+			}
+		}
+		chain := filter.NewChain()
+		chain.Add(NewFilter(WithMinSize(100)), http.HandlerFunc(h))
+		chain.ServeHTTP(w, r)
+		if 200 != w.Code {
+			t.Fatalf("unexpected status code: %v", w.Code)
+		}
+		if test.encoding != w.HeaderMap.Get("Content-Encoding") {
+			t.Fatalf("unexpected content encoding: %v", w.HeaderMap)
+		}
+		body := w.Body.Bytes()
+		if test.encoding == "gzip" {
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err = ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(bytes.Join(test.body, nil), body) {
+			t.Fatalf("unexpected body: %s", body)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package zstd adds zstd encoding to the compression filter using
+// github.com/klauspost/compress/zstd. Importing this package registers the
+// encoding, which then can be set in Encodings of the gzip filter:
+//
+//	import _ "github.com/goburrow/melon/server/gzip/zstd"
+package zstd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/goburrow/melon/server/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression levels of zstd.
+const (
+	minLevel = 1
+	maxLevel = 22
+)
+
+func init() {
+	gzip.RegisterEncoding(gzip.EncodingZstd, newEncoder)
+}
+
+// newEncoder creates a zstd encoder. Accepted levels are from 1 to 22 as in
+// zstd, which are mapped to the closest levels supported by the library.
+// Level 0 is the default level of the library.
+// Encoders are pooled by the filter, so they are created with concurrency 1
+// to compress responses synchronously in the request goroutine.
+func newEncoder(w io.Writer, level int) (gzip.Encoder, error) {
+	options := []zstd.EOption{
+		zstd.WithEncoderConcurrency(1),
+	}
+	if level != 0 {
+		if level < minLevel || level > maxLevel {
+			return nil, fmt.Errorf("zstd: invalid compression level: %d", level)
+		}
+		options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, options...)
+}
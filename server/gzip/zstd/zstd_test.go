@@ -0,0 +1,58 @@
+package zstd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstd(t *testing.T) {
+	chain := filter.NewChain()
+	chain.Add(gzip.NewFilter(gzip.WithEncodings(gzip.EncodingZstd, gzip.EncodingGzip)),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	// Encoders are reused by following requests.
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip, zstd")
+		chain.ServeHTTP(w, r)
+		if "zstd" != w.Header().Get("Content-Encoding") {
+			t.Fatalf("unexpected content encoding: %v", w.Header())
+		}
+		if "Accept-Encoding" != w.Header().Get("Vary") {
+			t.Fatalf("unexpected vary: %v", w.Header())
+		}
+		reader, err := zstd.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "ok" != string(body) {
+			t.Fatalf("unexpected body: %s", body)
+		}
+	}
+}
+
+func TestNewEncoderLevel(t *testing.T) {
+	for _, level := range []int{0, 1, 22} {
+		if _, err := newEncoder(ioutil.Discard, level); err != nil {
+			t.Fatalf("unexpected error for level %d: %v", level, err)
+		}
+	}
+	for _, level := range []int{-1, 23} {
+		if _, err := newEncoder(ioutil.Discard, level); err == nil {
+			t.Fatalf("error expected for level %d", level)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package header provides a filter enforcing response header policy.
+package header
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+// Rule is a set of header changes applied to responses of requests whose
+// paths start with PathPrefix. Changes are applied in the order of Remove,
+// Set and Default.
+type Rule struct {
+	// PathPrefix limits the rule to some paths. Empty prefix matches all.
+	PathPrefix string
+	// Remove deletes headers from responses.
+	Remove []string
+	// Set overrides header values set by handlers.
+	Set map[string]string
+	// Default only sets headers which are not set by handlers.
+	Default map[string]string
+}
+
+// policyFilter applies rules to response headers right before they are sent.
+type policyFilter struct {
+	rules []Rule
+}
+
+// Option is an option for the header policy Filter.
+type Option func(f *policyFilter)
+
+// WithRule adds a rule to the policy. Rules are applied in the order they
+// are added, so later rules take precedence.
+func WithRule(rule Rule) Option {
+	return func(f *policyFilter) {
+		f.rules = append(f.rules, rule)
+	}
+}
+
+// NewFilter allocates and returns a new Filter which enforces response header
+// policy. The policy is applied when the header is written, thus it also
+// governs headers set by handlers and inner filters.
+func NewFilter(options ...Option) filter.Filter {
+	f := &policyFilter{}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
+}
+
+func (f *policyFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rules []Rule
+	for i := range f.rules {
+		if strings.HasPrefix(r.URL.Path, f.rules[i].PathPrefix) {
+			rules = append(rules, f.rules[i])
+		}
+	}
+	if len(rules) == 0 {
+		filter.Continue(w, r)
+		return
+	}
+	rw := &responseWriter{
+		ResponseWriter: w,
+		rules:          rules,
+	}
+	filter.Continue(rw, r)
+	// Handler did not write anything, header is sent by the server.
+	if !rw.headerWritten {
+		rw.apply(http.StatusOK)
+	}
+}
+
+// responseWriter applies rules when the header is written.
+type responseWriter struct {
+	http.ResponseWriter
+	rules []Rule
+
+	headerWritten bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.headerWritten {
+		w.apply(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// apply changes headers according to the rules. Representation headers are
+// not added to responses without body, i.e. 1xx, 204 and 304.
+func (w *responseWriter) apply(status int) {
+	w.headerWritten = true
+	noBody := status < 200 || status == http.StatusNoContent || status == http.StatusNotModified
+	header := w.Header()
+	for i := range w.rules {
+		rule := &w.rules[i]
+		for _, k := range rule.Remove {
+			header.Del(k)
+		}
+		for k, v := range rule.Set {
+			if !noBody || !isRepresentationHeader(k) {
+				header.Set(k, v)
+			}
+		}
+		for k, v := range rule.Default {
+			if header.Get(k) == "" && (!noBody || !isRepresentationHeader(k)) {
+				header.Set(k, v)
+			}
+		}
+	}
+}
+
+func isRepresentationHeader(k string) bool {
+	switch http.CanonicalHeaderKey(k) {
+	case "Content-Type", "Content-Length", "Content-Encoding", "Content-Language":
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("not a Hijacker")
+}
@@ -0,0 +1,90 @@
+package header
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+func newTestChain(handler http.HandlerFunc) *filter.Chain {
+	chain := filter.NewChain()
+	chain.Add(NewFilter(
+		WithRule(Rule{
+			Remove:  []string{"Server", "X-Powered-By"},
+			Default: map[string]string{"X-Request-Id": "default", "Content-Type": "text/plain"},
+		}),
+		WithRule(Rule{
+			PathPrefix: "/api/",
+			Set:        map[string]string{"Cache-Control": "no-store"},
+		}),
+	), handler)
+	return chain
+}
+
+func TestPolicy(t *testing.T) {
+	chain := newTestChain(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+		// Too late to be sent
+		w.Header().Set("X-Powered-By", "Go")
+	})
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("GET", "/api/users", nil))
+	header := w.Result().Header
+	if header.Get("Server") != "" || header.Get("X-Request-Id") != "default" ||
+		header.Get("Cache-Control") != "no-store" || header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	// Cache-Control is only set for /api/
+	w = httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	header = w.Result().Header
+	if header.Get("Server") != "" || header.Get("Cache-Control") != "max-age=60" {
+		t.Fatalf("unexpected header: %v", header)
+	}
+}
+
+func TestPolicyHeaderSetBeforeWriteHeader(t *testing.T) {
+	chain := newTestChain(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		w.Header().Set("X-Powered-By", "Go")
+		w.WriteHeader(http.StatusCreated)
+	})
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("POST", "/api/users", nil))
+	header := w.Result().Header
+	if w.Code != http.StatusCreated || header.Get("X-Powered-By") != "" || header.Get("X-Request-Id") != "abc" {
+		t.Fatalf("unexpected response: %d %v", w.Code, header)
+	}
+}
+
+func TestPolicyNoBody(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		chain := newTestChain(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "nginx")
+			w.WriteHeader(status)
+		})
+		w := httptest.NewRecorder()
+		chain.ServeHTTP(w, httptest.NewRequest("GET", "/api/users", nil))
+		header := w.Result().Header
+		if w.Code != status || header.Get("Server") != "" || header.Get("Content-Type") != "" ||
+			header.Get("X-Request-Id") != "default" || header.Get("Cache-Control") != "no-store" {
+			t.Fatalf("unexpected response: %d %v", w.Code, header)
+		}
+	}
+}
+
+func TestPolicyEmptyResponse(t *testing.T) {
+	chain := newTestChain(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+	})
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	header := w.Result().Header
+	if header.Get("Server") != "" || header.Get("X-Request-Id") != "default" {
+		t.Fatalf("unexpected header: %v", header)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server/router"
+)
+
+const defaultHealthEndpointMaxAge = 5 * time.Second
+
+// HealthEndpointConfiguration exposes the aggregate health of the application
+// on the application connectors for platforms which can only probe the
+// application port. Only the status code is responded: 200 when all health
+// checks are healthy, 503 otherwise. Admin /healthcheck remains the detailed
+// source of health. It is disabled when Path is empty.
+type HealthEndpointConfiguration struct {
+	Path string
+	// MaxAge is how long results of health checks, e.g. run by admin
+	// /healthcheck, are reused. Default is 5s.
+	MaxAge string
+	// AllowedNetworks are CIDRs of clients allowed to access the endpoint.
+	// All clients are allowed when it is empty.
+	AllowedNetworks []string
+}
+
+// Build registers the health endpoint to the application router.
+func (f *HealthEndpointConfiguration) Build(env *core.Environment, handler *router.Router) error {
+	return f.build(env, handler, nil)
+}
+
+// build registers the health endpoint which also exports failures of the
+// admin self check if it is not nil.
+func (f *HealthEndpointConfiguration) build(env *core.Environment, handler *router.Router, selfCheck *adminSelfCheck) error {
+	if f.Path == "" {
+		return nil
+	}
+	h := &healthEndpoint{
+		registry:  env.Admin.HealthChecks,
+		maxAge:    defaultHealthEndpointMaxAge,
+		selfCheck: selfCheck,
+	}
+	if f.MaxAge != "" {
+		d, err := time.ParseDuration(f.MaxAge)
+		if err != nil {
+			return fmt.Errorf("server: invalid health endpoint max age %s: %v", f.MaxAge, err)
+		}
+		h.maxAge = d
+	}
+	for _, cidr := range f.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("server: invalid health endpoint network %s: %v", cidr, err)
+		}
+		h.networks = append(h.networks, network)
+	}
+	handler.Handle("GET", f.Path, h)
+	handler.Handle("HEAD", f.Path, h)
+	return nil
+}
+
+// healthEndpoint responds aggregate status of health checks without names or
+// messages. Consecutive failures of the admin self check are exported in
+// header X-Admin-Self-Check-Failures.
+type healthEndpoint struct {
+	registry health.Registry
+	maxAge   time.Duration
+	networks []*net.IPNet
+	// selfCheck is set when admin self check is enabled.
+	selfCheck *adminSelfCheck
+
+	// mu serializes running health checks when results are stale.
+	mu sync.Mutex
+}
+
+func (h *healthEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	if !h.allowed(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if h.selfCheck != nil {
+		w.Header().Set(adminSelfCheckFailuresHeader, strconv.FormatInt(h.selfCheck.Failures(), 10))
+	}
+	status := http.StatusOK
+	if !h.healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (h *healthEndpoint) allowed(r *http.Request) bool {
+	if len(h.networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthy returns the recorded results of health checks if they are not older
+// than maxAge, or runs health checks otherwise.
+func (h *healthEndpoint) healthy() bool {
+	registry, ok := h.registry.(health.HistoryRegistry)
+	if !ok {
+		return allHealthy(h.registry.RunCheckers())
+	}
+	if healthy, ok := h.recent(registry); ok {
+		return healthy
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Checks may have been run while waiting.
+	if healthy, ok := h.recent(registry); ok {
+		return healthy
+	}
+	return allHealthy(registry.RunCheckers())
+}
+
+func (h *healthEndpoint) recent(registry health.HistoryRegistry) (bool, bool) {
+	healthy, at := registry.History().LastRun()
+	// History is recorded in wall clock.
+	if at.IsZero() || time.Since(at) > h.maxAge {
+		return false, false
+	}
+	return healthy, true
+}
+
+func allHealthy(results map[string]health.Result) bool {
+	for _, result := range results {
+		if !result.Healthy() && health.Critical(result) {
+			return false
+		}
+	}
+	return true
+}
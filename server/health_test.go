@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server/router"
+)
+
+func TestHealthEndpoint(t *testing.T) {
+	env := core.NewEnvironment()
+	healthy := true
+	runs := 0
+	env.Admin.HealthChecks.Register("database", health.CheckerFunc(func() health.Result {
+		runs++
+		if healthy {
+			return health.Healthy
+		}
+		return health.ResultUnhealthy("secret host is down", errors.New("password rejected"))
+	}))
+	config := HealthEndpointConfiguration{Path: "/health", MaxAge: "1h"}
+	handler := router.New()
+	if err := config.Build(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	request := func(status int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		body := w.Body.String()
+		if w.Code != status || body != http.StatusText(status)+"\n" {
+			t.Fatalf("unexpected response: %d %s", w.Code, body)
+		}
+	}
+	request(http.StatusOK)
+	request(http.StatusOK)
+	if runs != 1 {
+		t.Fatalf("unexpected health check runs: %d", runs)
+	}
+	// Results of admin health checks are reused.
+	healthy = false
+	env.Admin.HealthChecks.RunCheckers()
+	request(http.StatusServiceUnavailable)
+	if runs != 2 {
+		t.Fatalf("unexpected health check runs: %d", runs)
+	}
+	env.Lifecycle.Drain()
+	healthy = true
+	env.Admin.HealthChecks.RunCheckers()
+	request(http.StatusServiceUnavailable)
+}
+
+func TestHealthEndpointAllowedNetworks(t *testing.T) {
+	config := HealthEndpointConfiguration{
+		Path:            "/health",
+		AllowedNetworks: []string{"10.0.0.0/8", "::1/128"},
+	}
+	handler := router.New()
+	if err := config.Build(core.NewEnvironment(), handler); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"[::1]:1234", http.StatusOK},
+		{"192.0.2.1:1234", http.StatusForbidden},
+		{"invalid", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/health", nil)
+		r.RemoteAddr = test.remoteAddr
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("unexpected status of %s: %d", test.remoteAddr, w.Code)
+		}
+	}
+}
+
+func TestInvalidHealthEndpoint(t *testing.T) {
+	configs := []HealthEndpointConfiguration{
+		{Path: "/health", MaxAge: "1"},
+		{Path: "/health", AllowedNetworks: []string{"10.0.0.1"}},
+	}
+	for _, c := range configs {
+		err := c.Build(core.NewEnvironment(), router.New())
+		if err == nil || !strings.HasPrefix(err.Error(), "server: invalid health endpoint") {
+			t.Fatalf("unexpected error for %+v: %v", c, err)
+		}
+	}
+}
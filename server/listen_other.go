@@ -0,0 +1,14 @@
+//go:build windows || plan9 || js || wasip1
+
+package server
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix listens on socket path. Mode is applied after listening on these
+// platforms.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes changes of the process umask.
+var umaskMu sync.Mutex
+
+// listenUnix listens on socket path. When mode is not zero, the umask is
+// tightened while listening so the socket file is created with mode and never
+// accessible to others.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		return net.Listen("unix", path)
+	}
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(int(^mode & 0777))
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixMode(t *testing.T) {
+	path := filepath.Join(tempDir(t), "melon.sock")
+	l, err := listenUnix(path, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stat, err := os.Stat(path)
+	if err != nil || stat.Mode().Perm() != 0640 {
+		t.Fatalf("unexpected socket file: %v %v", stat, err)
+	}
+}
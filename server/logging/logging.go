@@ -5,35 +5,95 @@ package logging
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/requestid"
 )
 
 const (
 	timeFormat = "02/Jan/2006:15:04:05 -0700"
 
-	xRequestID    = "X-Request-Id"
 	xForwardedFor = "X-Forwarded-For"
 )
 
 // For testing
 var now = time.Now
 
-// logFilter is a middleware which logs all requests in Common Log Format.
+// Entry is a logged request, which is given to templates of WithTemplate.
+type Entry struct {
+	// RemoteAddr is the client address or X-Forwarded-For header.
+	RemoteAddr string
+	// Time is when the request started.
+	Time   time.Time
+	Method string
+	// URI is the request URI sent by the client.
+	URI       string
+	Proto     string
+	Status    int
+	Size      uint64
+	Referer   string
+	UserAgent string
+	Latency   time.Duration
+	RequestID string
+}
+
+// Option is an option of the request log Filter.
+type Option func(f *logFilter)
+
+// WithCommonFormat logs requests in Common Log Format.
+func WithCommonFormat() Option {
+	return func(f *logFilter) {
+		f.format = formatCommon
+	}
+}
+
+// WithCombinedFormat logs requests in Combined Log Format, which is Common
+// Log Format with referer and user agent.
+func WithCombinedFormat() Option {
+	return func(f *logFilter) {
+		f.format = formatCombined
+	}
+}
+
+// WithTemplate logs requests with the template executed with *Entry. Each
+// entry is followed by a new line.
+func WithTemplate(t *template.Template) Option {
+	return func(f *logFilter) {
+		f.format = func(w *bytes.Buffer, e *Entry) {
+			if err := t.Execute(w, e); err != nil {
+				fmt.Fprintf(w, "request log template error: %v", err)
+			}
+		}
+	}
+}
+
+// logFilter is a middleware which logs all requests.
 type logFilter struct {
 	writer io.Writer
+	format func(w *bytes.Buffer, e *Entry)
 }
 
-// NewFilter returns a new Filter logging all HTTP requests in Common Log Format to given writer.
-func NewFilter(writer io.Writer) filter.Filter {
-	return &logFilter{writer: writer}
+// NewFilter returns a new Filter logging all HTTP requests to given writer.
+// By default, it uses Combined Log Format followed by the latency in
+// milliseconds and the request ID.
+func NewFilter(writer io.Writer, options ...Option) filter.Filter {
+	f := &logFilter{
+		writer: writer,
+		format: formatDefault,
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
 }
 
 func (f *logFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -43,37 +103,56 @@ func (f *logFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filter.Continue(responseWriter, r)
 	end := now()
 
-	remoteAddr := getRemoteAddr(r)
-	referer := r.Referer()
-	if referer == "" {
-		referer = "-"
-	}
-	userAgent := r.UserAgent()
-	if userAgent == "" {
-		userAgent = "-"
+	e := Entry{
+		RemoteAddr: getRemoteAddr(r),
+		Time:       start,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Status:     responseWriter.status,
+		Size:       responseWriter.size,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		Latency:    end.Sub(start),
+		RequestID:  requestid.Get(r),
 	}
-	startTime := start.Format(timeFormat)
-	responseTime := end.Sub(start).Nanoseconds() / int64(time.Millisecond)
-	requestID := r.Header.Get(xRequestID)
+	var buf bytes.Buffer
+	f.format(&buf, &e)
+	buf.WriteByte('\n')
+	f.writer.Write(buf.Bytes())
+}
 
-	// Common log format
-	fmt.Fprintf(f.writer, "%s %s %s [%s] \"%s %s %s\" %d %d %q %q %d %q\n",
-		remoteAddr,
+func formatCommon(w *bytes.Buffer, e *Entry) {
+	fmt.Fprintf(w, "%s %s %s [%s] \"%s %s %s\" %d %d",
+		e.RemoteAddr,
 		"-", // Identity is not supported.
 		"-", // UserID is not supported.
-		startTime,
-		r.Method,
-		r.RequestURI,
-		r.Proto,
-		responseWriter.status,
-		responseWriter.size,
-		referer,
-		userAgent,
-		responseTime,
-		requestID,
+		e.Time.Format(timeFormat),
+		e.Method,
+		e.URI,
+		e.Proto,
+		e.Status,
+		e.Size,
 	)
 }
 
+func formatCombined(w *bytes.Buffer, e *Entry) {
+	formatCommon(w, e)
+	fmt.Fprintf(w, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+}
+
+func formatDefault(w *bytes.Buffer, e *Entry) {
+	formatCombined(w, e)
+	fmt.Fprintf(w, " %d %q", e.Latency.Nanoseconds()/int64(time.Millisecond), e.RequestID)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func getRemoteAddr(r *http.Request) string {
 	if s := r.Header.Get(xForwardedFor); s != "" {
 		return s
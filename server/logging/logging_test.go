@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/requestid"
 )
 
 var today = time.Date(2015, time.January, 14, 1, 2, 3, 789000000, time.FixedZone("Asia/Ho_Chi_Minh", 7*60*60))
@@ -97,3 +99,42 @@ func TestResponseError(t *testing.T) {
 		t.Fatalf("unexpected access log %v", buf.String())
 	}
 }
+
+func TestFormats(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(`{{.Method}} {{.URI}} {{.Status}} {{.Size}} {{.RequestID}}`))
+	tests := []struct {
+		option   Option
+		expected string
+	}{
+		{WithCommonFormat(), `4.3.2.1 - - [14/Jan/2015:01:02:03 +0700] "GET /a?b=1 HTTP/1.1" 200 0`},
+		{WithCombinedFormat(), `4.3.2.1 - - [14/Jan/2015:01:02:03 +0700] "GET /a?b=1 HTTP/1.1" 200 0 "-" "melon/1.0"`},
+		{WithTemplate(tmpl), `GET /a?b=1 200 0 go123`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		chain := filter.NewChain()
+		// Handler never writes header nor body.
+		chain.Add(NewFilter(&buf, test.option), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/a?b=1", nil)
+		r.Header.Set("User-Agent", "melon/1.0")
+		r.Header.Set("X-Request-Id", "go123")
+		r.Header.Set("X-Forwarded-For", "4.3.2.1")
+		chain.ServeHTTP(httptest.NewRecorder(), r)
+		if buf.String() != test.expected+"\n" {
+			t.Fatalf("unexpected access log %v", buf.String())
+		}
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("test").Parse(`{{.RequestID}}`))
+	chain := filter.NewChain()
+	chain.Add(NewFilter(&buf, WithTemplate(tmpl)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Correlation-Id", "go456")
+	chain.ServeHTTP(httptest.NewRecorder(), r.WithContext(requestid.NewContext(r.Context(), "go456")))
+	if buf.String() != "go456\n" {
+		t.Fatalf("unexpected access log %v", buf.String())
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Package quota provides a filter limiting usage of API keys in calendar
+windows, e.g. 10000 requests per day.
+*/
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	defaultKeyHeader = "X-Api-Key"
+
+	xQuotaLimit     = "X-Quota-Limit"
+	xQuotaRemaining = "X-Quota-Remaining"
+	xQuotaReset     = "X-Quota-Reset"
+)
+
+// Quota counts requests of each key in a Store and rejects requests of keys
+// which have used up their limit in the current window.
+type Quota struct {
+	store   Store
+	limit   int64
+	window  Window
+	clock   core.Clock
+	keyFunc func(*http.Request) string
+}
+
+// Option configures Quota.
+type Option func(*Quota)
+
+// WithClock sets the clock of windows, SystemClock by default.
+func WithClock(clock core.Clock) Option {
+	return func(q *Quota) {
+		q.clock = clock
+	}
+}
+
+// WithKeyHeader extracts keys from the request header, X-Api-Key by default.
+func WithKeyHeader(name string) Option {
+	return func(q *Quota) {
+		q.keyFunc = func(r *http.Request) string {
+			return r.Header.Get(name)
+		}
+	}
+}
+
+// WithKeyFunc extracts keys with f. Requests with empty keys are not counted.
+func WithKeyFunc(f func(*http.Request) string) Option {
+	return func(q *Quota) {
+		q.keyFunc = f
+	}
+}
+
+// New creates a Quota allowing limit requests per key in each window.
+func New(store Store, limit int64, window Window, options ...Option) *Quota {
+	q := &Quota{
+		store:  store,
+		limit:  limit,
+		window: window,
+		clock:  core.SystemClock,
+	}
+	WithKeyHeader(defaultKeyHeader)(q)
+	for _, opt := range options {
+		opt(q)
+	}
+	return q
+}
+
+// Usage returns the number of requests of key in the current window and
+// when the window ends.
+func (q *Quota) Usage(key string) (int64, time.Time, error) {
+	now := q.clock.Now()
+	used, err := q.store.Get(key, q.window.Start(now))
+	return used, q.window.End(now), err
+}
+
+// Reset clears usage of key.
+func (q *Quota) Reset(key string) error {
+	return q.store.Reset(key)
+}
+
+// Filter returns the filter counting requests. Responses include headers
+// X-Quota-Limit, X-Quota-Remaining and X-Quota-Reset, which is the Unix time
+// the window ends. Requests are rejected with status 429 and a
+// problem+json body when the quota is exhausted, and are allowed when the
+// store fails.
+func (q *Quota) Filter() filter.Filter {
+	return http.HandlerFunc(q.serveHTTP)
+}
+
+func (q *Quota) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	key := q.keyFunc(r)
+	if key == "" {
+		filter.Continue(w, r)
+		return
+	}
+	now := q.clock.Now()
+	end := q.window.End(now)
+	used, err := q.store.Add(key, q.window.Start(now), 1)
+	if err != nil {
+		logger().Warnf("could not count usage: %v", err)
+		filter.Continue(w, r)
+		return
+	}
+	remaining := q.limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set(xQuotaLimit, strconv.FormatInt(q.limit, 10))
+	w.Header().Set(xQuotaRemaining, strconv.FormatInt(remaining, 10))
+	w.Header().Set(xQuotaReset, strconv.FormatInt(end.Unix(), 10))
+	if used <= q.limit {
+		filter.Continue(w, r)
+		return
+	}
+	retryAfter := int64(end.Sub(now)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	filter.WriteProblem(w, http.StatusTooManyRequests,
+		fmt.Sprintf("Quota of %d requests per %s is exhausted.", q.limit, q.window))
+}
+
+// task inspects and resets usage of keys.
+type task struct {
+	name  string
+	quota *Quota
+}
+
+// NewTask returns an admin task which shows usage of the key given in query
+// key, and resets it when query reset is true.
+func NewTask(name string, q *Quota) core.Task {
+	return &task{name: name, quota: q}
+}
+
+func (t *task) Name() string {
+	return t.name
+}
+
+func (t *task) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if key == "" {
+		http.Error(w, "Query key is required.", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("reset") == "true" {
+		if err := t.quota.Reset(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger().Infof("usage of quota %s is reset by %s", t.name, r.RemoteAddr)
+	}
+	used, end, err := t.quota.Usage(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Used: %d\nLimit: %d\nReset: %s\n", used, t.quota.limit, end.Format(time.RFC3339))
+}
+
+func logger() core.Logger {
+	return core.GetLogger("melon/quota")
+}
@@ -0,0 +1,184 @@
+package quota
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+func newTestQuota(t *testing.T, limit int64, unit string, clock *melontest.FakeClock) (*Quota, http.Handler) {
+	window, err := NewWindow(unit, "Asia/Ho_Chi_Minh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := New(NewMemoryStore(0), limit, window, WithClock(clock))
+	chain := filter.NewChain()
+	chain.Add(q.Filter(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	return q, chain
+}
+
+func request(handler http.Handler, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if key != "" {
+		r.Header.Set("X-Api-Key", key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestQuota(t *testing.T) {
+	// 23:00 in UTC+7
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 31, 16, 0, 0, 0, time.UTC))
+	_, handler := newTestQuota(t, 2, Day, clock)
+	reset := strconv.FormatInt(time.Date(2024, 1, 31, 17, 0, 0, 0, time.UTC).Unix(), 10)
+	for i, remaining := range []string{"1", "0"} {
+		w := request(handler, "a")
+		if w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "2" ||
+			w.Header().Get("X-Quota-Remaining") != remaining || w.Header().Get("X-Quota-Reset") != reset {
+			t.Fatalf("unexpected response %d: %d %v", i, w.Code, w.Header())
+		}
+	}
+	w := request(handler, "a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-Quota-Remaining") != "0" ||
+		w.Header().Get("Retry-After") != "3601" || w.Header().Get("Content-Type") != "application/problem+json" ||
+		!strings.Contains(w.Body.String(), `"detail":"Quota of 2 requests per day is exhausted."`) {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	// Other keys and requests without key are not limited.
+	if w = request(handler, "b"); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "1" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	if w = request(handler, ""); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	// Next day in UTC+7
+	clock.Add(time.Hour)
+	w = request(handler, "a")
+	reset = strconv.FormatInt(time.Date(2024, 2, 1, 17, 0, 0, 0, time.UTC).Unix(), 10)
+	if w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "1" || w.Header().Get("X-Quota-Reset") != reset {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestWindow(t *testing.T) {
+	location, _ := time.LoadLocation("Asia/Ho_Chi_Minh")
+	at := time.Date(2024, 2, 29, 10, 30, 0, 0, location)
+	tests := []struct {
+		unit       string
+		start, end time.Time
+	}{
+		{Hour, time.Date(2024, 2, 29, 10, 0, 0, 0, location), time.Date(2024, 2, 29, 11, 0, 0, 0, location)},
+		{Day, time.Date(2024, 2, 29, 0, 0, 0, 0, location), time.Date(2024, 3, 1, 0, 0, 0, 0, location)},
+		{Month, time.Date(2024, 2, 1, 0, 0, 0, 0, location), time.Date(2024, 3, 1, 0, 0, 0, 0, location)},
+	}
+	for _, test := range tests {
+		w, err := NewWindow(test.unit, "Asia/Ho_Chi_Minh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !w.Start(at).Equal(test.start) || !w.End(at).Equal(test.end) {
+			t.Fatalf("unexpected window %s: %v %v", test.unit, w.Start(at), w.End(at))
+		}
+	}
+	if _, err := NewWindow("week", ""); err == nil {
+		t.Fatalf("error expected for unsupported window")
+	}
+	if _, err := NewWindow(Day, "Mars/Olympus"); err == nil {
+		t.Fatalf("error expected for invalid time zone")
+	}
+}
+
+func TestTask(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q, handler := newTestQuota(t, 1, Month, clock)
+	request(handler, "a")
+	if w := request(handler, "a"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	task := NewTask("quota", q)
+	w := httptest.NewRecorder()
+	task.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/quota?key=a", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "Used: 2\nLimit: 1\nReset: 2024-02-01T00:00:00+07:00\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	task.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/quota?key=a&reset=true", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "Used: 0\n") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if w = request(handler, "a"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	task.ServeHTTP(w, httptest.NewRequest("POST", "/tasks/quota", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota.json")
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(0)
+	snapshot := NewSnapshot(store, file, time.Minute, clock)
+	if err := snapshot.Start(); err != nil {
+		t.Fatal(err)
+	}
+	store.Add("a", start, 5)
+	if err := snapshot.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemoryStore(0)
+	snapshot = NewSnapshot(restored, file, time.Minute, clock)
+	if err := snapshot.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Stop()
+	if n, _ := restored.Get("a", start); n != 5 {
+		t.Fatalf("unexpected usage: %d", n)
+	}
+	if n, _ := restored.Get("a", start.AddDate(0, 0, 1)); n != 0 {
+		t.Fatalf("unexpected usage: %d", n)
+	}
+}
+
+func TestMemoryStoreMaxKeys(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(2)
+	store.Add("a", start, 1)
+	store.Add("b", start, 1)
+	if _, err := store.Add("c", start, 1); err == nil {
+		t.Fatal("error expected when store is full")
+	}
+	if n, err := store.Add("a", start, 1); err != nil || n != 2 {
+		t.Fatalf("unexpected usage: %d %v", n, err)
+	}
+	// Usage of the previous window is pruned.
+	next := start.AddDate(0, 0, 1)
+	if n, err := store.Add("c", next, 1); err != nil || n != 1 {
+		t.Fatalf("unexpected usage: %d %v", n, err)
+	}
+	if len(store.usage) != 1 {
+		t.Fatalf("unexpected usage: %v", store.usage)
+	}
+	store.Add("d", next, 1)
+	var buf bytes.Buffer
+	if err := store.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `"a"`) || !strings.Contains(buf.String(), `"d"`) {
+		t.Fatalf("unexpected snapshot: %s", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+package quota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+// Store keeps usage of keys in their current windows. Implementations must
+// be concurrent-safe, e.g. a shared Redis for multiple instances.
+type Store interface {
+	// Add adds n to usage of key in the window starting at start and returns
+	// the usage after that. Usage of previous windows is discarded.
+	Add(key string, start time.Time, n int64) (int64, error)
+	// Get returns usage of key in the window starting at start.
+	Get(key string, start time.Time) (int64, error)
+	// Reset removes usage of key.
+	Reset(key string) error
+}
+
+// usage is the usage of a key in a window.
+type usage struct {
+	Start time.Time
+	Count int64
+}
+
+// DefaultMaxKeys is the number of keys kept by MemoryStore when it is not
+// set.
+const DefaultMaxKeys = 100000
+
+// MemoryStore is a Store in memory, which can be saved to and loaded from
+// a snapshot. Usage of previous windows is pruned when the store is full or
+// saved.
+type MemoryStore struct {
+	maxKeys int
+
+	mu    sync.Mutex
+	usage map[string]*usage
+	// latest is the start of the latest window seen in Add.
+	latest time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore keeping usage of up to maxKeys
+// keys, or DefaultMaxKeys if maxKeys is not positive.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &MemoryStore{
+		maxKeys: maxKeys,
+		usage:   make(map[string]*usage),
+	}
+}
+
+// Add adds n to usage of key. It fails when usage of maxKeys keys in the
+// current window is kept already.
+func (s *MemoryStore) Add(key string, start time.Time, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if start.After(s.latest) {
+		s.latest = start
+	}
+	u, ok := s.usage[key]
+	if !ok {
+		if len(s.usage) >= s.maxKeys {
+			s.prune()
+			if len(s.usage) >= s.maxKeys {
+				return 0, fmt.Errorf("quota: number of keys exceeds %d", s.maxKeys)
+			}
+		}
+		u = &usage{}
+		s.usage[key] = u
+	}
+	if !u.Start.Equal(start) {
+		u.Start = start
+		u.Count = 0
+	}
+	u.Count += n
+	return u.Count, nil
+}
+
+// Get returns usage of key.
+func (s *MemoryStore) Get(key string, start time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.usage[key]
+	if !ok || !u.Start.Equal(start) {
+		return 0, nil
+	}
+	return u.Count, nil
+}
+
+// Reset removes usage of key.
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	delete(s.usage, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// prune removes usage of windows before the latest one.
+func (s *MemoryStore) prune() {
+	for k, u := range s.usage {
+		if u.Start.Before(s.latest) {
+			delete(s.usage, k)
+		}
+	}
+}
+
+// Save writes usage of all keys in the latest window in JSON.
+func (s *MemoryStore) Save(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	return json.NewEncoder(w).Encode(s.usage)
+}
+
+// Load replaces usage of all keys with the ones written by Save.
+func (s *MemoryStore) Load(r io.Reader) error {
+	usage := make(map[string]*usage)
+	if err := json.NewDecoder(r).Decode(&usage); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.usage = usage
+	for _, u := range usage {
+		if u.Start.After(s.latest) {
+			s.latest = u.Start
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Snapshot saves a MemoryStore to a file periodically so that usage survives
+// restarts. It implements core.Managed.
+type Snapshot struct {
+	store    *MemoryStore
+	file     string
+	interval time.Duration
+	clock    core.Clock
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// NewSnapshot creates a Snapshot of store saved to file every interval.
+func NewSnapshot(store *MemoryStore, file string, interval time.Duration, clock core.Clock) *Snapshot {
+	return &Snapshot{
+		store:    store,
+		file:     file,
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+// Start loads the store from the file if it exists and starts saving it.
+func (s *Snapshot) Start() error {
+	f, err := os.Open(s.file)
+	if err == nil {
+		err = s.store.Load(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("quota: could not load snapshot %s: %v", s.file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		s.done = make(chan struct{})
+		go s.run(s.done)
+	}
+	return nil
+}
+
+// Stop stops saving periodically and saves the store.
+func (s *Snapshot) Stop() error {
+	s.mu.Lock()
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+	s.mu.Unlock()
+	return s.Save()
+}
+
+func (s *Snapshot) run(done chan struct{}) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := s.Save(); err != nil {
+				logger().Warnf("could not save snapshot: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Save writes the store to a temporary file which then replaces the snapshot
+// so that a crash does not leave a partial snapshot.
+func (s *Snapshot) Save() error {
+	f, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	err = s.store.Save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package quota
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window units.
+const (
+	Hour  = "hour"
+	Day   = "day"
+	Month = "month"
+)
+
+// Window is a calendar period in a time zone in which usage is counted.
+type Window struct {
+	unit     string
+	location *time.Location
+}
+
+// NewWindow returns a Window of unit, which is hour, day or month, in the
+// time zone of the given IANA name, e.g. Asia/Ho_Chi_Minh. Empty name is UTC.
+func NewWindow(unit, timeZone string) (Window, error) {
+	switch unit {
+	case Hour, Day, Month:
+	default:
+		return Window{}, fmt.Errorf("quota: unsupported window %s", unit)
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return Window{}, fmt.Errorf("quota: invalid time zone %s: %v", timeZone, err)
+	}
+	return Window{unit: unit, location: location}, nil
+}
+
+// String returns unit of the window.
+func (w Window) String() string {
+	return w.unit
+}
+
+// Start returns the beginning of the window containing t.
+func (w Window) Start(t time.Time) time.Time {
+	t = t.In(w.location)
+	switch w.unit {
+	case Hour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, w.location)
+	case Day:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.location)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, w.location)
+	}
+}
+
+// End returns the beginning of the window after the one containing t.
+func (w Window) End(t time.Time) time.Time {
+	start := w.Start(t)
+	switch w.unit {
+	case Hour:
+		// Hours are not shifted by daylight saving time.
+		return start.Add(time.Hour)
+	case Day:
+		return start.AddDate(0, 0, 1)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
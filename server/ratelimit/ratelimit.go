@@ -0,0 +1,191 @@
+/*
+Package ratelimit provides a filter limiting request rates of clients with
+token buckets, e.g. 10 requests per second with bursts of 20 requests.
+
+Buckets are kept in memory for the most recently seen keys only, so that
+memory stays bounded when clients change IP addresses. A key which has been
+evicted starts again with a full bucket.
+*/
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	// DefaultMaxKeys is the number of keys whose buckets are kept when it
+	// is not set.
+	DefaultMaxKeys = 10000
+
+	allowedCounter   = "HTTP.RateLimit.Allowed"
+	throttledCounter = "HTTP.RateLimit.Throttled"
+)
+
+// bucket is the token bucket of a key.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps buckets in least recently used order.
+type limiter struct {
+	rate    float64
+	burst   int
+	maxKeys int
+	clock   core.Clock
+	keyFunc func(*http.Request) string
+
+	metrics   *core.MetricsRegistry
+	allowed   core.MetricCounter
+	throttled core.MetricCounter
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+// Option is an option of the rate limiting Filter.
+type Option func(l *limiter)
+
+// WithKeyHeader limits requests by values of the request header, e.g. API
+// keys. Requests without the header are limited by their client IP.
+func WithKeyHeader(name string) Option {
+	return func(l *limiter) {
+		l.keyFunc = func(r *http.Request) string {
+			return r.Header.Get(name)
+		}
+	}
+}
+
+// WithKeyFunc limits requests by keys extracted with f. Requests with empty
+// keys are limited by their client IP.
+func WithKeyFunc(f func(*http.Request) string) Option {
+	return func(l *limiter) {
+		l.keyFunc = f
+	}
+}
+
+// WithMaxKeys sets the number of keys whose buckets are kept,
+// DefaultMaxKeys by default.
+func WithMaxKeys(n int) Option {
+	return func(l *limiter) {
+		l.maxKeys = n
+	}
+}
+
+// WithClock sets the clock of refilling buckets, SystemClock by default.
+func WithClock(clock core.Clock) Option {
+	return func(l *limiter) {
+		l.clock = clock
+	}
+}
+
+// WithMetrics sets the registry of the counters, e.g. Metrics of the
+// environment.
+func WithMetrics(m *core.MetricsRegistry) Option {
+	return func(l *limiter) {
+		l.metrics = m
+	}
+}
+
+// NewFilter returns a Filter allowing rate requests per second for each
+// client IP, with bursts of up to burst requests. Other requests are rejected
+// with status 429 and header Retry-After. Numbers of allowed and rejected
+// requests are counted in HTTP.RateLimit.Allowed and HTTP.RateLimit.Throttled
+// of the registry given by WithMetrics.
+func NewFilter(rate float64, burst int, options ...Option) filter.Filter {
+	l := &limiter{
+		rate:    rate,
+		burst:   burst,
+		maxKeys: DefaultMaxKeys,
+		clock:   core.SystemClock,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	for _, opt := range options {
+		opt(l)
+	}
+	if l.metrics == nil {
+		l.metrics = core.NewMetricsRegistry()
+	}
+	l.allowed = l.metrics.Counter(allowedCounter)
+	l.throttled = l.metrics.Counter(throttledCounter)
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	return l
+}
+
+func (l *limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key string
+	if l.keyFunc != nil {
+		key = l.keyFunc(r)
+	}
+	if key == "" {
+		key = clientIP(r)
+	}
+	wait := l.take(key)
+	if wait <= 0 {
+		l.allowed.Inc(1)
+		filter.Continue(w, r)
+		return
+	}
+	l.throttled.Inc(1)
+	retryAfter := int64(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	filter.WriteProblem(w, http.StatusTooManyRequests,
+		fmt.Sprintf("Rate limit of %g requests per second is exceeded.", l.rate))
+}
+
+// take takes a token from the bucket of key and returns zero, or the
+// duration until a token is available if the bucket is empty.
+func (l *limiter) take(key string) time.Duration {
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b *bucket
+	if e, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+		if elapsed := now.Sub(b.last); elapsed > 0 {
+			b.tokens = math.Min(float64(l.burst), b.tokens+elapsed.Seconds()*l.rate)
+		}
+		b.last = now
+	} else {
+		b = &bucket{key: key, tokens: float64(l.burst), last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		for l.maxKeys > 0 && l.lru.Len() > l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).key)
+		}
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if l.rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// clientIP returns the IP address of the client without port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+func newTestHandler(f filter.Filter) http.Handler {
+	chain := filter.NewChain()
+	chain.Add(f, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	return chain
+}
+
+func serve(h http.Handler, remoteAddr, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		r.Header.Set("X-Api-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	metrics.Reset()
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := newTestHandler(NewFilter(2, 5, WithClock(clock), WithMetrics(core.NewMetricsRegistry())))
+	for i := 0; i < 5; i++ {
+		if w := serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %d", i, w.Code)
+		}
+	}
+	w := serve(h, "10.0.0.1:5678", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" ||
+		w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	// Other clients are not limited.
+	if w = serve(h, "10.0.0.2:1234", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	// One token is refilled.
+	clock.Add(500 * time.Millisecond)
+	if w = serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w = serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	// Bucket is full again.
+	clock.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		if w := serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %d", i, w.Code)
+		}
+	}
+	counters, _ := metrics.Snapshot()
+	if counters[allowedCounter] != 12 || counters[throttledCounter] != 2 {
+		t.Fatalf("unexpected counters: %v", counters)
+	}
+}
+
+func TestRateLimitKeyHeader(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := newTestHandler(NewFilter(1, 1, WithClock(clock), WithKeyHeader("X-Api-Key")))
+	if w := serve(h, "10.0.0.1:1234", "a"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	// Same key from another IP.
+	if w := serve(h, "10.0.0.2:1234", "a"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w := serve(h, "10.0.0.1:1234", "b"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	// Requests without key are limited by IP.
+	if w := serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w := serve(h, "10.0.0.1:1234", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func TestRateLimitMaxKeys(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	f := NewFilter(1, 1, WithClock(clock), WithMaxKeys(2)).(*limiter)
+	h := newTestHandler(f)
+	for _, addr := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1"} {
+		serve(h, addr, "")
+	}
+	if len(f.buckets) != 2 || f.lru.Len() != 2 {
+		t.Fatalf("unexpected buckets: %v", f.buckets)
+	}
+	if _, ok := f.buckets["10.0.0.1"]; ok {
+		t.Fatalf("least recently used key must be evicted: %v", f.buckets)
+	}
+	// Evicted key starts with a full bucket.
+	if w := serve(h, "10.0.0.1:1", ""); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
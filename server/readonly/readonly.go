@@ -0,0 +1,237 @@
+/*
+Package readonly provides a read-only mode of the application, in which
+mutating requests are rejected while reads are still served, e.g. during
+database failovers.
+*/
+package readonly
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	statusPath = "/readonly"
+	gaugeName  = "ReadOnly"
+
+	// sourceManual and sourceHealth are what enabled read-only mode.
+	sourceManual = "manual"
+	sourceHealth = "health"
+)
+
+// Mode is a runtime flag of read-only mode. It is concurrent-safe.
+type Mode struct {
+	exempt []string
+
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	source  string
+}
+
+// Option configures Mode.
+type Option func(*Mode)
+
+// WithExemptPaths allows mutating requests to the paths in read-only mode.
+// A path ending with "*" matches all paths with the prefix.
+func WithExemptPaths(paths ...string) Option {
+	return func(m *Mode) {
+		m.exempt = append(m.exempt, paths...)
+	}
+}
+
+// New returns a new Mode which is initially disabled. Its state is exported
+// as gauge ReadOnly.
+func New(options ...Option) *Mode {
+	m := &Mode{}
+	for _, opt := range options {
+		opt(m)
+	}
+	metrics.Gauge(gaugeName).Set(0)
+	return m
+}
+
+// Enable enters read-only mode with the reason shown to clients.
+func (m *Mode) Enable(reason string) {
+	m.set(true, reason, sourceManual)
+}
+
+// Disable leaves read-only mode.
+func (m *Mode) Disable() {
+	m.set(false, "", sourceManual)
+}
+
+// Enabled returns whether read-only mode is enabled and its reason.
+func (m *Mode) Enabled() (bool, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, m.reason
+}
+
+func (m *Mode) set(enabled bool, reason, source string) {
+	var v int64
+	if enabled {
+		v = 1
+	}
+	m.mu.Lock()
+	changed := m.enabled != enabled
+	m.enabled = enabled
+	m.reason = reason
+	m.source = source
+	metrics.Gauge(gaugeName).Set(v)
+	m.mu.Unlock()
+	if changed {
+		logger().Infof("read-only mode enabled: %t (%s) %s", enabled, source, reason)
+	}
+}
+
+// BindHealthCheck enters read-only mode when the named health check of env
+// becomes unhealthy and leaves it when the check recovers, unless the mode
+// has been changed manually in between.
+func (m *Mode) BindHealthCheck(env *core.Environment, name string) {
+	core.Subscribe(env, func(e core.HealthEvent) {
+		if e.Name != name {
+			return
+		}
+		if !e.Healthy {
+			m.mu.RLock()
+			manual := m.enabled && m.source == sourceManual
+			m.mu.RUnlock()
+			if !manual {
+				m.set(true, fmt.Sprintf("health check %s failed: %s", name, e.Message), sourceHealth)
+			}
+			return
+		}
+		m.mu.RLock()
+		bound := m.enabled && m.source == sourceHealth
+		m.mu.RUnlock()
+		if bound {
+			m.set(false, "", sourceHealth)
+		}
+	})
+}
+
+// Filter returns the filter rejecting POST, PUT, PATCH and DELETE requests
+// to paths which are not exempt with status 503 and a problem+json body
+// while read-only mode is enabled.
+func (m *Mode) Filter() filter.Filter {
+	return http.HandlerFunc(m.serveHTTP)
+}
+
+func (m *Mode) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	enabled, reason := m.Enabled()
+	if !enabled || !isMutating(r.Method) || m.isExempt(r.URL.Path) {
+		filter.Continue(w, r)
+		return
+	}
+	detail := "The service is in read-only mode."
+	if reason != "" {
+		detail += " " + reason
+	}
+	filter.WriteProblem(w, http.StatusServiceUnavailable, detail)
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Mode) isExempt(path string) bool {
+	for _, p := range m.exempt {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(path, p[:len(p)-1]) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// task toggles read-only mode.
+type task struct {
+	name string
+	mode *Mode
+}
+
+// NewTask returns an admin task which enables read-only mode with query
+// enabled=true and optional reason, or disables it with enabled=false.
+// The current state is written in both cases.
+func NewTask(name string, m *Mode) core.ParamTask {
+	return &task{name: name, mode: m}
+}
+
+func (t *task) Name() string {
+	return t.name
+}
+
+func (t *task) Execute(params url.Values, out io.Writer) error {
+	if v := params.Get("enabled"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("readonly: invalid enabled %s", v)
+		}
+		if enabled {
+			t.mode.Enable(params.Get("reason"))
+		} else {
+			t.mode.Disable()
+		}
+	}
+	enabled, reason := t.mode.Enabled()
+	fmt.Fprintf(out, "readOnly: %t\nreason: %s\n", enabled, reason)
+	return nil
+}
+
+// Handler returns the admin handler which displays the state of read-only
+// mode.
+func (m *Mode) Handler() core.AdminHandler {
+	return &statusHandler{m}
+}
+
+type statusHandler struct {
+	mode *Mode
+}
+
+func (h *statusHandler) Name() string {
+	return "Read-only"
+}
+
+func (h *statusHandler) Path() string {
+	return statusPath
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "must-revalidate,no-cache,no-store")
+	w.Header().Set("Content-Type", "application/json")
+	enabled, reason := h.mode.Enabled()
+	h.mode.mu.RLock()
+	source := h.mode.source
+	h.mode.mu.RUnlock()
+	if !enabled {
+		source = ""
+	}
+	json.NewEncoder(w).Encode(struct {
+		ReadOnly bool
+		Reason   string `json:",omitempty"`
+		Source   string `json:",omitempty"`
+	}{enabled, reason, source})
+}
+
+func logger() core.Logger {
+	return core.GetLogger("melon/readonly")
+}
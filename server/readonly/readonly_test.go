@@ -0,0 +1,119 @@
+package readonly
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server/filter/filtertest"
+)
+
+func TestManualToggle(t *testing.T) {
+	m := New()
+	h := filtertest.NewHarness(m.Filter())
+	h.Request("POST", "/users").AssertReached(t)
+
+	var out bytes.Buffer
+	task := NewTask("read-only", m)
+	if err := task.Execute(url.Values{"enabled": {"true"}, "reason": {"Database failover."}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "readOnly: true\nreason: Database failover.\n" {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	if _, gauges := metrics.Snapshot(); gauges["ReadOnly"] != 1 {
+		t.Fatalf("unexpected gauges: %v", gauges)
+	}
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		res := h.Request(method, "/users/1")
+		res.AssertShortCircuited(t, http.StatusServiceUnavailable)
+		res.AssertHeader(t, "Content-Type", "application/problem+json")
+		var p struct {
+			Status int
+			Detail string
+		}
+		if err := json.Unmarshal([]byte(res.Body), &p); err != nil || p.Status != http.StatusServiceUnavailable ||
+			p.Detail != "The service is in read-only mode. Database failover." {
+			t.Fatalf("unexpected body: %s", res.Body)
+		}
+	}
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		h.Request(method, "/users/1").AssertReached(t)
+	}
+	if err := task.Execute(url.Values{"enabled": {"maybe"}}, &out); err == nil {
+		t.Fatal("error expected for invalid enabled")
+	}
+	out.Reset()
+	task.Execute(url.Values{"enabled": {"false"}}, &out)
+	if out.String() != "readOnly: false\nreason: \n" {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	h.Request("POST", "/users").AssertReached(t)
+}
+
+func TestExemptPaths(t *testing.T) {
+	m := New(WithExemptPaths("/auth/login", "/internal/*"))
+	m.Enable("")
+	h := filtertest.NewHarness(m.Filter())
+	h.Request("POST", "/auth/login").AssertReached(t)
+	h.Request("DELETE", "/internal/cache/all").AssertReached(t)
+	h.Request("POST", "/auth/login/other").AssertShortCircuited(t, http.StatusServiceUnavailable)
+	h.Request("POST", "/auth").AssertShortCircuited(t, http.StatusServiceUnavailable)
+}
+
+func TestHealthBoundToggle(t *testing.T) {
+	env := core.NewEnvironment()
+	var healthy int32 = 1
+	env.Admin.HealthChecks.Register("replication-lag", health.CheckerFunc(func() health.Result {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return health.ResultUnhealthy("lag 30s", nil)
+		}
+		return health.Healthy
+	}))
+	m := New()
+	m.BindHealthCheck(env, "replication-lag")
+	env.Events.Start()
+	defer env.Events.Stop()
+
+	waitEnabled := func(expected bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if enabled, _ := m.Enabled(); enabled == expected {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("read-only mode is expected to be %t", expected)
+	}
+	env.Admin.HealthChecks.RunCheckers()
+	atomic.StoreInt32(&healthy, 0)
+	env.Admin.HealthChecks.RunCheckers()
+	waitEnabled(true)
+	if _, reason := m.Enabled(); !strings.Contains(reason, "replication-lag failed: lag 30s") {
+		t.Fatalf("unexpected reason: %s", reason)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	env.Admin.HealthChecks.RunCheckers()
+	waitEnabled(false)
+
+	// Manual toggle is not overridden by the health check.
+	m.Enable("maintenance")
+	atomic.StoreInt32(&healthy, 0)
+	env.Admin.HealthChecks.RunCheckers()
+	atomic.StoreInt32(&healthy, 1)
+	env.Admin.HealthChecks.RunCheckers()
+	// Wait for the events to be delivered.
+	env.Events.Stop()
+	if enabled, reason := m.Enabled(); !enabled || reason != "maintenance" {
+		t.Fatalf("unexpected mode: %t %s", enabled, reason)
+	}
+}
@@ -5,53 +5,266 @@ package recovery
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/codahale/metrics"
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/requestid"
 )
 
 const (
-	stackSkip = 4
+	// stackSkip skips runtime.Callers, stack and the deferred function of
+	// the filter.
+	stackSkip = 3
 	stackMax  = 50
+
+	// statusClientClosedRequest is the response status of benign panics,
+	// which is not counted as a server error.
+	statusClientClosedRequest = 499
+)
+
+// Classifier reports whether a recovered value is benign, e.g. caused by the
+// client going away rather than a bug.
+type Classifier func(v interface{}) bool
+
+var (
+	classifiersMu sync.RWMutex
+	classifiers   = []Classifier{isAbortHandler, isCanceled, isConnectionError}
 )
 
+// RegisterBenign adds a classifier of benign panics. By default,
+// http.ErrAbortHandler, errors wrapping context.Canceled and broken pipe or
+// connection reset errors are benign.
+func RegisterBenign(c Classifier) {
+	classifiersMu.Lock()
+	classifiers = append(classifiers, c)
+	classifiersMu.Unlock()
+}
+
+func isBenign(v interface{}) bool {
+	classifiersMu.RLock()
+	defer classifiersMu.RUnlock()
+	for _, c := range classifiers {
+		if c(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAbortHandler(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && errors.Is(err, http.ErrAbortHandler)
+}
+
+func isCanceled(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && errors.Is(err, context.Canceled)
+}
+
+func isConnectionError(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// Reporter is notified of genuine panics, e.g. to send them to an error
+// tracker. Benign panics are not reported.
+type Reporter func(r *http.Request, v interface{}, stack []byte)
+
+// Handler writes the response of a genuine panic v, replacing the default
+// error response.
+type Handler func(w http.ResponseWriter, r *http.Request, v interface{})
+
 // recoveryFilter handles panics.
 type recoveryFilter struct {
-	panics metrics.Counter
+	panics      metrics.Counter
+	errorDetail core.ErrorDetail
+	reporter    Reporter
+	handler     Handler
+}
+
+// Option is an option for recovery Filter.
+type Option func(f *recoveryFilter)
+
+// WithErrorDetail sets the details of panics included in the response.
+// Panic value and stack trace are only included with core.ErrorDetailStack.
+func WithErrorDetail(detail core.ErrorDetail) Option {
+	return func(f *recoveryFilter) {
+		f.errorDetail = detail
+	}
+}
+
+// WithReporter sets the reporter of genuine panics.
+func WithReporter(reporter Reporter) Option {
+	return func(f *recoveryFilter) {
+		f.reporter = reporter
+	}
+}
+
+// WithHandler sets the handler writing responses of genuine panics. Panics
+// are still logged, counted and reported before the handler is called.
+func WithHandler(handler Handler) Option {
+	return func(f *recoveryFilter) {
+		f.handler = handler
+	}
 }
 
 // NewFilter returns a Filter whichs recovers and logs panics from HTTP handler.
-func NewFilter() filter.Filter {
-	return &recoveryFilter{
+func NewFilter(options ...Option) filter.Filter {
+	f := &recoveryFilter{
 		panics: metrics.Counter("HTTP.Panics"),
 	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
 }
 
+// ServeHTTP recovers panics of the next filters. Benign panics are logged at
+// debug level and respond status 499, except http.ErrAbortHandler which is
+// panicked again to abort the response. Genuine panics are counted, reported
+// and respond status 500.
 func (f *recoveryFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if err := recover(); err != nil {
+			if isBenign(err) {
+				core.GetLogger("melon/server").Debugf("benign panic %s %s: %v", r.Method, r.URL.Path, err)
+				if isAbortHandler(err) {
+					panic(err)
+				}
+				w.WriteHeader(statusClientClosedRequest)
+				return
+			}
 			f.panics.Add()
-			core.GetLogger("melon/server").Errorf("%v\n%s", err, stack())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			st := stack()
+			if id := requestid.Get(r); id != "" {
+				core.GetLogger("melon/server").Errorf("request %s: %v\n%s", id, err, st)
+			} else {
+				core.GetLogger("melon/server").Errorf("%v\n%s", err, st)
+			}
+			if f.reporter != nil {
+				f.reporter(r, err, st)
+			}
+			if f.handler != nil {
+				f.handler(w, r, err)
+			} else {
+				f.writeError(w, r, err, st)
+			}
 		}
 	}()
 	filter.Continue(w, r)
 }
 
+// errorResponse is the response body when client prefers JSON. It has the
+// same shape as errors written by views, with the request ID, panic value and
+// stack trace in details.
+type errorResponse struct {
+	Code    int
+	Message string
+	Details map[string]interface{} `json:",omitempty"`
+}
+
+func (f *recoveryFilter) writeError(w http.ResponseWriter, r *http.Request, err interface{}, st []byte) {
+	var requestID, panicValue string
+	if r != nil {
+		requestID = requestid.Get(r)
+	}
+	if f.errorDetail >= core.ErrorDetailStack {
+		panicValue = fmt.Sprint(err)
+	}
+	code := http.StatusInternalServerError
+	if r != nil && prefersJSON(r.Header.Get("Accept")) {
+		rsp := errorResponse{
+			Code:    code,
+			Message: http.StatusText(code),
+		}
+		if requestID != "" || panicValue != "" {
+			rsp.Details = make(map[string]interface{})
+			if requestID != "" {
+				rsp.Details["requestId"] = requestID
+			}
+			if panicValue != "" {
+				rsp.Details["panic"] = panicValue
+				rsp.Details["stack"] = strings.Split(strings.TrimSpace(string(st)), "\n")
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(&rsp)
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(http.StatusText(code))
+	if requestID != "" {
+		fmt.Fprintf(&buf, "\nRequest ID: %s", requestID)
+	}
+	if panicValue != "" {
+		fmt.Fprintf(&buf, "\n\n%s\n%s", panicValue, st)
+	}
+	// http.Error sends text/plain with nosniff, so the panic value is not escaped.
+	http.Error(w, buf.String(), code)
+}
+
+// prefersJSON reports whether the media type of the highest quality in
+// Accept header is JSON, e.g. application/json or application/problem+json.
+func prefersJSON(accept string) bool {
+	best := ""
+	bestQuality := 0.0
+	for _, mime := range strings.Split(accept, ",") {
+		quality := 1.0
+		if idx := strings.Index(mime, ";"); idx >= 0 {
+			for _, param := range strings.Split(mime[idx+1:], ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if name == "q" || name == "Q" {
+					if q, err := strconv.ParseFloat(value, 64); err == nil && q >= 0 && q <= 1 {
+						quality = q
+					}
+				}
+			}
+			mime = mime[:idx]
+		}
+		if quality > bestQuality {
+			best = strings.ToLower(strings.TrimSpace(mime))
+			bestQuality = quality
+		}
+	}
+	return best == "application/json" || strings.HasSuffix(best, "+json")
+}
+
+// stack returns the stack trace of the panic, starting from the function
+// which panicked. Frames of the recovery and of the runtime panicking are
+// omitted.
 func stack() []byte {
 	var buf bytes.Buffer
 
-	for i := stackSkip; i < stackMax; i++ {
-		pc, file, line, ok := runtime.Caller(i)
-		if !ok {
+	pcs := make([]uintptr, stackMax)
+	n := runtime.Callers(stackSkip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	top := true
+	for {
+		frame, more := frames.Next()
+		if top && strings.HasPrefix(frame.Function, "runtime.") {
+			if !more {
+				break
+			}
+			continue
+		}
+		top = false
+		fmt.Fprintf(&buf, "! %s:%d %s()\n", frame.File, frame.Line, frame.Function)
+		if !more {
 			break
 		}
-		f := runtime.FuncForPC(pc)
-		fmt.Fprintf(&buf, "! %s:%d %s()\n", file, line, f.Name())
 	}
 	return buf.Bytes()
 }
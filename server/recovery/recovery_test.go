@@ -1,13 +1,20 @@
 package recovery
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/codahale/metrics"
 	"github.com/goburrow/melon/core"
-	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/filter/filtertest"
 )
 
 type nopLogger struct{}
@@ -47,19 +54,183 @@ func TestNilPointer(t *testing.T) {
 }
 
 func testFilter(t *testing.T, h http.Handler) {
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest("GET", "/", nil)
+	res := filtertest.NewHarness(NewFilter()).SetHandler(h).Request("GET", "/")
+	res.AssertReached(t)
+	if res.Status != 500 {
+		t.Fatalf("unexpected code %v", res.Status)
+	}
+	if strings.TrimSpace(res.Body) != http.StatusText(http.StatusInternalServerError) {
+		t.Fatalf("unexpected body %v", res.Body)
+	}
+}
+
+func panicHandler(http.ResponseWriter, *http.Request) {
+	panic("<secret>")
+}
+
+func TestErrorDetail(t *testing.T) {
+	tests := []struct {
+		detail core.ErrorDetail
+		accept string
+		panic  bool
+	}{
+		{core.ErrorDetailNone, "", false},
+		{core.ErrorDetailMessage, "", false},
+		{core.ErrorDetailStack, "", true},
+		{core.ErrorDetailNone, "application/json", false},
+		{core.ErrorDetailStack, "application/json", true},
+	}
+	for _, test := range tests {
+		h := filtertest.NewHarness(NewFilter(WithErrorDetail(test.detail))).SetHandler(http.HandlerFunc(panicHandler))
+		res := h.Request("GET", "/", filtertest.WithHeader("X-Request-Id", "abc"), filtertest.WithHeader("Accept", test.accept))
+		if res.Status != 500 {
+			t.Fatalf("unexpected code %v", res.Status)
+		}
+		body := res.Body
+		if !strings.Contains(body, "abc") {
+			t.Fatalf("request id expected in body %v", body)
+		}
+		if test.accept == "application/json" {
+			if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+				t.Fatalf("unexpected content type %v", res.Header)
+			}
+		} else if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") ||
+			res.Header.Get("X-Content-Type-Options") != "nosniff" {
+			// Panic values are sent as is in plain text.
+			t.Fatalf("unexpected header %v", res.Header)
+		}
+		hasPanic := strings.Contains(body, "secret") && strings.Contains(body, "recovery_test.go")
+		if hasPanic && test.accept == "" && !strings.Contains(body, "<secret>") {
+			t.Fatalf("unexpected panic value in body %v", body)
+		}
+		if test.panic != hasPanic {
+			t.Fatalf("unexpected body for %+v: %v", test, body)
+		}
+	}
+}
+
+func TestBenignPanics(t *testing.T) {
+	type customAbort struct{}
+	RegisterBenign(func(v interface{}) bool {
+		_, ok := v.(customAbort)
+		return ok
+	})
+	tests := []struct {
+		value  interface{}
+		benign bool
+	}{
+		{fmt.Errorf("reading body: %w", context.Canceled), true},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{fmt.Errorf("copy: %w", syscall.ECONNRESET), true},
+		{customAbort{}, true},
+		{"genuine", false},
+		{errors.New("nil map"), false},
+	}
+	for _, test := range tests {
+		var reported []interface{}
+		f := NewFilter(WithReporter(func(r *http.Request, v interface{}, stack []byte) {
+			reported = append(reported, v)
+		}))
+		before := panicCount()
+		res := filtertest.NewHarness(f).SetHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic(test.value)
+		})).Request("GET", "/")
+		res.AssertReached(t)
+		counted := panicCount() - before
+		if test.benign {
+			if res.Status != statusClientClosedRequest || len(reported) != 0 || counted != 0 {
+				t.Fatalf("unexpected handling of benign panic %#v: %d %v %d", test.value, res.Status, reported, counted)
+			}
+		} else {
+			if res.Status != http.StatusInternalServerError || len(reported) != 1 || reported[0] != test.value || counted != 1 {
+				t.Fatalf("unexpected handling of panic %#v: %d %v %d", test.value, res.Status, reported, counted)
+			}
+		}
+	}
+}
+
+func TestAbortHandlerPanic(t *testing.T) {
+	reported := false
+	f := NewFilter(WithReporter(func(r *http.Request, v interface{}, stack []byte) {
+		reported = true
+	}))
+	before := panicCount()
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler || reported || panicCount() != before {
+			t.Fatalf("unexpected handling of abort: %v %v", v, reported)
+		}
+	}()
+	filtertest.NewHarness(f).SetHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).Request("GET", "/")
+	t.Fatal("ErrAbortHandler is expected to be panicked again")
+}
+
+func panicCount() uint64 {
+	counters, _ := metrics.Snapshot()
+	return counters["HTTP.Panics"]
+}
+
+func TestHandler(t *testing.T) {
+	var stack []byte
+	f := NewFilter(WithHandler(func(w http.ResponseWriter, r *http.Request, v interface{}) {
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprintf(w, "custom %v", v)
+	}), WithReporter(func(r *http.Request, v interface{}, st []byte) {
+		stack = st
+	}))
+	before := panicCount()
+	res := filtertest.NewHarness(f).SetHandler(http.HandlerFunc(panicHandler)).Request("GET", "/")
+	if res.Status != http.StatusTeapot || res.Body != "custom <secret>" || panicCount() != before+1 {
+		t.Fatalf("unexpected response: %d %v", res.Status, res.Body)
+	}
+	// Stack starts from the panicking function.
+	first := strings.SplitN(string(stack), "\n", 2)[0]
+	if !strings.Contains(first, "recovery.panicHandler") {
+		t.Fatalf("unexpected stack: %s", stack)
+	}
+}
 
-	f := NewFilter()
+func TestJSONError(t *testing.T) {
+	tests := []struct {
+		accept string
+		body   string
+	}{
+		{"application/json", `{"Code":500,"Message":"Internal Server Error"}`},
+		{"text/html, application/json;q=0.5", "Internal Server Error"},
+		{"", "Internal Server Error"},
+	}
+	for _, test := range tests {
+		h := filtertest.NewHarness(NewFilter()).SetHandler(http.HandlerFunc(panicHandler))
+		res := h.Request("GET", "/", filtertest.WithHeader("Accept", test.accept))
+		if res.Status != 500 || strings.TrimSpace(res.Body) != test.body {
+			t.Fatalf("unexpected response for %q: %d %v", test.accept, res.Status, res.Body)
+		}
+	}
+	// Request may not be available.
+	w := httptest.NewRecorder()
+	NewFilter().(*recoveryFilter).writeError(w, nil, "panic", nil)
+	if w.Code != 500 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
 
-	chain := filter.NewChain()
-	chain.Add(f, h)
-	chain.ServeHTTP(w, r)
-	w.Flush()
-	if w.Code != 500 {
-		t.Fatalf("unexpected code %v", w.Code)
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		json   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"Application/JSON; charset=utf-8", true},
+		{"application/problem+json", true},
+		{"text/plain;q=0.9, application/json", true},
+		{"application/json;q=0.1, text/html", false},
 	}
-	if strings.TrimSpace(w.Body.String()) != http.StatusText(http.StatusInternalServerError) {
-		t.Fatalf("unexpected body %v", w.Body.String())
+	for _, test := range tests {
+		if prefersJSON(test.accept) != test.json {
+			t.Fatalf("unexpected result for %q", test.accept)
+		}
 	}
 }
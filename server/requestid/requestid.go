@@ -0,0 +1,89 @@
+/*
+Package requestid provides a filter which assigns an ID to each request.
+
+The ID is stored in the request context, so that handlers, request log and
+panic recovery can correlate log lines of a request with FromContext.
+*/
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+// DefaultHeader is the request and response header of request IDs.
+const DefaultHeader = "X-Request-Id"
+
+// requestIDFilter sets the ID header of requests which do not have one.
+type requestIDFilter struct {
+	generator core.IDGenerator
+	header    string
+}
+
+// Option is an option for the request ID Filter.
+type Option func(f *requestIDFilter)
+
+// WithHeader sets the header of request IDs, X-Request-Id by default.
+func WithHeader(header string) Option {
+	return func(f *requestIDFilter) {
+		f.header = http.CanonicalHeaderKey(header)
+	}
+}
+
+// NewFilter returns a Filter which sets a new ID from the generator to
+// X-Request-Id header of the request if it is not provided by the client.
+// The ID is also included in the response header and the request context.
+func NewFilter(generator core.IDGenerator, options ...Option) filter.Filter {
+	f := &requestIDFilter{
+		generator: generator,
+		header:    DefaultHeader,
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
+}
+
+func (f *requestIDFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(f.header)
+	if id == "" {
+		id = f.generator.NewID()
+		r.Header.Set(f.header, id)
+	}
+	w.Header().Set(f.header, id)
+	filter.Continue(w, r.WithContext(NewContext(r.Context(), id)))
+}
+
+// contextKey is a value for use with context.WithValue
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return "melon/server context value " + c.name
+}
+
+var requestIDContextKey = &contextKey{"requestid"}
+
+// NewContext returns a new context carrying request ID id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// FromContext returns the request ID stored in ctx or empty if the request
+// ID filter is not enabled.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// Get returns the ID of request r from its context or X-Request-Id header.
+func Get(r *http.Request) string {
+	if id := FromContext(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(DefaultHeader)
+}
@@ -0,0 +1,69 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+type staticGenerator string
+
+func (g staticGenerator) NewID() string {
+	return string(g)
+}
+
+func TestRequestID(t *testing.T) {
+	var requestID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-Id")
+		if FromContext(r.Context()) != requestID {
+			t.Fatalf("unexpected request id in context: %v", FromContext(r.Context()))
+		}
+	}
+	chain := filter.NewChain()
+	chain.Add(NewFilter(staticGenerator("generated")), http.HandlerFunc(handler))
+
+	tests := map[string]string{
+		"":       "generated",
+		"client": "client",
+	}
+	for header, expected := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("X-Request-Id", header)
+		}
+		chain.ServeHTTP(w, r)
+		if requestID != expected {
+			t.Fatalf("unexpected request id: %v, want: %v", requestID, expected)
+		}
+		if w.Header().Get("X-Request-Id") != expected {
+			t.Fatalf("unexpected response header: %v", w.Header())
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	var requestID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requestID = Get(r)
+	}
+	chain := filter.NewChain()
+	chain.Add(NewFilter(staticGenerator("generated"), WithHeader("x-correlation-id")), http.HandlerFunc(handler))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "ignored")
+	chain.ServeHTTP(w, r)
+	if requestID != "generated" || w.Header().Get("X-Correlation-Id") != "generated" || w.Header().Get("X-Request-Id") != "" {
+		t.Fatalf("unexpected request id: %v, header: %v", requestID, w.Header())
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Correlation-Id", "client")
+	chain.ServeHTTP(httptest.NewRecorder(), r)
+	if requestID != "client" {
+		t.Fatalf("unexpected request id: %v", requestID)
+	}
+}
@@ -0,0 +1,169 @@
+package router
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Converter validates and converts a typed path parameter, which is declared
+// in a pattern as {name:converter}, e.g. /user/{id:int}.
+type Converter struct {
+	// Pattern is the regular expression of valid values.
+	Pattern string
+	// Convert returns the value of the parameter. When it is nil, the
+	// parameter is kept as string.
+	Convert func(string) (interface{}, error)
+}
+
+// converters contains registered converters by name.
+var converters = map[string]Converter{
+	"int": {
+		Pattern: `-?[0-9]+`,
+		Convert: func(s string) (interface{}, error) {
+			return strconv.ParseInt(s, 10, 64)
+		},
+	},
+	"uuid": {
+		Pattern: `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+		Convert: func(s string) (interface{}, error) {
+			return strings.ToLower(s), nil
+		},
+	},
+}
+
+// RegisterConverter registers a converter which can be used in route patterns
+// by its name. Routes registered before are not affected.
+// RegisterConverter is not concurrent-safe and should be called in init functions.
+func RegisterConverter(name string, c Converter) {
+	converters[name] = c
+}
+
+// paramConverter is a converter of a path parameter.
+type paramConverter struct {
+	Converter
+	regexp *regexp.Regexp
+}
+
+// parsePattern replaces registered converters in pattern with their regular
+// expressions, or removes them if strict is false, so that invalid values can
+// be handled by convertHandler instead. The returned pattern is used when the
+// route cannot be matched segment by segment.
+func parsePattern(pattern string, strict bool) (string, map[string]*paramConverter) {
+	var params map[string]*paramConverter
+	var buf strings.Builder
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			break
+		}
+		end := closingBrace(pattern, start)
+		if end < 0 {
+			break
+		}
+		buf.WriteString(pattern[:start])
+		param := pattern[start+1 : end]
+		pattern = pattern[end+1:]
+
+		name, convName := param, ""
+		if i := strings.IndexByte(param, ':'); i >= 0 {
+			name, convName = param[:i], param[i+1:]
+		}
+		c, ok := converters[convName]
+		if !ok {
+			// Plain parameter or regular expression.
+			buf.WriteString("{" + param + "}")
+			continue
+		}
+		if params == nil {
+			params = make(map[string]*paramConverter)
+		}
+		params[name] = &paramConverter{
+			Converter: c,
+			regexp:    regexp.MustCompile("^(?:" + c.Pattern + ")$"),
+		}
+		if strict {
+			buf.WriteString("{" + name + ":" + c.Pattern + "}")
+		} else {
+			buf.WriteString("{" + name + "}")
+		}
+	}
+	buf.WriteString(pattern)
+	return buf.String(), params
+}
+
+// closingBrace returns index of the brace closing the one at start.
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// convertHandler converts typed path parameters before calling handler.
+// Requests with invalid parameters are responded with status.
+type convertHandler struct {
+	handler http.Handler
+	params  map[string]*paramConverter
+	status  int
+}
+
+func (h *convertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := pathParamsFromContext(r.Context())
+	for name, c := range h.params {
+		i := -1
+		if p != nil {
+			i = p.index(name)
+		}
+		if i < 0 || !c.regexp.MatchString(p.params[i].value) {
+			http.Error(w, http.StatusText(h.status), h.status)
+			return
+		}
+		s := p.params[i].value
+		if c.Convert == nil {
+			p.params[i].converted = s
+			continue
+		}
+		v, err := c.Convert(s)
+		if err != nil {
+			http.Error(w, http.StatusText(h.status), h.status)
+			return
+		}
+		p.params[i].converted = v
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// contextKey is a value for use with context.WithValue
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return "melon/router context value " + c.name
+}
+
+// PathInt returns the value of path parameter declared as {name:int}.
+func PathInt(r *http.Request, name string) int64 {
+	v, _ := PathValue(r, name)
+	n, _ := v.(int64)
+	return n
+}
+
+// PathUUID returns the value of path parameter declared as {name:uuid} in
+// lower case.
+func PathUUID(r *http.Request, name string) string {
+	v, _ := PathValue(r, name)
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterConverter("slug", Converter{
+		Pattern: `[a-z0-9]+(?:-[a-z0-9]+)*`,
+	})
+}
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", path, nil)
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestConverters(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{id:int}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "user %d", PathInt(r, "id"))
+	}))
+	r.Handle("GET", "/doc/{id:uuid}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "doc %s", PathUUID(r, "id"))
+	}))
+	r.Handle("GET", "/post/{slug:slug}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, _ := PathValue(r, "slug")
+		fmt.Fprintf(w, "post %v", v)
+	}))
+	r.Handle("GET", "/code/{code:[A-Z]{3}}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "code %s", PathParams(r)["code"])
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/user/123", http.StatusOK, "user 123"},
+		{"/user/-1", http.StatusOK, "user -1"},
+		{"/user/abc", http.StatusNotFound, ""},
+		{"/user/99999999999999999999", http.StatusNotFound, ""},
+		{"/doc/123E4567-E89B-12D3-A456-426614174000", http.StatusOK, "doc 123e4567-e89b-12d3-a456-426614174000"},
+		{"/doc/123", http.StatusNotFound, ""},
+		{"/post/hello-world", http.StatusOK, "post hello-world"},
+		{"/post/Hello_World", http.StatusNotFound, ""},
+		{"/code/ABC", http.StatusOK, "code ABC"},
+		{"/code/AB", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serve(r, test.path)
+		if w.Code != test.status {
+			t.Fatalf("%s: unexpected status: %v, want: %v", test.path, w.Code, test.status)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Fatalf("%s: unexpected body: %v, want: %v", test.path, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestConverterFallThrough(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{id:int}", nameHandler("id"))
+	r.Handle("GET", "/user/{name}", nameHandler("name"))
+
+	if w := serve(r, "/user/1"); w.Body.String() != "id" {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+	if w := serve(r, "/user/bob"); w.Body.String() != "name" {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
+
+func TestInvalidParamStatus(t *testing.T) {
+	r := New(WithInvalidParamStatus(http.StatusBadRequest))
+	r.Handle("GET", "/user/{id:int}", nameHandler("id"))
+
+	if w := serve(r, "/user/1"); w.Code != http.StatusOK || w.Body.String() != "id" {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Body.String())
+	}
+	w := serve(r, "/user/abc")
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "id") {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Body.String())
+	}
+	if w = serve(r, "/user/99999999999999999999"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+	endpoints := r.Endpoints()
+	if len(endpoints) != 1 || !strings.Contains(endpoints[0], "/user/{id:int}") {
+		t.Fatalf("unexpected endpoints: %v", endpoints)
+	}
+}
@@ -0,0 +1,195 @@
+package router
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// matcher matches request paths of a route. Patterns only consisting of
+// static segments and whole-segment parameters are matched segment by segment
+// without allocation, others by a regular expression of the whole path.
+type matcher struct {
+	segments []segment
+
+	regexp *regexp.Regexp
+	// names are parameter names of regexp groups.
+	names  []string
+	groups []int
+
+	// prefix is true for wildcard patterns, in which the last segment or the
+	// regular expression only matches the beginning of the remaining path.
+	prefix bool
+}
+
+// segment is a static segment or a parameter.
+type segment struct {
+	// value is either the static value or the parameter name.
+	value string
+	param bool
+	// regexp validates values of typed parameters.
+	regexp *regexp.Regexp
+}
+
+// newMatcher creates a matcher for the pattern. expanded is the pattern
+// returned by parsePattern and params are its typed parameters.
+func newMatcher(pattern, expanded string, params map[string]*paramConverter, strict bool) (matcher, error) {
+	var m matcher
+	if strings.HasSuffix(pattern, "*") {
+		m.prefix = true
+		pattern = pattern[:len(pattern)-1]
+		expanded = expanded[:len(expanded)-1]
+	}
+	if segments, ok := parseSegments(pattern, params, strict, m.prefix); ok {
+		m.segments = segments
+		return m, nil
+	}
+	err := m.compile(expanded)
+	return m, err
+}
+
+// parseSegments returns false if pattern has a parameter which is not a whole
+// segment or has a custom regular expression, which may also match slashes.
+func parseSegments(pattern string, params map[string]*paramConverter, strict, prefix bool) ([]segment, bool) {
+	parts := strings.Split(pattern, "/")
+	segments := make([]segment, len(parts))
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "{}") {
+			segments[i].value = part
+			continue
+		}
+		if part[0] != '{' || closingBrace(part, 0) != len(part)-1 || (prefix && i == len(parts)-1) {
+			return nil, false
+		}
+		name := part[1 : len(part)-1]
+		if idx := strings.IndexByte(name, ':'); idx >= 0 {
+			c, ok := params[name[:idx]]
+			if !ok {
+				return nil, false
+			}
+			name = name[:idx]
+			if strict {
+				segments[i].regexp = c.regexp
+			}
+		}
+		segments[i].value = name
+		segments[i].param = true
+	}
+	return segments, true
+}
+
+// compile builds the regular expression of pattern, in which parameters
+// match non-empty segments unless their regular expressions are given.
+func (m *matcher) compile(pattern string) error {
+	var buf strings.Builder
+	buf.WriteByte('^')
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			break
+		}
+		end := closingBrace(pattern, start)
+		if end < 0 {
+			break
+		}
+		buf.WriteString(regexp.QuoteMeta(pattern[:start]))
+		param := pattern[start+1 : end]
+		pattern = pattern[end+1:]
+
+		name, expr := param, "[^/]+"
+		if i := strings.IndexByte(param, ':'); i >= 0 {
+			name, expr = param[:i], param[i+1:]
+		}
+		fmt.Fprintf(&buf, "(?P<v%d>%s)", len(m.names), expr)
+		m.names = append(m.names, name)
+	}
+	buf.WriteString(regexp.QuoteMeta(pattern))
+	if !m.prefix {
+		buf.WriteByte('$')
+	}
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return err
+	}
+	m.regexp = re
+	m.groups = make([]int, len(m.names))
+	for i := range m.names {
+		m.groups[i] = re.SubexpIndex(fmt.Sprintf("v%d", i))
+	}
+	return nil
+}
+
+// match reports whether p matches and adds its parameters to params.
+// params is reset when p does not match.
+func (m *matcher) match(p string, params *pathParams) bool {
+	if m.regexp != nil {
+		idx := m.regexp.FindStringSubmatchIndex(p)
+		if idx == nil {
+			return false
+		}
+		for i, name := range m.names {
+			g := m.groups[i]
+			params.add(name, p[idx[2*g]:idx[2*g+1]])
+		}
+		return true
+	}
+	last := len(m.segments) - 1
+	for i := range m.segments {
+		s := &m.segments[i]
+		if i > 0 {
+			if p == "" || p[0] != '/' {
+				params.reset()
+				return false
+			}
+			p = p[1:]
+		}
+		if i == last && m.prefix {
+			if strings.HasPrefix(p, s.value) {
+				return true
+			}
+			params.reset()
+			return false
+		}
+		value := p
+		if end := strings.IndexByte(p, '/'); end >= 0 {
+			value = p[:end]
+		}
+		p = p[len(value):]
+		if !s.param {
+			if value != s.value {
+				params.reset()
+				return false
+			}
+			continue
+		}
+		if value == "" || (s.regexp != nil && !s.regexp.MatchString(value)) {
+			params.reset()
+			return false
+		}
+		params.add(s.value, value)
+	}
+	if p != "" {
+		params.reset()
+		return false
+	}
+	return true
+}
+
+// cleanPath returns the canonical path of p, keeping the trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		if np == p[:len(p)-1] {
+			return p
+		}
+		np += "/"
+	}
+	return np
+}
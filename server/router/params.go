@@ -0,0 +1,119 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// maxInlineParams is the number of path parameters stored without allocation.
+const maxInlineParams = 4
+
+// pathParam is a path parameter of the matched route.
+type pathParam struct {
+	name  string
+	value string
+	// converted is the value of a typed parameter, nil otherwise.
+	converted interface{}
+}
+
+// pathParams contains parameters of a request. It is reused from paramsPool
+// and released when the route handler returns.
+type pathParams struct {
+	params []pathParam
+	inline [maxInlineParams]pathParam
+	// m is built when PathParams is called.
+	m map[string]string
+}
+
+var paramsPool = sync.Pool{
+	New: func() interface{} {
+		p := &pathParams{}
+		p.params = p.inline[:0]
+		return p
+	},
+}
+
+func acquireParams() *pathParams {
+	return paramsPool.Get().(*pathParams)
+}
+
+func releaseParams(p *pathParams) {
+	p.reset()
+	paramsPool.Put(p)
+}
+
+func (p *pathParams) add(name, value string) {
+	p.params = append(p.params, pathParam{name: name, value: value})
+}
+
+func (p *pathParams) reset() {
+	for i := range p.params {
+		p.params[i] = pathParam{}
+	}
+	p.params = p.inline[:0]
+	p.m = nil
+}
+
+// index returns position of the named parameter or -1 if not found.
+func (p *pathParams) index(name string) int {
+	for i := range p.params {
+		if p.params[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var pathParamsContextKey = &contextKey{"pathParams"}
+
+func pathParamsFromContext(ctx context.Context) *pathParams {
+	p, _ := ctx.Value(pathParamsContextKey).(*pathParams)
+	return p
+}
+
+// emptyParams hides parameters of outer routers from nested routes.
+var emptyParams = &pathParams{}
+
+// PathParams returns path parameters from the path of the request.
+// The map is built on the first call, PathParam is preferred in hot paths.
+func PathParams(r *http.Request) map[string]string {
+	p := pathParamsFromContext(r.Context())
+	if p == nil || len(p.params) == 0 {
+		return nil
+	}
+	if p.m == nil {
+		p.m = make(map[string]string, len(p.params))
+		for _, param := range p.params {
+			p.m[param.name] = param.value
+		}
+	}
+	return p.m
+}
+
+// PathParam returns value of the named path parameter or empty string if it
+// does not exist. It does not allocate.
+// Parameters of the request are only available until the handler returns.
+func PathParam(r *http.Request, name string) string {
+	p := pathParamsFromContext(r.Context())
+	if p == nil {
+		return ""
+	}
+	if i := p.index(name); i >= 0 {
+		return p.params[i].value
+	}
+	return ""
+}
+
+// PathValue returns the converted value of the typed path parameter.
+func PathValue(r *http.Request, name string) (interface{}, bool) {
+	p := pathParamsFromContext(r.Context())
+	if p == nil {
+		return nil, false
+	}
+	i := p.index(name)
+	if i < 0 || p.params[i].converted == nil {
+		return nil, false
+	}
+	return p.params[i].converted, true
+}
@@ -1,40 +1,69 @@
 /*
 Package router supports dynamic routes for http server.
+
+Routes are matched by precedence regardless of their registration order.
+Patterns are compared segment by segment, in which a static segment
+(e.g. /user/settings) beats a parameter (e.g. /user/{name}), which beats a
+wildcard (e.g. /user/*). Among wildcards, the longer prefix wins. When
+patterns have the same precedence, routes with an explicit method are matched
+before those registered with "*", then registration order applies.
+Registering the same method and pattern twice is a conflict.
+
+Path parameters are stored in pooled buffers, which are released when the
+route handler returns, so PathParam does not allocate.
 */
 package router
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"path"
 	"strings"
 
+	"github.com/goburrow/melon/core"
 	"github.com/goburrow/melon/server/filter"
-	"github.com/gorilla/mux"
 )
 
+// dispatcherName is the name of the last filter in the chain, which
+// dispatches routes.
+const dispatcherName = "melon/router"
+
 // Router handles HTTP requests.
 // It implements core.Router
 type Router struct {
-	// serverMux is the HTTP request router.
-	serveMux *mux.Router
 	// filterChain is the builder for HTTP filters.
 	filterChain *filter.Chain
 
 	pathPrefix string
-	endpoints  []string
+	// invalidParamStatus is the response status for invalid typed path parameters.
+	invalidParamStatus int
+	// deprecations tracks usage of deprecated routes.
+	deprecations *core.DeprecationTracker
+	// routes are sorted by precedence. It is replaced when a route is added.
+	routes []*route
+}
+
+// route is a registered handler.
+type route struct {
+	method  string
+	pattern string
+	handler http.Handler
+
+	// matcher matches request paths and serveHandler converts typed path
+	// parameters before calling handler.
+	matcher      matcher
+	serveHandler http.Handler
 }
 
 // New creates a new Router.
 func New(options ...Option) *Router {
-	serveMux := mux.NewRouter()
-	chain := filter.NewChain()
-	chain.Add(serveMux)
-
 	r := &Router{
-		serveMux:    serveMux,
-		filterChain: chain,
+		filterChain: filter.NewChain(),
+
+		invalidParamStatus: http.StatusNotFound,
 	}
+	r.filterChain.AddNamed(dispatcherName, http.HandlerFunc(r.serveRoute))
 	for _, opt := range options {
 		opt(r)
 	}
@@ -42,20 +71,58 @@ func New(options ...Option) *Router {
 }
 
 // Handle registers the handler for the given pattern.
+// Path parameters can be typed with registered converters, e.g. {id:int} or
+// {id:uuid}, and requests with invalid values are rejected before reaching
+// the handler.
+// Responses of handlers implementing core.Deprecated include deprecation
+// headers.
+// HEAD requests are served by the GET route of the path, without response
+// body, when there is no HEAD route.
+// A conflicting registration is logged and ignored.
 func (h *Router) Handle(method, pattern string, handler http.Handler) {
-	r := h.serveMux.NewRoute()
-	r.Handler(handler)
-	if method != "" && method != "*" {
-		r.Methods(method)
+	rt := &route{
+		method:  method,
+		pattern: pattern,
+		handler: handler,
+
+		serveHandler: handler,
 	}
-	if strings.HasSuffix(pattern, "*") {
-		r.PathPrefix(pattern[:len(pattern)-1])
-	} else {
-		r.Path(pattern)
+	// Invalid values do not match the route if the status is 404.
+	strict := h.invalidParamStatus == http.StatusNotFound
+	expanded, params := parsePattern(pattern, strict)
+	m, err := newMatcher(pattern, expanded, params, strict)
+	if err != nil {
+		core.GetLogger("melon/server").Errorf("invalid route: %s %s%s (%T): %v",
+			method, h.pathPrefix, pattern, handler, err)
+		return
 	}
-	// log endpoint
-	endpoint := fmt.Sprintf("%-7s %s%s (%T)", method, h.pathPrefix, pattern, handler)
-	h.endpoints = append(h.endpoints, endpoint)
+	rt.matcher = m
+	if len(params) > 0 {
+		rt.serveHandler = &convertHandler{
+			handler: handler,
+			params:  params,
+			status:  h.invalidParamStatus,
+		}
+	}
+	idx := len(h.routes)
+	for i, r := range h.routes {
+		if r.pattern == pattern && r.method == method {
+			core.GetLogger("melon/server").Errorf("route conflict: %s %s%s (%T) is already registered with %T",
+				method, h.pathPrefix, pattern, handler, r.handler)
+			return
+		}
+		if idx == len(h.routes) && higherPrecedence(rt, r) {
+			idx = i
+		}
+	}
+	if d := core.DeprecationOf(handler); d != nil {
+		rt.serveHandler = h.deprecations.Handler(method, h.pathPrefix+pattern, d, rt.serveHandler)
+	}
+	routes := make([]*route, 0, len(h.routes)+1)
+	routes = append(routes, h.routes[:idx]...)
+	routes = append(routes, rt)
+	routes = append(routes, h.routes[idx:]...)
+	h.routes = routes
 }
 
 // PathPrefix returns server root context path.
@@ -63,13 +130,195 @@ func (h *Router) PathPrefix() string {
 	return h.pathPrefix
 }
 
-// Endpoints returns all registered endpoints.
+// Endpoints returns all registered endpoints in the order of precedence.
 func (h *Router) Endpoints() []string {
-	return h.endpoints
+	endpoints := make([]string, len(h.routes))
+	for i, r := range h.routes {
+		endpoints[i] = fmt.Sprintf("%-7s %s%s (%T)", r.method, h.pathPrefix, r.pattern, r.handler)
+		if core.DeprecationOf(r.handler) != nil {
+			endpoints[i] += " [deprecated]"
+		}
+		if n := negotiatedOf(r.handler); n != nil {
+			if c := n.Consumes(); len(c) > 0 {
+				endpoints[i] += " [consumes " + strings.Join(c, ", ") + "]"
+			}
+			if p := n.Produces(); len(p) > 0 {
+				endpoints[i] += " [produces " + strings.Join(p, ", ") + "]"
+			}
+		}
+	}
+	return endpoints
+}
+
+// negotiatedOf returns the first of handler and the handlers it wraps which
+// implements core.Negotiated.
+func negotiatedOf(handler http.Handler) core.Negotiated {
+	for handler != nil {
+		if n, ok := handler.(core.Negotiated); ok {
+			return n
+		}
+		u, ok := handler.(interface{ Unwrap() http.Handler })
+		if !ok {
+			break
+		}
+		handler = u.Unwrap()
+	}
+	return nil
+}
+
+// Routes returns all registered routes in the order of precedence.
+// Tags, deprecations and media types are taken from handlers implementing
+// core.Tagged, core.Deprecated and core.Negotiated, and handlers wrapping
+// components are described by the innermost one, which is returned by their
+// Unwrap method.
+func (h *Router) Routes() []core.RouteInfo {
+	routes := make([]core.RouteInfo, len(h.routes))
+	for i, r := range h.routes {
+		info := core.RouteInfo{
+			Method:  r.method,
+			Pattern: h.pathPrefix + r.pattern,
+		}
+		var handler interface{} = r.handler
+		for {
+			if t, ok := handler.(core.Tagged); ok && info.Tags == nil {
+				info.Tags = t.Tags()
+			}
+			u, ok := handler.(interface{ Unwrap() http.Handler })
+			if !ok {
+				break
+			}
+			handler = u.Unwrap()
+		}
+		info.Component = fmt.Sprintf("%T", handler)
+		info.Deprecation = core.DeprecationOf(r.handler)
+		if n := negotiatedOf(r.handler); n != nil {
+			info.Consumes = n.Consumes()
+			info.Produces = n.Produces()
+		}
+		routes[i] = info
+	}
+	return routes
 }
 
-// ServeHTTP strips path prefix in the request and executes filter chain,
-// which should include ServeMux as the last one.
+// Match reports whether a route of any method matches path p, which does not
+// include the path prefix of the router.
+func (h *Router) Match(p string) bool {
+	params := acquireParams()
+	defer releaseParams(params)
+	for _, rt := range h.routes {
+		if rt.matcher.match(p, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRoute dispatches the request to the matched route. Requests with
+// unclean paths are redirected to their canonical form.
+func (h *Router) serveRoute(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	if cp := cleanPath(p); cp != p {
+		u := *r.URL
+		u.Path = cp
+		w.Header().Set("Location", u.String())
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	params := acquireParams()
+	methodMismatch := false
+	// getRoute serves HEAD requests when there is no HEAD route.
+	var getRoute *route
+	for _, rt := range h.routes {
+		if !rt.matcher.match(p, params) {
+			continue
+		}
+		if !isAnyMethod(rt.method) && rt.method != r.Method {
+			if getRoute == nil && rt.method == http.MethodGet && r.Method == http.MethodHead {
+				getRoute = rt
+			}
+			methodMismatch = true
+			params.reset()
+			continue
+		}
+		rt.serve(w, r, params)
+		return
+	}
+	if getRoute != nil {
+		// Response body is discarded by net/http for HEAD requests.
+		getRoute.matcher.match(p, params)
+		getRoute.serve(w, r, params)
+		return
+	}
+	releaseParams(params)
+	if methodMismatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serve calls the route handler with params, which are released after that.
+// The request context is only replaced when there are parameters.
+func (rt *route) serve(w http.ResponseWriter, r *http.Request, params *pathParams) {
+	if len(params.params) == 0 {
+		releaseParams(params)
+		if pathParamsFromContext(r.Context()) != nil {
+			// Hide parameters of the outer router.
+			r = r.WithContext(context.WithValue(r.Context(), pathParamsContextKey, emptyParams))
+		}
+		rt.serveHandler.ServeHTTP(w, r)
+		return
+	}
+	ctx := context.WithValue(r.Context(), pathParamsContextKey, params)
+	rt.serveHandler.ServeHTTP(w, r.WithContext(ctx))
+	releaseParams(params)
+}
+
+// Precedence of a path segment.
+const (
+	segmentStatic = iota
+	segmentParam
+	segmentWildcard
+)
+
+func segmentRank(segment string) int {
+	if strings.HasSuffix(segment, "*") {
+		return segmentWildcard
+	}
+	if strings.Contains(segment, "{") {
+		return segmentParam
+	}
+	return segmentStatic
+}
+
+// higherPrecedence reports whether route a must be matched before route b.
+func higherPrecedence(a, b *route) bool {
+	as := strings.Split(a.pattern, "/")
+	bs := strings.Split(b.pattern, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ra, rb := segmentRank(as[i]), segmentRank(bs[i])
+		if ra != rb {
+			return ra < rb
+		}
+		if ra == segmentWildcard {
+			if len(as[i]) != len(bs[i]) {
+				return len(as[i]) > len(bs[i])
+			}
+			break
+		}
+	}
+	if len(as) != len(bs) {
+		return len(as) > len(bs)
+	}
+	return !isAnyMethod(a.method) && isAnyMethod(b.method)
+}
+
+func isAnyMethod(method string) bool {
+	return method == "" || method == "*"
+}
+
+// ServeHTTP strips path prefix in the request, which is kept in BasePath, and
+// executes filter chain, which dispatches routes as the last one.
 func (h *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.pathPrefix != "" {
 		p := strings.TrimPrefix(r.URL.Path, h.pathPrefix)
@@ -77,16 +326,51 @@ func (h *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			p = "/"
 		}
 		r.URL.Path = p
+		r = withBasePath(r, h.pathPrefix)
 	}
 	h.filterChain.ServeHTTP(w, r)
 }
 
 // AddFilter adds a filter middleware.
 func (h *Router) AddFilter(f filter.Filter) {
-	// Filter f is always added before the last filter, which is server mux.
+	// Filter f is always added before the last filter, which dispatches routes.
 	h.filterChain.Insert(f, h.filterChain.Length()-1)
 }
 
+// AddNamedFilter adds a filter middleware with the given name, so that other
+// filters can be inserted around it. Names of filters must be unique.
+func (h *Router) AddNamedFilter(name string, f filter.Filter) error {
+	if name == dispatcherName {
+		return fmt.Errorf("router: filter name %s is reserved", name)
+	}
+	// Move the dispatcher after the new filter.
+	h.filterChain.Remove(dispatcherName)
+	err := h.filterChain.AddNamed(name, f)
+	h.filterChain.AddNamed(dispatcherName, http.HandlerFunc(h.serveRoute))
+	return err
+}
+
+// InsertFilterBefore adds a filter middleware before the filter with the
+// given name.
+func (h *Router) InsertFilterBefore(name string, f filter.Filter) error {
+	return h.filterChain.InsertBefore(name, f)
+}
+
+// InsertFilterAfter adds a filter middleware after the filter with the given
+// name.
+func (h *Router) InsertFilterAfter(name string, f filter.Filter) error {
+	if name == dispatcherName {
+		return fmt.Errorf("router: filter %s not found", name)
+	}
+	return h.filterChain.InsertAfter(name, f)
+}
+
+// RemoveFilter removes the filter with the given name and reports whether it
+// was added.
+func (h *Router) RemoveFilter(name string) bool {
+	return name != dispatcherName && h.filterChain.Remove(name)
+}
+
 // Option is router options.
 type Option func(r *Router)
 
@@ -106,7 +390,20 @@ func WithPathPrefix(prefix string) Option {
 	}
 }
 
-// PathParams returns path parameters from the path of the request.
-func PathParams(r *http.Request) map[string]string {
-	return mux.Vars(r)
+// WithDeprecationTracker returns an Option which counts requests of
+// deprecated routes with t, usually Deprecations of core.ServerEnvironment.
+// Deprecation headers are set regardless of the option.
+func WithDeprecationTracker(t *core.DeprecationTracker) Option {
+	return func(r *Router) {
+		r.deprecations = t
+	}
+}
+
+// WithInvalidParamStatus returns an Option which sets the response status,
+// either 404 (default) or 400, for requests with invalid typed path parameters.
+// With 404, the request may match other routes.
+func WithInvalidParamStatus(status int) Option {
+	return func(r *Router) {
+		r.invalidParamStatus = status
+	}
 }
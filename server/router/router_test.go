@@ -1,10 +1,16 @@
 package router
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
 )
 
 var _ core.Router = (*Router)(nil)
@@ -26,3 +32,357 @@ func TestPathPrefix(t *testing.T) {
 		}
 	}
 }
+
+type nameHandler string
+
+func (h nameHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(h))
+}
+
+func TestPrecedence(t *testing.T) {
+	patterns := []string{
+		"/*",
+		"/user/*",
+		"/user/{name}",
+		"/user/{name}/*",
+		"/user/settings",
+		"/user/{name}/posts",
+		"/user/admin/*",
+		"/static/a*",
+		"/static/*",
+	}
+	tests := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{"GET", "/", "/*"},
+		{"GET", "/other/path", "/*"},
+		{"GET", "/user", "/*"},
+		{"GET", "/user/", "/user/*"},
+		{"GET", "/user/foo", "/user/{name}"},
+		{"GET", "/user/settings", "/user/settings"},
+		{"GET", "/user/foo/posts", "/user/{name}/posts"},
+		{"GET", "/user/foo/posts/1", "/user/{name}/*"},
+		{"GET", "/user/admin/posts", "/user/admin/*"},
+		{"GET", "/user/admin", "/user/{name}"},
+		{"GET", "/static/abc", "/static/a*"},
+		{"GET", "/static/bcd", "/static/*"},
+	}
+	// Registration order must not matter.
+	for _, reversed := range []bool{false, true} {
+		r := New()
+		for i := range patterns {
+			p := patterns[i]
+			if reversed {
+				p = patterns[len(patterns)-1-i]
+			}
+			r.Handle("GET", p, nameHandler(p))
+		}
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if test.route != w.Body.String() {
+				t.Errorf("unexpected route for %s (reversed: %v): %s, want: %s",
+					test.path, reversed, w.Body.String(), test.route)
+			}
+		}
+	}
+}
+
+func TestPrecedenceMethod(t *testing.T) {
+	r := New()
+	r.Handle("*", "/user", nameHandler("any"))
+	r.Handle("GET", "/user", nameHandler("get"))
+	r.Handle("POST", "/user", nameHandler("post"))
+	tests := map[string]string{
+		"GET":    "get",
+		"POST":   "post",
+		"DELETE": "any",
+	}
+	for method, route := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/user", nil))
+		if route != w.Body.String() {
+			t.Errorf("unexpected route for %s: %s, want: %s", method, w.Body.String(), route)
+		}
+	}
+}
+
+func TestConflict(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{name}", nameHandler("first"))
+	r.Handle("GET", "/user/{name}", nameHandler("second"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/user/foo", nil))
+	if "first" != w.Body.String() {
+		t.Fatalf("unexpected route: %s", w.Body.String())
+	}
+	if len(r.Endpoints()) != 1 {
+		t.Fatalf("unexpected endpoints: %v", r.Endpoints())
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	r := New(WithPathPrefix("/app"))
+	r.Handle("GET", "/*", nameHandler(""))
+	r.Handle("GET", "/{name}", nameHandler(""))
+	r.Handle("GET", "/index", nameHandler(""))
+	endpoints := r.Endpoints()
+	expected := []string{
+		"GET     /app/index (router.nameHandler)",
+		"GET     /app/{name} (router.nameHandler)",
+		"GET     /app/* (router.nameHandler)",
+	}
+	if strings.Join(expected, "\n") != strings.Join(endpoints, "\n") {
+		t.Fatalf("unexpected endpoints: %q", endpoints)
+	}
+}
+
+type deprecatedHandler struct {
+	nameHandler
+}
+
+func (deprecatedHandler) Deprecated() *core.Deprecation {
+	return &core.Deprecation{Link: "/v2/users"}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	tracker := core.NewDeprecationTracker()
+	r := New(WithPathPrefix("/app"), WithDeprecationTracker(tracker))
+	r.Handle("GET", "/v1/users/{id:int}", deprecatedHandler{"v1"})
+	r.Handle("GET", "/v2/users/{id:int}", nameHandler("v2"))
+	w := serve(r, "/app/v1/users/1")
+	if w.Code != http.StatusOK || w.Body.String() != "v1" || w.Header().Get("Deprecation") != "true" ||
+		w.Header().Get("Link") != `</v2/users>; rel="successor-version"` {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	// Invalid parameters are not counted.
+	serve(r, "/app/v1/users/x")
+	if w = serve(r, "/app/v2/users/1"); w.Header().Get("Deprecation") != "" {
+		t.Fatalf("unexpected header: %v", w.Header())
+	}
+	if usage := tracker.Usage(); len(usage) != 1 || usage["GET /app/v1/users/{id:int}"] != 1 {
+		t.Fatalf("unexpected usage: %v", usage)
+	}
+	routes := r.Routes()
+	if routes[0].Deprecation == nil || routes[0].Deprecation.Link != "/v2/users" || routes[1].Deprecation != nil {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+	expected := []string{
+		"GET     /app/v1/users/{id:int} (router.deprecatedHandler) [deprecated]",
+		"GET     /app/v2/users/{id:int} (router.nameHandler)",
+	}
+	if endpoints := r.Endpoints(); strings.Join(expected, "\n") != strings.Join(endpoints, "\n") {
+		t.Fatalf("unexpected endpoints: %q", endpoints)
+	}
+}
+
+func TestPathParams(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{name}/posts/{id:int}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := PathParams(r)
+		fmt.Fprintf(w, "%s %s %s %d", PathParam(r, "name"), params["name"], params["id"], PathInt(r, "id"))
+	}))
+	r.Handle("GET", "/files/{path:.+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s", PathParam(r, "path"))
+	}))
+	r.Handle("GET", "/doc/{name}.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %v", PathParam(r, "name"), PathParams(r))
+	}))
+	r.Handle("GET", "/static", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%v %q", PathParams(r) == nil, PathParam(r, "name"))
+	}))
+	tests := map[string]string{
+		"/user/bob/posts/12": "bob bob 12 12",
+		"/files/a/b/c.txt":   "a/b/c.txt",
+		"/doc/readme.json":   "readme map[name:readme]",
+		"/static":            `true ""`,
+	}
+	for path, body := range tests {
+		if w := serve(r, path); w.Code != http.StatusOK || w.Body.String() != body {
+			t.Fatalf("%s: unexpected response: %v %q, want: %q", path, w.Code, w.Body.String(), body)
+		}
+	}
+}
+
+func TestNestedRouterParams(t *testing.T) {
+	inner := New()
+	inner.Handle("GET", "/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q", PathParam(r, "tenant"))
+	}))
+	r := New()
+	r.Handle("GET", "/{tenant}/*", inner)
+	if w := serve(r, "/acme/users"); w.Body.String() != `""` {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	r := New()
+	r.Handle("POST", "/user", nameHandler("post"))
+	if w := serve(r, "/user"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+	if w := serve(r, "/other"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+}
+
+func TestHeadMethod(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{name}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Name", PathParam(r, "name"))
+		w.Write([]byte("get"))
+	}))
+	r.Handle("GET", "/group", nameHandler("get"))
+	r.Handle("HEAD", "/group", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Name", "head")
+	}))
+	r.Handle("POST", "/job", nameHandler("post"))
+
+	tests := []struct {
+		path   string
+		status int
+		name   string
+	}{
+		{"/user/a", http.StatusOK, "a"},
+		{"/group", http.StatusOK, "head"},
+		{"/job", http.StatusMethodNotAllowed, ""},
+	}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	for _, test := range tests {
+		resp, err := http.Head(server.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != test.status || resp.Header.Get("X-Name") != test.name || len(body) != 0 {
+			t.Fatalf("unexpected response %s: %d %v %q %v", test.path, resp.StatusCode, resp.Header, body, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	r := New(WithPathPrefix("/api"))
+	r.Handle("GET", "/users/{id:int}", http.NotFoundHandler())
+	r.Handle("POST", "/static/*", http.NotFoundHandler())
+	for p, expected := range map[string]bool{
+		"/users/1":     true,
+		"/users/a":     false,
+		"/users":       false,
+		"/static/a/b":  true,
+		"/api/users/1": false,
+	} {
+		if r.Match(p) != expected {
+			t.Fatalf("unexpected match %s: %v", p, !expected)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/", nameHandler("user"))
+	if w := serve(r, "/user/"); w.Body.String() != "user" {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+	w := serve(r, "/a/../user/./?q=1")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/user/?q=1" {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Header())
+	}
+}
+
+func benchmarkRouter(b *testing.B, pattern, path string) {
+	r := New()
+	r.Handle("GET", "/", nameHandler(""))
+	r.Handle("GET", "/static/*", nameHandler(""))
+	r.Handle("GET", pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		PathParam(r, "name")
+	}))
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkRouterNoParams(b *testing.B) {
+	benchmarkRouter(b, "/user/settings", "/user/settings")
+}
+
+func BenchmarkRouterTwoParams(b *testing.B) {
+	benchmarkRouter(b, "/user/{name}/posts/{id}", "/user/bob/posts/12")
+}
+
+func TestURLFor(t *testing.T) {
+	var paths []string
+	inner := New(WithPathPrefix("/api"))
+	inner.Handle("GET", "/users/{id:int}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, params := range [][]string{{"id", "1"}, {"id", "a b/c"}, {"name", "1"}, {"id"}} {
+			p, err := URLFor(r, "/users/{id:int}", params...)
+			if err != nil {
+				p = "error"
+			}
+			paths = append(paths, p)
+		}
+		p, err := AbsoluteURLFor(r, "/files/{name:.+}/raw", "name", "a.txt")
+		if err != nil {
+			p = err.Error()
+		}
+		paths = append(paths, p)
+		if _, err := URLFor(r, "/static/*"); err == nil {
+			t.Errorf("error expected for wildcard pattern")
+		}
+	}))
+	outer := New(WithPathPrefix("/application"))
+	outer.Handle("*", "/api/*", inner)
+	outer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/application/api/users/2", nil))
+	expected := []string{"/application/api/users/1", "/application/api/users/a%20b%2Fc", "error", "error",
+		"http://example.com/application/api/files/a.txt/raw"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+}
+
+type writeFilter string
+
+func (f writeFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(f))
+	filter.Continue(w, r)
+}
+
+func TestNamedFilters(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/", nameHandler("/"))
+	if err := r.AddNamedFilter("log", writeFilter("L")); err != nil {
+		t.Fatal(err)
+	}
+	r.AddFilter(writeFilter("1"))
+	if err := r.AddNamedFilter("recovery", writeFilter("R")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertFilterBefore("log", writeFilter("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertFilterAfter("recovery", writeFilter("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertFilterAfter(dispatcherName, writeFilter("x")); err == nil {
+		t.Fatal("error expected when inserting after dispatcher")
+	}
+	if r.RemoveFilter(dispatcherName) {
+		t.Fatal("dispatcher must not be removed")
+	}
+	if !r.RemoveFilter("log") {
+		t.Fatal("log filter expected to be removed")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "a1Rb/" {
+		t.Fatalf("unexpected body: %v", w.Body.String())
+	}
+}
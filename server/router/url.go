@@ -0,0 +1,104 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var basePathContextKey = &contextKey{"basePath"}
+
+// BasePath returns the path prefixes which routers have stripped from the
+// request path, e.g. the application context path.
+func BasePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathContextKey).(string)
+	return p
+}
+
+func withBasePath(r *http.Request, prefix string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), basePathContextKey, BasePath(r)+prefix))
+}
+
+// BaseURL returns the absolute URL of the root of the router serving the
+// request. Scheme is https for TLS connectors and host is the request host,
+// both are taken from X-Forwarded-Proto and X-Forwarded-Host when they are
+// trusted by the forwarded headers filter.
+func BaseURL(r *http.Request) *url.URL {
+	scheme := r.URL.Scheme
+	if scheme != "http" && scheme != "https" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return &url.URL{
+		Scheme: scheme,
+		Host:   r.Host,
+		Path:   BasePath(r),
+	}
+}
+
+// URLFor returns the path of the route pattern, including the base path,
+// with its parameters filled and escaped. params are pairs of parameter
+// names and values, e.g. URLFor(r, "/users/{id:int}", "id", "1").
+func URLFor(r *http.Request, pattern string, params ...string) (string, error) {
+	p, err := fillPattern(pattern, params)
+	if err != nil {
+		return "", err
+	}
+	return BasePath(r) + p, nil
+}
+
+// AbsoluteURLFor is URLFor returning an absolute URL based on BaseURL.
+func AbsoluteURLFor(r *http.Request, pattern string, params ...string) (string, error) {
+	p, err := fillPattern(pattern, params)
+	if err != nil {
+		return "", err
+	}
+	u := BaseURL(r)
+	return u.Scheme + "://" + u.Host + u.Path + p, nil
+}
+
+func fillPattern(pattern string, params []string) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("router: odd number of parameters for %s", pattern)
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return "", fmt.Errorf("router: wildcard pattern %s is not supported", pattern)
+	}
+	var buf strings.Builder
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			break
+		}
+		end := closingBrace(pattern, start)
+		if end < 0 {
+			return "", fmt.Errorf("router: invalid pattern %s", pattern)
+		}
+		buf.WriteString(pattern[:start])
+		name := pattern[start+1 : end]
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name = name[:i]
+		}
+		value, ok := paramValue(params, name)
+		if !ok {
+			return "", fmt.Errorf("router: missing parameter %s", name)
+		}
+		buf.WriteString(url.PathEscape(value))
+		pattern = pattern[end+1:]
+	}
+	buf.WriteString(pattern)
+	return buf.String(), nil
+}
+
+func paramValue(params []string, name string) (string, bool) {
+	for i := 0; i < len(params); i += 2 {
+		if params[i] == name {
+			return params[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+	"github.com/goburrow/melon/server/router"
+)
+
+const defaultProxyTimeout = 30 * time.Second
+
+// RoutesConfiguration contains static routes of the application, e.g. for
+// redirecting or proxying legacy paths during migrations.
+type RoutesConfiguration struct {
+	Redirects []RedirectConfiguration
+	Proxies   []ProxyConfiguration
+}
+
+// Build registers redirect and proxy routes to the application router.
+func (f *RoutesConfiguration) Build(env *core.Environment, handler *router.Router) error {
+	for i := range f.Redirects {
+		if err := f.Redirects[i].register(handler); err != nil {
+			return err
+		}
+	}
+	for i := range f.Proxies {
+		if err := f.Proxies[i].register(env, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedirectConfiguration redirects either an exact Path or all paths under
+// Prefix to Target.
+type RedirectConfiguration struct {
+	Path   string
+	Prefix string
+	// Target is the redirect location. For Prefix, the remaining path of
+	// the request is appended.
+	Target string `valid:"notempty"`
+	// Status is either 301 (default), 302 or 307.
+	Status int
+	// PreserveQuery appends query string of the request to Target.
+	PreserveQuery bool
+}
+
+func (f *RedirectConfiguration) register(handler *router.Router) error {
+	if (f.Path == "") == (f.Prefix == "") {
+		return fmt.Errorf("server: redirect to %s must have either path or prefix", f.Target)
+	}
+	status := f.Status
+	switch status {
+	case 0:
+		status = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect:
+	default:
+		return fmt.Errorf("server: unsupported redirect status %d", status)
+	}
+	h := &redirectHandler{
+		target:        f.Target,
+		status:        status,
+		preserveQuery: f.PreserveQuery,
+	}
+	if f.Path != "" {
+		handler.Handle("*", f.Path, h)
+		return nil
+	}
+	h.prefix = strings.TrimSuffix(f.Prefix, "/")
+	handler.Handle("*", h.prefix, h)
+	handler.Handle("*", h.prefix+"/*", h)
+	return nil
+}
+
+// redirectHandler redirects requests to the target.
+type redirectHandler struct {
+	// prefix is removed from the request path and the rest is appended to
+	// target. It is empty for exact paths.
+	prefix        string
+	target        string
+	status        int
+	preserveQuery bool
+}
+
+func (h *redirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	location := h.target
+	if h.prefix != "" {
+		rest := strings.TrimPrefix(r.URL.Path, h.prefix)
+		if rest != "" {
+			location = strings.TrimSuffix(location, "/") + rest
+		}
+	}
+	if h.preserveQuery && r.URL.RawQuery != "" {
+		if strings.Contains(location, "?") {
+			location += "&" + r.URL.RawQuery
+		} else {
+			location += "?" + r.URL.RawQuery
+		}
+	}
+	http.Redirect(w, r, location, h.status)
+}
+
+// ProxyConfiguration forwards all requests under Prefix to Upstream.
+type ProxyConfiguration struct {
+	Prefix   string `valid:"notempty"`
+	Upstream string `valid:"notempty"`
+	// StripPrefix removes Prefix from the path sent to Upstream.
+	StripPrefix bool
+	// Timeout is the maximum duration of a proxied request, 30s by default.
+	Timeout string
+	// PassHeaders are the request headers sent to Upstream.
+	// All headers are sent when it is empty.
+	PassHeaders []string
+	// Headers overrides request headers sent to Upstream.
+	Headers map[string]string
+	// HealthCheckPath is requested to check health of Upstream.
+	// Health check is not registered when it is empty.
+	HealthCheckPath string
+	// TLS configures connections to Upstream, e.g. for a private CA or
+	// client certificates.
+	TLS *TLSClientConfiguration
+}
+
+func (f *ProxyConfiguration) register(env *core.Environment, handler *router.Router) error {
+	p, err := f.build()
+	if err != nil {
+		return err
+	}
+	handler.Handle("*", p.prefix, p)
+	handler.Handle("*", p.prefix+"/*", p)
+	env.Lifecycle.Manage(p)
+	if f.HealthCheckPath != "" {
+		env.Admin.HealthChecks.Register("proxy "+p.prefix, &upstreamHealthCheck{
+			url:    strings.TrimSuffix(f.Upstream, "/") + f.HealthCheckPath,
+			client: &http.Client{Transport: p.transport, Timeout: p.timeout},
+		})
+	}
+	return nil
+}
+
+func (f *ProxyConfiguration) build() (*proxyHandler, error) {
+	target, err := url.Parse(f.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid proxy upstream %s: %v", f.Upstream, err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("server: invalid proxy upstream %s", f.Upstream)
+	}
+	timeout := defaultProxyTimeout
+	if f.Timeout != "" {
+		timeout, err = time.ParseDuration(f.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid proxy timeout: %v", err)
+		}
+	}
+	h := &proxyHandler{
+		prefix:    strings.TrimSuffix(f.Prefix, "/"),
+		timeout:   timeout,
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	if f.TLS != nil {
+		h.transport.TLSClientConfig, err = f.TLS.Build()
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid proxy TLS of %s: %v", f.Upstream, err)
+		}
+	}
+	var passHeaders map[string]bool
+	if len(f.PassHeaders) > 0 {
+		passHeaders = make(map[string]bool, len(f.PassHeaders))
+		for _, k := range f.PassHeaders {
+			passHeaders[http.CanonicalHeaderKey(k)] = true
+		}
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		if f.StripPrefix {
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, h.prefix)
+			r.URL.RawPath = ""
+		}
+		director(r)
+		if passHeaders != nil {
+			for k := range r.Header {
+				if !passHeaders[k] {
+					delete(r.Header, k)
+				}
+			}
+		}
+		for k, v := range f.Headers {
+			if http.CanonicalHeaderKey(k) == "Host" {
+				r.Host = v
+			} else {
+				r.Header.Set(k, v)
+			}
+		}
+	}
+	proxy.Transport = h.transport
+	proxy.ErrorHandler = h.serveError
+	h.proxy = proxy
+	return h, nil
+}
+
+// proxyHandler forwards requests to an upstream server.
+type proxyHandler struct {
+	prefix    string
+	timeout   time.Duration
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	h.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// serveError responds 504 when upstream does not respond in time and 502
+// for other errors.
+func (h *proxyHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	if r.Context().Err() == context.DeadlineExceeded {
+		status = http.StatusGatewayTimeout
+	}
+	logger().Warnf("proxy %s %s: %v", r.Method, r.URL, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// Start does nothing.
+func (h *proxyHandler) Start() error {
+	return nil
+}
+
+// Stop closes idle connections to the upstream.
+func (h *proxyHandler) Stop() error {
+	h.transport.CloseIdleConnections()
+	return nil
+}
+
+// upstreamHealthCheck is healthy when the upstream responds with status 2xx.
+type upstreamHealthCheck struct {
+	url    string
+	client *http.Client
+}
+
+func (c *upstreamHealthCheck) Check() health.Result {
+	rsp, err := c.client.Get(c.url)
+	if err != nil {
+		return health.ResultUnhealthy("upstream is unavailable", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return health.ResultUnhealthy(fmt.Sprintf("upstream responded %s", rsp.Status), nil)
+	}
+	return health.Healthy
+}
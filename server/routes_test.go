@@ -0,0 +1,135 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+)
+
+func TestRedirectRoutes(t *testing.T) {
+	config := RoutesConfiguration{
+		Redirects: []RedirectConfiguration{
+			{Path: "/old", Target: "/new"},
+			{Path: "/temp", Target: "/elsewhere?a=1", Status: http.StatusTemporaryRedirect, PreserveQuery: true},
+			{Prefix: "/docs/", Target: "https://example.com/documents", Status: http.StatusFound},
+		},
+	}
+	handler := router.New()
+	if err := config.Build(core.NewEnvironment(), handler); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/old?x=1", http.StatusMovedPermanently, "/new"},
+		{"/temp?b=2", http.StatusTemporaryRedirect, "/elsewhere?a=1&b=2"},
+		{"/docs", http.StatusFound, "https://example.com/documents"},
+		{"/docs/api/v1", http.StatusFound, "https://example.com/documents/api/v1"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Fatalf("unexpected response of %s: %d %s", test.path, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestInvalidRedirectRoutes(t *testing.T) {
+	configs := []RedirectConfiguration{
+		{Target: "/new"},
+		{Path: "/a", Prefix: "/b", Target: "/new"},
+		{Path: "/a", Target: "/new", Status: http.StatusOK},
+	}
+	for _, c := range configs {
+		config := RoutesConfiguration{Redirects: []RedirectConfiguration{c}}
+		if err := config.Build(core.NewEnvironment(), router.New()); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Token", r.Header.Get("X-Token"))
+		w.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+		w.Write([]byte(r.Header.Get("X-Forwarded-Service")))
+	}))
+	defer upstream.Close()
+
+	config := RoutesConfiguration{
+		Proxies: []ProxyConfiguration{
+			{
+				Prefix:          "/legacy",
+				Upstream:        upstream.URL + "/api",
+				StripPrefix:     true,
+				Timeout:         "100ms",
+				PassHeaders:     []string{"X-Token"},
+				Headers:         map[string]string{"X-Forwarded-Service": "melon"},
+				HealthCheckPath: "/health",
+			},
+		},
+	}
+	env := core.NewEnvironment()
+	handler := router.New()
+	if err := config.Build(env, handler); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/legacy/users/1", nil)
+	r.Header.Set("X-Token", "abc")
+	r.Header.Set("Cookie", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "melon" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Path") != "/api/users/1" || w.Header().Get("X-Token") != "abc" || w.Header().Get("X-Cookie") != "" {
+		t.Fatalf("unexpected upstream request: %v", w.Header())
+	}
+	// Timeout
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/legacy/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	result, ok := env.Admin.HealthChecks.RunChecker("proxy /legacy")
+	if !ok || !result.Healthy() {
+		t.Fatalf("unexpected health check result: %#v", result)
+	}
+	// Upstream is down
+	upstream.Close()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/legacy", nil))
+	if w.Code != http.StatusBadGateway {
+		body, _ := ioutil.ReadAll(w.Body)
+		t.Fatalf("unexpected response: %d %s", w.Code, body)
+	}
+	result, ok = env.Admin.HealthChecks.RunChecker("proxy /legacy")
+	if !ok || result.Healthy() {
+		t.Fatalf("unexpected health check result: %#v", result)
+	}
+}
+
+func TestInvalidProxyRoutes(t *testing.T) {
+	configs := []ProxyConfiguration{
+		{Prefix: "/a", Upstream: "localhost"},
+		{Prefix: "/a", Upstream: "http://localhost", Timeout: "1"},
+	}
+	for _, c := range configs {
+		config := RoutesConfiguration{Proxies: []ProxyConfiguration{c}}
+		if err := config.Build(core.NewEnvironment(), router.New()); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/health"
+)
+
+const (
+	defaultAdminSelfCheckTimeout  = time.Second
+	defaultAdminSelfCheckFailures = 3
+
+	// adminPingPath is the path of the admin ping handler.
+	adminPingPath = "/ping"
+	// AdminSelfCheckName is the name of the fatal health check registered by
+	// AdminSelfCheckConfiguration.
+	AdminSelfCheckName = "admin-self-check"
+	// adminSelfCheckFailuresHeader is set in responses of the health endpoint.
+	adminSelfCheckFailuresHeader = "X-Admin-Self-Check-Failures"
+)
+
+// AdminSelfCheckConfiguration periodically requests admin /ping in process,
+// through the admin filters and router, to detect a wedged admin port while
+// the application port still serves. No connection is made so the check also
+// works when admin connectors are the broken part.
+// It is disabled when Interval is empty.
+type AdminSelfCheckConfiguration struct {
+	Interval string
+	// Timeout is the deadline of each request, 1s by default.
+	Timeout string
+	// Failures is the number of consecutive failed requests after which the
+	// admin port is considered unhealthy, 3 by default.
+	Failures int
+	// FatalGrace registers a fatal health check, which shuts down the
+	// application when the admin port stays unhealthy longer than it.
+	FatalGrace string
+}
+
+// Build creates and manages the self check requesting the path of handler.
+// It returns nil when the self check is disabled.
+func (f *AdminSelfCheckConfiguration) Build(env *core.Environment, handler http.Handler, path string) (*adminSelfCheck, error) {
+	if f.Interval == "" {
+		return nil, nil
+	}
+	c := &adminSelfCheck{
+		handler:     handler,
+		path:        path,
+		timeout:     defaultAdminSelfCheckTimeout,
+		maxFailures: defaultAdminSelfCheckFailures,
+		clock:       env.GetClock(),
+		metric:      metrics.Counter("Admin.SelfCheck.Failures"),
+	}
+	var err error
+	c.interval, err = time.ParseDuration(f.Interval)
+	if err != nil || c.interval <= 0 {
+		return nil, fmt.Errorf("server: invalid admin self check interval %s", f.Interval)
+	}
+	if f.Timeout != "" {
+		c.timeout, err = time.ParseDuration(f.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid admin self check timeout: %v", err)
+		}
+	}
+	if f.Failures > 0 {
+		c.maxFailures = f.Failures
+	}
+	if f.FatalGrace != "" {
+		grace, err := time.ParseDuration(f.FatalGrace)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid admin self check fatal grace: %v", err)
+		}
+		env.HealthMonitor.RegisterFatal(AdminSelfCheckName, c, grace)
+	}
+	env.Lifecycle.Manage(c)
+	return c, nil
+}
+
+// adminSelfCheck requests the admin handler periodically.
+type adminSelfCheck struct {
+	handler     http.Handler
+	path        string
+	interval    time.Duration
+	timeout     time.Duration
+	maxFailures int
+	clock       core.Clock
+	metric      metrics.Counter
+
+	// failures is the number of consecutive failed requests.
+	failures int64
+	// blocked is set while a request has not returned. Another request is
+	// not sent until it returns.
+	blocked int32
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// Start runs the self check in the background.
+func (c *adminSelfCheck) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil {
+		c.done = make(chan struct{})
+		go c.run(c.done)
+	}
+	return nil
+}
+
+// Stop stops the self check.
+func (c *adminSelfCheck) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+	return nil
+}
+
+func (c *adminSelfCheck) run(done chan struct{}) {
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			c.check()
+		case <-done:
+			return
+		}
+	}
+}
+
+// check sends a request and updates the number of consecutive failures.
+func (c *adminSelfCheck) check() {
+	err := c.request()
+	if err == nil {
+		if n := atomic.SwapInt64(&c.failures, 0); n >= int64(c.maxFailures) {
+			logger().Infof("admin self check recovered after %d failures", n)
+		}
+		return
+	}
+	c.metric.Add()
+	n := atomic.AddInt64(&c.failures, 1)
+	if n == int64(c.maxFailures) {
+		logger().Errorf("ADMIN PORT IS NOT RESPONDING: %d consecutive requests to %s failed: %v", n, c.path, err)
+	} else {
+		logger().Warnf("admin self check %s failed: %v", c.path, err)
+	}
+}
+
+// request serves a request in process and waits for the response until
+// timeout. A blocked handler is left running and fails next requests until it
+// returns.
+func (c *adminSelfCheck) request() error {
+	if !atomic.CompareAndSwapInt32(&c.blocked, 0, 1) {
+		return errors.New("previous request has not returned")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, "GET", c.path, nil)
+	if err != nil {
+		atomic.StoreInt32(&c.blocked, 0)
+		return err
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+	w := &selfCheckResponse{header: make(http.Header)}
+	returned := make(chan struct{})
+	go func() {
+		defer atomic.StoreInt32(&c.blocked, 0)
+		defer close(returned)
+		c.handler.ServeHTTP(w, r)
+	}()
+	timer := c.clock.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case <-returned:
+	case <-timer.C():
+		return fmt.Errorf("no response in %v", c.timeout)
+	}
+	if w.status != 0 && w.status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", w.status)
+	}
+	return nil
+}
+
+// Failures returns the number of consecutive failed requests.
+func (c *adminSelfCheck) Failures() int64 {
+	return atomic.LoadInt64(&c.failures)
+}
+
+// Check is unhealthy when the number of consecutive failures reaches the limit.
+func (c *adminSelfCheck) Check() health.Result {
+	if n := c.Failures(); n >= int64(c.maxFailures) {
+		return health.ResultUnhealthy(fmt.Sprintf("admin port is not responding after %d requests", n), nil)
+	}
+	return health.Healthy
+}
+
+// selfCheckResponse discards the response body.
+type selfCheckResponse struct {
+	header http.Header
+	status int
+}
+
+func (w *selfCheckResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *selfCheckResponse) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *selfCheckResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
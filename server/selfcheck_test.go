@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/router"
+)
+
+func TestAdminSelfCheck(t *testing.T) {
+	env := core.NewEnvironment()
+	var blocking int32
+	release := make(chan struct{})
+	adminHandler := router.New()
+	adminHandler.AddFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&blocking) == 1 {
+			<-release
+		}
+		filter.Continue(w, r)
+	}))
+	adminHandler.Handle("GET", adminPingPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	config := AdminSelfCheckConfiguration{Interval: "1m", Timeout: "20ms", Failures: 2, FatalGrace: "1m"}
+	c, err := config.Build(env, adminHandler, adminPingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appHandler := router.New()
+	endpoint := HealthEndpointConfiguration{Path: "/health", MaxAge: "0s"}
+	if err = endpoint.build(env, appHandler, c); err != nil {
+		t.Fatal(err)
+	}
+	health := func(status int, failures string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		appHandler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		if w.Code != status || w.Header().Get(adminSelfCheckFailuresHeader) != failures {
+			t.Fatalf("unexpected health response: %d %v", w.Code, w.Header())
+		}
+	}
+	c.check()
+	health(http.StatusOK, "0")
+
+	atomic.StoreInt32(&blocking, 1)
+	c.check()
+	if c.Failures() != 1 || !selfCheckHealthy(env) {
+		t.Fatalf("unexpected failures: %d", c.Failures())
+	}
+	// The blocked request has not returned.
+	c.check()
+	if c.Failures() != 2 || selfCheckHealthy(env) {
+		t.Fatalf("unexpected failures: %d", c.Failures())
+	}
+	health(http.StatusServiceUnavailable, "2")
+
+	atomic.StoreInt32(&blocking, 0)
+	close(release)
+	for i := 0; atomic.LoadInt32(&c.blocked) == 1; i++ {
+		if i > 100 {
+			t.Fatalf("blocked request has not returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.check()
+	if c.Failures() != 0 || !selfCheckHealthy(env) {
+		t.Fatalf("unexpected failures: %d", c.Failures())
+	}
+	health(http.StatusOK, "0")
+}
+
+func selfCheckHealthy(env *core.Environment) bool {
+	result, ok := env.Admin.HealthChecks.RunChecker(AdminSelfCheckName)
+	return ok && result.Healthy()
+}
+
+func TestInvalidAdminSelfCheck(t *testing.T) {
+	configs := []AdminSelfCheckConfiguration{
+		{Interval: "1"},
+		{Interval: "-1s"},
+		{Interval: "1s", Timeout: "1"},
+		{Interval: "1s", FatalGrace: "1"},
+	}
+	for _, c := range configs {
+		if _, err := c.Build(core.NewEnvironment(), http.NotFoundHandler(), adminPingPath); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+	c, err := (&AdminSelfCheckConfiguration{}).Build(core.NewEnvironment(), http.NotFoundHandler(), adminPingPath)
+	if c != nil || err != nil {
+		t.Fatalf("unexpected self check: %v %v", c, err)
+	}
+}
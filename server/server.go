@@ -6,18 +6,22 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/goburrow/dynamic"
 	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/conncache"
 )
 
 func init() {
 	dynamic.Register("DefaultServer", func() interface{} {
-		return newDefaultFactory()
+		return NewDefaultFactory()
 	})
 	dynamic.Register("SimpleServer", func() interface{} {
 		return newSimpleFactory()
@@ -26,22 +30,107 @@ func init() {
 
 // Connector represents http server configuration.
 type Connector struct {
+	// Type is http, https or unix.
 	Type string `valid:"notempty"`
+	// Addr is the TCP address or the socket path of unix connectors.
 	Addr string
 
+	// CertFile and KeyFile are the certificate and private key of https
+	// connectors.
 	CertFile string
 	KeyFile  string
+	// MinVersion is the minimum TLS version of https connectors: 1.0, 1.1,
+	// 1.2 (default) or 1.3.
+	MinVersion string
+	// CipherSuites limits cipher suites of TLS 1.0-1.2 to the given names,
+	// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go defaults are used if it
+	// is empty. Insecure cipher suites, see tls.InsecureCipherSuites, are not
+	// allowed.
+	CipherSuites []string
+	// ClientAuth is the policy of client certificates of https connectors:
+	// none (default), request or require-and-verify. Requested certificates
+	// are verified if they are given and ClientCAFile is set.
+	ClientAuth string
+	// ClientCAFile contains PEM encoded certificates of the authorities
+	// signing client certificates. It is required by require-and-verify.
+	ClientCAFile string
+
+	// SocketMode is the octal file mode of the socket of unix connectors,
+	// e.g. 0660.
+	SocketMode string
+	// SocketOwner is user[:group] owning the socket of unix connectors.
+	SocketOwner string
+
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout are
+	// durations of reading requests and writing responses, e.g. 30s, see
+	// http.Server. ReadHeaderTimeout is 30s by default and others are not
+	// limited unless they are set.
+	ReadTimeout       string
+	ReadHeaderTimeout string
+	WriteTimeout      string
+	IdleTimeout       string
+	// MaxHeaderBytes limits size of request headers, 1MB by default.
+	MaxHeaderBytes int `valid:"min=0"`
+
+	// ConnectionCache memoizes parsed Accept headers and authenticated
+	// principals per connection, see package conncache.
+	ConnectionCache bool
 }
 
+// defaultReadHeaderTimeout protects connectors from clients which send
+// request headers slowly.
+const defaultReadHeaderTimeout = 30 * time.Second
+
+// Validate checks timeouts of the connector.
+func (c *Connector) Validate() error {
+	for _, t := range c.timeouts(&http.Server{}) {
+		if err := core.ValidateDuration(t.name, *t.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type connectorTimeout struct {
+	name  string
+	value *string
+	field *time.Duration
+}
+
+// timeouts returns timeout settings of the connector and fields of srv they
+// are applied to.
+func (c *Connector) timeouts(s *http.Server) []connectorTimeout {
+	return []connectorTimeout{
+		{"ReadTimeout", &c.ReadTimeout, &s.ReadTimeout},
+		{"ReadHeaderTimeout", &c.ReadHeaderTimeout, &s.ReadHeaderTimeout},
+		{"WriteTimeout", &c.WriteTimeout, &s.WriteTimeout},
+		{"IdleTimeout", &c.IdleTimeout, &s.IdleTimeout},
+	}
+}
+
+// defaultShutdownTimeout is the maximum duration of draining requests when
+// the server stops.
+const defaultShutdownTimeout = 30 * time.Second
+
 // server implements core.Managed interface. Each server can have multiple
 // connectors (listeners).
 type server struct {
 	connectors []*http.Server
+	// sockets contains sockets of unix connectors.
+	sockets map[*http.Server]*unixSocket
+	// shutdownTimeout limits draining in Stop.
+	shutdownTimeout time.Duration
+
+	mu sync.Mutex
+	// active contains connections processing requests.
+	active map[net.Conn]struct{}
 }
 
 // newServer allocates and returns a new Server.
 func newServer() *server {
-	return &server{}
+	return &server{
+		shutdownTimeout: defaultShutdownTimeout,
+	}
 }
 
 // Start starts all connectors of the server.
@@ -55,7 +144,12 @@ func (s *server) Start() error {
 			defer wg.Done()
 			logger().Infof("listening %s", srv.Addr)
 			var err error
-			if srv.TLSConfig == nil {
+			if socket, ok := s.sockets[srv]; ok {
+				var l net.Listener
+				if l, err = socket.listen(); err == nil {
+					err = srv.Serve(l)
+				}
+			} else if srv.TLSConfig == nil {
 				err = srv.ListenAndServe()
 			} else {
 				err = srv.ListenAndServeTLS("", "")
@@ -70,15 +164,54 @@ func (s *server) Start() error {
 	return nil
 }
 
-// Stop stops all running connectors of the server.
+// Stop stops all running connectors of the server, waiting for in-flight
+// requests until the shutdown timeout. Remaining connections are then closed
+// and an error is returned. Socket files of unix connectors are removed when
+// their listeners are closed.
 func (s *server) Stop() error {
-	ctx, _ := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	var err error
 	for _, conn := range s.connectors {
-		conn.Shutdown(ctx)
+		if e := conn.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		s.mu.Lock()
+		n := len(s.active)
+		s.mu.Unlock()
+		s.Close()
+		return fmt.Errorf("server: abandoned %d requests: %v", n, err)
 	}
 	return nil
 }
 
+// Close closes all connectors and their connections immediately.
+func (s *server) Close() error {
+	var err error
+	for _, conn := range s.connectors {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// trackConnState records connections which are processing requests.
+func (s *server) trackConnState(conn net.Conn, state http.ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state == http.StateActive {
+		if s.active == nil {
+			s.active = make(map[net.Conn]struct{})
+		}
+		s.active[conn] = struct{}{}
+	} else {
+		delete(s.active, conn)
+	}
+}
+
 // addConnectors adds a new connector to the server.
 func (s *server) addConnectors(handler http.Handler, connectors []Connector) error {
 	for i := range connectors {
@@ -86,6 +219,17 @@ func (s *server) addConnectors(handler http.Handler, connectors []Connector) err
 		if err != nil {
 			return err
 		}
+		srv.ConnState = s.trackConnState
+		if connectors[i].Type == "unix" {
+			socket, err := newUnixSocket(&connectors[i])
+			if err != nil {
+				return err
+			}
+			if s.sockets == nil {
+				s.sockets = make(map[*http.Server]*unixSocket)
+			}
+			s.sockets[srv] = socket
+		}
 		s.connectors = append(s.connectors, srv)
 	}
 	return nil
@@ -93,28 +237,134 @@ func (s *server) addConnectors(handler http.Handler, connectors []Connector) err
 
 func newHTTPServer(handler http.Handler, c *Connector) (*http.Server, error) {
 	httpServer := &http.Server{
-		Addr:    c.Addr,
-		Handler: handler,
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+	for _, t := range c.timeouts(httpServer) {
+		if *t.value == "" {
+			continue
+		}
+		d, err := core.ParseDuration(*t.value, 0)
+		if err != nil {
+			return nil, fmt.Errorf("server: invalid %s %s of connector %s", t.name, *t.value, c.Addr)
+		}
+		*t.field = d
+	}
+	if c.ConnectionCache {
+		httpServer.ConnContext = conncache.ConnContext
 	}
 	switch c.Type {
-	case "", "http":
+	case "", "http", "unix":
 		// Nothing to do
 	case "https":
-		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		config, err := c.tlsConfig()
 		if err != nil {
 			return nil, err
 		}
-		httpServer.TLSConfig = &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			Certificates: []tls.Certificate{cert},
-			NextProtos:   []string{"h2"},
-		}
+		httpServer.TLSConfig = config
 	default:
 		return nil, fmt.Errorf("unsupported connector type: %v", c.Type)
 	}
 	return httpServer, nil
 }
 
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfig loads the certificate and returns TLS configuration of the https
+// connector.
+func (c *Connector) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("server: could not load certificate of connector %s: %v", c.Addr, err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("server: unsupported TLS version %s of connector %s", c.MinVersion, c.Addr)
+		}
+		config.MinVersion = v
+	}
+	for _, name := range c.CipherSuites {
+		if isInsecureCipherSuite(name) {
+			return nil, fmt.Errorf("server: insecure cipher suite %s of connector %s", name, c.Addr)
+		}
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("server: unsupported cipher suite %s of connector %s", name, c.Addr)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("server: could not load client CA of connector %s: %v", c.Addr, err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("server: no certificates in client CA file %s of connector %s", c.ClientCAFile, c.Addr)
+		}
+	}
+	switch c.ClientAuth {
+	case "", "none":
+	case "request":
+		if config.ClientCAs != nil {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			config.ClientAuth = tls.RequestClientCert
+		}
+	case "require-and-verify":
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("server: client CA file is required by client auth %s of connector %s", c.ClientAuth, c.Addr)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("server: unsupported client auth %s of connector %s", c.ClientAuth, c.Addr)
+	}
+	return config, nil
+}
+
+// ClientCertificate returns the verified certificate of the client of request
+// r, or nil if the connection is not TLS or the client certificate is not
+// given or verified, see Connector.ClientAuth.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// cipherSuite returns ID of the named secure cipher suite.
+func cipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+// isInsecureCipherSuite returns true if name is one of tls.InsecureCipherSuites.
+func isInsecureCipherSuite(name string) bool {
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Factory is an union of DefaultFactory and SimpleFactory.
 type Factory struct {
 	dynamic.Type
@@ -1,7 +1,23 @@
 package server
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/goburrow/melon/core"
 )
@@ -36,3 +52,398 @@ func TestInvalidFactory(t *testing.T) {
 		t.Fatal("error expected")
 	}
 }
+
+func TestServerShutdownTimeout(t *testing.T) {
+	factory := newCommonFactory()
+	factory.ShutdownTimeout = "50ms"
+	s, err := factory.newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	srv.ConnState = s.trackConnState
+	s.connectors = append(s.connectors, srv)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	requestErr := make(chan error, 1)
+	go func() {
+		_, err := http.Get("http://" + l.Addr().String())
+		requestErr <- err
+	}()
+	<-started
+	start := time.Now()
+	err = s.Stop()
+	if err == nil || !strings.Contains(err.Error(), "abandoned 1 requests") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("unexpected shutdown duration: %v", elapsed)
+	}
+	// Remaining connection is closed.
+	select {
+	case err = <-requestErr:
+		if err == nil {
+			t.Fatal("error expected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not closed")
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	addr := freeAddr(t)
+	s := newServer()
+	started := make(chan struct{})
+	err := s.addConnectors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}), []Connector{{Type: "http", Addr: addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		var err error
+		for i := 0; i < 50; i++ {
+			var res *http.Response
+			if res, err = http.Get("http://" + addr); err == nil {
+				body, _ := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				resCh <- result{res.StatusCode, string(body), nil}
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		resCh <- result{err: err}
+	}()
+	<-started
+	if err = s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	res := <-resCh
+	if res.err != nil || res.status != http.StatusOK || res.body != "done" {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+	// New connections are refused.
+	if _, err = http.Get("http://" + addr); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestInvalidShutdownTimeout(t *testing.T) {
+	for _, timeout := range []string{"1", "-1s"} {
+		factory := newCommonFactory()
+		factory.ShutdownTimeout = timeout
+		if _, err := factory.newServer(); err == nil {
+			t.Fatalf("error expected for %s", timeout)
+		}
+	}
+	factory := newCommonFactory()
+	s, err := factory.newServer()
+	if err != nil || s.shutdownTimeout != defaultShutdownTimeout {
+		t.Fatalf("unexpected server: %+v %v", s, err)
+	}
+}
+
+// writeSelfSignedCert writes a self-signed certificate for localhost and
+// returns paths of the certificate and key.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+// freeAddr returns a local address which is currently free.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestHTTPSConnector(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, tempDir(t))
+	httpsAddr, httpAddr := freeAddr(t), freeAddr(t)
+	s := newServer()
+	err := s.addConnectors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Write([]byte("https"))
+		} else {
+			w.Write([]byte("http"))
+		}
+	}), []Connector{
+		{Type: "https", Addr: httpsAddr, CertFile: certFile, KeyFile: keyFile,
+			MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}},
+		{Type: "http", Addr: httpAddr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12},
+	}}
+	get := func(url string) string {
+		var err error
+		for i := 0; i < 50; i++ {
+			var res *http.Response
+			if res, err = client.Get(url); err == nil {
+				defer res.Body.Close()
+				body, _ := ioutil.ReadAll(res.Body)
+				if res.TLS != nil && res.TLS.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+					t.Fatalf("unexpected cipher suite: %v", tls.CipherSuiteName(res.TLS.CipherSuite))
+				}
+				return string(body)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal(err)
+		return ""
+	}
+	if body := get("https://" + httpsAddr); body != "https" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	if body := get("http://" + httpAddr); body != "http" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
+
+func TestInvalidHTTPSConnector(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+	connectors := []Connector{
+		{Type: "https", Addr: ":8443", CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_UNKNOWN"}},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, ClientAuth: "require-and-verify"},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, ClientAuth: "always"},
+		{Type: "https", Addr: ":8443", CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile},
+		{Type: "spdy", Addr: ":8443"},
+	}
+	for _, c := range connectors {
+		if _, err := newHTTPServer(http.NotFoundHandler(), &c); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+	_, err := newHTTPServer(http.NotFoundHandler(), &connectors[0])
+	if err == nil || !strings.HasPrefix(err.Error(), "server: could not load certificate of connector :8443: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = newHTTPServer(http.NotFoundHandler(), &connectors[3])
+	if err == nil || err.Error() != "server: insecure cipher suite TLS_RSA_WITH_RC4_128_SHA of connector :8443" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestClientAuthConnector(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(caFile, ca.pem, 0600)
+	clientCertFile, clientKeyFile := ca.writeClientCert(t, dir, "client1")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireAddr, requestAddr := freeAddr(t), freeAddr(t)
+	s := newServer()
+	err = s.addConnectors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert := ClientCertificate(r); cert != nil {
+			w.Write([]byte(cert.Subject.CommonName))
+		} else {
+			w.Write([]byte("anonymous"))
+		}
+	}), []Connector{
+		{Type: "https", Addr: requireAddr, CertFile: certFile, KeyFile: keyFile,
+			ClientAuth: "require-and-verify", ClientCAFile: caFile},
+		{Type: "https", Addr: requestAddr, CertFile: certFile, KeyFile: keyFile,
+			ClientAuth: "request", ClientCAFile: caFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	get := func(url string, certs ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs},
+		}}
+		var err error
+		for i := 0; i < 50; i++ {
+			var res *http.Response
+			if res, err = client.Get(url); err == nil {
+				defer res.Body.Close()
+				body, _ := ioutil.ReadAll(res.Body)
+				return string(body), nil
+			}
+			if !strings.Contains(err.Error(), "connection refused") {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return "", err
+	}
+	if body, err := get("https://"+requireAddr, clientCert); err != nil || body != "client1" {
+		t.Fatalf("unexpected response: %s %v", body, err)
+	}
+	if body, err := get("https://" + requireAddr); err == nil {
+		t.Fatalf("error expected without client certificate: %s", body)
+	}
+	if body, err := get("https://"+requestAddr, clientCert); err != nil || body != "client1" {
+		t.Fatalf("unexpected response: %s %v", body, err)
+	}
+	if body, err := get("https://" + requestAddr); err != nil || body != "anonymous" {
+		t.Fatalf("unexpected response: %s %v", body, err)
+	}
+}
+
+func TestUnixConnector(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "melon.sock")
+	// Stale socket file
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s := newServer()
+	err = s.addConnectors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unix"))
+	}), []Connector{
+		{Type: "unix", Addr: path, SocketMode: "0600"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	var res *http.Response
+	for i := 0; i < 50; i++ {
+		if res, err = client.Get("http://melon/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "unix" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	stat, err := os.Stat(path)
+	if err != nil || stat.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected socket file: %v %v", stat, err)
+	}
+	if err = s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file must be removed: %v", err)
+	}
+}
+
+func TestInvalidUnixConnector(t *testing.T) {
+	connectors := []Connector{
+		{Type: "unix"},
+		{Type: "unix", Addr: "melon.sock", SocketMode: "0999"},
+		{Type: "unix", Addr: "melon.sock", SocketOwner: "no-such-user-melon"},
+	}
+	for _, c := range connectors {
+		if err := newServer().addConnectors(http.NotFoundHandler(), []Connector{c}); err == nil {
+			t.Fatalf("error expected for %+v", c)
+		}
+	}
+}
+
+func TestConnectorTimeouts(t *testing.T) {
+	srv, err := newHTTPServer(http.NotFoundHandler(), &Connector{Addr: ":8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.ReadHeaderTimeout != defaultReadHeaderTimeout || srv.ReadTimeout != 0 ||
+		srv.WriteTimeout != 0 || srv.IdleTimeout != 0 || srv.MaxHeaderBytes != 0 {
+		t.Fatalf("unexpected server: %+v", srv)
+	}
+	c := Connector{
+		Addr:              ":8080",
+		ReadTimeout:       "10s",
+		ReadHeaderTimeout: "2s",
+		WriteTimeout:      "1m",
+		IdleTimeout:       "2m",
+		MaxHeaderBytes:    4096,
+	}
+	if err = c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	srv, err = newHTTPServer(http.NotFoundHandler(), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.ReadTimeout != 10*time.Second || srv.ReadHeaderTimeout != 2*time.Second ||
+		srv.WriteTimeout != time.Minute || srv.IdleTimeout != 2*time.Minute || srv.MaxHeaderBytes != 4096 {
+		t.Fatalf("unexpected server: %+v", srv)
+	}
+	c.WriteTimeout = "30"
+	if err = c.Validate(); err == nil || err.Error() != "WriteTimeout must be a non-negative duration: 30" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = newHTTPServer(http.NotFoundHandler(), &c); err == nil {
+		t.Fatalf("error expected")
+	}
+}
@@ -18,6 +18,7 @@ type SimpleFactory struct {
 
 func newSimpleFactory() *SimpleFactory {
 	return &SimpleFactory{
+		commonFactory:          newCommonFactory(),
 		ApplicationContextPath: "/application",
 		AdminContextPath:       "/admin",
 		Connector: Connector{
@@ -30,29 +31,53 @@ func newSimpleFactory() *SimpleFactory {
 // Build creates a new server listening on single port for both application and admin.
 func (factory *SimpleFactory) BuildServer(env *core.Environment) (core.Managed, error) {
 	// Both application and admin share same handler
-	appHandler := router.New(router.WithPathPrefix(factory.ApplicationContextPath))
+	appHandler := router.New(router.WithPathPrefix(factory.ApplicationContextPath),
+		router.WithDeprecationTracker(env.Server.Deprecations))
 	env.Server.Router = appHandler
 	env.Server.AddResourceHandler(newResourceHandler(appHandler))
 
 	adminHandler := router.New(router.WithPathPrefix(factory.AdminContextPath))
 	env.Admin.Router = adminHandler
-
-	return factory.buildServer(env, appHandler, adminHandler)
-}
-
-func (factory *SimpleFactory) buildServer(env *core.Environment, handlers ...*router.Router) (core.Managed, error) {
+	if err := factory.commonFactory.AddApplicationFilters(env, appHandler); err != nil {
+		return nil, err
+	}
+	// Compression is configured separately for application and admin.
+	if err := factory.commonFactory.AddGzipFilters(appHandler, adminHandler); err != nil {
+		return nil, err
+	}
+	if err := factory.commonFactory.AddCORSFilter(appHandler); err != nil {
+		return nil, err
+	}
+	factory.commonFactory.AddBodyLimitFilter(appHandler)
+	appHandler.AddFilter(newDrainFilter(env.Lifecycle))
+	err := factory.commonFactory.Routes.Build(env, appHandler)
+	if err != nil {
+		return nil, err
+	}
 	handler := router.New()
 	// Sub routers (e.g. /application and /admin)
-	for _, h := range handlers {
+	for _, h := range []*router.Router{appHandler, adminHandler} {
 		handler.Handle("*", h.PathPrefix()+"/*", h)
 		handler.Handle("*", h.PathPrefix(), http.RedirectHandler(h.PathPrefix()+"/", http.StatusMovedPermanently))
 	}
+	// Admin is checked through the filters of the root handler.
+	err = factory.commonFactory.buildHealth(env, appHandler, handler, adminHandler.PathPrefix()+adminPingPath)
+	if err != nil {
+		return nil, err
+	}
+	return factory.buildServer(env, handler)
+}
+
+func (factory *SimpleFactory) buildServer(env *core.Environment, handler *router.Router) (core.Managed, error) {
 	// Default filters are only needed in the root handler.
 	err := factory.commonFactory.AddFilters(env, handler)
 	if err != nil {
 		return nil, err
 	}
-	server := newServer()
+	server, err := factory.commonFactory.newServer()
+	if err != nil {
+		return nil, err
+	}
 	err = server.addConnectors(handler, []Connector{factory.Connector})
 	if err != nil {
 		return nil, err
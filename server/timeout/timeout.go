@@ -0,0 +1,169 @@
+/*
+Package timeout provides a filter limiting the duration of handling requests.
+
+Requests which have not started responding by the timeout are answered with
+status 503 and their context is cancelled, so that handlers respecting it stop
+waiting for slow downstream calls. Responses which have been started, e.g.
+streams, are not interrupted and their context stays alive.
+*/
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/filter"
+)
+
+const timeoutMessage = "Request timed out."
+
+// timeoutFilter runs handlers with a deadline.
+type timeoutFilter struct {
+	timeout time.Duration
+	clock   core.Clock
+}
+
+// Option is an option of the timeout Filter.
+type Option func(f *timeoutFilter)
+
+// WithClock sets the clock of timers, SystemClock by default.
+func WithClock(clock core.Clock) Option {
+	return func(f *timeoutFilter) {
+		f.clock = clock
+	}
+}
+
+// NewFilter returns a Filter which cancels the context of requests after
+// timeout and responds status 503 if handlers have not written responses.
+func NewFilter(timeout time.Duration, options ...Option) filter.Filter {
+	f := &timeoutFilter{
+		timeout: timeout,
+		clock:   core.SystemClock,
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+	return f
+}
+
+func (f *timeoutFilter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := f.clock.Now()
+	// The context is only cancelled when the timeout response is written,
+	// not when the handler has started responding in time.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+	timer := f.clock.NewTimer(f.timeout)
+	defer timer.Stop()
+	tw := &timeoutWriter{
+		w:      w,
+		header: make(http.Header),
+		timer:  timer,
+	}
+	// done receives the panic of the handler or nil.
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			done <- recover()
+		}()
+		filter.Continue(tw, r)
+	}()
+	var timedOut bool
+	select {
+	case p := <-done:
+		if p != nil {
+			panic(p)
+		}
+		return
+	case <-timer.C():
+		timedOut = true
+	case <-ctx.Done():
+		// Client has gone.
+	}
+	tw.mu.Lock()
+	if tw.wroteHeader {
+		// Let the handler finish its response.
+		tw.mu.Unlock()
+		if p := <-done; p != nil {
+			panic(p)
+		}
+		return
+	}
+	tw.timedOut = true
+	tw.mu.Unlock()
+	cancel()
+	if !timedOut {
+		return
+	}
+	logger().Warnf("request %s %s timed out after %v", r.Method, r.RequestURI, f.clock.Now().Sub(start))
+	http.Error(w, timeoutMessage, http.StatusServiceUnavailable)
+}
+
+// timeoutWriter discards responses written after the timeout. Headers are
+// kept separately until the response is written so that they do not race
+// with the timeout response.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	// timer is stopped when the response is started.
+	timer core.Timer
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut {
+		tw.writeHeader(code)
+	}
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.timer.Stop()
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func logger() core.Logger {
+	return core.GetLogger("melon/timeout")
+}
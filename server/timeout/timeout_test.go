@@ -0,0 +1,102 @@
+package timeout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/melontest"
+	"github.com/goburrow/melon/server/filter"
+)
+
+func serve(f filter.Filter, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	chain := filter.NewChain()
+	chain.Add(f, handler)
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, httptest.NewRequest("GET", "/slow?q=1", nil))
+	return w
+}
+
+func TestTimeout(t *testing.T) {
+	cancelled := make(chan error, 1)
+	w := serve(NewFilter(20*time.Millisecond), func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+		// Give the filter time to respond.
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("X-Late", "true")
+		w.Write([]byte("late"))
+	})
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != timeoutMessage+"\n" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body)
+	}
+	if err := <-cancelled; err == nil {
+		t.Fatal("context must be cancelled")
+	}
+	if w.Header().Get("X-Late") != "" {
+		t.Fatalf("unexpected header: %v", w.Header())
+	}
+}
+
+func TestTimeoutClock(t *testing.T) {
+	clock := melontest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	started := make(chan struct{})
+	go func() {
+		<-started
+		// Wait for the timer of the filter before advancing the clock.
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Add(time.Minute)
+	}()
+	w := serve(NewFilter(time.Minute, WithClock(clock)), func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != timeoutMessage+"\n" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestTimeoutNotExceeded(t *testing.T) {
+	w := serve(NewFilter(time.Second), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	})
+	if w.Code != http.StatusCreated || w.Body.String() != "ok" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected response: %d %q %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestTimeoutStartedResponse(t *testing.T) {
+	w := serve(NewFilter(20*time.Millisecond), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first "))
+		select {
+		case <-r.Context().Done():
+			t.Errorf("context of started response must not be cancelled: %v", r.Context().Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+		w.Write([]byte("second"))
+	})
+	if w.Code != http.StatusOK || w.Body.String() != "first second" {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	defer func() {
+		if p := recover(); p != "handler" {
+			t.Fatalf("unexpected panic: %v", p)
+		}
+	}()
+	serve(NewFilter(time.Second), func(w http.ResponseWriter, r *http.Request) {
+		panic("handler")
+	})
+	t.Fatal("panic expected")
+}
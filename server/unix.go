@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// unixSocket is the listening socket file of a unix connector.
+type unixSocket struct {
+	path string
+	// mode is not changed if it is zero.
+	mode os.FileMode
+	// uid and gid are not changed if they are -1.
+	uid int
+	gid int
+}
+
+func newUnixSocket(c *Connector) (*unixSocket, error) {
+	if c.Addr == "" {
+		return nil, fmt.Errorf("server: socket path is required for unix connector")
+	}
+	s := &unixSocket{
+		path: c.Addr,
+		uid:  -1,
+		gid:  -1,
+	}
+	if c.SocketMode != "" {
+		mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("server: invalid socket mode %s of connector %s", c.SocketMode, c.Addr)
+		}
+		s.mode = os.FileMode(mode)
+	}
+	if c.SocketOwner != "" {
+		var err error
+		if s.uid, s.gid, err = lookupOwner(c.SocketOwner); err != nil {
+			return nil, fmt.Errorf("server: invalid socket owner %s of connector %s: %v", c.SocketOwner, c.Addr, err)
+		}
+	}
+	return s, nil
+}
+
+// listen removes the socket file if nothing is listening on it, then listens
+// and sets permissions of the new socket file. The file is created with mode
+// where the platform allows, so it is not briefly accessible with the default
+// permissions. The file is removed when the listener is closed.
+func (s *unixSocket) listen() (net.Listener, error) {
+	if err := s.removeStale(); err != nil {
+		return nil, err
+	}
+	l, err := listenUnix(s.path, s.mode)
+	if err != nil {
+		return nil, err
+	}
+	if s.mode != 0 {
+		if err = os.Chmod(s.path, s.mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if s.uid != -1 || s.gid != -1 {
+		if err = os.Lchown(s.path, s.uid, s.gid); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// removeStale removes the socket file left by a process which did not exit
+// gracefully.
+func (s *unixSocket) removeStale() error {
+	stat, err := os.Lstat(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("server: %s is not a socket", s.path)
+	}
+	conn, err := net.Dial("unix", s.path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("server: socket %s is in use", s.path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	logger().Warnf("removing stale socket %s", s.path)
+	return os.Remove(s.path)
+}
+
+// lookupOwner returns user and group IDs of owner, which is user[:group] in
+// names or numeric IDs. Group is not changed if it is not given.
+func lookupOwner(owner string) (int, int, error) {
+	name, group := owner, ""
+	if i := strings.IndexByte(owner, ':'); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+	uid, gid := -1, -1
+	if name != "" {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return 0, 0, err
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, err
+			}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, err
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, err
+			}
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
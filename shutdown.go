@@ -0,0 +1,198 @@
+package melon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/metrics"
+)
+
+// ShutdownConfiguration configures reporting of the shutdown reason.
+type ShutdownConfiguration struct {
+	// StateFile is where the shutdown reason is saved so that it is logged
+	// at next startup. It is disabled when empty.
+	StateFile string
+}
+
+// shutdownConfigurable is implemented by configurations providing
+// ShutdownConfiguration, e.g. Configuration.
+type shutdownConfigurable interface {
+	ShutdownConfiguration() *ShutdownConfiguration
+}
+
+// ExitCodeFatalHealth is the exit code of ExitError returned when a fatal
+// health check stopped the application.
+const ExitCodeFatalHealth = 3
+
+// ExitError is returned by Run when the application stopped gracefully but
+// the process should exit with a distinct code, e.g.
+//
+//	if err, ok := err.(*melon.ExitError); ok {
+//		os.Exit(err.Code)
+//	}
+type ExitError struct {
+	Code   int
+	Reason core.ShutdownReason
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit %d: %s", e.Code, e.Reason)
+}
+
+// shutdownState is saved to the state file.
+type shutdownState struct {
+	Reason   core.ShutdownReason
+	Uptime   string
+	Requests uint64
+	Drained  bool
+	Error    string `json:",omitempty"`
+}
+
+// shutdownReporter logs why and how the application stopped.
+type shutdownReporter struct {
+	stateFile string
+	started   time.Time
+}
+
+func newShutdownReporter(config interface{}) *shutdownReporter {
+	r := &shutdownReporter{
+		started: time.Now(),
+	}
+	if c, ok := config.(shutdownConfigurable); ok {
+		r.stateFile = c.ShutdownConfiguration().StateFile
+	}
+	return r
+}
+
+// logPrevious logs the shutdown state of the previous run if available.
+func (r *shutdownReporter) logPrevious() {
+	if r.stateFile == "" {
+		return
+	}
+	state, err := readShutdownState(r.stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger().Warnf("could not read shutdown state %s: %v", r.stateFile, err)
+		}
+		return
+	}
+	logger().Infof("previous shutdown: reason=%q time=%s uptime=%s requests=%d drained=%t",
+		state.Reason.String(), state.Reason.Time.Format(time.RFC3339), state.Uptime, state.Requests, state.Drained)
+}
+
+// report logs the final shutdown line and saves it to the state file.
+// stopErr is the error returned when stopping the server.
+func (r *shutdownReporter) report(lifecycle *core.LifecycleEnvironment, stopErr error) {
+	reason, ok := lifecycle.ShutdownReason()
+	if !ok {
+		reason = core.ShutdownReason{
+			Trigger: core.ShutdownServerError,
+			Detail:  "server stopped",
+			Time:    time.Now(),
+		}
+	}
+	state := shutdownState{
+		Reason:   reason,
+		Uptime:   time.Since(r.started).String(),
+		Requests: metrics.RequestCount(),
+		Drained:  stopErr == nil,
+	}
+	if stopErr != nil {
+		state.Error = stopErr.Error()
+		logger().Warnf("shutdown complete: reason=%q uptime=%s requests=%d drained=false error=%q",
+			reason.String(), state.Uptime, state.Requests, state.Error)
+	} else {
+		logger().Infof("shutdown complete: reason=%q uptime=%s requests=%d drained=true",
+			reason.String(), state.Uptime, state.Requests)
+	}
+	if r.stateFile != "" {
+		if err := writeShutdownState(r.stateFile, &state); err != nil {
+			logger().Errorf("could not write shutdown state %s: %v", r.stateFile, err)
+		}
+	}
+}
+
+func readShutdownState(name string) (*shutdownState, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var state shutdownState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// writeShutdownState writes state to a temporary file then renames it so that
+// the state file is never partially written.
+func writeShutdownState(name string, state *shutdownState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// waitShutdown stops the server when a signal is received or shutdown is
+// requested via the lifecycle. Another signal while the server is draining
+// closes remaining connections immediately. The error of stopping server is
+// sent to the returned channel.
+func waitShutdown(sigCh <-chan os.Signal, done <-chan struct{}, lifecycle *core.LifecycleEnvironment, server core.Managed) <-chan error {
+	stopped := make(chan error, 1)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logger().Debugf("received signal %v", sig)
+			lifecycle.Shutdown(core.ShutdownSignal, sig.String())
+		case <-lifecycle.ShutdownRequested():
+		case <-done:
+			return
+		}
+		start := time.Now()
+		drained := make(chan error, 1)
+		go func() {
+			drained <- server.Stop()
+		}()
+		var err error
+		select {
+		case err = <-drained:
+		case sig := <-sigCh:
+			logger().Warnf("received signal %v while draining, closing connections", sig)
+			if c, ok := server.(interface{ Close() error }); ok {
+				c.Close()
+			}
+			if err = <-drained; err == nil {
+				err = fmt.Errorf("draining aborted by signal %v", sig)
+			}
+		}
+		lifecycle.RecordShutdown("server drain", time.Since(start))
+		if err != nil {
+			logger().Errorf("could not stop server: %v", err)
+		}
+		stopped <- err
+	}()
+	return stopped
+}
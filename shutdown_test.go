@@ -0,0 +1,160 @@
+package melon
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/core"
+)
+
+type stopManaged struct {
+	stopped chan struct{}
+}
+
+func (m *stopManaged) Start() error {
+	return nil
+}
+
+func (m *stopManaged) Stop() error {
+	close(m.stopped)
+	return nil
+}
+
+func waitStopped(t *testing.T, stopped <-chan error) {
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is not stopped")
+	}
+}
+
+func TestShutdownSignal(t *testing.T) {
+	lifecycle := core.NewLifecycleEnvironment()
+	server := &stopManaged{make(chan struct{})}
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	stopped := waitShutdown(sigCh, done, lifecycle, server)
+	sigCh <- os.Interrupt
+	waitStopped(t, stopped)
+
+	reason, ok := lifecycle.ShutdownReason()
+	if !ok || reason.Trigger != core.ShutdownSignal || reason.Detail != os.Interrupt.String() {
+		t.Fatalf("unexpected shutdown reason: %+v", reason)
+	}
+}
+
+// drainingServer stops when it is closed.
+type drainingServer struct {
+	closed chan struct{}
+}
+
+func (s *drainingServer) Start() error {
+	return nil
+}
+
+func (s *drainingServer) Stop() error {
+	<-s.closed
+	return nil
+}
+
+func (s *drainingServer) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestShutdownSecondSignal(t *testing.T) {
+	lifecycle := core.NewLifecycleEnvironment()
+	server := &drainingServer{make(chan struct{})}
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	stopped := waitShutdown(sigCh, done, lifecycle, server)
+	sigCh <- os.Interrupt
+	sigCh <- os.Interrupt
+	select {
+	case err := <-stopped:
+		if err == nil || err.Error() != "draining aborted by signal interrupt" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is not stopped")
+	}
+}
+
+func TestShutdownTask(t *testing.T) {
+	env := core.NewEnvironment()
+	server := &stopManaged{make(chan struct{})}
+	done := make(chan struct{})
+	defer close(done)
+
+	stopped := waitShutdown(nil, done, env.Lifecycle, server)
+	task := core.NewShutdownTask(env.Lifecycle)
+	if task.Name() != "shutdown" {
+		t.Fatalf("unexpected task name: %v", task.Name())
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/tasks/shutdown", nil)
+	task.ServeHTTP(w, r)
+	waitStopped(t, stopped)
+
+	reason, ok := env.Lifecycle.ShutdownReason()
+	if !ok || reason.Trigger != core.ShutdownTask {
+		t.Fatalf("unexpected shutdown reason: %+v", reason)
+	}
+	// Only the first reason is kept.
+	env.Lifecycle.Shutdown(core.ShutdownSignal, "terminated")
+	if reason, _ = env.Lifecycle.ShutdownReason(); reason.Trigger != core.ShutdownTask {
+		t.Fatalf("unexpected shutdown reason: %+v", reason)
+	}
+}
+
+func TestShutdownStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "melon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Configuration{}
+	config.Shutdown.StateFile = filepath.Join(dir, "shutdown.json")
+	reporter := newShutdownReporter(config)
+	if reporter.stateFile != config.Shutdown.StateFile {
+		t.Fatalf("unexpected state file: %v", reporter.stateFile)
+	}
+	// No previous state.
+	reporter.logPrevious()
+
+	lifecycle := core.NewLifecycleEnvironment()
+	lifecycle.Shutdown(core.ShutdownSignal, "terminated")
+	reporter.report(lifecycle, errors.New("abandoned 2 requests"))
+
+	state, err := readShutdownState(config.Shutdown.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Reason.Trigger != core.ShutdownSignal || state.Reason.Detail != "terminated" {
+		t.Fatalf("unexpected shutdown reason: %+v", state.Reason)
+	}
+	if state.Drained || state.Error != "abandoned 2 requests" {
+		t.Fatalf("unexpected shutdown state: %+v", state)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	reporter.logPrevious()
+}
@@ -0,0 +1,73 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package melon
+
+import (
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/views"
+)
+
+func TestServerSignal(t *testing.T) {
+	port := freePort(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	b := New("signal").
+		Port(port).
+		Resource(views.NewResource("GET", "/slow", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+			close(started)
+			<-release
+			return "done", nil
+		})))
+	done := make(chan error, 1)
+	go func() {
+		done <- b.run(nil)
+	}()
+	waitGet(t, fmt.Sprintf("http://localhost:%d/ping", port+1))
+
+	// In-flight request is drained.
+	status := make(chan int, 1)
+	go func() {
+		rsp, err := http.Get(fmt.Sprintf("http://localhost:%d/slow", port))
+		if err != nil {
+			status <- 0
+			return
+		}
+		rsp.Body.Close()
+		status <- rsp.StatusCode
+	}()
+	<-started
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	lifecycle := b.environment().Lifecycle
+	for i := 0; i < 50; i++ {
+		if _, ok := lifecycle.ShutdownReason(); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Server is draining, give it time to close listeners.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if s := <-status; s != http.StatusOK {
+		t.Fatalf("unexpected status: %d", s)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("application is not stopped")
+	}
+	reason, ok := lifecycle.ShutdownReason()
+	if !ok || reason.Trigger != core.ShutdownSignal || reason.Detail != syscall.SIGTERM.String() {
+		t.Fatalf("unexpected shutdown reason: %+v", reason)
+	}
+}
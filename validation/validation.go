@@ -4,6 +4,9 @@ Package validation provides validator for applications.
 package validation
 
 import (
+	"fmt"
+	"reflect"
+
 	"github.com/goburrow/melon/core"
 	"github.com/goburrow/validator"
 )
@@ -22,5 +25,136 @@ func NewFactory() core.ValidatorFactory {
 
 // Validator returns validator of this factory.
 func (f *factory) BuildValidator(bootstrap *core.Bootstrap) (core.Validator, error) {
-	return f.validator, nil
+	return &pathValidator{f.validator}, nil
+}
+
+// pathValidator prefixes validation errors with the path of the struct
+// containing the invalid field, e.g. "Server.ApplicationConnectors[0]: Type
+// must not be empty".
+type pathValidator struct {
+	validator *validator.Validator
+}
+
+// Validatable is implemented by values which validate themselves in addition
+// to their field tags, e.g. to check formats of durations.
+type Validatable interface {
+	Validate() error
+}
+
+func (v *pathValidator) Validate(value interface{}) error {
+	err := v.validator.Validate(value)
+	if err == nil {
+		return validateAll(reflect.ValueOf(value), "")
+	}
+	if path := v.errorPath(reflect.ValueOf(value), "", err.Error()); path != "" {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return err
+}
+
+// validateAll calls Validate of value and its exported fields, elements and
+// map values which implement Validatable.
+func validateAll(value reflect.Value, path string) error {
+	if value.CanInterface() {
+		validatable, ok := value.Interface().(Validatable)
+		if !ok && value.CanAddr() {
+			validatable, ok = value.Addr().Interface().(Validatable)
+		}
+		if ok && (value.Kind() != reflect.Ptr || !value.IsNil()) {
+			if err := validatable.Validate(); err != nil {
+				if path != "" {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				return err
+			}
+		}
+	}
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			p := path
+			if !f.Anonymous {
+				if p != "" {
+					p += "."
+				}
+				p += f.Name
+			}
+			if err := validateAll(value.Field(i), p); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := validateAll(value.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range value.MapKeys() {
+			if err := validateAll(value.MapIndex(k), fmt.Sprintf("%s[%v]", path, k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errorPath returns the path of the innermost value of value having the
+// same validation error.
+func (v *pathValidator) errorPath(value reflect.Value, path string, msg string) string {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return path
+		}
+		value = value.Elem()
+	}
+	invalid := func(child reflect.Value) bool {
+		if !child.CanInterface() {
+			return false
+		}
+		err := v.validator.Validate(child.Interface())
+		return err != nil && err.Error() == msg
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		t := value.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			child := value.Field(i)
+			if !invalid(child) {
+				continue
+			}
+			if f.Anonymous {
+				return v.errorPath(child, path, msg)
+			}
+			if path != "" {
+				return v.errorPath(child, path+"."+f.Name, msg)
+			}
+			return v.errorPath(child, f.Name, msg)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if child := value.Index(i); invalid(child) {
+				return v.errorPath(child, fmt.Sprintf("%s[%d]", path, i), msg)
+			}
+		}
+	case reflect.Map:
+		for _, k := range value.MapKeys() {
+			if child := value.MapIndex(k); invalid(child) {
+				return v.errorPath(child, fmt.Sprintf("%s[%v]", path, k), msg)
+			}
+		}
+	}
+	return path
 }
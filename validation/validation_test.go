@@ -1,6 +1,7 @@
 package validation
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -79,9 +80,77 @@ func TestValidateStruct(t *testing.T) {
 	if err = validator.Validate(&c); err == nil {
 		t.Fatal("error must be thrown")
 	}
+	if err.Error() != "Y[0]: C is out of range" {
+		t.Fatalf("unexpected error message: %v", err)
+	}
 	c.Y[0].C = 5
 	c.Y[0].D = []string{""}
 	if err = validator.Validate(&c); err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
 }
+
+type outer struct {
+	Inner  *inner2
+	Others map[string]inner2
+}
+
+func TestValidateErrorPath(t *testing.T) {
+	factory := NewFactory()
+	validator, _ := factory.BuildValidator(nil)
+
+	c := outer{Inner: &inner2{C: 1}}
+	err := validator.Validate(&c)
+	if err == nil || err.Error() != "Inner: D must not be empty" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Inner.D = []string{"a"}
+	c.Others = map[string]inner2{"x": {C: 20, D: []string{"a"}}}
+	err = validator.Validate(&c)
+	if err == nil || err.Error() != "Others[x]: C is out of range" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Fields of the root value have no path.
+	err = validator.Validate(&inner2{})
+	if err == nil || err.Error() != "C is out of range" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type selfValidated struct {
+	Timeout string
+}
+
+func (s *selfValidated) Validate() error {
+	if s.Timeout == "" {
+		return errors.New("Timeout must not be empty")
+	}
+	return nil
+}
+
+func TestValidateValidatable(t *testing.T) {
+	factory := NewFactory()
+	validator, _ := factory.BuildValidator(nil)
+
+	type config struct {
+		Server interface{}
+		Items  []selfValidated
+	}
+	c := config{
+		Server: &struct{ Connectors []selfValidated }{[]selfValidated{{"1s"}, {}}},
+	}
+	err := validator.Validate(&c)
+	if err == nil || err.Error() != "Server.Connectors[1]: Timeout must not be empty" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Server = nil
+	c.Items = []selfValidated{{}}
+	err = validator.Validate(&c)
+	if err == nil || err.Error() != "Items[0]: Timeout must not be empty" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Items[0].Timeout = "1s"
+	if err = validator.Validate(&c); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package views
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/goburrow/melon/server/filter"
+)
+
+const (
+	defaultMaxBatchSize = 20
+	defaultBatchTimeout = 10 * time.Second
+)
+
+// BatchResource executes multiple requests against the application router in
+// one HTTP round trip. The request is a JSON array of BatchRequest and the
+// response is a JSON array of BatchResponse in the same order.
+type BatchResource struct {
+	path    string
+	maxSize int
+	timeout time.Duration
+}
+
+// BatchOption is an option for BatchResource.
+type BatchOption func(b *BatchResource)
+
+// NewBatchResource creates a new BatchResource handling POST requests at path.
+// It requires a JSON provider.
+func NewBatchResource(path string, options ...BatchOption) *BatchResource {
+	b := &BatchResource{
+		path:    path,
+		maxSize: defaultMaxBatchSize,
+		timeout: defaultBatchTimeout,
+	}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b
+}
+
+// WithMaxBatchSize sets the maximum number of requests in a batch.
+func WithMaxBatchSize(size int) BatchOption {
+	return func(b *BatchResource) {
+		b.maxSize = size
+	}
+}
+
+// WithBatchTimeout sets the timeout of each request in a batch.
+func WithBatchTimeout(timeout time.Duration) BatchOption {
+	return func(b *BatchResource) {
+		b.timeout = timeout
+	}
+}
+
+// BatchRequest is a request in a batch.
+type BatchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchResponse is the response of a BatchRequest. Body is embedded if it is
+// JSON, otherwise it is a string.
+type BatchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+var batchContextKey = &contextKey{"batch"}
+
+// IsBatchRequest returns true if r is executed as a part of a batch.
+func IsBatchRequest(r *http.Request) bool {
+	return r.Context().Value(batchContextKey) != nil
+}
+
+// SkipInBatch returns a Filter which does not run f for requests executed as
+// a part of a batch, e.g. access log or rate limit already applied to the
+// batch request.
+func SkipInBatch(f filter.Filter) filter.Filter {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsBatchRequest(r) {
+			filter.Continue(w, r)
+			return
+		}
+		f.ServeHTTP(w, r)
+	})
+}
+
+// batchHandler executes requests in a batch.
+type batchHandler struct {
+	*BatchResource
+	// handler is the application router.
+	handler    http.Handler
+	pathPrefix string
+}
+
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	HandlerFunc(h.serve).ServeHTTP(w, r)
+}
+
+func (h *batchHandler) serve(r *http.Request) (interface{}, error) {
+	if IsBatchRequest(r) {
+		return nil, NewBadRequest("batch: nested batch is not allowed")
+	}
+	var requests []BatchRequest
+	if err := Entity(r, &requests); err != nil {
+		return nil, err
+	}
+	if len(requests) > h.maxSize {
+		return nil, NewBadRequest(fmt.Sprintf("batch: too many requests %d, maximum %d", len(requests), h.maxSize))
+	}
+	for i := range requests {
+		p := requests[i].Path
+		if idx := strings.IndexByte(p, '?'); idx >= 0 {
+			p = p[:idx]
+		}
+		if path.Clean("/"+p) == path.Clean(h.path) {
+			return nil, NewBadRequest("batch: nested batch is not allowed")
+		}
+	}
+	responses := make([]BatchResponse, len(requests))
+	for i := range requests {
+		responses[i] = h.execute(r, &requests[i])
+	}
+	return responses, nil
+}
+
+// execute runs req against the application router. Failure is reported in
+// the response status.
+func (h *batchHandler) execute(parent *http.Request, req *BatchRequest) BatchResponse {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	ctx, cancel := context.WithTimeout(parent.Context(), h.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, batchContextKey, true)
+
+	r, err := http.NewRequest(method, h.pathPrefix+req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return newBatchError(http.StatusBadRequest, err.Error())
+	}
+	r = r.WithContext(ctx)
+	r.RequestURI = r.URL.RequestURI()
+	r.RemoteAddr = parent.RemoteAddr
+	for k, v := range req.Headers {
+		r.Header.Set(k, v)
+	}
+	if len(req.Body) > 0 && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			done <- recover()
+		}()
+		h.handler.ServeHTTP(w, r)
+	}()
+	select {
+	case p := <-done:
+		if p != nil {
+			logger().Errorf("batch: panic in %s %s: %v", method, req.Path, p)
+			return newBatchError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+	case <-ctx.Done():
+		return newBatchError(http.StatusGatewayTimeout, http.StatusText(http.StatusGatewayTimeout))
+	}
+	return w.response()
+}
+
+func newBatchError(status int, message string) BatchResponse {
+	body, _ := json.Marshal(message)
+	return BatchResponse{
+		Status: status,
+		Body:   body,
+	}
+}
+
+// batchResponseWriter records response of a request in a batch.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) response() BatchResponse {
+	rsp := BatchResponse{
+		Status: w.status,
+	}
+	if rsp.Status == 0 {
+		rsp.Status = http.StatusOK
+	}
+	if len(w.header) > 0 {
+		rsp.Headers = make(map[string]string, len(w.header))
+		for k := range w.header {
+			rsp.Headers[k] = w.header.Get(k)
+		}
+	}
+	body := bytes.TrimSpace(w.body.Bytes())
+	if len(body) > 0 {
+		if strings.Contains(w.header.Get("Content-Type"), "json") && json.Valid(body) {
+			rsp.Body = body
+		} else {
+			rsp.Body, _ = json.Marshal(string(body))
+		}
+	}
+	return rsp
+}
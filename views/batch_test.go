@@ -0,0 +1,109 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/server/filter"
+	"github.com/goburrow/melon/server/router"
+)
+
+func newBatchTestRouter() *router.Router {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("GET", "/user/{name}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return map[string]string{"name": router.PathParams(r)["name"]}, nil
+	})))
+	h.HandleResource(NewResource("POST", "/user", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		var user map[string]string
+		if err := Entity(r, &user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	})))
+	h.HandleResource(NewResource("GET", "/fail", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return nil, NewBadRequest("failed")
+	})))
+	h.HandleResource(NewResource("GET", "/slow", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		<-r.Context().Done()
+		return nil, nil
+	})))
+	h.HandleResource(NewBatchResource("/batch", WithMaxBatchSize(5), WithBatchTimeout(50*time.Millisecond)))
+	return rt
+}
+
+func postBatch(rt http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	rt.ServeHTTP(w, r)
+	return w
+}
+
+func TestBatch(t *testing.T) {
+	rt := newBatchTestRouter()
+	var skipped int32
+	rt.AddFilter(SkipInBatch(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&skipped, 1)
+		filter.Continue(w, r)
+	})))
+	w := postBatch(rt, `[
+		{"method": "GET", "path": "/user/alice"},
+		{"method": "GET", "path": "/fail"},
+		{"method": "POST", "path": "/user", "body": {"name": "bob"}},
+		{"method": "GET", "path": "/notfound"},
+		{"method": "GET", "path": "/slow"}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v %v", w.Code, w.Body.String())
+	}
+	var responses []BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, `{"name":"alice"}`},
+		{http.StatusBadRequest, ""},
+		{http.StatusOK, `{"name":"bob"}`},
+		{http.StatusNotFound, ""},
+		{http.StatusGatewayTimeout, ""},
+	}
+	if len(responses) != len(expected) {
+		t.Fatalf("unexpected responses: %+v", responses)
+	}
+	for i, e := range expected {
+		if responses[i].Status != e.status {
+			t.Fatalf("%d: unexpected status: %v, want: %v", i, responses[i].Status, e.status)
+		}
+		if e.body != "" && string(responses[i].Body) != e.body {
+			t.Fatalf("%d: unexpected body: %s, want: %s", i, responses[i].Body, e.body)
+		}
+	}
+	// The filter only runs for the batch request.
+	if n := atomic.LoadInt32(&skipped); n != 1 {
+		t.Fatalf("unexpected filter calls: %v", n)
+	}
+}
+
+func TestBatchSizeLimit(t *testing.T) {
+	rt := newBatchTestRouter()
+	w := postBatch(rt, `[{"path": "/fail"}, {"path": "/fail"}, {"path": "/fail"}, {"path": "/fail"}, {"path": "/fail"}, {"path": "/fail"}]`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too many requests") {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Body.String())
+	}
+}
+
+func TestBatchRecursion(t *testing.T) {
+	rt := newBatchTestRouter()
+	w := postBatch(rt, `[{"path": "/user/alice"}, {"method": "POST", "path": "/batch?x=1", "body": []}]`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "nested batch") {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goburrow/melon/server/router"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 1000
+)
+
+// ErrNotFound is returned by Store when the entity does not exist.
+var ErrNotFound error = &ErrorMessage{http.StatusNotFound, "Not found."}
+
+// Store is a storage of entities of type T used by CRUD.
+type Store[T any] interface {
+	// Get returns ErrNotFound if the entity does not exist.
+	Get(ctx context.Context, id string) (T, error)
+	// List returns at most limit entities starting from offset.
+	List(ctx context.Context, offset, limit int) ([]T, error)
+	// Create returns ID of the created entity.
+	Create(ctx context.Context, v T) (string, error)
+	// Update returns ErrNotFound if the entity does not exist.
+	Update(ctx context.Context, id string, v T) error
+	// Delete returns ErrNotFound if the entity does not exist.
+	Delete(ctx context.Context, id string) error
+}
+
+// Resources is a group of resources registered together.
+type Resources []*Resource
+
+// CRUD returns resources which create, read, update and delete entities in
+// store:
+//
+//	GET    path?offset=0&limit=20  lists entities
+//	POST   path                    creates an entity, responds 201 with Location
+//	GET    path/{id}               gets an entity
+//	PUT    path/{id}               updates an entity
+//	DELETE path/{id}               deletes an entity, responds 204
+//
+// Request entities are validated with the validator of the environment.
+// Options are applied to all resources.
+func CRUD[T any](path string, store Store[T], options ...Option) Resources {
+	path = strings.TrimSuffix(path, "/")
+	h := &crudHandler[T]{store: store}
+	return Resources{
+		NewResource("GET", path, HandlerFunc(h.list), options...),
+		NewResource("POST", path, HandlerFunc(h.create), options...),
+		NewResource("GET", path+"/{id}", HandlerFunc(h.get), options...),
+		NewResource("PUT", path+"/{id}", HandlerFunc(h.update), options...),
+		NewResource("DELETE", path+"/{id}", HandlerFunc(h.delete), options...),
+	}
+}
+
+// crudHandler serves resources created by CRUD.
+type crudHandler[T any] struct {
+	store Store[T]
+}
+
+func (h *crudHandler[T]) list(r *http.Request) (interface{}, error) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := queryInt(r, "limit", defaultListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	list, err := h.store.List(r.Context(), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []T{}
+	}
+	return list, nil
+}
+
+func (h *crudHandler[T]) get(r *http.Request) (interface{}, error) {
+	v, err := h.store.Get(r.Context(), router.PathParam(r, "id"))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return v, nil
+}
+
+func (h *crudHandler[T]) create(r *http.Request) (interface{}, error) {
+	var v T
+	if err := Entity(r, &v); err != nil {
+		return nil, err
+	}
+	id, err := h.store.Create(r.Context(), v)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	// Location includes the context path of the application.
+	return Created(router.BasePath(r)+strings.TrimSuffix(r.URL.Path, "/")+"/"+url.PathEscape(id), v), nil
+}
+
+func (h *crudHandler[T]) update(r *http.Request) (interface{}, error) {
+	var v T
+	if err := Entity(r, &v); err != nil {
+		return nil, err
+	}
+	if err := h.store.Update(r.Context(), router.PathParam(r, "id"), v); err != nil {
+		return nil, storeError(err)
+	}
+	return v, nil
+}
+
+func (h *crudHandler[T]) delete(r *http.Request) (interface{}, error) {
+	if err := h.store.Delete(r.Context(), router.PathParam(r, "id")); err != nil {
+		return nil, storeError(err)
+	}
+	return NoContent(), nil
+}
+
+// storeError converts errors wrapping ErrNotFound to ErrNotFound so that it
+// is mapped to status 404.
+func storeError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func queryInt(r *http.Request, name string, value int) (int, error) {
+	n, err := QueryInt(r, name, value)
+	if err != nil || n < 0 {
+		return 0, NewBadRequest("Invalid " + name + ".")
+	}
+	return n, nil
+}
+
+// MemoryStore is a Store keeping entities in memory, which is intended for
+// prototyping and tests. Entities are listed in the order of creation.
+type MemoryStore[T any] struct {
+	mu       sync.RWMutex
+	entities map[string]T
+	// seq is the last generated ID.
+	seq int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore[T any]() *MemoryStore[T] {
+	return &MemoryStore[T]{
+		entities: make(map[string]T),
+	}
+}
+
+// Get returns the entity with the given id.
+func (s *MemoryStore[T]) Get(ctx context.Context, id string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.entities[id]
+	if !ok {
+		return v, ErrNotFound
+	}
+	return v, nil
+}
+
+// List returns entities ordered by their IDs.
+func (s *MemoryStore[T]) List(ctx context.Context, offset, limit int) ([]T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]int, 0, len(s.entities))
+	for id := range s.entities {
+		n, _ := strconv.Atoi(id)
+		ids = append(ids, n)
+	}
+	sort.Ints(ids)
+	if offset >= len(ids) {
+		return nil, nil
+	}
+	ids = ids[offset:]
+	if limit < len(ids) {
+		ids = ids[:limit]
+	}
+	list := make([]T, len(ids))
+	for i, id := range ids {
+		list[i] = s.entities[strconv.Itoa(id)]
+	}
+	return list, nil
+}
+
+// Create adds v with a sequential ID.
+func (s *MemoryStore[T]) Create(ctx context.Context, v T) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	id := strconv.Itoa(s.seq)
+	s.entities[id] = v
+	return id, nil
+}
+
+// Update replaces the entity with the given id.
+func (s *MemoryStore[T]) Update(ctx context.Context, id string, v T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entities[id]; !ok {
+		return ErrNotFound
+	}
+	s.entities[id] = v
+	return nil
+}
+
+// Delete removes the entity with the given id.
+func (s *MemoryStore[T]) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entities[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.entities, id)
+	return nil
+}
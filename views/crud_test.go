@@ -0,0 +1,145 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+)
+
+type crudUser struct {
+	Name string
+	Age  int
+}
+
+type crudValidator struct{}
+
+func (crudValidator) Validate(v interface{}) error {
+	if u, ok := v.(*crudUser); ok && u.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func newCRUDRouter() (*router.Router, *MemoryStore[crudUser]) {
+	env := core.NewEnvironment()
+	env.Validator = crudValidator{}
+	rt := router.New()
+	env.Server.Router = rt
+	h := newResourceHandler(env)
+	h.HandleResource(NewJSONProvider())
+	store := NewMemoryStore[crudUser]()
+	h.HandleResource(CRUD[crudUser]("/users", store))
+	return rt, store
+}
+
+func serveCRUD(rt http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	return w
+}
+
+func TestCRUD(t *testing.T) {
+	rt, store := newCRUDRouter()
+
+	w := serveCRUD(rt, "POST", "/users", `{"Name":"foo","Age":20}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/1" ||
+		w.Body.String() != `{"Name":"foo","Age":20}`+"\n" {
+		t.Fatalf("unexpected response: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	serveCRUD(rt, "POST", "/users", `{"Name":"bar","Age":30}`)
+
+	w = serveCRUD(rt, "GET", "/users/1", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"Name":"foo","Age":20}`+"\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serveCRUD(rt, "GET", "/users?offset=1&limit=1", "")
+	if w.Code != http.StatusOK || w.Body.String() != `[{"Name":"bar","Age":30}]`+"\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serveCRUD(rt, "GET", "/users?offset=5", "")
+	if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	w = serveCRUD(rt, "GET", "/users?limit=x", "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serveCRUD(rt, "PUT", "/users/1", `{"Name":"foo","Age":21}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if u, _ := store.Get(context.Background(), "1"); u.Age != 21 {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	w = serveCRUD(rt, "DELETE", "/users/1", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := store.Get(context.Background(), "1"); err != ErrNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCRUDNotFound(t *testing.T) {
+	rt, _ := newCRUDRouter()
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{"GET", ""},
+		{"PUT", `{"Name":"foo"}`},
+		{"DELETE", ""},
+	}
+	for _, test := range tests {
+		w := serveCRUD(rt, test.method, "/users/1", test.body)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("unexpected response of %s: %d %s", test.method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCRUDValidation(t *testing.T) {
+	rt, _ := newCRUDRouter()
+	serveCRUD(rt, "POST", "/users", `{"Name":"foo"}`)
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/users", `{"Age":20}`, http.StatusBadRequest},
+		{"POST", "/users", `{"Name":`, statusUnprocessableEntity},
+		{"PUT", "/users/1", `{"Age":20}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := serveCRUD(rt, test.method, test.path, test.body)
+		if w.Code != test.status {
+			t.Fatalf("unexpected response of %s %s: %d %s", test.method, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCRUDLocationWithContextPath(t *testing.T) {
+	env := core.NewEnvironment()
+	rt := router.New(router.WithPathPrefix("/application"))
+	env.Server.Router = rt
+	h := newResourceHandler(env)
+	h.HandleResource(NewJSONProvider())
+	h.HandleResource(CRUD[crudUser]("/users", NewMemoryStore[crudUser]()))
+	w := serveCRUD(rt, "POST", "/application/users", `{"Name":"foo"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/application/users/1" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+}
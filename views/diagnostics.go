@@ -0,0 +1,75 @@
+package views
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// httpVerbs are method names which look like handlers of HTTP methods.
+var httpVerbs = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// handlerFuncType is the expected signature of resource methods.
+var handlerFuncType = reflect.TypeOf(HandlerFunc(nil))
+
+const handlerFuncSignature = "func(*http.Request) (interface {}, error)"
+
+// verbMethods returns exported methods of v named like HTTP methods, e.g. GET
+// or Get, which are not part of http.Handler.
+func verbMethods(v interface{}) []reflect.Method {
+	if v == nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	var methods []reflect.Method
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		for _, verb := range httpVerbs {
+			if strings.EqualFold(m.Name, verb) {
+				methods = append(methods, m)
+				break
+			}
+		}
+	}
+	return methods
+}
+
+// diagnoseComponent warns about components which are not supported but have
+// methods named like HTTP methods, as they are probably meant to be resources.
+func diagnoseComponent(v interface{}) {
+	for _, m := range verbMethods(v) {
+		// Method type includes the receiver.
+		in := make([]reflect.Type, m.Type.NumIn()-1)
+		for i := range in {
+			in[i] = m.Type.In(i + 1)
+		}
+		out := make([]reflect.Type, m.Type.NumOut())
+		for i := range out {
+			out[i] = m.Type.Out(i)
+		}
+		found := reflect.FuncOf(in, out, m.Type.IsVariadic())
+		if found.ConvertibleTo(handlerFuncType) {
+			logger().Warnf("%T.%s is not registered: register it with views.NewResource(%q, path, views.HandlerFunc(v.%s))",
+				v, m.Name, strings.ToUpper(m.Name), m.Name)
+		} else {
+			logger().Warnf("%T.%s is not registered: found signature %v, expected %s to use with views.NewResource",
+				v, m.Name, found, handlerFuncSignature)
+		}
+	}
+}
+
+// validateResource returns an error if r cannot be registered.
+func validateResource(r *Resource) error {
+	switch {
+	case r.handler == nil:
+		return fmt.Errorf("views: resource %s %s is not registered: nil handler", r.method, r.path)
+	case r.method == "":
+		return fmt.Errorf("views: resource %s is not registered: empty method", r.path)
+	case !strings.HasPrefix(r.path, "/"):
+		return fmt.Errorf("views: resource %s %s is not registered: path must start with /", r.method, r.path)
+	}
+	if h, ok := r.handler.(HandlerFunc); ok && h == nil {
+		return fmt.Errorf("views: resource %s %s is not registered: nil handler", r.method, r.path)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+)
+
+// recordLogger records warnings and errors.
+type recordLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	l.logs = append(l.logs, level+" "+fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) {}
+func (l *recordLogger) Infof(format string, args ...interface{})  {}
+func (l *recordLogger) Warnf(format string, args ...interface{})  { l.log("WARN", format, args...) }
+func (l *recordLogger) Errorf(format string, args ...interface{}) { l.log("ERROR", format, args...) }
+
+var testLogger = &recordLogger{}
+
+func init() {
+	core.SetLoggerFactory(func(string) core.Logger {
+		return testLogger
+	})
+}
+
+// captureLogs returns warnings and errors logged by f.
+func captureLogs(f func()) []string {
+	testLogger.mu.Lock()
+	testLogger.logs = nil
+	testLogger.mu.Unlock()
+	f()
+	testLogger.mu.Lock()
+	defer testLogger.mu.Unlock()
+	return testLogger.logs
+}
+
+type missingErrorResource struct{}
+
+func (missingErrorResource) GET(ctx context.Context) interface{} { return nil }
+
+type writerResource struct{}
+
+func (*writerResource) Post(w http.ResponseWriter, r *http.Request) {}
+
+type validSignatureResource struct{}
+
+func (validSignatureResource) Delete(r *http.Request) (interface{}, error) { return nil, nil }
+
+type noVerbResource struct{}
+
+func (noVerbResource) Fetch(r *http.Request) (interface{}, error) { return nil, nil }
+
+func TestDiagnoseComponent(t *testing.T) {
+	tests := []struct {
+		component interface{}
+		log       string
+	}{
+		{missingErrorResource{}, "WARN views.missingErrorResource.GET is not registered: found signature func(context.Context) interface {}, expected func(*http.Request) (interface {}, error)"},
+		{&writerResource{}, "WARN *views.writerResource.Post is not registered: found signature func(http.ResponseWriter, *http.Request), expected"},
+		{validSignatureResource{}, `WARN views.validSignatureResource.Delete is not registered: register it with views.NewResource("DELETE", path, views.HandlerFunc(v.Delete))`},
+		{noVerbResource{}, ""},
+		{struct{}{}, ""},
+	}
+	for _, test := range tests {
+		_, h := newTestRouter()
+		var claimed bool
+		logs := captureLogs(func() {
+			claimed = h.HandleResource(test.component)
+		})
+		if claimed {
+			t.Fatalf("unexpected claim of %T", test.component)
+		}
+		if test.log == "" {
+			if len(logs) != 0 {
+				t.Fatalf("unexpected logs for %T: %v", test.component, logs)
+			}
+			continue
+		}
+		if len(logs) != 1 || !strings.HasPrefix(logs[0], test.log) {
+			t.Fatalf("unexpected logs for %T: %v", test.component, logs)
+		}
+	}
+}
+
+func TestInvalidResource(t *testing.T) {
+	handler := HandlerFunc(func(r *http.Request) (interface{}, error) { return "ok", nil })
+	tests := []struct {
+		resource *Resource
+		err      string
+	}{
+		{NewResource("GET", "/a", nil), "views: resource GET /a is not registered: nil handler"},
+		{NewResource("GET", "/b", HandlerFunc(nil)), "views: resource GET /b is not registered: nil handler"},
+		{NewResource("", "/c", handler), "views: resource /c is not registered: empty method"},
+		{NewResource("GET", "d", handler), "views: resource GET d is not registered: path must start with /"},
+	}
+	for _, test := range tests {
+		rt, h := newTestRouter()
+		if !h.HandleResource(test.resource) {
+			t.Fatalf("resource must be claimed: %+v", test.resource)
+		}
+		if err := h.ResourceError(); err == nil || err.Error() != test.err {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(rt.Endpoints()) != 0 {
+			t.Fatalf("unexpected endpoints: %v", rt.Endpoints())
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest("GET", "/a", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+}
+
+func TestInvalidResourceStart(t *testing.T) {
+	env := core.NewEnvironment()
+	env.Server.Router = router.New()
+	if err := NewBundle().Run(nil, env); err != nil {
+		t.Fatal(err)
+	}
+	env.Server.Register(NewResource("GET", "users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return "ok", nil
+	})))
+	if err := env.Start(); err == nil || !strings.Contains(err.Error(), "GET users is not registered") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
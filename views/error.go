@@ -1,11 +1,22 @@
 package views
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/requestid"
 )
 
+// HTTPError is implemented by errors which determine status code of the
+// response. Their messages are written with the providers of the resource.
+type HTTPError interface {
+	error
+	StatusCode() int
+}
+
 // ErrorMessage represents a HTTP error with status code and message.
 type ErrorMessage struct {
 	// Code is HTTP status code.
@@ -18,6 +29,29 @@ func (e *ErrorMessage) Error() string {
 	return e.Message
 }
 
+// StatusCode returns Code of the error.
+func (e *ErrorMessage) StatusCode() int {
+	return e.Code
+}
+
+// DetailedError is an ErrorMessage with details, e.g. invalid fields of the
+// request entity. Details are not written in XML responses.
+type DetailedError struct {
+	ErrorMessage
+	Details map[string]interface{} `json:",omitempty" xml:"-"`
+}
+
+// NewDetailedError creates a new DetailedError with the status code.
+func NewDetailedError(code int, message string, details map[string]interface{}) *DetailedError {
+	return &DetailedError{
+		ErrorMessage: ErrorMessage{
+			Code:    code,
+			Message: message,
+		},
+		Details: details,
+	}
+}
+
 // NewBadRequest creates a new ErrorMessage with status code http.StatusBadRequest.
 func NewBadRequest(message string) *ErrorMessage {
 	return &ErrorMessage{
@@ -41,33 +75,64 @@ type ErrorMapper interface {
 
 // errorMapper is a default implementation of ErrorMapper interface.
 type errorMapper struct {
+	errorDetail core.ErrorDetail
 }
 
-func newErrorMapper() *errorMapper {
-	return &errorMapper{}
+func newErrorMapper(errorDetail core.ErrorDetail) *errorMapper {
+	return &errorMapper{
+		errorDetail: errorDetail,
+	}
 }
 
 func (h *errorMapper) MapError(w http.ResponseWriter, r *http.Request, err error) {
 	var errMsg *ErrorMessage
-	switch v := err.(type) {
-	case *ErrorMessage:
-		errMsg = v
+	// body is the response entity, errMsg or the error if it is a DetailedError.
+	var body interface{}
+	// Errors may be wrapped, e.g. with fmt.Errorf and %w.
+	var detailed *DetailedError
+	var httpErr HTTPError
+	switch {
+	case errors.As(err, &detailed):
+		errMsg = &detailed.ErrorMessage
+		body = detailed
+	case errors.As(err, &errMsg):
+	case errors.As(err, &httpErr):
+		errMsg = &ErrorMessage{Code: httpErr.StatusCode(), Message: httpErr.Error()}
 	default:
-		// Unknown error type, treat it as a server error
-		id := rand.Int63()
-		logger().Errorf("error handling request %s (ID %016x): %v", r.URL.Path, id, err)
-		errMsg = NewServerError(fmt.Sprintf(
-			"error processing your request (ID %016x)", id))
+		// Unknown error type, treat it as a server error.
+		// Request ID is used when available.
+		id := requestid.Get(r)
+		if id == "" {
+			id = fmt.Sprintf("%016x", rand.Int63())
+		}
+		logger().Errorf("error handling request %s (ID %s): %v", r.URL.Path, id, err)
+		switch h.errorDetail {
+		case core.ErrorDetailMessage:
+			errMsg = NewServerError(fmt.Sprintf("%v (ID %s)", err, id))
+		case core.ErrorDetailStack:
+			errMsg = NewServerError(fmt.Sprintf("%+v (ID %s)", err, id))
+		default:
+			errMsg = NewServerError(fmt.Sprintf("error processing your request (ID %s)", id))
+		}
+	}
+	if errMsg.Code < 100 || errMsg.Code > 999 {
+		// Invalid status code would panic in WriteHeader.
+		logger().Errorf("invalid status code %d of error: %v", errMsg.Code, err)
+		errMsg = &ErrorMessage{Code: http.StatusInternalServerError, Message: errMsg.Message}
+		body = errMsg
+	}
+	if body == nil {
+		body = errMsg
 	}
 	// Use provider to writes error when possible
 	if ctx := fromContext(r.Context()); ctx != nil {
-		writer, contentType := ctx.findWriter(w, r, errMsg)
+		writer, contentType := ctx.findWriter(w, r, body)
 		if writer != nil {
 			if contentType != "" {
 				w.Header().Set("Content-Type", contentType)
 			}
 			w.WriteHeader(errMsg.Code)
-			err = writer.WriteResponse(w, r, errMsg)
+			err = writer.WriteResponse(w, r, body)
 			if err != nil {
 				logger().Errorf("response writer: %v", err)
 			}
@@ -0,0 +1,125 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+)
+
+func TestErrorMapperDetail(t *testing.T) {
+	tests := []struct {
+		detail  core.ErrorDetail
+		err     error
+		code    int
+		message string
+	}{
+		{core.ErrorDetailNone, errors.New("db down"), 500, "error processing your request (ID abc)"},
+		{core.ErrorDetailMessage, errors.New("db down"), 500, "db down (ID abc)"},
+		{core.ErrorDetailStack, errors.New("db down"), 500, "db down (ID abc)"},
+		{core.ErrorDetailNone, NewBadRequest("invalid"), 400, "invalid"},
+		{core.ErrorDetailStack, NewBadRequest("invalid"), 400, "invalid"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-Id", "abc")
+		newErrorMapper(test.detail).MapError(w, r, test.err)
+		if test.code != w.Code {
+			t.Fatalf("unexpected code %v", w.Code)
+		}
+		if test.message != strings.TrimSpace(w.Body.String()) {
+			t.Fatalf("unexpected body %q", w.Body.String())
+		}
+	}
+}
+
+func TestErrorMapperNegotiation(t *testing.T) {
+	rt, h := newTestRouter()
+	h.errorMapper = newErrorMapper(core.ErrorDetailMessage)
+	handler := func(r *http.Request) (interface{}, error) {
+		return nil, errors.New("db down")
+	}
+	h.HandleResource(NewResource("GET", "/", HandlerFunc(handler)))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	r.Header.Set("X-Request-Id", "abc")
+	rt.ServeHTTP(w, r)
+	if 500 != w.Code {
+		t.Fatalf("unexpected code %v", w.Code)
+	}
+	if `{"Code":500,"Message":"db down (ID abc)"}` != strings.TrimSpace(w.Body.String()) {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+// conflictError implements HTTPError.
+type conflictError struct{}
+
+func (conflictError) Error() string {
+	return "version conflict"
+}
+
+func (conflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+// statusError implements HTTPError with an arbitrary status code.
+type statusError int
+
+func (e statusError) Error() string {
+	return "status " + strconv.Itoa(int(e))
+}
+
+func (e statusError) StatusCode() int {
+	return int(e)
+}
+
+func TestHTTPError(t *testing.T) {
+	rt, h := newTestRouter()
+	errs := map[string]error{
+		"/users/1":  ErrNotFound,
+		"/users/2":  NewDetailedError(statusUnprocessableEntity, "Invalid user.", map[string]interface{}{"name": "required"}),
+		"/users/3":  conflictError{},
+		"/users/db": errors.New("db down"),
+		"/users/4":  fmt.Errorf("finding user: %w", ErrNotFound),
+		"/users/5":  fmt.Errorf("updating user: %w", conflictError{}),
+		"/users/6":  statusError(0),
+	}
+	h.HandleResource(NewResource("PUT", "/users/{id}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return nil, errs[r.URL.Path]
+	})))
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/users/1", 404, `{"Code":404,"Message":"Not found."}`},
+		{"/users/2", 422, `{"Code":422,"Message":"Invalid user.","Details":{"name":"required"}}`},
+		{"/users/3", 409, `{"Code":409,"Message":"version conflict"}`},
+		{"/users/db", 500, `{"Code":500,"Message":"error processing your request (ID abc)"}`},
+		{"/users/4", 404, `{"Code":404,"Message":"Not found."}`},
+		{"/users/5", 409, `{"Code":409,"Message":"version conflict"}`},
+		{"/users/6", 500, `{"Code":500,"Message":"status 0"}`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", test.path, nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-Request-Id", "abc")
+		rt.ServeHTTP(w, r)
+		if w.Code != test.code || strings.TrimSpace(w.Body.String()) != test.body {
+			t.Fatalf("unexpected response %s: %d %q", test.path, w.Code, w.Body)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("unexpected content type: %v", w.Header())
+		}
+	}
+}
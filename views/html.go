@@ -6,6 +6,8 @@ import (
 	"io"
 	"net/http"
 	"path/filepath"
+
+	"github.com/goburrow/melon/core"
 )
 
 var htmlMediaTypes = []string{
@@ -26,6 +28,13 @@ type htmlProvider struct {
 	renderer HTMLRenderer
 }
 
+func (p *htmlProvider) configureMode(mode core.Mode) {
+	if r, ok := p.renderer.(*templateRenderer); ok && r.reload == nil {
+		reload := mode.Defaults().ReloadTemplates
+		r.reload = &reload
+	}
+}
+
 // Consumes returns html media types.
 func (p *htmlProvider) Consumes() []string {
 	return htmlMediaTypes
@@ -47,14 +56,21 @@ func (p *htmlProvider) Produces() []string {
 	return htmlMediaTypes
 }
 
-// IsWriteable checks if request context contains a HTML template name.
+// IsWriteable checks if v is a View or request context contains a HTML
+// template name.
 func (p *htmlProvider) IsWriteable(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if _, ok := asView(v); ok {
+		return true
+	}
 	ctx := fromContext(r.Context())
 	return ctx != nil && ctx.handler.htmlTemplate != ""
 }
 
 // WriteResponse uses a Renderer to render HTML.
 func (p *htmlProvider) WriteResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if view, ok := asView(v); ok {
+		return p.renderer.RenderHTML(w, view.Template, view.Data)
+	}
 	ctx := fromContext(r.Context())
 	if ctx == nil || ctx.handler.htmlTemplate == "" {
 		return fmt.Errorf("melon/views: unsupported context: %#v", r.Context())
@@ -62,6 +78,24 @@ func (p *htmlProvider) WriteResponse(w http.ResponseWriter, r *http.Request, v i
 	return p.renderer.RenderHTML(w, ctx.handler.htmlTemplate, v)
 }
 
+// A View or *View can be returned by resources to render the named template
+// with data in HTML responses, which overrides WithHTMLTemplate.
+type View struct {
+	Template string
+	Data     interface{}
+}
+
+// asView returns the View v or v points to.
+func asView(v interface{}) (*View, bool) {
+	switch view := v.(type) {
+	case *View:
+		return view, view != nil
+	case View:
+		return &view, true
+	}
+	return nil, false
+}
+
 // WithHTMLTemplate registers template name for a resource.
 func WithHTMLTemplate(name string) Option {
 	return func(h *httpHandler) {
@@ -74,19 +108,54 @@ type HTMLRenderer interface {
 	RenderHTML(w io.Writer, name string, data interface{}) error
 }
 
+// HTMLRendererOption is an option for the HTML renderer.
+type HTMLRendererOption func(r *templateRenderer)
+
+// WithTemplateReload sets whether templates are parsed again on every render,
+// so changes are applied without restarting. It is enabled in development
+// mode by default.
+func WithTemplateReload(reload bool) HTMLRendererOption {
+	return func(r *templateRenderer) {
+		r.reload = &reload
+	}
+}
+
 // NewHTMLRenderer returns a HTMLRenderer which takes templates from
 // files which pattern pat in directory dir.
-func NewHTMLRenderer(dir, pat string) (HTMLRenderer, error) {
-	glob := filepath.Join(dir, pat)
-	tpl, err := template.ParseGlob(glob)
+func NewHTMLRenderer(dir, pat string, options ...HTMLRendererOption) (HTMLRenderer, error) {
+	r := &templateRenderer{
+		glob: filepath.Join(dir, pat),
+	}
+	for _, opt := range options {
+		opt(r)
+	}
+	tpl, err := template.ParseGlob(r.glob)
 	if err != nil {
 		return nil, err
 	}
-	return rendererFunc(tpl.ExecuteTemplate), nil
+	r.tpl = tpl
+	return r, nil
 }
 
-type rendererFunc func(w io.Writer, name string, data interface{}) error
+// templateRenderer renders templates matching glob.
+type templateRenderer struct {
+	glob string
+	tpl  *template.Template
+	// reload is nil when it is not set explicitly.
+	reload *bool
+}
 
-func (f rendererFunc) RenderHTML(w io.Writer, name string, data interface{}) error {
-	return f(w, name, data)
+func (r *templateRenderer) RenderHTML(w io.Writer, name string, data interface{}) error {
+	tpl := r.tpl
+	if r.reload != nil && *r.reload {
+		var err error
+		tpl, err = template.ParseGlob(r.glob)
+		if err != nil {
+			return err
+		}
+	}
+	if tpl.Lookup(name) == nil {
+		return fmt.Errorf("melon/views: template %q is not defined in %s", name, r.glob)
+	}
+	return tpl.ExecuteTemplate(w, name, data)
 }
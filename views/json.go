@@ -3,6 +3,8 @@ package views
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/goburrow/melon/core"
 )
 
 var jsonMediaTypes = []string{
@@ -12,11 +14,36 @@ var jsonMediaTypes = []string{
 }
 
 // jsonProvider handles JSON requests and responses.
-type jsonProvider struct{}
+type jsonProvider struct {
+	// pretty is nil when it is not set explicitly.
+	pretty *bool
+}
+
+// JSONOption is an option for the JSON provider.
+type JSONOption func(p *jsonProvider)
 
 // NewJSONProvider returns a Provider which reads JSON request and responds JSON.
-func NewJSONProvider() Provider {
-	return &jsonProvider{}
+// Responses are indented in development mode unless WithPrettyJSON is given.
+func NewJSONProvider(options ...JSONOption) Provider {
+	p := &jsonProvider{}
+	for _, opt := range options {
+		opt(p)
+	}
+	return p
+}
+
+// WithPrettyJSON sets whether JSON responses are indented.
+func WithPrettyJSON(pretty bool) JSONOption {
+	return func(p *jsonProvider) {
+		p.pretty = &pretty
+	}
+}
+
+func (p *jsonProvider) configureMode(mode core.Mode) {
+	if p.pretty == nil {
+		pretty := mode.Defaults().PrettyJSON
+		p.pretty = &pretty
+	}
 }
 
 // Consumes returns JSON media types.
@@ -48,5 +75,8 @@ func (p *jsonProvider) IsWriteable(w http.ResponseWriter, r *http.Request, v int
 // WriteResponse encode v and writes to w.
 func (p *jsonProvider) WriteResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	encoder := json.NewEncoder(w)
+	if p.pretty != nil && *p.pretty {
+		encoder.SetIndent("", "  ")
+	}
 	return encoder.Encode(v)
 }
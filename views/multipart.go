@@ -0,0 +1,170 @@
+package views
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+)
+
+var (
+	errPartTooLarge      = errors.New("multipart part too large")
+	errMultipartTooLarge = errors.New("multipart request too large")
+)
+
+// Part is a part of a multipart request streamed by StreamMultipart.
+// Reading from it returns an error once the part or the request exceeds its
+// size budget.
+type Part struct {
+	// Name is the form field name.
+	Name string
+	// FileName is the file name of file parts, empty otherwise.
+	FileName    string
+	ContentType string
+	Header      textproto.MIMEHeader
+
+	reader io.Reader
+	stream *multipartStream
+}
+
+// Read reads data of the part.
+func (p *Part) Read(b []byte) (int, error) {
+	return p.reader.Read(b)
+}
+
+// OnAbort registers cleanup, e.g. removing a partially written file, to be
+// called when streaming is aborted by an error or exceeded budget. Cleanups
+// are called in reverse order of registration after the last part.
+func (p *Part) OnAbort(cleanup func()) {
+	p.stream.cleanups = append(p.stream.cleanups, cleanup)
+}
+
+// MultipartOption is an option for StreamMultipart.
+type MultipartOption func(s *multipartStream)
+
+// WithMaxPartSize limits size of data of each part. There is no limit by
+// default.
+func WithMaxPartSize(size int64) MultipartOption {
+	return func(s *multipartStream) {
+		s.maxPartSize = size
+	}
+}
+
+// WithMaxMultipartSize limits size of the whole request body, including part
+// headers and boundaries. There is no limit by default.
+func WithMaxMultipartSize(size int64) MultipartOption {
+	return func(s *multipartStream) {
+		s.maxSize = size
+	}
+}
+
+// multipartStream is the state of StreamMultipart.
+type multipartStream struct {
+	maxPartSize int64
+	maxSize     int64
+
+	// exceeded is the budget error, kept even when it is not returned by the
+	// consumer.
+	exceeded error
+	cleanups []func()
+}
+
+// StreamMultipart calls f for each part of multipart request r in order, so
+// large uploads can be piped to disk or storage without buffering them in
+// memory or temporary files. Data of a part not read by f is discarded.
+//
+// Exceeding a size budget aborts streaming with a 413 ErrorMessage, which
+// the handler can return as is. A malformed request results in a 400
+// ErrorMessage and other errors returned by f are returned as they are.
+func StreamMultipart(r *http.Request, f func(part *Part) error, options ...MultipartOption) (err error) {
+	s := &multipartStream{}
+	for _, opt := range options {
+		opt(s)
+	}
+	defer func() {
+		if err != nil {
+			for i := len(s.cleanups) - 1; i >= 0; i-- {
+				s.cleanups[i]()
+			}
+		}
+	}()
+	if s.maxSize > 0 {
+		r.Body = &budgetReader{
+			ReadCloser: r.Body,
+			remaining:  s.maxSize,
+			err:        errMultipartTooLarge,
+			exceeded:   &s.exceeded,
+		}
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return NewBadRequest(err.Error())
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if e := bodyTooLarge(err); e != nil {
+				return e
+			}
+			return s.error(NewBadRequest(err.Error()))
+		}
+		p := &Part{
+			Name:        part.FormName(),
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Header:      part.Header,
+			reader:      part,
+			stream:      s,
+		}
+		if s.maxPartSize > 0 {
+			p.reader = &budgetReader{
+				ReadCloser: part,
+				remaining:  s.maxPartSize,
+				err:        errPartTooLarge,
+				exceeded:   &s.exceeded,
+			}
+		}
+		err = f(p)
+		part.Close()
+		if err != nil || s.exceeded != nil {
+			return s.error(err)
+		}
+	}
+}
+
+// error returns 413 ErrorMessage if a budget was exceeded, otherwise err.
+func (s *multipartStream) error(err error) error {
+	if s.exceeded != nil {
+		return &ErrorMessage{http.StatusRequestEntityTooLarge, s.exceeded.Error()}
+	}
+	return err
+}
+
+// budgetReader returns err once more than remaining bytes are read.
+type budgetReader struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  *error
+}
+
+func (r *budgetReader) Read(b []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, r.err
+	}
+	// Read one more byte to detect exceeding the budget.
+	if int64(len(b)) > r.remaining+1 {
+		b = b[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		n += int(r.remaining)
+		*r.exceeded = r.err
+		return n, r.err
+	}
+	return n, err
+}
@@ -0,0 +1,131 @@
+package views
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// zeroReader is an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// newMultipartRequest streams parts of the given sizes generated on the fly.
+func newMultipartRequest(sizes ...int64) *http.Request {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		for i, size := range sizes {
+			part, err := mw.CreateFormFile("file", strings.Repeat("f", i+1)+".bin")
+			if err == nil {
+				_, err = io.Copy(part, io.LimitReader(zeroReader{}, size))
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	r := httptest.NewRequest("POST", "/upload", pr)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestStreamMultipartLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large upload in short mode")
+	}
+	const size = 300 << 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var names []string
+	var total int64
+	err := StreamMultipart(newMultipartRequest(size, size/3), func(part *Part) error {
+		names = append(names, part.FileName)
+		h := sha256.New()
+		n, err := io.Copy(h, part)
+		total += n
+		return err
+	}, WithMaxPartSize(size), WithMaxMultipartSize(2*size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if total != size+size/3 || len(names) != 2 || names[0] != "f.bin" || names[1] != "ff.bin" {
+		t.Fatalf("unexpected parts: %v %d", names, total)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Fatalf("unexpected allocation streaming %d bytes: %d", total, alloc)
+	}
+}
+
+func TestStreamMultipartBudget(t *testing.T) {
+	tests := []struct {
+		options []MultipartOption
+		sizes   []int64
+	}{
+		{[]MultipartOption{WithMaxPartSize(1 << 20)}, []int64{1 << 10, 1<<20 + 1}},
+		{[]MultipartOption{WithMaxMultipartSize(1 << 20)}, []int64{1 << 19, 1 << 19}},
+	}
+	for _, test := range tests {
+		var aborted []string
+		r := newMultipartRequest(test.sizes...)
+		err := StreamMultipart(r, func(part *Part) error {
+			name := part.FileName
+			part.OnAbort(func() {
+				aborted = append(aborted, name)
+			})
+			// Consumer ignores the error.
+			io.Copy(ioutil.Discard, part)
+			return nil
+		}, test.options...)
+		r.Body.Close()
+		if e, ok := err.(*ErrorMessage); !ok || e.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if len(aborted) != 2 || aborted[0] != "ff.bin" || aborted[1] != "f.bin" {
+			t.Fatalf("unexpected aborted parts: %v", aborted)
+		}
+	}
+}
+
+func TestStreamMultipartInvalid(t *testing.T) {
+	r := httptest.NewRequest("POST", "/upload", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	err := StreamMultipart(r, func(part *Part) error {
+		t.Fatalf("unexpected part: %v", part.Name)
+		return nil
+	})
+	if e, ok := err.(*ErrorMessage); !ok || e.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	// Unread parts are skipped and consumer errors are returned.
+	called := 0
+	r = newMultipartRequest(10, 10, 10)
+	defer r.Body.Close()
+	err = StreamMultipart(r, func(part *Part) error {
+		called++
+		if called == 2 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != io.ErrUnexpectedEOF || called != 2 {
+		t.Fatalf("unexpected error: %v %d", err, called)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Package protobuf provides a views Provider which reads and writes protocol
+buffers messages:
+
+	views.NewBundle(views.NewJSONProvider(), protobuf.NewProvider())
+
+It is separated from package views so that applications not using protocol
+buffers do not depend on google.golang.org/protobuf.
+*/
+package protobuf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/goburrow/melon/views"
+	"google.golang.org/protobuf/proto"
+)
+
+var mediaTypes = []string{
+	"application/x-protobuf",
+	"application/protobuf",
+}
+
+// provider handles protocol buffers requests and responses.
+type provider struct{}
+
+// NewProvider returns a Provider which reads and writes protocol buffers
+// messages, i.e. proto.Message. Resources negotiated as protocol buffers which
+// return other entities, including errors, respond status 500 and the entity
+// type is logged.
+func NewProvider() views.Provider {
+	return &provider{}
+}
+
+// Consumes returns protocol buffers media types.
+func (p *provider) Consumes() []string {
+	return mediaTypes
+}
+
+// IsReadable returns true if v is a proto.Message.
+func (p *provider) IsReadable(r *http.Request, v interface{}) bool {
+	_, ok := v.(proto.Message)
+	return ok
+}
+
+// ReadRequest decodes message v from request body.
+func (p *provider) ReadRequest(r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, v.(proto.Message))
+}
+
+// Produces returns protocol buffers media types.
+func (p *provider) Produces() []string {
+	return mediaTypes
+}
+
+// IsWriteable always returns true so that WriteResponse reports entities
+// which are not messages.
+func (p *provider) IsWriteable(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return true
+}
+
+// WriteResponse encodes message v and writes to w.
+func (p *provider) WriteResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
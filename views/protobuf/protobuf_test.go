@@ -0,0 +1,97 @@
+package protobuf
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+	"github.com/goburrow/melon/views"
+	"github.com/goburrow/melon/views/protobuf/testdata"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestRouter(t *testing.T, resources ...interface{}) *router.Router {
+	env := core.NewEnvironment()
+	rt := router.New()
+	env.Server.Router = rt
+	env.Admin.Router = router.New()
+	if err := views.NewBundle(views.NewJSONProvider(), NewProvider()).Run(nil, env); err != nil {
+		t.Fatal(err)
+	}
+	env.Server.Register(resources...)
+	if err := env.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { env.Stop() })
+	return rt
+}
+
+func TestProvider(t *testing.T) {
+	rt := newTestRouter(t,
+		views.NewResource("POST", "/users", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+			var u testdata.User
+			if err := views.Entity(r, &u); err != nil {
+				return nil, err
+			}
+			u.Id++
+			return &u, nil
+		})),
+		views.NewResource("GET", "/names", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+			return []string{"a"}, nil
+		})))
+
+	body, err := proto.Marshal(&testdata.User{Name: "melon", Id: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/users", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("Accept", "application/json;q=0.5, application/protobuf")
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/protobuf" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	var u testdata.User
+	if err = proto.Unmarshal(w.Body.Bytes(), &u); err != nil || u.Name != "melon" || u.Id != 301 {
+		t.Fatalf("unexpected message: %v %v", &u, err)
+	}
+	// Same message in JSON
+	r = httptest.NewRequest("POST", "/users", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"name":"melon","id":301}`+"\n" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	// Invalid message
+	r = httptest.NewRequest("POST", "/users", bytes.NewReader([]byte{0xff}))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestProviderNotMessage(t *testing.T) {
+	var p provider
+	err := p.WriteResponse(httptest.NewRecorder(), nil, []string{"a"})
+	if err == nil || err.Error() != "protobuf: []string is not a proto.Message" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rt := newTestRouter(t, views.NewResource("GET", "/names", views.HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return []string{"a"}, nil
+	})))
+	r := httptest.NewRequest("GET", "/names", nil)
+	r.Header.Set("Accept", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
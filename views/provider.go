@@ -1,6 +1,10 @@
 package views
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/goburrow/melon/core"
+)
 
 // requestReader reads entity from message body.
 type requestReader interface {
@@ -28,6 +32,12 @@ type Provider interface {
 
 // providers is used to look up providers by MIME type.
 // TODO: Error mapper.
+// modeConfigurable is implemented by providers whose defaults depend on the
+// mode of the environment.
+type modeConfigurable interface {
+	configureMode(mode core.Mode)
+}
+
 type providers interface {
 	GetRequestReaders(string) []requestReader
 	GetResponseWriters(string) []responseWriter
@@ -86,6 +96,43 @@ func (p *providerMap) GetResponseWriters(mime string) []responseWriter {
 	return p.writersByType[mime]
 }
 
+// ConsumedMediaTypes returns media types consumed by the readers in the order
+// of registration.
+func (p *providerMap) ConsumedMediaTypes() []string {
+	var mediaTypes []string
+	for _, r := range p.readers {
+		for _, m := range r.Consumes() {
+			if !containsString(mediaTypes, m) {
+				mediaTypes = append(mediaTypes, m)
+			}
+		}
+	}
+	return mediaTypes
+}
+
+// MediaTypes returns media types produced by the writers in the order of
+// registration.
+func (p *providerMap) MediaTypes() []string {
+	var mediaTypes []string
+	for _, w := range p.writers {
+		for _, m := range w.Produces() {
+			if !containsString(mediaTypes, m) {
+				mediaTypes = append(mediaTypes, m)
+			}
+		}
+	}
+	return mediaTypes
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // explicitProviderMap returns only supported requestReader and responseWriter
 // from explicited consumes and produces.
 type explicitProviderMap struct {
@@ -149,6 +196,36 @@ func (p *explicitProviderMap) GetResponseWriters(mime string) []responseWriter {
 	return nil
 }
 
+// ConsumedMediaTypes returns the consumes list if set, or media types of all
+// readers. Only media types which have readers are returned.
+func (p *explicitProviderMap) ConsumedMediaTypes() []string {
+	if len(p.consumes) == 0 {
+		return p.parent.ConsumedMediaTypes()
+	}
+	var mediaTypes []string
+	for _, m := range p.consumes {
+		if len(p.parent.GetRequestReaders(m)) > 0 {
+			mediaTypes = append(mediaTypes, m)
+		}
+	}
+	return mediaTypes
+}
+
+// MediaTypes returns the produces list if set, or media types of all writers.
+// Only media types which have writers are returned.
+func (p *explicitProviderMap) MediaTypes() []string {
+	if len(p.produces) == 0 {
+		return p.parent.MediaTypes()
+	}
+	var mediaTypes []string
+	for _, m := range p.produces {
+		if len(p.parent.GetResponseWriters(m)) > 0 {
+			mediaTypes = append(mediaTypes, m)
+		}
+	}
+	return mediaTypes
+}
+
 func isWildcard(mediaType string) bool {
 	return mediaType == "" || mediaType == "*/*"
 }
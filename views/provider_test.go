@@ -1,6 +1,18 @@
 package views
 
-import "testing"
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/router"
+)
 
 func TestDefaultProviders(t *testing.T) {
 	p := newProviderMap()
@@ -72,3 +84,118 @@ func TestExplicitProviders(t *testing.T) {
 		t.Fatalf("provider does not support text/xml %#v", p)
 	}
 }
+
+func TestJSONProviderMode(t *testing.T) {
+	tests := []struct {
+		mode     core.Mode
+		options  []JSONOption
+		expected string
+	}{
+		{core.ModeProduction, nil, "{\"a\":1}\n"},
+		{core.ModeDevelopment, nil, "{\n  \"a\": 1\n}\n"},
+		{core.ModeDevelopment, []JSONOption{WithPrettyJSON(false)}, "{\"a\":1}\n"},
+		{core.ModeProduction, []JSONOption{WithPrettyJSON(true)}, "{\n  \"a\": 1\n}\n"},
+	}
+	for _, test := range tests {
+		p := NewJSONProvider(test.options...)
+		p.(modeConfigurable).configureMode(test.mode)
+		w := httptest.NewRecorder()
+		if err := p.WriteResponse(w, httptest.NewRequest("GET", "/", nil), map[string]int{"a": 1}); err != nil {
+			t.Fatal(err)
+		}
+		if w.Body.String() != test.expected {
+			t.Fatalf("unexpected response in %v mode: %q", test.mode, w.Body.String())
+		}
+	}
+}
+
+func TestHTMLRendererMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "index.html")
+	if err = ioutil.WriteFile(file, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		mode     core.Mode
+		options  []HTMLRendererOption
+		expected string
+	}{
+		{core.ModeProduction, nil, "v1"},
+		{core.ModeDevelopment, nil, "v2"},
+		{core.ModeDevelopment, []HTMLRendererOption{WithTemplateReload(false)}, "v1"},
+	}
+	for _, test := range tests {
+		if err = ioutil.WriteFile(file, []byte("v1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		renderer, err := NewHTMLRenderer(dir, "*.html", test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		NewHTMLProvider(renderer).(modeConfigurable).configureMode(test.mode)
+		if err = ioutil.WriteFile(file, []byte("v2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = renderer.RenderHTML(&buf, "index.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.expected {
+			t.Fatalf("unexpected render in %v mode: %s", test.mode, buf.String())
+		}
+	}
+}
+
+func TestHTMLView(t *testing.T) {
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "user.html"), []byte("<p>{{.Name}}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := NewHTMLRenderer(dir, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, h := newTestRouter()
+	h.HandleResource(NewHTMLProvider(renderer))
+	h.HandleResource(NewResource("GET", "/users/{name}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		template := "user.html"
+		if router.PathParam(r, "name") == "missing" {
+			template = "missing.html"
+		}
+		return &View{Template: template, Data: &rawEntity{Name: router.PathParam(r, "name")}}, nil
+	})))
+	h.HandleResource(NewResource("GET", "/names/{name}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return View{Template: "user.html", Data: &rawEntity{Name: router.PathParam(r, "name")}}, nil
+	})))
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/users/a", http.StatusOK, "<p>a</p>"},
+		{"/users/%3Cscript%3E", http.StatusOK, "<p>&lt;script&gt;</p>"},
+		{"/users/missing", http.StatusInternalServerError, ""},
+		{"/names/b", http.StatusOK, "<p>b</p>"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status || (test.body != "" && w.Body.String() != test.body) {
+			t.Fatalf("%s: unexpected response: %d %v %s", test.path, w.Code, w.Header(), w.Body)
+		}
+	}
+	err = renderer.RenderHTML(&bytes.Buffer{}, "missing.html", nil)
+	if err == nil || !strings.Contains(err.Error(), `template "missing.html" is not defined`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package views
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// QueryInt returns the integer value of query parameter name of request r, or
+// value if it is absent. A malformed value returns a 400 ErrorMessage, which
+// can be returned by the resource as it is.
+func QueryInt(r *http.Request, name string, value int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return value, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, NewBadRequest("Invalid " + name + ".")
+	}
+	return n, nil
+}
+
+// QueryBool returns the boolean value of query parameter name of request r,
+// or value if it is absent. Values accepted by strconv.ParseBool are valid,
+// and a parameter without value, e.g. ?verbose, is true. A malformed value
+// returns a 400 ErrorMessage.
+func QueryBool(r *http.Request, name string, value bool) (bool, error) {
+	values, ok := r.URL.Query()[name]
+	if !ok {
+		return value, nil
+	}
+	if values[0] == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, NewBadRequest("Invalid " + name + ".")
+	}
+	return b, nil
+}
@@ -0,0 +1,75 @@
+package views
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		target string
+		value  int
+		err    bool
+	}{
+		{"/", 20, false},
+		{"/?limit=", 20, false},
+		{"/?limit=-5", -5, false},
+		{"/?limit=10&limit=11", 10, false},
+		{"/?limit=ten", 0, true},
+	}
+	for _, test := range tests {
+		n, err := QueryInt(httptest.NewRequest("GET", test.target, nil), "limit", 20)
+		if n != test.value || (err != nil) != test.err {
+			t.Fatalf("unexpected value of %s: %v %v", test.target, n, err)
+		}
+		if e, ok := err.(*ErrorMessage); test.err && (!ok || e.Code != http.StatusBadRequest) {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	}
+}
+
+func TestQueryBool(t *testing.T) {
+	tests := []struct {
+		target string
+		value  bool
+		err    bool
+	}{
+		{"/", false, false},
+		{"/?verbose", true, false},
+		{"/?verbose=1", true, false},
+		{"/?verbose=false", false, false},
+		{"/?verbose=yes", false, true},
+	}
+	for _, test := range tests {
+		b, err := QueryBool(httptest.NewRequest("GET", test.target, nil), "verbose", false)
+		if b != test.value || (err != nil) != test.err {
+			t.Fatalf("unexpected value of %s: %v %v", test.target, b, err)
+		}
+	}
+}
+
+func TestQueryParamsInResource(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("GET", "/items", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		n, err := QueryInt(r, "limit", 1)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	})))
+	tests := map[string]int{
+		"/items":           http.StatusOK,
+		"/items?limit=2":   http.StatusOK,
+		"/items?limit=two": http.StatusBadRequest,
+	}
+	for target, code := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept", "application/json")
+		rt.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Fatalf("unexpected response %s: %d %s", target, w.Code, w.Body)
+		}
+	}
+}
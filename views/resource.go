@@ -2,13 +2,23 @@
 package views
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/codahale/metrics"
+	"github.com/goburrow/melon/auth"
 	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/conncache"
+	"github.com/goburrow/melon/server/filter"
 )
 
 // Resource is a view resource.
@@ -59,64 +69,159 @@ func (u *bundle) Run(conf interface{}, env *core.Environment) error {
 	return nil
 }
 
-// resourceHandler implements core.ResourceHandler
+// resourceHandler implements core.ResourceHandler and core.ResourceValidator.
 type resourceHandler struct {
 	router    core.Router
 	validator core.Validator
+	mode      core.Mode
 
 	// providers contains all supported Provider.
 	providers   *providerMap
 	errorMapper ErrorMapper
+	// errs are errors of resources which could not be registered.
+	errs []error
 }
 
 func newResourceHandler(env *core.Environment) *resourceHandler {
 	return &resourceHandler{
 		router:    env.Server.Router,
 		validator: env.Validator,
+		mode:      env.Mode,
 
 		providers:   newProviderMap(),
-		errorMapper: newErrorMapper(),
+		errorMapper: newErrorMapper(env.Server.ErrorDetail),
 	}
 }
 
+// ResourceError returns errors of resources which could not be registered,
+// so that the environment fails to start.
+func (h *resourceHandler) ResourceError() error {
+	return errors.Join(h.errs...)
+}
+
 // HandleResource registers providers.
-// It supports Provider, ErrorMapper and Resource.
-func (h *resourceHandler) HandleResource(v interface{}) {
+// It supports Provider, ErrorMapper, Resource, Resources and BatchResource.
+func (h *resourceHandler) HandleResource(v interface{}) bool {
+	if rs, ok := v.(Resources); ok {
+		for _, r := range rs {
+			h.HandleResource(r)
+		}
+		return true
+	}
+	if r, ok := v.(*BatchResource); ok {
+		handler, ok := h.router.(http.Handler)
+		if !ok {
+			h.errs = append(h.errs, fmt.Errorf("views: batch router %T is not a http.Handler", h.router))
+			return true
+		}
+		v = NewResource("POST", r.path, &batchHandler{
+			BatchResource: r,
+			handler:       handler,
+			pathPrefix:    h.router.PathPrefix(),
+		}, WithConsumes(jsonMediaTypes...), WithProduces(jsonMediaTypes...))
+	}
+	claimed := false
 	if r, ok := v.(Provider); ok {
+		if m, ok := r.(modeConfigurable); ok {
+			m.configureMode(h.mode)
+		}
 		h.providers.AddProvider(r)
+		claimed = true
 	}
 	if r, ok := v.(ErrorMapper); ok {
 		// FIMXE: support multiple error mappers.
 		h.errorMapper = r
+		claimed = true
 	}
 	if r, ok := v.(*Resource); ok {
+		if err := validateResource(r); err != nil {
+			h.errs = append(h.errs, err)
+			return true
+		}
 		handler := &httpHandler{
 			handler:     r.handler,
 			errorMapper: h.errorMapper,
 			validator:   h.validator,
 			providers:   newExplicitProviderMap(h.providers),
 		}
+		if t, ok := r.handler.(core.Tagged); ok {
+			handler.tags = append(handler.tags, t.Tags()...)
+		}
+		if c, ok := r.handler.(interface{ Consumes() []string }); ok {
+			handler.providers.consumes = c.Consumes()
+		}
+		if p, ok := r.handler.(interface{ Produces() []string }); ok {
+			handler.providers.produces = p.Produces()
+		}
+		if a, ok := r.handler.(interface{ RolesAllowed() []string }); ok {
+			handler.rolesAllowed = append([]string{}, a.RolesAllowed()...)
+		}
 		for _, opt := range r.options {
 			opt(handler)
 		}
 		h.router.Handle(r.method, r.path, handler)
+		claimed = true
+	}
+	if !claimed {
+		diagnoseComponent(v)
 	}
+	return claimed
 }
 
-// WithConsumes defines the MIME Types that a resource can accept.
+// WithConsumes defines the MIME Types that a resource can accept. Requests
+// with other content types are rejected with status 415 even if there are
+// providers registered for them. Resource handlers can also declare
+// Consumes() []string.
 func WithConsumes(consumes ...string) Option {
 	return func(h *httpHandler) {
 		h.providers.consumes = consumes
 	}
 }
 
-// WithProduces defines the MIME Types that a resource can produce.
+// WithProduces defines the MIME Types that a resource can produce in the
+// order of preference. Requests accepting none of them are rejected with
+// status 406. Resource handlers can also declare Produces() []string.
 func WithProduces(produces ...string) Option {
 	return func(h *httpHandler) {
 		h.providers.produces = produces
 	}
 }
 
+// WithoutBuffering disables output buffering for the resource. Responses are
+// not compressed and header "X-Accel-Buffering: no" is set for proxies like nginx.
+func WithoutBuffering() Option {
+	return func(h *httpHandler) {
+		h.noBuffering = true
+	}
+}
+
+// WithTags adds tags to the route of the resource, e.g. public or internal.
+// Tags are also taken from the resource handler if it implements core.Tagged.
+func WithTags(tags ...string) Option {
+	return func(h *httpHandler) {
+		h.tags = append(h.tags, tags...)
+	}
+}
+
+// WithDeprecation marks the route of the resource as deprecated, so that its
+// responses include deprecation headers and its usage is tracked.
+// Resource handlers can also implement core.Deprecated.
+func WithDeprecation(d core.Deprecation) Option {
+	return func(h *httpHandler) {
+		h.deprecation = &d
+	}
+}
+
+// WithRolesAllowed restricts the resource to principals having one of roles,
+// see auth.RoleHolder. Requests without principals are rejected with status
+// 401 and those of other principals with status 403. Resource handlers can
+// also declare RolesAllowed() []string. No roles denies all requests.
+func WithRolesAllowed(roles ...string) Option {
+	return func(h *httpHandler) {
+		h.rolesAllowed = append([]string{}, roles...)
+	}
+}
+
 // WithTimerMetric adds metric record to the resource.
 func WithTimerMetric(name string) Option {
 	return func(h *httpHandler) {
@@ -132,6 +237,8 @@ var (
 	errInternalServerError  = &ErrorMessage{http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)}
 	errNotAcceptable        = &ErrorMessage{http.StatusNotAcceptable, http.StatusText(http.StatusNotAcceptable)}
 	errUnsupportedMediaType = &ErrorMessage{http.StatusUnsupportedMediaType, http.StatusText(http.StatusUnsupportedMediaType)}
+	errUnauthorized         = &ErrorMessage{http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized)}
+	errForbidden            = &ErrorMessage{http.StatusForbidden, http.StatusText(http.StatusForbidden)}
 )
 
 // httpHandler implements melon server.webResource
@@ -146,6 +253,40 @@ type httpHandler struct {
 	metricLatency  *metrics.Histogram
 
 	htmlTemplate string
+	noBuffering  bool
+	tags         []string
+	deprecation  *core.Deprecation
+	// rolesAllowed is nil when the resource is not restricted and empty when
+	// all requests are denied.
+	rolesAllowed []string
+}
+
+// Tags returns tags of the resource.
+func (h *httpHandler) Tags() []string {
+	return h.tags
+}
+
+// Deprecated returns deprecation of the resource or nil if it is not
+// deprecated.
+func (h *httpHandler) Deprecated() *core.Deprecation {
+	return h.deprecation
+}
+
+// Consumes returns media types of request bodies the resource is restricted
+// to, or nil if it accepts those of all providers.
+func (h *httpHandler) Consumes() []string {
+	return h.providers.consumes
+}
+
+// Produces returns media types of responses the resource is restricted to,
+// or nil if it responds those of all providers.
+func (h *httpHandler) Produces() []string {
+	return h.providers.produces
+}
+
+// Unwrap returns the resource handler.
+func (h *httpHandler) Unwrap() http.Handler {
+	return h.handler
 }
 
 // ServeHTTP attaches handlerContext to request context. It also checks
@@ -165,41 +306,88 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		readers:     requestReaders,
 		writers:     responseWriters,
 		contentType: contentType,
+		direct:      &directResponseWriter{ResponseWriter: w},
 	}
 	ctx := newContext(r.Context(), handlerCtx)
 	r = r.WithContext(ctx)
 	// Check if readable
 	if len(requestReaders) == 0 {
-		h.errorMapper.MapError(w, r, errUnsupportedMediaType)
+		h.errorMapper.MapError(w, r, h.unsupportedMediaType())
 		return
 	}
 	// Check if acceptable
 	if len(responseWriters) == 0 {
-		h.errorMapper.MapError(w, r, errNotAcceptable)
+		h.errorMapper.MapError(w, r, h.notAcceptable())
+		return
+	}
+	// Errors are written in the negotiated media type.
+	if err := h.authorize(r); err != nil {
+		h.errorMapper.MapError(w, r, err)
 		return
 	}
+	if h.noBuffering {
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
 	h.handler.ServeHTTP(w, r)
 }
 
-// getRequestReaders returns a list of requestReader according Content-Type in the request header.
+// authorize checks the principal of request r against allowed roles.
+func (h *httpHandler) authorize(r *http.Request) error {
+	if h.rolesAllowed == nil {
+		return nil
+	}
+	p := auth.PrincipalFromContext(r.Context())
+	if p == nil {
+		return errUnauthorized
+	}
+	if !auth.HasAnyRole(p, h.rolesAllowed...) {
+		return errForbidden
+	}
+	return nil
+}
+
+// getRequestReaders returns a list of requestReader according Content-Type in
+// the request header, ignoring its parameters. All readers are returned when
+// it is missing.
 func (h *httpHandler) getRequestReaders(r *http.Request) []requestReader {
-	mime := r.Header.Get("Content-Type")
-	return h.providers.GetRequestReaders(mime)
+	contentType := r.Header.Get("Content-Type")
+	if idx := strings.Index(contentType, ";"); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+	return h.providers.GetRequestReaders(strings.ToLower(strings.TrimSpace(contentType)))
 }
 
-// getResponseWriters returns a list of responseWriter according Accept in the request header.
+// unsupportedMediaType returns 415 error listing media types consumed by the
+// handler.
+func (h *httpHandler) unsupportedMediaType() error {
+	mediaTypes := h.providers.ConsumedMediaTypes()
+	if len(mediaTypes) == 0 {
+		return errUnsupportedMediaType
+	}
+	return &ErrorMessage{
+		Code:    http.StatusUnsupportedMediaType,
+		Message: http.StatusText(http.StatusUnsupportedMediaType) + ". Supported media types: " + strings.Join(mediaTypes, ", "),
+	}
+}
+
+// getResponseWriters returns a list of responseWriter according Accept in the
+// request header. Media types are tried in the order of their quality values.
 func (h *httpHandler) getResponseWriters(r *http.Request) ([]responseWriter, string) {
 	mime := r.Header.Get("Accept")
 	if isWildcard(mime) {
 		return h.providers.GetResponseWriters(mime), ""
 	}
-	mediaTypes := strings.Split(mime, ",")
+	mediaTypes := conncache.Load(r, "Accept", parseAccept).([]string)
 	// Return providers that support the first mime type
 	for _, mime = range mediaTypes {
-		// TODO: support priority
-		idx := strings.Index(mime, ";")
-		if idx >= 0 {
-			mime = mime[:idx]
+		if strings.HasSuffix(mime, "/*") && !isWildcard(mime) {
+			// Range of subtypes, e.g. application/*
+			for _, m := range h.providers.MediaTypes() {
+				if strings.HasPrefix(m, mime[:len(mime)-1]) {
+					return h.providers.GetResponseWriters(m), m
+				}
+			}
+			continue
 		}
 		writers := h.providers.GetResponseWriters(mime)
 		if len(writers) > 0 {
@@ -209,6 +397,55 @@ func (h *httpHandler) getResponseWriters(r *http.Request) ([]responseWriter, str
 	return nil, ""
 }
 
+// notAcceptable returns 406 error listing media types produced by the handler.
+func (h *httpHandler) notAcceptable() error {
+	mediaTypes := h.providers.MediaTypes()
+	if len(mediaTypes) == 0 {
+		return errNotAcceptable
+	}
+	return &ErrorMessage{
+		Code:    http.StatusNotAcceptable,
+		Message: http.StatusText(http.StatusNotAcceptable) + ". Supported media types: " + strings.Join(mediaTypes, ", "),
+	}
+}
+
+// parseAccept returns media types of Accept header without parameters, sorted
+// by their quality values. Media types with quality 0 are not acceptable and
+// excluded.
+func parseAccept(accept string) interface{} {
+	type mediaRange struct {
+		mime    string
+		quality float64
+	}
+	ranges := make([]mediaRange, 0, strings.Count(accept, ",")+1)
+	for _, mime := range strings.Split(accept, ",") {
+		quality := 1.0
+		if idx := strings.Index(mime, ";"); idx >= 0 {
+			for _, param := range strings.Split(mime[idx+1:], ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if name == "q" || name == "Q" {
+					if q, err := strconv.ParseFloat(value, 64); err == nil && q >= 0 && q <= 1 {
+						quality = q
+					}
+				}
+			}
+			mime = mime[:idx]
+		}
+		mime = strings.TrimSpace(mime)
+		if mime != "" && quality > 0 {
+			ranges = append(ranges, mediaRange{mime, quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mime
+	}
+	return mediaTypes
+}
+
 func (h *httpHandler) setMetrics(name string) {
 	h.metricRequests = metrics.Counter("HTTP.Requests." + name)
 	// 5 min window tracking
@@ -233,6 +470,8 @@ type handlerContext struct {
 
 	// contentType is expected response content type
 	contentType string
+	// direct is given to resources writing response by themselves.
+	direct *directResponseWriter
 }
 
 // contextKey is a value for use with context.WithValue
@@ -288,7 +527,20 @@ func (c *handlerContext) findWriter(w http.ResponseWriter, r *http.Request, data
 
 // Serve uses provider assigned to the request context to render data
 // and writes to HTTP response.
+// Data implementing io.WriterTo or io.Reader is streamed to the response
+// without providers, with Content-Type application/octet-stream unless it is
+// set, e.g. in Response.Header. It is closed afterwards if it is an io.Closer.
 func Serve(w http.ResponseWriter, r *http.Request, data interface{}) {
+	serve(w, r, 0, data)
+}
+
+// serve writes data with the given status code, or the default one if it is 0.
+func serve(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	switch data.(type) {
+	case io.WriterTo, io.Reader:
+		serveStream(w, status, data)
+		return
+	}
 	ctx := fromContext(r.Context())
 	if ctx == nil {
 		logger().Errorf("no handler in request context: %v", r.Context())
@@ -297,7 +549,7 @@ func Serve(w http.ResponseWriter, r *http.Request, data interface{}) {
 	writer, contentType := ctx.findWriter(w, r, data)
 	if writer == nil {
 		// FIXME: Hanlde unknown type
-		logger().Warnf("no response writer for %T", data)
+		logger().Warnf("no response writer for %T as %s", data, ctx.contentType)
 		ctx.handler.errorMapper.MapError(w, r, errInternalServerError)
 		return
 	}
@@ -305,6 +557,9 @@ func Serve(w http.ResponseWriter, r *http.Request, data interface{}) {
 	if contentType != "" {
 		w.Header().Set("Content-Type", contentType)
 	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
 	// write data
 	err := writer.WriteResponse(w, r, data)
 	if err != nil {
@@ -314,17 +569,55 @@ func Serve(w http.ResponseWriter, r *http.Request, data interface{}) {
 	}
 }
 
+// serveStream copies data, which is an io.WriterTo or io.Reader, to the
+// response.
+func serveStream(w http.ResponseWriter, status int, data interface{}) {
+	if c, ok := data.(io.Closer); ok {
+		defer c.Close()
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	var err error
+	if wt, ok := data.(io.WriterTo); ok {
+		_, err = wt.WriteTo(w)
+	} else {
+		_, err = io.Copy(w, data.(io.Reader))
+	}
+	if err != nil {
+		// Response has been written partially.
+		logger().Warnf("streaming response: %v", err)
+	}
+}
+
 // Error writes error to HTTP response given the request context.
+// The error is also recorded for filters, see filter.Error.
 func Error(w http.ResponseWriter, r *http.Request, err error) {
 	ctx := fromContext(r.Context())
 	if ctx == nil {
 		logger().Errorf("no handler in request context: %v", r.Context())
 		return
 	}
+	filter.SetError(r, err)
 	ctx.handler.errorMapper.MapError(w, r, err)
 }
 
-// Entity reads and validates entity v from request r.
+// Flush sends any buffered data, including data buffered by filters such as
+// gzip, to the client. It returns false if w does not support flushing.
+func Flush(w http.ResponseWriter) bool {
+	fl, ok := w.(http.Flusher)
+	if ok {
+		fl.Flush()
+	}
+	return ok
+}
+
+// Entity reads and validates entity v from request r. Errors of the validator
+// of the environment are responded with status 400 and errors of Validatable
+// entities with status 422.
 func Entity(r *http.Request, v interface{}) error {
 	ctx := fromContext(r.Context())
 	if ctx == nil {
@@ -338,18 +631,37 @@ func Entity(r *http.Request, v interface{}) error {
 	}
 	err := reader.ReadRequest(r, v)
 	if err != nil {
+		if e := bodyTooLarge(err); e != nil {
+			return e
+		}
 		return &ErrorMessage{statusUnprocessableEntity, err.Error()}
 	}
 	validator := ctx.handler.validator
 	if validator != nil {
 		err = validator.Validate(v)
 		if err != nil {
-			return &ErrorMessage{http.StatusBadRequest, err.Error()}
+			return validationError(http.StatusBadRequest, err)
+		}
+	}
+	if e, ok := v.(Validatable); ok {
+		err = e.Validate()
+		if err != nil {
+			return validationError(statusUnprocessableEntity, err)
 		}
 	}
 	return nil
 }
 
+// bodyTooLarge returns 413 ErrorMessage if err is caused by a request body
+// exceeding its limit, e.g. of http.MaxBytesReader, or nil otherwise.
+func bodyTooLarge(err error) error {
+	var e *http.MaxBytesError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	return &ErrorMessage{http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes.", e.Limit)}
+}
+
 // HandlerFunc is a http.Handler which allows users to write view handler like:
 //
 // 	func handle(r *http.Request) (interface{}, error) {
@@ -368,16 +680,113 @@ func Entity(r *http.Request, v interface{}) error {
 //
 type HandlerFunc func(*http.Request) (interface{}, error)
 
+// Response can be returned by HandlerFunc to respond status codes other than
+// 200 OK and additional headers. The body is empty when Entity is nil.
+type Response struct {
+	// StatusCode is http.StatusOK if it is zero.
+	StatusCode int
+	Header     http.Header
+	Entity     interface{}
+}
+
+// Created returns a 201 Response with the Location header.
+func Created(location string, entity interface{}) *Response {
+	return &Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Location": []string{location}},
+		Entity:     entity,
+	}
+}
+
+// NoContent returns a 204 Response.
+func NoContent() *Response {
+	return &Response{StatusCode: http.StatusNoContent}
+}
+
+// write writes headers, status code and entity of the response.
+func (rsp *Response) write(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for k, v := range rsp.Header {
+		header[k] = append(header[k], v...)
+	}
+	if rsp.Entity == nil {
+		status := rsp.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		return
+	}
+	serve(w, r, rsp.StatusCode, rsp.Entity)
+}
+
 // ServeHTTP invokes Error if returned error is not nil or Serve for returned data.
+// Both are skipped if the handler has written to RawResponseWriter.
+// Returned *Response sets status code and headers of the response.
 func (h HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	data, err := h(r)
+	if ctx := fromContext(r.Context()); ctx != nil && ctx.direct.written {
+		if data != nil || err != nil {
+			logger().Warnf("response has been written directly, discarding returned entity %T and error %v", data, err)
+		}
+		return
+	}
 	if err != nil {
 		Error(w, r, err)
 		return
 	}
+	if rsp, ok := data.(*Response); ok {
+		rsp.write(w, r)
+		return
+	}
 	Serve(w, r, data)
 }
 
+// RawResponseWriter returns the underlying http.ResponseWriter for
+// HandlerFunc which needs to write response by itself, e.g. hijacking the
+// connection. Once it is written to, the HandlerFunc must return nil entity
+// as provider serialization is skipped.
+func RawResponseWriter(r *http.Request) (http.ResponseWriter, bool) {
+	ctx := fromContext(r.Context())
+	if ctx == nil {
+		return nil, false
+	}
+	return ctx.direct, true
+}
+
+// directResponseWriter records whether response has been written.
+type directResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *directResponseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *directResponseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher.
+func (w *directResponseWriter) Flush() {
+	w.written = true
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *directResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.written = true
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("not a Hijacker")
+}
+
 func logger() core.Logger {
 	return core.GetLogger("melon/views")
 }
@@ -0,0 +1,602 @@
+package views
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/melon/auth"
+	"github.com/goburrow/melon/core"
+	"github.com/goburrow/melon/server/bodylimit"
+	sgzip "github.com/goburrow/melon/server/gzip"
+	"github.com/goburrow/melon/server/router"
+)
+
+func newTestRouter() (*router.Router, *resourceHandler) {
+	env := core.NewEnvironment()
+	rt := router.New()
+	env.Server.Router = rt
+	h := newResourceHandler(env)
+	h.HandleResource(NewJSONProvider())
+	return rt, h
+}
+
+func TestFlush(t *testing.T) {
+	testFlush(t, "gzip")
+}
+
+func TestFlushWithoutBuffering(t *testing.T) {
+	testFlush(t, "", WithoutBuffering())
+}
+
+func testFlush(t *testing.T, encoding string, options ...Option) {
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("line1\n"))
+		if !Flush(w) {
+			t.Errorf("response writer is not a flusher: %T", w)
+		}
+		<-release
+		w.Write([]byte("line2\n"))
+	}
+	rt, h := newTestRouter()
+	rt.AddFilter(sgzip.NewFilter())
+	h.HandleResource(NewResource("GET", "/stream", http.HandlerFunc(handler), options...))
+
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		close(release)
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if encoding != rsp.Header.Get("Content-Encoding") {
+		close(release)
+		t.Fatalf("unexpected content encoding: %v", rsp.Header)
+	}
+	var body io.Reader = rsp.Body
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(rsp.Body)
+		if err != nil {
+			close(release)
+			t.Fatal(err)
+		}
+		body = gz
+	} else if "no" != rsp.Header.Get("X-Accel-Buffering") {
+		t.Errorf("unexpected buffering header: %v", rsp.Header)
+	}
+	reader := bufio.NewReader(body)
+	lines := make(chan string)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		lines <- line
+	}()
+	// Handler is still blocking while the first line is received.
+	select {
+	case line := <-lines:
+		if "line1\n" != line {
+			t.Errorf("unexpected line: %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for flushed data")
+	}
+	close(release)
+	line, _ := reader.ReadString('\n')
+	if "line2\n" != line {
+		t.Fatalf("unexpected line: %q", line)
+	}
+}
+
+type rawEntity struct {
+	Name string `json:"name"`
+}
+
+func TestRawResponseWriter(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("GET", "/direct", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		w, ok := RawResponseWriter(r)
+		if !ok {
+			t.Errorf("no raw response writer")
+			return nil, nil
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK"))
+		return nil, nil
+	})))
+	h.HandleResource(NewResource("GET", "/normal", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		if _, ok := RawResponseWriter(r); !ok {
+			t.Errorf("no raw response writer")
+		}
+		return &rawEntity{"normal"}, nil
+	})))
+	h.HandleResource(NewResource("GET", "/misuse", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		w, _ := RawResponseWriter(r)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("direct"))
+		return &rawEntity{"misuse"}, nil
+	})))
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/direct", http.StatusOK, "application/zip", "PK"},
+		{"/normal", http.StatusOK, "application/json", "{\"name\":\"normal\"}\n"},
+		{"/misuse", http.StatusAccepted, "", "direct"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("%s: unexpected status: %v", test.path, w.Code)
+		}
+		if test.contentType != "" && w.Header().Get("Content-Type") != test.contentType {
+			t.Fatalf("%s: unexpected content type: %v", test.path, w.Header())
+		}
+		if w.Body.String() != test.body {
+			t.Fatalf("%s: unexpected body: %q", test.path, w.Body.String())
+		}
+	}
+}
+
+type taggedHandler struct{}
+
+func (taggedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func (taggedHandler) Tags() []string {
+	return []string{"public"}
+}
+
+func TestResourceTags(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("GET", "/users/{id:int}", taggedHandler{}, WithTags("rate-limit:low")))
+	h.HandleResource(NewResource("POST", "/jobs", http.NotFoundHandler()))
+	routes := rt.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+	r := routes[0]
+	if r.Method != "GET" || r.Pattern != "/users/{id:int}" || r.Component != "views.taggedHandler" ||
+		len(r.Tags) != 2 || r.Tags[0] != "public" || r.Tags[1] != "rate-limit:low" {
+		t.Fatalf("unexpected route: %+v", r)
+	}
+	r = routes[1]
+	if r.Method != "POST" || r.Pattern != "/jobs" || r.Component != "http.HandlerFunc" || r.Tags != nil {
+		t.Fatalf("unexpected route: %+v", r)
+	}
+}
+
+func TestResourceDeprecation(t *testing.T) {
+	rt, h := newTestRouter()
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.HandleResource(NewResource("GET", "/v1/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return []string{"a"}, nil
+	}), WithDeprecation(core.Deprecation{Sunset: sunset, Link: "/v2/users"})))
+	h.HandleResource(NewResource("GET", "/v2/users", http.NotFoundHandler()))
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" ||
+		w.Header().Get("Sunset") != "Tue, 01 Jan 2030 00:00:00 GMT" ||
+		w.Header().Get("Link") != `</v2/users>; rel="successor-version"` {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	routes := rt.Routes()
+	if routes[0].Deprecation == nil || !routes[0].Deprecation.Sunset.Equal(sunset) || routes[1].Deprecation != nil {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+}
+
+type adminResource struct{}
+
+func (adminResource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func (adminResource) RolesAllowed() []string {
+	return []string{"admin"}
+}
+
+func TestResourceRolesAllowed(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("GET", "/admin", adminResource{}))
+	h.HandleResource(NewResource("DELETE", "/admin", adminResource{}, WithRolesAllowed("owner", "admin")))
+	h.HandleResource(NewResource("PUT", "/admin", adminResource{}, WithRolesAllowed()))
+	tests := []struct {
+		method    string
+		principal auth.Principal
+		code      int
+		body      string
+	}{
+		{"GET", nil, 401, `{"Code":401,"Message":"Unauthorized"}`},
+		{"GET", auth.NewPrincipal("anonymous"), 403, `{"Code":403,"Message":"Forbidden"}`},
+		{"GET", auth.NewPrincipalWithRoles("user", "user"), 403, `{"Code":403,"Message":"Forbidden"}`},
+		{"GET", auth.NewPrincipalWithRoles("admin", "user", "admin"), 200, "ok"},
+		{"DELETE", auth.NewPrincipalWithRoles("owner", "owner"), 200, "ok"},
+		// No roles denies all.
+		{"PUT", nil, 401, `{"Code":401,"Message":"Unauthorized"}`},
+		{"PUT", auth.NewPrincipalWithRoles("admin", "admin"), 403, `{"Code":403,"Message":"Forbidden"}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/admin", nil)
+		r.Header.Set("Accept", "application/json")
+		if test.principal != nil {
+			r = r.WithContext(auth.NewContext(r.Context(), test.principal))
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.code || strings.TrimSpace(w.Body.String()) != test.body {
+			t.Fatalf("unexpected response %+v: %d %q", test, w.Code, w.Body)
+		}
+	}
+	// Negotiation is checked first.
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("unexpected response: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestEntityTooLarge(t *testing.T) {
+	rt, h := newTestRouter()
+	rt.AddFilter(bodylimit.NewFilter(32))
+	h.HandleResource(NewResource("POST", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		var user rawEntity
+		if err := Entity(r, &user); err != nil {
+			return nil, err
+		}
+		return &user, nil
+	})))
+	tests := []struct {
+		name string
+		code int
+	}{
+		{"foo", http.StatusOK},
+		{strings.Repeat("a", 32), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"`+test.name+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+		}
+	}
+}
+
+func TestResourceMethods(t *testing.T) {
+	rt, h := newTestRouter()
+	for _, method := range []string{"GET", "PUT", "PATCH", "OPTIONS"} {
+		method := method
+		h.HandleResource(NewResource(method, "/users/{id}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+			return map[string]string{"method": method, "id": router.PathParam(r, "id")}, nil
+		})))
+	}
+	tests := []struct {
+		method string
+		body   string
+	}{
+		{"PUT", `{"id":"1","method":"PUT"}` + "\n"},
+		{"PATCH", `{"id":"1","method":"PATCH"}` + "\n"},
+		{"OPTIONS", `{"id":"1","method":"OPTIONS"}` + "\n"},
+		// HEAD is served by GET, the body is discarded by net/http server.
+		{"HEAD", `{"id":"1","method":"GET"}` + "\n"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, "/users/1", nil)
+		r.Header.Set("Accept", "application/json")
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != test.body ||
+			!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("unexpected response %s: %d %v %q", test.method, w.Code, w.Header(), w.Body)
+		}
+	}
+	if endpoints := rt.Endpoints(); len(endpoints) != 4 {
+		t.Fatalf("unexpected endpoints: %v", endpoints)
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	tests := map[string][]string{
+		"application/json":                                {"application/json"},
+		"application/xml;q=0.9, application/json":         {"application/json", "application/xml"},
+		"text/html, */*;q=0.1, application/*;q=0.5":       {"text/html", "application/*", "*/*"},
+		"application/xml; charset=utf-8; q=0, text/plain": {"text/plain"},
+		"text/plain;q=x":                                  {"text/plain"},
+	}
+	for accept, expected := range tests {
+		mediaTypes := parseAccept(accept).([]string)
+		if !reflect.DeepEqual(mediaTypes, expected) {
+			t.Fatalf("unexpected media types of %q: %v", accept, mediaTypes)
+		}
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewXMLProvider())
+	h.HandleResource(NewResource("GET", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return &rawEntity{Name: "a"}, nil
+	})))
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"application/xml;q=0.9, application/json", http.StatusOK, "application/json"},
+		{"application/json;q=0.5, application/xml", http.StatusOK, "application/xml"},
+		{"image/*, application/*;q=0.8", http.StatusOK, "application/json"},
+		{"text/csv, */*;q=0.1", http.StatusOK, "application/json"},
+		{"text/csv", http.StatusNotAcceptable, "text/plain"},
+		{"application/json;q=0", http.StatusNotAcceptable, "text/plain"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/users", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status || !strings.HasPrefix(w.Header().Get("Content-Type"), test.contentType) {
+			t.Fatalf("%s: unexpected response: %d %v %s", test.accept, w.Code, w.Header(), w.Body)
+		}
+		if test.status == http.StatusNotAcceptable &&
+			!strings.Contains(w.Body.String(), "Supported media types: application/json, text/json, text/javascript, application/xml, text/xml") {
+			t.Fatalf("%s: unexpected body: %s", test.accept, w.Body)
+		}
+	}
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewXMLProvider())
+	h.HandleResource(NewResource("POST", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		var v rawEntity
+		if err := Entity(r, &v); err != nil {
+			return nil, err
+		}
+		return v.Name, nil
+	})))
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{"name":"a"}`, http.StatusOK},
+		{"Application/JSON; charset=utf-8", `{"name":"a"}`, http.StatusOK},
+		{"application/xml", "<rawEntity><Name>a</Name></rawEntity>", http.StatusOK},
+		// First provider by default.
+		{"", `{"name":"a"}`, http.StatusOK},
+		{"text/plain", "a", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/users", strings.NewReader(test.body))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("%s: unexpected response: %d %s", test.contentType, w.Code, w.Body)
+		}
+		if w.Code == http.StatusOK && strings.TrimSpace(w.Body.String()) != `"a"` {
+			t.Fatalf("%s: unexpected body: %s", test.contentType, w.Body)
+		}
+		if test.status == http.StatusUnsupportedMediaType &&
+			!strings.Contains(w.Body.String(), "Supported media types: application/json, text/json, text/javascript, application/xml, text/xml") {
+			t.Fatalf("%s: unexpected body: %s", test.contentType, w.Body)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("POST", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return Created("/users/1", &rawEntity{Name: "a"}), nil
+	})))
+	h.HandleResource(NewResource("DELETE", "/users/{id}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return NoContent(), nil
+	})))
+	h.HandleResource(NewResource("PUT", "/users/{id}", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		return &Response{Header: http.Header{"Etag": {`"1"`}}, Entity: "updated"}, nil
+	})))
+	tests := []struct {
+		method string
+		path   string
+		status int
+		header string
+		body   string
+	}{
+		{"POST", "/users", http.StatusCreated, "/users/1", `{"name":"a"}`},
+		{"DELETE", "/users/1", http.StatusNoContent, "", ""},
+		{"PUT", "/users/1", http.StatusOK, `"1"`, `"updated"`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		header := w.Header().Get("Location") + w.Header().Get("Etag")
+		if w.Code != test.status || header != test.header || strings.TrimSpace(w.Body.String()) != test.body {
+			t.Fatalf("%s %s: unexpected response: %d %v %q", test.method, test.path, w.Code, w.Header(), w.Body)
+		}
+	}
+}
+
+// countingReader generates size bytes and records whether it is closed.
+type countingReader struct {
+	size   int64
+	read   int64
+	closed bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.read >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.read {
+		p = p[:r.size-r.read]
+	}
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func (r *countingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+// boundedWriter discards the response and fails if the reader runs ahead of
+// it by more than max bytes, i.e. the body is buffered.
+type boundedWriter struct {
+	*httptest.ResponseRecorder
+	t       *testing.T
+	reader  *countingReader
+	max     int64
+	written int64
+	fail    bool
+}
+
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	if w.reader.read-w.written > w.max {
+		w.t.Fatalf("response is buffered: read %d, written %d", w.reader.read, w.written)
+	}
+	if w.fail {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+func TestStreamResponse(t *testing.T) {
+	rt, h := newTestRouter()
+	var reader *countingReader
+	h.HandleResource(NewResource("GET", "/export", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		if r.URL.Query().Get("type") == "csv" {
+			return &Response{Header: http.Header{"Content-Type": {"text/csv"}}, Entity: reader}, nil
+		}
+		return reader, nil
+	})))
+	tests := []struct {
+		target      string
+		fail        bool
+		contentType string
+	}{
+		{"/export", false, "application/octet-stream"},
+		{"/export?type=csv", false, "text/csv"},
+		// Client has gone.
+		{"/export", true, "application/octet-stream"},
+	}
+	for _, test := range tests {
+		reader = &countingReader{size: 10 << 20}
+		w := &boundedWriter{ResponseRecorder: httptest.NewRecorder(), t: t, reader: reader, max: 64 << 10, fail: test.fail}
+		r := httptest.NewRequest("GET", test.target, nil)
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != test.contentType || !reader.closed {
+			t.Fatalf("unexpected response %s: %d %v %v", test.target, w.Code, w.Header(), reader.closed)
+		}
+		if !test.fail && w.written != 10<<20 {
+			t.Fatalf("unexpected size: %d", w.written)
+		}
+	}
+}
+
+// webhookHandler only accepts JSON.
+type webhookHandler struct{}
+
+func (webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var v rawEntity
+	if err := Entity(r, &v); err != nil {
+		Error(w, r, err)
+		return
+	}
+	Serve(w, r, &v)
+}
+
+func (webhookHandler) Consumes() []string {
+	return []string{"application/json"}
+}
+
+func (webhookHandler) Produces() []string {
+	return []string{"application/json"}
+}
+
+func TestResourceMediaTypes(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewXMLProvider())
+	h.HandleResource(NewResource("POST", "/hooks", webhookHandler{}))
+	h.HandleResource(NewResource("POST", "/events", webhookHandler{},
+		WithConsumes("application/xml"), WithProduces("application/xml")))
+	h.HandleResource(NewResource("POST", "/any", webhookHandler{},
+		WithConsumes(), WithProduces()))
+
+	tests := []struct {
+		path        string
+		contentType string
+		accept      string
+		body        string
+		status      int
+		response    string
+	}{
+		{"/hooks", "application/json", "", `{"name":"a"}`, http.StatusOK, "application/json"},
+		{"/hooks", "application/json", "application/xml, */*", `{"name":"a"}`, http.StatusOK, "application/json"},
+		{"/hooks", "application/xml", "", "<rawEntity><name>a</name></rawEntity>", http.StatusUnsupportedMediaType, ""},
+		{"/hooks", "application/json", "application/xml", `{"name":"a"}`, http.StatusNotAcceptable, ""},
+		// Options take precedence over the handler.
+		{"/events", "application/xml", "", "<rawEntity><name>a</name></rawEntity>", http.StatusOK, "application/xml"},
+		{"/events", "application/json", "", `{"name":"a"}`, http.StatusUnsupportedMediaType, ""},
+		// All providers without restrictions.
+		{"/any", "application/xml", "application/xml", "<rawEntity><name>a</name></rawEntity>", http.StatusOK, "application/xml"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("%s %s %s: unexpected status: %d %s", test.path, test.contentType, test.accept, w.Code, w.Body)
+		}
+		if test.response != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), test.response) {
+			t.Fatalf("%s %s %s: unexpected content type: %v", test.path, test.contentType, test.accept, w.Header())
+		}
+	}
+	routes := rt.Routes()
+	if !reflect.DeepEqual(routes[0].Consumes, []string{"application/json"}) ||
+		!reflect.DeepEqual(routes[1].Produces, []string{"application/xml"}) ||
+		routes[2].Consumes != nil || routes[2].Produces != nil {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+	endpoints := rt.Endpoints()
+	if !strings.HasSuffix(endpoints[0], "[consumes application/json] [produces application/json]") ||
+		strings.Contains(endpoints[2], "[consumes") {
+		t.Fatalf("unexpected endpoints: %q", endpoints)
+	}
+}
@@ -0,0 +1,54 @@
+package views
+
+import (
+	"sort"
+	"strings"
+)
+
+// Validatable is implemented by entities validating themselves. Validate is
+// called by Entity after the validator of the environment, and its error is
+// responded with status 422 Unprocessable Entity.
+type Validatable interface {
+	Validate() error
+}
+
+// FieldErrors maps invalid fields to their messages. Validators and Validate
+// methods can return it to respond per-field messages in Details of a
+// DetailedError.
+type FieldErrors map[string]string
+
+// Error returns messages of all fields sorted by field names.
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(f)
+		b.WriteString(": ")
+		b.WriteString(e[f])
+	}
+	return b.String()
+}
+
+// validationError returns the error responded for validation error err.
+// HTTPError is returned as it is.
+func validationError(code int, err error) error {
+	switch e := err.(type) {
+	case FieldErrors:
+		details := make(map[string]interface{}, len(e))
+		for f, msg := range e {
+			details[f] = msg
+		}
+		return NewDetailedError(code, e.Error(), details)
+	case HTTPError:
+		return e
+	default:
+		return &ErrorMessage{code, err.Error()}
+	}
+}
@@ -0,0 +1,87 @@
+package views
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type validatableUser struct {
+	Name string
+	Age  int
+}
+
+func (u *validatableUser) Validate() error {
+	if u.Age < 0 {
+		return errors.New("Age must not be negative.")
+	}
+	errs := FieldErrors{}
+	if u.Name == "" {
+		errs["Name"] = "must not be empty"
+	}
+	if u.Age > 200 {
+		errs["Age"] = "is too large"
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func TestValidatable(t *testing.T) {
+	rt, h := newTestRouter()
+	h.HandleResource(NewResource("POST", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		var u validatableUser
+		if err := Entity(r, &u); err != nil {
+			return nil, err
+		}
+		return Created("/users/1", &u), nil
+	})))
+	tests := []struct {
+		body   string
+		status int
+		rsp    string
+	}{
+		{`{"Name":"a"}`, http.StatusCreated, `{"Name":"a","Age":0}`},
+		{`{"Age":300}`, statusUnprocessableEntity,
+			`{"Code":422,"Message":"Age: is too large; Name: must not be empty","Details":{"Age":"is too large","Name":"must not be empty"}}`},
+		{`{"Name":"a","Age":-1}`, statusUnprocessableEntity, `{"Code":422,"Message":"Age must not be negative."}`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/users", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != test.status || strings.TrimSpace(w.Body.String()) != test.rsp {
+			t.Fatalf("%s: unexpected response: %d %s", test.body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestValidatorFieldErrors(t *testing.T) {
+	rt, h := newTestRouter()
+	h.validator = validatorFunc(func(v interface{}) error {
+		return FieldErrors{"Name": "must not be empty"}
+	})
+	h.HandleResource(NewResource("POST", "/users", HandlerFunc(func(r *http.Request) (interface{}, error) {
+		var u validatableUser
+		return nil, Entity(r, &u)
+	})))
+	r := httptest.NewRequest("POST", "/users", strings.NewReader(`{}`))
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest ||
+		strings.TrimSpace(w.Body.String()) != `{"Code":400,"Message":"Name: must not be empty","Details":{"Name":"must not be empty"}}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+}
+
+type validatorFunc func(v interface{}) error
+
+func (f validatorFunc) Validate(v interface{}) error {
+	return f(v)
+}